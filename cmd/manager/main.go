@@ -7,12 +7,19 @@ import (
 	"os"
 	"strings"
 
+	oconfig "github.com/openshift/api/config/v1"
 	configclient "github.com/openshift/client-go/config/clientset/versioned"
+	configinformers "github.com/openshift/client-go/config/informers/externalversions"
 	operatorv1 "github.com/openshift/client-go/operator/clientset/versioned/typed/operator/v1"
 	"github.com/openshift/windows-machine-config-operator/pkg/apis"
+	wmcapi "github.com/openshift/windows-machine-config-operator/pkg/apis/wmc/v1alpha1"
 	"github.com/openshift/windows-machine-config-operator/pkg/clusternetwork"
+	"github.com/openshift/windows-machine-config-operator/pkg/clusteroperator"
 	"github.com/openshift/windows-machine-config-operator/pkg/controller"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/operatorconfig"
 	wkl "github.com/openshift/windows-machine-config-operator/pkg/controller/wellknownlocations"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/windowsmachine/nodeconfig"
+	wmcometrics "github.com/openshift/windows-machine-config-operator/pkg/metrics"
 	"github.com/openshift/windows-machine-config-operator/version"
 	"github.com/operator-framework/operator-sdk/pkg/k8sutil"
 	kubemetrics "github.com/operator-framework/operator-sdk/pkg/kube-metrics"
@@ -23,10 +30,13 @@ import (
 	"github.com/spf13/pflag"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
@@ -105,43 +115,31 @@ func main() {
 		os.Exit(1)
 	}
 
-	// get cluster configuration
-	clusterconfig, err := newClusterConfig(cfg)
+	namespace, err := k8sutil.GetWatchNamespace()
 	if err != nil {
-		log.Error(err, "failed to get cluster configuration")
+		log.Error(err, "failed to get watch namespace")
 		os.Exit(1)
 	}
 
-	// validate cluster for required configurations
-	if err := clusterconfig.validate(); err != nil {
-		log.Error(err, "failed to validate required cluster configuration")
+	// The health of the operator, including the startup failures below, is reported through its ClusterOperator
+	oclient, err := configclient.NewForConfig(cfg)
+	if err != nil {
+		log.Error(err, "failed to create config clientset")
 		os.Exit(1)
 	}
+	reporter := clusteroperator.NewReporter(oclient.ConfigV1(), namespace)
 
-	// Checking if required files exist before starting the operator
-	requiredFiles := []string{
-		wkl.FlannelCNIPluginPath,
-		wkl.HostLocalCNIPlugin,
-		wkl.WinBridgeCNIPlugin,
-		wkl.WinOverlayCNIPlugin,
-		wkl.HybridOverlayPath,
-		wkl.KubeletPath,
-		wkl.KubeProxyPath,
-		wkl.IgnoreWgetPowerShellPath,
-		wkl.WmcbPath,
-		wkl.PrivateKeyPath,
-		wkl.CNIConfigTemplatePath,
-		wkl.HNSPSModule,
-	}
-	if err := checkIfRequiredFilesExist(requiredFiles); err != nil {
-		log.Error(err, "could not start the operator")
-		os.Exit(1)
+	// get cluster configuration
+	clusterconfig, err := newClusterConfig(cfg)
+	if err != nil {
+		log.Error(err, "failed to get cluster configuration")
+		exitWithFailure(reporter, clusteroperator.ReasonInvalidClusterConfiguration, err)
 	}
 
-	namespace, err := k8sutil.GetWatchNamespace()
-	if err != nil {
-		log.Error(err, "failed to get watch namespace")
-		os.Exit(1)
+	// validate cluster for required configurations
+	if err := clusterconfig.validate(); err != nil {
+		log.Error(err, "failed to validate required cluster configuration")
+		exitWithFailure(reporter, clusteroperator.ReasonInvalidClusterConfiguration, err)
 	}
 
 	ctx := context.TODO()
@@ -172,15 +170,58 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Get the cluster serviceCIDR using clusterNetworkConfig interface
-	clusterServiceCIDR, err := clusterconfig.network.GetServiceCIDR()
+	// Checking if required files exist before starting the operator, including the files of the network backend, in
+	// the payload directory of the operator configuration
+	operatorConfig, err := operatorconfig.Get(mgr.GetAPIReader())
+	if err != nil {
+		log.Error(err, "failed to get the operator configuration")
+		exitWithFailure(reporter, clusteroperator.ReasonOperatorConfigurationUnavailable, err)
+	}
+	if err := checkIfRequiredFilesExist(requiredFiles(clusterconfig.network, &operatorConfig.Spec)); err != nil {
+		log.Error(err, "could not start the operator")
+		exitWithFailure(reporter, clusteroperator.ReasonMissingPayloadFiles, err)
+	}
+	// The payload is part of the operator image, so its contents are read once for all the controllers
+	payload, err := operatorconfig.ReadPayload(operatorConfig.Spec.PayloadDirectory)
+	if err != nil {
+		log.Error(err, "could not start the operator")
+		exitWithFailure(reporter, clusteroperator.ReasonMissingPayloadFiles, err)
+	}
+
+	// Rediscover the ignition endpoint whenever the cluster Infrastructure object changes
+	if err := clusterconfig.watchInfrastructure(mgr); err != nil {
+		log.Error(err, "failed to watch the cluster Infrastructure object")
+		os.Exit(1)
+	}
+
+	// The Windows nodes are watched once for all the controllers, which look them up and wait for them through it
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		log.Error(err, "failed to create kubernetes clientset")
+		os.Exit(1)
+	}
+	nodes := nodeconfig.NewNodeWatcher(kubeClient)
+	if err := mgr.Add(nodes); err != nil {
+		log.Error(err, "failed to add the Windows node watcher to the Manager")
+		os.Exit(1)
+	}
+
+	// The nodes, the CSRs, the Machines and the WindowsMachineConfig are served to the controllers by a single cache,
+	// which is not restricted to the manager's namespaces as most of them are cluster scoped
+	clusterCache, err := cache.New(cfg, cache.Options{Scheme: mgr.GetScheme(), Mapper: mgr.GetRESTMapper()})
 	if err != nil {
-		log.Error(err, "failed to get service CIDR from the cluster configuration")
+		log.Error(err, "failed to create the cluster scoped cache")
+		os.Exit(1)
+	}
+	if err := mgr.Add(clusterCache); err != nil {
+		log.Error(err, "failed to add the cluster scoped cache to the Manager")
 		os.Exit(1)
 	}
 
-	// Setup all Controllers
-	if err := controller.AddToManager(mgr, clusterServiceCIDR); err != nil {
+	// Setup all Controllers. The cluster network configuration validated above is the initial configuration, which is
+	// kept up to date by watching the cluster network objects.
+	if err := controller.AddToManager(mgr, clusternetwork.NewStore(clusterconfig.network), nodes,
+		clusterCache, payload); err != nil {
 		log.Error(err, "failed to add all Controllers to the Manager")
 		os.Exit(1)
 	}
@@ -188,6 +229,14 @@ func main() {
 	// Add the Metrics Service
 	addMetrics(ctx, cfg, namespace)
 
+	// The operator is available from now on. The conditions reported here are the ones of a cluster without Windows
+	// nodes, and are kept up to date by the WindowsMachineConfig controller once it observes the Windows nodes.
+	wmcometrics.SetPayloadVersions(payload.Versions)
+	if err := reporter.Report(clusteroperator.StatusConditions(&wmcapi.WindowsMachineConfigStatus{}),
+		payload.Versions); err != nil {
+		log.Error(err, "failed to report the operator status")
+	}
+
 	log.Info("starting the Cmd.")
 
 	// Start the Cmd
@@ -258,6 +307,34 @@ func serveCRMetrics(cfg *rest.Config) error {
 	return nil
 }
 
+// requiredFiles returns the files the operator requires for the given cluster network and operator configuration
+func requiredFiles(network clusternetwork.ClusterNetworkConfig, config *wmcapi.WindowsMachineConfigSpec) []string {
+	files := []string{wkl.PrivateKeyPath}
+	for _, file := range []string{
+		wkl.FlannelCNIPluginPath,
+		wkl.WinBridgeCNIPlugin,
+		wkl.KubeletPath,
+		wkl.KubeProxyPath,
+		wkl.KubeNodeVersionPath,
+		wkl.IgnoreWgetPowerShellPath,
+		wkl.WmcbPath,
+	} {
+		files = append(files, wkl.PayloadPath(file, config.PayloadDirectory))
+	}
+	for file := range network.PayloadFiles(config) {
+		files = append(files, file)
+	}
+	return files
+}
+
+// exitWithFailure reports the given startup failure through the ClusterOperator and exits
+func exitWithFailure(reporter *clusteroperator.Reporter, reason string, err error) {
+	if reportErr := reporter.ReportFailure(reason, err); reportErr != nil {
+		log.Error(reportErr, "failed to report the operator status")
+	}
+	os.Exit(1)
+}
+
 // checkIfRequiredFilesExist checks for the existence of required files and binaries before starting WMCO
 // sample error message: errors encountered with required files: could not stat /payload/hybrid-overlay-node.exe:
 // stat /payload/hybrid-overlay-node.exe: no such file or directory, could not stat /payload/wmcb.exe: stat /payload/wmcb.exe:
@@ -291,8 +368,18 @@ func newClusterConfig(config *rest.Config) (*clusterConfig, error) {
 		return nil, errors.Wrap(err, "could not create operator clientset")
 	}
 
+	kubeClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create kubernetes clientset")
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create dynamic client")
+	}
+
 	// get cluster network configurations
-	network, err := clusternetwork.NetworkConfigurationFactory(oclient, operatorClient)
+	network, err := clusternetwork.NetworkConfigurationFactory(oclient, operatorClient, kubeClient, dynamicClient)
 	if err != nil {
 		return nil, errors.Wrap(err, "error getting cluster network")
 	}
@@ -304,6 +391,36 @@ func newClusterConfig(config *rest.Config) (*clusterConfig, error) {
 	}, nil
 }
 
+// watchInfrastructure invalidates the nodeconfig cache whenever the API server URLs in the cluster Infrastructure
+// object change, as the ignition endpoint is derived from them. The informer is started along with the manager.
+func (c *clusterConfig) watchInfrastructure(mgr manager.Manager) error {
+	informerFactory := configinformers.NewSharedInformerFactory(c.oclient, 0)
+	informer := informerFactory.Config().V1().Infrastructures().Informer()
+	informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldInfra, ok := oldObj.(*oconfig.Infrastructure)
+			if !ok {
+				return
+			}
+			newInfra, ok := newObj.(*oconfig.Infrastructure)
+			if !ok {
+				return
+			}
+			if oldInfra.Status.APIServerInternalURL != newInfra.Status.APIServerInternalURL ||
+				oldInfra.Status.APIServerURL != newInfra.Status.APIServerURL {
+				log.Info("cluster Infrastructure API server URLs changed, invalidating nodeconfig cache")
+				nodeconfig.InvalidateCache()
+			}
+		},
+	})
+
+	return mgr.Add(manager.RunnableFunc(func(stop <-chan struct{}) error {
+		informerFactory.Start(stop)
+		<-stop
+		return nil
+	}))
+}
+
 // validateK8sVersion checks for valid k8s version in the cluster. It returns an error for all versions not equal
 // to supported major version. This is being done this way, and not by directly getting the cluster version, as OpenShift CI
 // returns version in the format 0.0.x and not the actual version attached to its clusters.
@@ -0,0 +1,10 @@
+package apis
+
+import (
+	"github.com/openshift/windows-machine-config-operator/pkg/apis/wmc/v1alpha1"
+)
+
+func init() {
+	// Register the types with the Scheme so the components can map objects to GroupVersionKinds and back
+	AddToSchemes = append(AddToSchemes, v1alpha1.SchemeBuilder.AddToScheme)
+}
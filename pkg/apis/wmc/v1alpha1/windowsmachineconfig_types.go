@@ -0,0 +1,314 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WindowsMachineConfigName is the name of the WindowsMachineConfig singleton, any other WindowsMachineConfig is
+// ignored
+const WindowsMachineConfigName = "cluster"
+
+// WindowsMachineConfigSpec defines the desired configuration of the Windows nodes
+type WindowsMachineConfigSpec struct {
+	// Network holds the network settings of the Windows nodes
+	// +optional
+	Network NetworkSpec `json:"network,omitempty"`
+	// KubeProxy holds the kube-proxy settings of the Windows nodes
+	// +optional
+	KubeProxy KubeProxySpec `json:"kubeProxy,omitempty"`
+	// Kubelet holds the kubelet settings of the Windows nodes
+	// +optional
+	Kubelet KubeletSpec `json:"kubelet,omitempty"`
+	// Monitoring holds the settings of the collection of the Windows node metrics by the cluster monitoring
+	// +optional
+	Monitoring MonitoringSpec `json:"monitoring,omitempty"`
+	// HealthCheck holds the settings of the periodic checks of the components run on the configured Windows nodes
+	// +optional
+	HealthCheck HealthCheckSpec `json:"healthCheck,omitempty"`
+	// Bootstrap holds the settings of the ignition config the Windows nodes are bootstrapped from
+	// +optional
+	Bootstrap BootstrapSpec `json:"bootstrap,omitempty"`
+	// Retry holds the settings of the retries and waits done while configuring the Windows nodes
+	// +optional
+	Retry RetrySpec `json:"retry,omitempty"`
+	// Concurrency holds the limits of the work done on several Windows VMs at once
+	// +optional
+	Concurrency ConcurrencySpec `json:"concurrency,omitempty"`
+	// Drain holds the settings of the draining of the Windows nodes before their components are restarted
+	// +optional
+	Drain DrainSpec `json:"drain,omitempty"`
+	// Address holds the policy choosing the address the operator connects to each Windows VM through, among the
+	// internal addresses of its Machine
+	// +optional
+	Address AddressSpec `json:"address,omitempty"`
+	// RemoteDirectories holds the directories used by the operator on the Windows VMs
+	// +optional
+	RemoteDirectories RemoteDirectoriesSpec `json:"remoteDirectories,omitempty"`
+	// PayloadDirectory is the directory of the operator image holding the files copied to the Windows VMs. Defaults
+	// to /payload/.
+	// +optional
+	PayloadDirectory string `json:"payloadDirectory,omitempty"`
+	// SSHUsername is the user the operator connects to the Windows VMs as. Defaults to Administrator.
+	// +optional
+	SSHUsername string `json:"sshUsername,omitempty"`
+}
+
+// BootstrapSpec defines where the Windows nodes get their ignition config from
+type BootstrapSpec struct {
+	// MachineConfigPool is the MachineConfigPool whose ignition config is used to bootstrap the Windows nodes.
+	// Defaults to worker.
+	// +optional
+	MachineConfigPool string `json:"machineConfigPool,omitempty"`
+	// IgnitionEndpoint is a Machine Config Server endpoint overriding the one discovered from the cluster
+	// Infrastructure object. The path of the MachineConfigPool config is appended when the endpoint has no path.
+	// +optional
+	IgnitionEndpoint string `json:"ignitionEndpoint,omitempty"`
+}
+
+// RetrySpec defines how long the operator waits for the Windows nodes to reach the expected state
+type RetrySpec struct {
+	// Count is the number of times an operation run on a Windows VM is attempted. Defaults to 20.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Count int32 `json:"count,omitempty"`
+	// Interval is the time waited between two attempts. Defaults to 15s.
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+	// Timeout is the total time waited for a Windows node to reach the expected state. Defaults to 10m.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// ConcurrencySpec defines how many Windows VMs the operator works on at once. A Windows VM is never configured by two
+// operations at once, whatever the limits.
+type ConcurrencySpec struct {
+	// MaxConfigurations is the number of Windows VMs configured as nodes at once. Defaults to 5.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=20
+	// +optional
+	MaxConfigurations int32 `json:"maxConfigurations,omitempty"`
+	// MaxUploads is the number of payload files copied to the Windows VMs at once, across all the VMs. The uploads
+	// are not limited when unset.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxUploads int32 `json:"maxUploads,omitempty"`
+}
+
+// DrainSpec defines how the pods are moved off a Windows node before a disruptive update, such as a reconfiguration
+// or a kubelet or network update. The pods are evicted through the eviction API, so that PodDisruptionBudgets are
+// honoured, and the node is uncordoned once it is healthy again.
+type DrainSpec struct {
+	// Timeout is the time allowed for the pods of a node to be evicted and deleted. Defaults to 10m.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// Force deletes the pods whose eviction is still blocked by a PodDisruptionBudget once the timeout has elapsed,
+	// instead of failing the update
+	// +optional
+	Force bool `json:"force,omitempty"`
+}
+
+// IPFamily is the family of an IP address
+type IPFamily string
+
+const (
+	// IPv4 selects IPv4 addresses
+	IPv4 IPFamily = "IPv4"
+	// IPv6 selects IPv6 addresses
+	IPv6 IPFamily = "IPv6"
+)
+
+// AddressSpec defines how the address of a Windows VM is chosen among the internal addresses of its Machine. The
+// addresses of the family are kept, those in the first matching network of CIDRs are preferred, and the first of
+// them in the Machine status is chosen. The address the node was configured through is recorded, and the network
+// components of the node are set up again when the chosen address changes.
+type AddressSpec struct {
+	// Family is the family of the chosen address. Defaults to IPv4.
+	// +kubebuilder:validation:Enum=IPv4;IPv6
+	// +optional
+	Family IPFamily `json:"family,omitempty"`
+	// CIDRs restricts the chosen address to the given networks, in order of preference. Any network is allowed when
+	// unset.
+	// +optional
+	CIDRs []string `json:"cidrs,omitempty"`
+	// Interface is the name of the network interface of the Windows VM whose address is chosen, such as Ethernet.
+	// The addresses of the interface are looked up on the VM through the first address matching the other settings.
+	// +optional
+	Interface string `json:"interface,omitempty"`
+}
+
+// RemoteDirectoriesSpec defines the directories used by the operator on the Windows VMs. The directories must be
+// absolute paths ending with a backslash. The Kubernetes directory C:\k\ is set by the bootstrapper and cannot be
+// changed.
+type RemoteDirectoriesSpec struct {
+	// Payload is the directory the payload files are copied to. Defaults to C:\Temp\.
+	// +optional
+	Payload string `json:"payload,omitempty"`
+	// CNI is the directory the CNI plugins and configuration are copied to. Defaults to the cni\ directory of the
+	// payload directory.
+	// +optional
+	CNI string `json:"cni,omitempty"`
+	// Log is the directory the node components log to. Defaults to C:\var\log\.
+	// +optional
+	Log string `json:"log,omitempty"`
+}
+
+// NetworkSpec defines the network settings of the Windows nodes
+type NetworkSpec struct {
+	// MTU is the MTU of the hybrid overlay network on the Windows nodes. It must leave room for the VXLAN header on
+	// the underlying network. When unset, the MTU is chosen by the hybrid overlay.
+	// +kubebuilder:validation:Minimum=576
+	// +kubebuilder:validation:Maximum=9216
+	// +optional
+	MTU uint32 `json:"mtu,omitempty"`
+}
+
+// KubeProxySpec defines the kube-proxy settings of the Windows nodes. Changes are rolled out to the existing nodes by
+// restarting kube-proxy.
+type KubeProxySpec struct {
+	// Verbosity is the log level of kube-proxy. Defaults to 4.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=10
+	// +optional
+	Verbosity *int32 `json:"verbosity,omitempty"`
+	// FeatureGates enables or disables kube-proxy feature gates, in addition to the ones required by the cluster
+	// network. The feature gates must be supported by the kube-proxy version shipped with the operator.
+	// +optional
+	FeatureGates map[string]bool `json:"featureGates,omitempty"`
+	// EnableDSR enables Direct Server Return for the services load balanced by kube-proxy
+	// +optional
+	EnableDSR bool `json:"enableDSR,omitempty"`
+	// ExtraArgs holds additional kube-proxy arguments, mapping the lowercase flag name without the leading dashes to
+	// its value. The values cannot contain whitespace, quotes or shell metacharacters. Flags managed by the operator
+	// cannot be set.
+	// +optional
+	ExtraArgs map[string]string `json:"extraArgs,omitempty"`
+}
+
+// KubeletSpec defines the kubelet settings of the Windows nodes, overriding the configuration generated by the
+// bootstrapper. Changes are rolled out to the existing nodes one at a time, cordoning and draining each node before
+// restarting its kubelet.
+type KubeletSpec struct {
+	// SystemReserved maps resource names to the quantity reserved for the Windows system daemons, for example
+	// cpu: 500m. The supported resources are cpu, memory, ephemeral-storage and pid.
+	// +optional
+	SystemReserved map[string]string `json:"systemReserved,omitempty"`
+	// KubeReserved maps resource names to the quantity reserved for the Kubernetes node components, for example
+	// memory: 1Gi. The supported resources are cpu, memory, ephemeral-storage and pid.
+	// +optional
+	KubeReserved map[string]string `json:"kubeReserved,omitempty"`
+	// EvictionHard maps eviction signals to the threshold at which pods are evicted, for example
+	// memory.available: 500Mi. Thresholds can be quantities or percentages.
+	// +optional
+	EvictionHard map[string]string `json:"evictionHard,omitempty"`
+	// NodeLabels holds extra labels registered by the kubelet with the node. Labels in the kubernetes.io and k8s.io
+	// namespaces are reserved and cannot be set.
+	// +optional
+	NodeLabels map[string]string `json:"nodeLabels,omitempty"`
+	// MaxPods is the maximum number of pods that can run on a node
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxPods *int32 `json:"maxPods,omitempty"`
+	// Verbosity is the log level of the kubelet
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=10
+	// +optional
+	Verbosity *int32 `json:"verbosity,omitempty"`
+}
+
+// MonitoringSpec defines how the metrics of the Windows nodes are collected. The Windows node exporter is run as a
+// service on each Windows node, and the nodes are listed in a Service monitored by the cluster Prometheus.
+type MonitoringSpec struct {
+	// Enabled deploys the Windows node exporter on the Windows nodes and registers them with the cluster monitoring
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+	// Port is the port the Windows node exporter listens on, which is opened in the Windows firewall. Defaults to
+	// 9182.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	Port int32 `json:"port,omitempty"`
+}
+
+// HealthCheckMode defines what the operator does with the Windows node components found unhealthy
+type HealthCheckMode string
+
+const (
+	// HealthCheckRemediate reports the unhealthy components and repairs them
+	HealthCheckRemediate HealthCheckMode = "Remediate"
+	// HealthCheckReport reports the unhealthy components without repairing them
+	HealthCheckReport HealthCheckMode = "Report"
+	// HealthCheckDisabled disables the health checks
+	HealthCheckDisabled HealthCheckMode = "Disabled"
+)
+
+// HealthCheckSpec defines how the operator checks the kubelet, kube-proxy, network and CNI configuration of the
+// configured Windows nodes. The results are reported as conditions of each Windows node, and the repairs as events.
+type HealthCheckSpec struct {
+	// Mode is Remediate to restart or reconfigure the unhealthy components, Report to only report them, or Disabled.
+	// Defaults to Remediate.
+	// +kubebuilder:validation:Enum=Remediate;Report;Disabled
+	// +optional
+	Mode HealthCheckMode `json:"mode,omitempty"`
+	// Interval is the time between two health checks of a Windows node. Defaults to 5m.
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+}
+
+// WindowsMachineConfigStatus defines the observed state of WindowsMachineConfig
+type WindowsMachineConfigStatus struct {
+	// ObservedGeneration is the generation of the WindowsMachineConfig the status was computed for
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// ValidationErrors lists the problems found in the spec. The Windows nodes are not updated until they are fixed.
+	// +optional
+	ValidationErrors []string `json:"validationErrors,omitempty"`
+	// WindowsNodes is the number of Windows nodes in the cluster
+	WindowsNodes int32 `json:"windowsNodes"`
+	// ConfiguredNodes is the number of Windows nodes which have been fully configured by the operator
+	ConfiguredNodes int32 `json:"configuredNodes"`
+	// PausedMachines lists the Windows Machines on which the operator actions are paused by the
+	// windowsmachineconfig.openshift.io/paused annotation
+	// +optional
+	PausedMachines []string `json:"pausedMachines,omitempty"`
+	// QuarantinedMachines lists the Windows Machines which are no longer configured after failing permanently. They
+	// are configured again once the windowsmachineconfig.openshift.io/retry annotation is set on them.
+	// +optional
+	QuarantinedMachines []string `json:"quarantinedMachines,omitempty"`
+	// SupportedWindowsBuilds lists the Windows builds supported by the operator payload. Windows VMs running any other
+	// build are not configured.
+	// +optional
+	SupportedWindowsBuilds []string `json:"supportedWindowsBuilds,omitempty"`
+	// UnsupportedNodes lists the Windows nodes running a Windows build which is not supported by the operator payload,
+	// such as the nodes configured by a previous version of the operator
+	// +optional
+	UnsupportedNodes []string `json:"unsupportedNodes,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// WindowsMachineConfig is the configuration of the Windows Machine Config Operator. It is a cluster scoped singleton
+// named cluster.
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:path=windowsmachineconfigs,scope=Cluster
+// +kubebuilder:printcolumn:name="Windows Nodes",type=integer,JSONPath=`.status.windowsNodes`
+// +kubebuilder:printcolumn:name="Configured",type=integer,JSONPath=`.status.configuredNodes`
+type WindowsMachineConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WindowsMachineConfigSpec   `json:"spec,omitempty"`
+	Status WindowsMachineConfigStatus `json:"status,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// WindowsMachineConfigList contains a list of WindowsMachineConfig
+type WindowsMachineConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []WindowsMachineConfig `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&WindowsMachineConfig{}, &WindowsMachineConfigList{})
+}
@@ -0,0 +1,72 @@
+package clusternetwork
+
+import (
+	"os"
+
+	wmcapi "github.com/openshift/windows-machine-config-operator/pkg/apis/wmc/v1alpha1"
+	"github.com/openshift/windows-machine-config-operator/pkg/cni"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/windowsmachine/windows"
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+)
+
+// Backend holds the Windows node side of a cluster network type. Every supported network type provides a Backend,
+// registered with registerBackend(), so that the Windows node configuration does not depend on the network type.
+type Backend interface {
+	// PayloadFiles returns the payload files the backend requires, mapped to the remote directory they are copied to
+	// on the Windows VMs, for the payload and remote directories of the given operator configuration
+	PayloadFiles(*wmcapi.WindowsMachineConfigSpec) map[string]string
+	// HostSubnet returns the pod subnet assigned to the given node, or an empty string if it has not been assigned
+	HostSubnet(*core.Node) string
+	// ConfigureNode runs the backend setup steps on the given Windows VM of the given node, using the given operator
+	// configuration. The node is ready for the CNI configuration once NodeReady() returns true.
+	ConfigureNode(windows.Windows, *core.Node, *wmcapi.WindowsMachineConfigSpec) error
+	// NodeReady returns true if the backend setup of the given node has completed
+	NodeReady(*core.Node) bool
+	// VerifyNode returns an error if the backend components of the given Windows VM are not running, or if their
+	// setup does not match the network settings of the given operator configuration, in which case ConfigureNode()
+	// must be run again
+	VerifyNode(windows.Windows, *wmcapi.WindowsMachineConfigSpec) error
+	// VerifyKubeProxyNetwork returns an error if the network resources kube-proxy depends on are missing from the
+	// given Windows VM, in which case kube-proxy must be reconfigured with the arguments returned by KubeProxyArgs()
+	VerifyKubeProxyNetwork(windows.Windows, *wmcapi.WindowsMachineConfigSpec) error
+	// CNIConfig returns the CNI configuration of a node with the given pod subnet, with loopback Direct Server Return
+	// enabled if the kube-proxy settings of the given operator configuration enable it
+	CNIConfig(string, *wmcapi.WindowsMachineConfigSpec) (*cni.Config, error)
+	// KubeProxyFeatureGates returns the kube-proxy feature gates required by the network type
+	KubeProxyFeatureGates() map[string]bool
+	// KubeProxyArgs returns the network specific kube-proxy arguments of the given node running on the given
+	// Windows VM, feature gates excluded
+	KubeProxyArgs(windows.Windows, *core.Node, *wmcapi.WindowsMachineConfigSpec) ([]string, error)
+}
+
+// backendFactory returns the ClusterNetworkConfig of a network type from the common cluster network configuration
+type backendFactory func(networkType, *clusterNetworkCfg) ClusterNetworkConfig
+
+// backends maps the supported network types to the factory of their ClusterNetworkConfig
+var backends = map[string]backendFactory{}
+
+// registerBackend makes the given network type supported, using the given factory to build its ClusterNetworkConfig.
+// It is meant to be called from the init() function of the file implementing the backend.
+func registerBackend(name string, factory backendFactory) {
+	if _, found := backends[name]; found {
+		panic("network backend " + name + " registered twice")
+	}
+	backends[name] = factory
+}
+
+// newCNIConfigBuilder returns a CNI config builder based on the template at templatePath if it exists, or on the
+// default win-overlay configuration otherwise
+func newCNIConfigBuilder(templatePath string) (*cni.Builder, error) {
+	if _, err := os.Stat(templatePath); err != nil {
+		if os.IsNotExist(err) {
+			return cni.NewBuilder(), nil
+		}
+		return nil, errors.Wrapf(err, "error checking for CNI config template %s", templatePath)
+	}
+	builder, err := cni.NewBuilderFromTemplate(templatePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "error loading CNI config template")
+	}
+	return builder, nil
+}
@@ -8,14 +8,29 @@ import (
 	operatorv1 "github.com/openshift/client-go/operator/clientset/versioned/typed/operator/v1"
 	"github.com/pkg/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
 )
 
-const ovnKubernetesNetwork = "OVNKubernetes"
+var log = logf.Log.WithName("clusternetwork")
 
-// ClusterNetworkConfig interface contains methods to validate network configuration of a cluster
+// ClusterNetworkConfig interface contains methods to validate network configuration of a cluster and to configure the
+// Windows nodes for it. The Backend methods are only meaningful for configurations that have been validated.
 type ClusterNetworkConfig interface {
 	Validate() error
-	GetServiceCIDR() (string, error)
+	// GetServiceCIDRs returns the cluster service network CIDRs, in the order they are defined in the cluster
+	GetServiceCIDRs() ([]string, error)
+	// GetClusterNetworkCIDRs returns the CIDRs the cluster pod IPs are allocated from
+	GetClusterNetworkCIDRs() ([]string, error)
+	// Equal returns true if the given configuration results in the same Windows node network configuration. It is
+	// only meaningful for configurations that have been validated.
+	Equal(ClusterNetworkConfig) bool
+	// Settings returns the values the Windows node network configuration is generated from, in a form that can be
+	// marshalled to JSON. Configurations that are Equal return the same settings. It is only meaningful for
+	// configurations that have been validated.
+	Settings() interface{}
+	Backend
 }
 
 // networkType holds information for a required network type
@@ -24,85 +39,70 @@ type networkType struct {
 	name string
 	// operatorClient is the OpenShift operator client, we will use to interact with OpenShift operator objects
 	operatorClient operatorv1.OperatorV1Interface
+	// kubeClient is the kubernetes client, used to look up the Windows nodes and the cluster install config
+	kubeClient kubernetes.Interface
+	// dynamicClient is used to read the operator fields missing from the vendored OpenShift API
+	dynamicClient dynamic.Interface
 }
 
 // clusterNetworkCfg struct holds the information for the cluster network
 type clusterNetworkCfg struct {
-	// serviceCIDR holds the value for cluster network service CIDR
-	serviceCIDR string
-}
-
-// ovnKubernetes contains information specific to network type OVNKubernetes
-type ovnKubernetes struct {
-	networkType
-	clusterNetworkConfig *clusterNetworkCfg
+	// serviceCIDRs holds the values for cluster network service CIDRs
+	serviceCIDRs []string
+	// clusterNetworkCIDRs holds the values for the cluster pod network CIDRs
+	clusterNetworkCIDRs []string
+	// machineNetworkCIDRs holds the values for the cluster machine network CIDRs, empty if they are unknown
+	machineNetworkCIDRs []string
 }
 
 // NetworkConfigurationFactory is a factory method that returns information specific to network type
-func NetworkConfigurationFactory(oclient configclient.Interface, operatorClient operatorv1.OperatorV1Interface) (ClusterNetworkConfig, error) {
+func NetworkConfigurationFactory(oclient configclient.Interface, operatorClient operatorv1.OperatorV1Interface,
+	kubeClient kubernetes.Interface, dynamicClient dynamic.Interface) (ClusterNetworkConfig, error) {
 	network, err := getNetworkType(oclient)
 	if err != nil {
 		return nil, errors.Wrap(err, "error getting cluster network type")
 	}
 
-	// retrieve serviceCIDR using cluster config required for cni configurations
-	serviceCIDR, err := getServiceNetworkCIDR(oclient)
-	if err != nil || serviceCIDR == "" {
-		return nil, errors.Wrap(err, "error getting service network CIDR")
+	// retrieve serviceCIDRs using cluster config required for cni configurations
+	serviceCIDRs, err := getServiceNetworkCIDRs(oclient)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting service network CIDRs")
 	}
-	clusterNetworkCfg, err := NewClusterNetworkCfg(serviceCIDR)
+	clusterNetworkCfg, err := NewClusterNetworkCfg(serviceCIDRs)
 	if err != nil {
 		return nil, errors.Wrapf(err, "error getting cluster network config")
 	}
-	switch network {
-	case ovnKubernetesNetwork:
-		return &ovnKubernetes{
-			networkType{
-				name:           network,
-				operatorClient: operatorClient,
-			},
-			clusterNetworkCfg,
-		}, nil
-	default:
+	// retrieve the cluster network CIDRs, which are excluded from NAT in the cni configuration
+	if clusterNetworkCfg.clusterNetworkCIDRs, err = getClusterNetworkCIDRs(oclient); err != nil {
+		return nil, errors.Wrap(err, "error getting cluster network CIDRs")
+	}
+	// retrieve the machine network CIDRs, which the hybrid overlay network must not overlap with
+	if clusterNetworkCfg.machineNetworkCIDRs, err = getMachineNetworkCIDRs(kubeClient); err != nil {
+		return nil, errors.Wrap(err, "error getting machine network CIDRs")
+	}
+	factory, found := backends[network]
+	if !found {
 		return nil, errors.Errorf("%s : network type not supported", network)
 	}
+	return factory(networkType{
+		name:           network,
+		operatorClient: operatorClient,
+		kubeClient:     kubeClient,
+		dynamicClient:  dynamicClient,
+	}, clusterNetworkCfg), nil
 }
 
-// NewClusterNetworkCfg assigns a serviceCIDR value and returns a pointer to the clusterNetworkCfg struct
-func NewClusterNetworkCfg(serviceCIDR string) (*clusterNetworkCfg, error) {
-	if serviceCIDR == "" {
-		return nil, errors.Errorf("can't instantiate cluster network config" +
+// NewClusterNetworkCfg assigns the serviceCIDRs value and returns a pointer to the clusterNetworkCfg struct
+func NewClusterNetworkCfg(serviceCIDRs []string) (*clusterNetworkCfg, error) {
+	if len(serviceCIDRs) == 0 {
+		return nil, errors.Errorf("can't instantiate cluster network config " +
 			"with empty service CIDR value")
 	}
 	return &clusterNetworkCfg{
-		serviceCIDR: serviceCIDR,
+		serviceCIDRs: serviceCIDRs,
 	}, nil
 }
 
-// GetServiceCIDR returns the serviceCIDR string
-func (ovn *ovnKubernetes) GetServiceCIDR() (string, error) {
-	return ovn.clusterNetworkConfig.serviceCIDR, nil
-}
-
-// Validate for OVN Kubernetes checks for network type and hybrid overlay.
-func (ovn *ovnKubernetes) Validate() error {
-	//check if hybrid overlay is enabled for the cluster
-	networkCR, err := ovn.operatorClient.Networks().Get(context.TODO(), "cluster", metav1.GetOptions{})
-	if err != nil {
-		return errors.Wrap(err, "error getting cluster network.operator object")
-	}
-
-	defaultNetwork := networkCR.Spec.DefaultNetwork
-	if defaultNetwork.OVNKubernetesConfig == nil || defaultNetwork.OVNKubernetesConfig.HybridOverlayConfig == nil {
-		return errors.New("cluster is not configured for OVN hybrid networking")
-	}
-
-	if len(networkCR.Spec.DefaultNetwork.OVNKubernetesConfig.HybridOverlayConfig.HybridClusterNetwork) == 0 {
-		return errors.New("invalid OVN hybrid networking configuration")
-	}
-	return nil
-}
-
 // getNetworkType returns network type of the cluster
 func getNetworkType(oclient configclient.Interface) (string, error) {
 	// Get the cluster network object so that we can find the network type
@@ -113,22 +113,100 @@ func getNetworkType(oclient configclient.Interface) (string, error) {
 	return networkCR.Spec.NetworkType, nil
 }
 
-// getServiceNetworkCIDR gets the serviceCIDR using cluster config required for cni configuration
-func getServiceNetworkCIDR(oclient configclient.Interface) (string, error) {
+// getServiceNetworkCIDRs gets the serviceCIDRs using cluster config required for cni configuration
+func getServiceNetworkCIDRs(oclient configclient.Interface) ([]string, error) {
 	// Get the cluster network object so that we can find the service network
 	networkCR, err := oclient.ConfigV1().Networks().Get(context.TODO(), "cluster", metav1.GetOptions{})
 	if err != nil {
-		return "", errors.Wrap(err, "error getting cluster network object")
+		return nil, errors.Wrap(err, "error getting cluster network object")
 	}
 	if len(networkCR.Spec.ServiceNetwork) == 0 {
-		return "", errors.Wrapf(err, "error getting cluster service CIDR,"+
+		return nil, errors.New("error getting cluster service CIDR, " +
 			"received empty value for service networks")
 	}
-	serviceCIDR := networkCR.Spec.ServiceNetwork[0]
-	if ValidateCIDR(serviceCIDR) != nil {
-		return "", errors.Wrapf(err, "invalid cluster service CIDR %s", serviceCIDR)
+	for _, serviceCIDR := range networkCR.Spec.ServiceNetwork {
+		if err := ValidateCIDR(serviceCIDR); err != nil {
+			return nil, errors.Wrapf(err, "invalid cluster service CIDR %s", serviceCIDR)
+		}
+	}
+	return networkCR.Spec.ServiceNetwork, nil
+}
+
+// getClusterNetworkCIDRs gets the cluster network CIDRs from the cluster config
+func getClusterNetworkCIDRs(oclient configclient.Interface) ([]string, error) {
+	networkCR, err := oclient.ConfigV1().Networks().Get(context.TODO(), "cluster", metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting cluster network object")
+	}
+	cidrs := make([]string, 0, len(networkCR.Spec.ClusterNetwork))
+	for _, clusterNetwork := range networkCR.Spec.ClusterNetwork {
+		if err := ValidateCIDR(clusterNetwork.CIDR); err != nil {
+			return nil, errors.Wrapf(err, "invalid cluster network CIDR %s", clusterNetwork.CIDR)
+		}
+		cidrs = append(cidrs, clusterNetwork.CIDR)
+	}
+	return cidrs, nil
+}
+
+// ValidateServiceCIDRs checks that the given service network CIDRs form a combination supported by Windows nodes.
+// Any number of non-overlapping IPv4 service networks is supported, optionally along with a single IPv6 service
+// network for dual-stack clusters. IPv6 single-stack clusters are not supported as the Windows overlay network
+// requires an IPv4 service network.
+func ValidateServiceCIDRs(serviceCIDRs []string) error {
+	if len(serviceCIDRs) == 0 {
+		return errors.New("no service network CIDRs found")
+	}
+	var ipv4Networks, ipv6Networks []*net.IPNet
+	for _, serviceCIDR := range serviceCIDRs {
+		_, ipNet, err := net.ParseCIDR(serviceCIDR)
+		if err != nil {
+			return errors.Wrapf(err, "received invalid CIDR value %s", serviceCIDR)
+		}
+		if ipNet.IP.To4() != nil {
+			ipv4Networks = append(ipv4Networks, ipNet)
+		} else {
+			ipv6Networks = append(ipv6Networks, ipNet)
+		}
 	}
-	return serviceCIDR, nil
+	if len(ipv4Networks) == 0 {
+		return errors.Errorf("IPv6 single-stack service networks %v are not supported on Windows nodes, "+
+			"an IPv4 service network is required", serviceCIDRs)
+	}
+	if len(ipv6Networks) > 1 {
+		return errors.Errorf("multiple IPv6 service networks %v are not supported on Windows nodes, "+
+			"dual-stack clusters must have a single IPv6 service network", ipv6Networks)
+	}
+	for i := range ipv4Networks {
+		for j := i + 1; j < len(ipv4Networks); j++ {
+			if cidrsOverlap(ipv4Networks[i], ipv4Networks[j]) {
+				return errors.Errorf("service networks %s and %s overlap", ipv4Networks[i], ipv4Networks[j])
+			}
+		}
+	}
+	return nil
+}
+
+// IsDualStack returns true if the given service network CIDRs contain both IPv4 and IPv6 networks. The CIDRs are
+// expected to have been validated.
+func IsDualStack(serviceCIDRs []string) bool {
+	var hasIPv4, hasIPv6 bool
+	for _, serviceCIDR := range serviceCIDRs {
+		ip, _, err := net.ParseCIDR(serviceCIDR)
+		if err != nil {
+			continue
+		}
+		if ip.To4() != nil {
+			hasIPv4 = true
+		} else {
+			hasIPv6 = true
+		}
+	}
+	return hasIPv4 && hasIPv6
+}
+
+// cidrsOverlap returns true if either of the given networks contains the other
+func cidrsOverlap(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
 }
 
 // ValidateCIDR uses the parseCIDR from network package to validate the format of the CIDR
@@ -2,7 +2,6 @@ package clusternetwork
 
 import (
 	"context"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"testing"
 
 	v1 "github.com/openshift/api/config/v1"
@@ -13,7 +12,15 @@ import (
 	operatorclient "github.com/openshift/client-go/operator/clientset/versioned/typed/operator/v1"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
 )
 
 // TestNetworkConfigurationFactory tests if NetworkConfigurationFactory function throws appropriate errors
@@ -29,9 +36,10 @@ func TestNetworkConfigurationFactory(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			fakeConfigClient, fakeOperatorClient := createFakeClients(tt.networkType)
+			fakeConfigClient, fakeOperatorClient, fakeKubeClient, fakeDynamicClient := createFakeClients(tt.networkType)
 
-			_, err := NetworkConfigurationFactory(fakeConfigClient, fakeOperatorClient)
+			_, err := NetworkConfigurationFactory(fakeConfigClient, fakeOperatorClient, fakeKubeClient,
+				fakeDynamicClient)
 			if tt.errorMessage == "" {
 				require.Nil(t, err, "Successful check for valid network type")
 			} else {
@@ -62,13 +70,14 @@ func TestNetworkConfigurationValidate(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			fakeConfigClient, fakeOperatorClient := createFakeClients(tt.networkType)
+			fakeConfigClient, fakeOperatorClient, fakeKubeClient, fakeDynamicClient := createFakeClients(tt.networkType)
 			if tt.networkPatch != nil {
 				_, err := fakeOperatorClient.Networks().Patch(context.TODO(), "cluster", k8stypes.MergePatchType, tt.networkPatch, metav1.PatchOptions{})
 				require.Nil(t, err, "network patch should not throw error")
 			}
 
-			network, err := NetworkConfigurationFactory(fakeConfigClient, fakeOperatorClient)
+			network, err := NetworkConfigurationFactory(fakeConfigClient, fakeOperatorClient, fakeKubeClient,
+				fakeDynamicClient)
 			require.Nil(t, err, "networkConfigurationFactory should not throw error")
 			err = network.Validate()
 
@@ -83,10 +92,250 @@ func TestNetworkConfigurationValidate(t *testing.T) {
 	}
 }
 
-// CreateFakeClients is a helper function to create fake OpenShift API config and operator clients
-func createFakeClients(networkType string) (configclient.Interface, operatorclient.OperatorV1Interface) {
+// TestStoreUpdate tests that the Store only reports a change when the service CIDR or hybrid overlay configuration
+// of the validated cluster network configuration changes
+func TestStoreUpdate(t *testing.T) {
+	hybridOverlayPatch := []byte(`{"spec":{"defaultNetwork":{"ovnKubernetesConfig":{"hybridOverlayConfig":` +
+		`{"hybridClusterNetwork":[{"cidr":"10.132.0.0/14","hostPrefix":23}]}}}}}`)
+	fakeConfigClient, fakeOperatorClient, fakeKubeClient, fakeDynamicClient := createFakeClients("OVNKubernetes")
+	_, err := fakeOperatorClient.Networks().Patch(context.TODO(), "cluster", k8stypes.MergePatchType,
+		hybridOverlayPatch, metav1.PatchOptions{})
+	require.Nil(t, err, "network patch should not throw error")
+
+	getValidatedConfig := func() ClusterNetworkConfig {
+		network, err := NetworkConfigurationFactory(fakeConfigClient, fakeOperatorClient, fakeKubeClient,
+			fakeDynamicClient)
+		require.Nil(t, err, "networkConfigurationFactory should not throw error")
+		require.Nil(t, network.Validate(), "network configuration should be valid")
+		return network
+	}
+
+	store := NewStore(getValidatedConfig())
+	assert.False(t, store.Update(getValidatedConfig()), "unchanged configuration reported as changed")
+
+	hybridOverlayPatch = []byte(`{"spec":{"defaultNetwork":{"ovnKubernetesConfig":{"hybridOverlayConfig":` +
+		`{"hybridClusterNetwork":[{"cidr":"10.140.0.0/14","hostPrefix":23}]}}}}}`)
+	_, err = fakeOperatorClient.Networks().Patch(context.TODO(), "cluster", k8stypes.MergePatchType,
+		hybridOverlayPatch, metav1.PatchOptions{})
+	require.Nil(t, err, "network patch should not throw error")
+	assert.True(t, store.Update(getValidatedConfig()), "hybrid overlay change not reported")
+
+	_, err = fakeConfigClient.ConfigV1().Networks().Patch(context.TODO(), "cluster", k8stypes.MergePatchType,
+		[]byte(`{"spec":{"serviceNetwork":["172.40.0.0/16"]}}`), metav1.PatchOptions{})
+	require.Nil(t, err, "network patch should not throw error")
+	assert.True(t, store.Update(getValidatedConfig()), "service CIDR change not reported")
+	serviceCIDRs, err := store.Get().GetServiceCIDRs()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"172.40.0.0/16"}, serviceCIDRs)
+}
+
+// TestValidateServiceCIDRs tests that ValidateServiceCIDRs rejects service network combinations that Windows nodes
+// do not support
+func TestValidateServiceCIDRs(t *testing.T) {
+	var tests = []struct {
+		name         string
+		serviceCIDRs []string
+		dualStack    bool
+		errorMessage string
+	}{
+		{"single IPv4 service network", []string{"172.30.0.0/16"}, false, ""},
+		{"multiple IPv4 service networks", []string{"172.30.0.0/16", "134.20.0.0/16"}, false, ""},
+		{"dual-stack service networks", []string{"172.30.0.0/16", "fd02::/112"}, true, ""},
+		{"dual-stack service networks with IPv6 first", []string{"fd02::/112", "172.30.0.0/16"}, true, ""},
+		{"no service networks", []string{}, false, "no service network CIDRs found"},
+		{"invalid service network", []string{"172.30.0.0"}, false, "received invalid CIDR value 172.30.0.0"},
+		{"IPv6 single-stack service network", []string{"fd02::/112"}, false,
+			"IPv6 single-stack service networks [fd02::/112] are not supported on Windows nodes"},
+		{"multiple IPv6 service networks", []string{"172.30.0.0/16", "fd02::/112", "fd03::/112"}, false,
+			"multiple IPv6 service networks"},
+		{"overlapping IPv4 service networks", []string{"172.30.0.0/16", "172.30.128.0/17"}, false,
+			"service networks 172.30.0.0/16 and 172.30.128.0/17 overlap"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateServiceCIDRs(tt.serviceCIDRs)
+			if tt.errorMessage == "" {
+				require.Nil(t, err, "Successful check for valid service networks")
+				assert.Equal(t, tt.dualStack, IsDualStack(tt.serviceCIDRs))
+			} else {
+				require.Error(t, err, "Function ValidateServiceCIDRs did not throw an error "+
+					"when it was expected to")
+				assert.Contains(t, err.Error(), tt.errorMessage)
+			}
+		})
+	}
+}
+
+// TestHybridOverlayValidation tests that Validate() rejects hybrid overlay configurations which conflict with the
+// cluster or the Windows nodes
+func TestHybridOverlayValidation(t *testing.T) {
+	windowsNode := func(name, kernelVersion string) *core.Node {
+		node := &core.Node{}
+		node.Name = name
+		node.Labels = map[string]string{core.LabelOSStable: "windows"}
+		node.Status.NodeInfo.KernelVersion = kernelVersion
+		return node
+	}
+	var tests = []struct {
+		name                 string
+		hybridClusterNetwork string
+		genevePort           string
+		vxlanPort            int64
+		nodes                []*core.Node
+		errorMessages        []string
+	}{
+		{"valid configuration", `[{"cidr":"10.132.0.0/14","hostPrefix":23}]`, "", 0,
+			[]*core.Node{windowsNode("node1", "10.0.17763.1457")}, nil},
+		{"invalid CIDR", `[{"cidr":"10.132.0.0","hostPrefix":23}]`, "", 0, nil,
+			[]string{`hybridClusterNetwork[0]: invalid CIDR "10.132.0.0"`}},
+		{"CIDR with host bits", `[{"cidr":"10.132.0.1/14","hostPrefix":23}]`, "", 0, nil,
+			[]string{"CIDR 10.132.0.1/14 has host bits set, set cidr to 10.132.0.0/14"}},
+		{"IPv6 CIDR", `[{"cidr":"fd01::/48","hostPrefix":64}]`, "", 0, nil,
+			[]string{"CIDR fd01::/48 is not an IPv4 CIDR"}},
+		{"host prefix shorter than CIDR", `[{"cidr":"10.132.0.0/14","hostPrefix":12}]`, "", 0, nil,
+			[]string{"invalid hostPrefix 12 for CIDR 10.132.0.0/14, set hostPrefix between 15 and 30"}},
+		{"host prefix too long", `[{"cidr":"10.132.0.0/14","hostPrefix":31}]`, "", 0, nil,
+			[]string{"invalid hostPrefix 31 for CIDR 10.132.0.0/14, set hostPrefix between 15 and 30"}},
+		{"overlapping hybrid cluster networks",
+			`[{"cidr":"10.132.0.0/14","hostPrefix":23},{"cidr":"10.133.0.0/16","hostPrefix":23}]`, "", 0, nil,
+			[]string{"hybrid cluster networks 10.132.0.0/14 and 10.133.0.0/16 overlap"}},
+		{"overlap with cluster network", `[{"cidr":"10.128.0.0/14","hostPrefix":23}]`, "", 0, nil,
+			[]string{"overlaps with the cluster network 10.128.0.0/14"}},
+		{"overlap with service network", `[{"cidr":"172.30.0.0/15","hostPrefix":23}]`, "", 0, nil,
+			[]string{"overlaps with the service network 172.30.0.0/16"}},
+		{"overlap with machine network", `[{"cidr":"10.0.0.0/16","hostPrefix":23}]`, "", 0, nil,
+			[]string{"overlaps with the machine network 10.0.0.0/16"}},
+		{"not enough node subnets", `[{"cidr":"10.132.0.0/24","hostPrefix":25}]`, "", 0,
+			[]*core.Node{windowsNode("node1", "10.0.17763.1457"), windowsNode("node2", "10.0.17763.1457")},
+			[]string{"can only be split into 2 node subnets, which does not leave room for the 2 existing " +
+				"Windows nodes and a new one"}},
+		{"custom VXLAN port", `[{"cidr":"10.132.0.0/14","hostPrefix":23}]`, "", 9898,
+			[]*core.Node{windowsNode("node1", "10.0.19041.450")}, nil},
+		{"default VXLAN port on Windows Server 2019", `[{"cidr":"10.132.0.0/14","hostPrefix":23}]`, "", 4789,
+			[]*core.Node{windowsNode("node1", "10.0.17763.1457")}, nil},
+		{"custom VXLAN port on Windows Server 2019", `[{"cidr":"10.132.0.0/14","hostPrefix":23}]`, "", 9898,
+			[]*core.Node{windowsNode("node1", "10.0.17763.1457"), windowsNode("node2", "10.0.19041.450")},
+			[]string{"hybrid overlay VXLAN port 9898 is not supported by Windows nodes node1 (build 17763)"}},
+		{"VXLAN port conflicting with default Geneve port", `[{"cidr":"10.132.0.0/14","hostPrefix":23}]`, "", 6081,
+			nil, []string{"hybrid overlay VXLAN port 6081 conflicts with the OVN Kubernetes Geneve port"}},
+		{"VXLAN port conflicting with custom Geneve port", `[{"cidr":"10.132.0.0/14","hostPrefix":23}]`,
+			`,"genevePort":9000`, 9000, nil,
+			[]string{"hybrid overlay VXLAN port 9000 conflicts with the OVN Kubernetes Geneve port"}},
+		{"multiple errors", `[{"cidr":"10.128.0.0/14","hostPrefix":12},{"cidr":"172.30.0.0/16","hostPrefix":23}]`,
+			"", 0, nil, []string{"invalid hostPrefix 12", "overlaps with the service network 172.30.0.0/16"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeConfigClient, fakeOperatorClient, _, _ := createFakeClients("OVNKubernetes")
+			_, err := fakeConfigClient.ConfigV1().Networks().Patch(context.TODO(), "cluster", k8stypes.MergePatchType,
+				[]byte(`{"spec":{"clusterNetwork":[{"cidr":"10.128.0.0/14","hostPrefix":23}]}}`), metav1.PatchOptions{})
+			require.Nil(t, err, "network patch should not throw error")
+			_, err = fakeOperatorClient.Networks().Patch(context.TODO(), "cluster", k8stypes.MergePatchType,
+				[]byte(`{"spec":{"defaultNetwork":{"ovnKubernetesConfig":{"hybridOverlayConfig":`+
+					`{"hybridClusterNetwork":`+tt.hybridClusterNetwork+`}`+tt.genevePort+`}}}}`), metav1.PatchOptions{})
+			require.Nil(t, err, "network patch should not throw error")
+
+			installConfig := &core.ConfigMap{}
+			installConfig.Name = "cluster-config-v1"
+			installConfig.Namespace = "kube-system"
+			installConfig.Data = map[string]string{"install-config": "networking:\n  machineNetwork:\n" +
+				"  - cidr: 10.0.0.0/16\n"}
+			objects := []runtime.Object{installConfig}
+			for _, node := range tt.nodes {
+				objects = append(objects, node)
+			}
+			fakeKubeClient := k8sfake.NewSimpleClientset(objects...)
+
+			networkOperator := newUnstructuredNetworkOperator()
+			if tt.vxlanPort != 0 {
+				require.Nil(t, unstructured.SetNestedField(networkOperator.Object, tt.vxlanPort, "spec",
+					"defaultNetwork", "ovnKubernetesConfig", "hybridOverlayConfig", "hybridOverlayVXLANPort"))
+			}
+			fakeDynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), networkOperator)
+
+			network, err := NetworkConfigurationFactory(fakeConfigClient, fakeOperatorClient, fakeKubeClient,
+				fakeDynamicClient)
+			require.Nil(t, err, "networkConfigurationFactory should not throw error")
+			err = network.Validate()
+			if len(tt.errorMessages) == 0 {
+				require.Nil(t, err, "Successful check for valid hybrid overlay configuration")
+				vxlanPort, err := network.(*ovnKubernetes).GetHybridOverlayVXLANPort()
+				require.Nil(t, err)
+				if tt.vxlanPort == DefaultVXLANPort {
+					assert.Zero(t, vxlanPort, "default VXLAN port should not be reported as custom")
+				} else {
+					assert.Equal(t, uint32(tt.vxlanPort), vxlanPort)
+				}
+				return
+			}
+			require.Error(t, err, "Function Validate did not throw an error when it was expected to")
+			for _, errorMessage := range tt.errorMessages {
+				assert.Contains(t, err.Error(), errorMessage)
+			}
+		})
+	}
+}
+
+// TestGetMachineNetworkCIDRs tests that the machine network CIDRs are read from the cluster install config
+func TestGetMachineNetworkCIDRs(t *testing.T) {
+	var tests = []struct {
+		name          string
+		installConfig *string
+		expected      []string
+		errorMessage  string
+	}{
+		{"no install config", nil, nil, ""},
+		{"machine networks", stringPtr("networking:\n  machineNetwork:\n  - cidr: 10.0.0.0/16\n" +
+			"  - cidr: 10.1.0.0/16\n"), []string{"10.0.0.0/16", "10.1.0.0/16"}, ""},
+		{"legacy machine CIDR", stringPtr("networking:\n  machineCIDR: 10.0.0.0/16\n"), []string{"10.0.0.0/16"}, ""},
+		{"invalid install config", stringPtr("networking: ["), nil, "error parsing cluster install config"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeKubeClient := k8sfake.NewSimpleClientset()
+			if tt.installConfig != nil {
+				configMap := &core.ConfigMap{}
+				configMap.Name = "cluster-config-v1"
+				configMap.Namespace = "kube-system"
+				configMap.Data = map[string]string{"install-config": *tt.installConfig}
+				fakeKubeClient = k8sfake.NewSimpleClientset(configMap)
+			}
+			cidrs, err := getMachineNetworkCIDRs(fakeKubeClient)
+			if tt.errorMessage != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorMessage)
+				return
+			}
+			require.Nil(t, err)
+			assert.Equal(t, tt.expected, cidrs)
+		})
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+// newUnstructuredNetworkOperator returns the cluster network.operator object, as read by the dynamic client
+func newUnstructuredNetworkOperator() *unstructured.Unstructured {
+	network := &unstructured.Unstructured{}
+	network.SetAPIVersion(operatorv1.GroupVersion.String())
+	network.SetKind("Network")
+	network.SetName("cluster")
+	return network
+}
+
+// createFakeClients is a helper function to create fake OpenShift API config and operator clients, along with fake
+// kubernetes and dynamic clients
+func createFakeClients(networkType string) (configclient.Interface, operatorclient.OperatorV1Interface,
+	kubernetes.Interface, dynamic.Interface) {
 	fakeOperatorClient := fakeoperatorclient.NewSimpleClientset().OperatorV1()
 	fakeConfigClient := fakeconfigclient.NewSimpleClientset()
+	fakeKubeClient := k8sfake.NewSimpleClientset()
+	fakeDynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), newUnstructuredNetworkOperator())
 	serviceNetworks := []string{"172.30.0.0/16", "134.20.0.0/16"}
 
 	testNetworkConfig := &v1.Network{}
@@ -99,11 +348,11 @@ func createFakeClients(networkType string) (configclient.Interface, operatorclie
 
 	_, err := fakeConfigClient.ConfigV1().Networks().Create(context.TODO(), testNetworkConfig, metav1.CreateOptions{})
 	if err != nil {
-		return nil, nil
+		return nil, nil, nil, nil
 	}
 	_, err = fakeOperatorClient.Networks().Create(context.TODO(), testNetworkOperator, metav1.CreateOptions{})
 	if err != nil {
-		return nil, nil
+		return nil, nil, nil, nil
 	}
-	return fakeConfigClient, fakeOperatorClient
+	return fakeConfigClient, fakeOperatorClient, fakeKubeClient, fakeDynamicClient
 }
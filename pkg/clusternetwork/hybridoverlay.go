@@ -0,0 +1,252 @@
+package clusternetwork
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+
+	operatorv1api "github.com/openshift/api/operator/v1"
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// DefaultVXLANPort is the VXLAN port used by the hybrid overlay when no custom port is configured
+	DefaultVXLANPort = 4789
+	// defaultGenevePort is the Geneve port used by OVN Kubernetes when no custom port is configured
+	defaultGenevePort = 6081
+	// minCustomVXLANPortBuild is the first Windows Server build supporting a custom VXLAN port. Windows Server 2019
+	// (build 17763) only supports the default port.
+	minCustomVXLANPortBuild = 18362
+	// maxHostPrefix is the longest host prefix leaving room for pods on a node
+	maxHostPrefix = 30
+	// windowsNodeSelector selects the Windows nodes in the cluster
+	windowsNodeSelector = core.LabelOSStable + "=windows"
+)
+
+// operatorNetworkResource is the resource of the network.operator object
+var operatorNetworkResource = schema.GroupVersionResource{Group: operatorv1api.GroupName, Version: "v1",
+	Resource: "networks"}
+
+// installConfig holds the part of the cluster install config which describes the machine networks
+type installConfig struct {
+	Networking struct {
+		// MachineCIDR is the machine network of clusters installed before machineNetwork was introduced
+		MachineCIDR    string `json:"machineCIDR,omitempty"`
+		MachineNetwork []struct {
+			CIDR string `json:"cidr"`
+		} `json:"machineNetwork,omitempty"`
+	} `json:"networking"`
+}
+
+// getHybridOverlayVXLANPort returns the custom hybrid overlay VXLAN port of the cluster, or nil if the default port is
+// used. The vendored OpenShift API predates the hybridOverlayVXLANPort field, so the network.operator object is read
+// through the dynamic client.
+func getHybridOverlayVXLANPort(dynamicClient dynamic.Interface) (*uint32, error) {
+	network, err := dynamicClient.Resource(operatorNetworkResource).Get(context.TODO(), "cluster",
+		metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting cluster network.operator object")
+	}
+	port, found, err := unstructured.NestedInt64(network.Object, "spec", "defaultNetwork", "ovnKubernetesConfig",
+		"hybridOverlayConfig", "hybridOverlayVXLANPort")
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting hybrid overlay VXLAN port")
+	}
+	if !found {
+		return nil, nil
+	}
+	if port < 1 || port > math.MaxUint16 {
+		return nil, errors.Errorf("invalid hybrid overlay VXLAN port %d, set hybridOverlayVXLANPort to a port "+
+			"between 1 and 65535", port)
+	}
+	vxlanPort := uint32(port)
+	return &vxlanPort, nil
+}
+
+// getMachineNetworkCIDRs returns the machine network CIDRs from the cluster install config. It returns no CIDRs if
+// the install config is not available.
+func getMachineNetworkCIDRs(kubeClient kubernetes.Interface) ([]string, error) {
+	configMap, err := kubeClient.CoreV1().ConfigMaps("kube-system").Get(context.TODO(), "cluster-config-v1",
+		metav1.GetOptions{})
+	if err != nil {
+		if k8sapierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "error getting cluster install config")
+	}
+	config := installConfig{}
+	if err := yaml.Unmarshal([]byte(configMap.Data["install-config"]), &config); err != nil {
+		return nil, errors.Wrap(err, "error parsing cluster install config")
+	}
+	var cidrs []string
+	for _, machineNetwork := range config.Networking.MachineNetwork {
+		cidrs = append(cidrs, machineNetwork.CIDR)
+	}
+	if len(cidrs) == 0 && config.Networking.MachineCIDR != "" {
+		cidrs = append(cidrs, config.Networking.MachineCIDR)
+	}
+	return cidrs, nil
+}
+
+// hybridOverlayValidator validates the hybrid overlay configuration against the rest of the cluster configuration
+type hybridOverlayValidator struct {
+	// hybridClusterNetwork is the hybrid overlay network to validate
+	hybridClusterNetwork []operatorv1api.ClusterNetworkEntry
+	// vxlanPort is the custom VXLAN port, nil if the default port is used
+	vxlanPort *uint32
+	// genevePort is the Geneve port used by OVN Kubernetes, nil if the default port is used
+	genevePort *uint32
+	// reservedCIDRs maps the name of the networks the hybrid overlay network must not overlap with to their CIDRs
+	reservedCIDRs map[string][]string
+	// windowsNodes are the Windows nodes in the cluster
+	windowsNodes []core.Node
+}
+
+// newHybridOverlayValidator returns a hybridOverlayValidator for the given OVN Kubernetes configuration
+func newHybridOverlayValidator(ovnConfig *operatorv1api.OVNKubernetesConfig, vxlanPort *uint32,
+	networkCfg *clusterNetworkCfg, kubeClient kubernetes.Interface) (*hybridOverlayValidator, error) {
+	nodes, err := kubeClient.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{LabelSelector: windowsNodeSelector})
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing Windows nodes")
+	}
+	return &hybridOverlayValidator{
+		hybridClusterNetwork: ovnConfig.HybridOverlayConfig.HybridClusterNetwork,
+		vxlanPort:            vxlanPort,
+		genevePort:           ovnConfig.GenevePort,
+		reservedCIDRs: map[string][]string{
+			"cluster network": networkCfg.clusterNetworkCIDRs,
+			"service network": networkCfg.serviceCIDRs,
+			"machine network": networkCfg.machineNetworkCIDRs,
+		},
+		windowsNodes: nodes.Items,
+	}, nil
+}
+
+// validate returns an actionable error for each problem found in the hybrid overlay configuration
+func (v *hybridOverlayValidator) validate() []error {
+	var errs []error
+	var hybridNetworks []*net.IPNet
+	// nodeSubnets is the number of node subnets the hybrid overlay network can be split into
+	nodeSubnets := 0.0
+	for i, entry := range v.hybridClusterNetwork {
+		ipNet, err := validateHybridClusterNetworkEntry(entry)
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "hybridClusterNetwork[%d]", i))
+			continue
+		}
+		prefixLength, _ := ipNet.Mask.Size()
+		nodeSubnets += math.Pow(2, float64(int(entry.HostPrefix)-prefixLength))
+		hybridNetworks = append(hybridNetworks, ipNet)
+	}
+
+	for i, hybridNetwork := range hybridNetworks {
+		for _, other := range hybridNetworks[i+1:] {
+			if cidrsOverlap(hybridNetwork, other) {
+				errs = append(errs, errors.Errorf("hybrid cluster networks %s and %s overlap, change them so "+
+					"they do not overlap", hybridNetwork, other))
+			}
+		}
+		for _, name := range []string{"cluster network", "service network", "machine network"} {
+			for _, cidr := range v.reservedCIDRs[name] {
+				_, reserved, err := net.ParseCIDR(cidr)
+				if err != nil || !cidrsOverlap(hybridNetwork, reserved) {
+					continue
+				}
+				errs = append(errs, errors.Errorf("hybrid cluster network %s overlaps with the %s %s, choose "+
+					"a hybrid cluster network outside of it", hybridNetwork, name, cidr))
+			}
+		}
+	}
+
+	// Every Windows node gets a subnet of the hybrid overlay network, make sure the next node can get one too
+	if len(hybridNetworks) > 0 && nodeSubnets < float64(len(v.windowsNodes)+1) {
+		errs = append(errs, errors.Errorf("hybrid cluster networks can only be split into %.0f node subnets, "+
+			"which does not leave room for the %d existing Windows nodes and a new one, increase the hostPrefix "+
+			"or use a larger hybrid cluster network", nodeSubnets, len(v.windowsNodes)))
+	}
+
+	if err := v.validateVXLANPort(); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// validateVXLANPort checks that a custom VXLAN port does not conflict with the Geneve port and is supported by the
+// Windows build of every Windows node
+func (v *hybridOverlayValidator) validateVXLANPort() error {
+	if v.vxlanPort == nil || *v.vxlanPort == DefaultVXLANPort {
+		return nil
+	}
+	genevePort := uint32(defaultGenevePort)
+	if v.genevePort != nil {
+		genevePort = *v.genevePort
+	}
+	if *v.vxlanPort == genevePort {
+		return errors.Errorf("hybrid overlay VXLAN port %d conflicts with the OVN Kubernetes Geneve port, set "+
+			"hybridOverlayVXLANPort to a different port", *v.vxlanPort)
+	}
+
+	var unsupported []string
+	for _, node := range v.windowsNodes {
+		build, err := windowsBuild(node.Status.NodeInfo.KernelVersion)
+		if err != nil {
+			log.Info("unable to determine Windows build", "node", node.GetName(), "error", err.Error())
+			continue
+		}
+		if build < minCustomVXLANPortBuild {
+			unsupported = append(unsupported, fmt.Sprintf("%s (build %d)", node.GetName(), build))
+		}
+	}
+	if len(unsupported) > 0 {
+		return errors.Errorf("hybrid overlay VXLAN port %d is not supported by Windows nodes %s which require "+
+			"build %d or later, remove hybridOverlayVXLANPort or replace the nodes", *v.vxlanPort,
+			strings.Join(unsupported, ", "), minCustomVXLANPortBuild)
+	}
+	return nil
+}
+
+// validateHybridClusterNetworkEntry checks that the entry has a valid IPv4 CIDR and a host prefix that splits it into
+// node subnets large enough for pods, returning the parsed CIDR
+func validateHybridClusterNetworkEntry(entry operatorv1api.ClusterNetworkEntry) (*net.IPNet, error) {
+	ip, ipNet, err := net.ParseCIDR(entry.CIDR)
+	if err != nil {
+		return nil, errors.Errorf("invalid CIDR %q, set cidr to a valid IPv4 CIDR", entry.CIDR)
+	}
+	if !ip.Equal(ipNet.IP) {
+		return nil, errors.Errorf("CIDR %s has host bits set, set cidr to %s", entry.CIDR, ipNet)
+	}
+	if ip.To4() == nil {
+		return nil, errors.Errorf("CIDR %s is not an IPv4 CIDR, the hybrid overlay only supports IPv4", entry.CIDR)
+	}
+	prefixLength, _ := ipNet.Mask.Size()
+	if int(entry.HostPrefix) <= prefixLength || entry.HostPrefix > maxHostPrefix {
+		return nil, errors.Errorf("invalid hostPrefix %d for CIDR %s, set hostPrefix between %d and %d",
+			entry.HostPrefix, entry.CIDR, prefixLength+1, maxHostPrefix)
+	}
+	return ipNet, nil
+}
+
+// windowsBuild returns the build number from the kernel version of a Windows node. For example 10.0.17763.1457
+// returns 17763.
+func windowsBuild(kernelVersion string) (int, error) {
+	versionTokens := strings.Split(kernelVersion, ".")
+	if len(versionTokens) < 3 {
+		return 0, errors.Errorf("unexpected kernel version format %q", kernelVersion)
+	}
+	build, err := strconv.Atoi(versionTokens[2])
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid build in kernel version %q", kernelVersion)
+	}
+	return build, nil
+}
@@ -0,0 +1,447 @@
+package clusternetwork
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	operatorv1api "github.com/openshift/api/operator/v1"
+	wmcapi "github.com/openshift/windows-machine-config-operator/pkg/apis/wmc/v1alpha1"
+	"github.com/openshift/windows-machine-config-operator/pkg/cni"
+	wkl "github.com/openshift/windows-machine-config-operator/pkg/controller/wellknownlocations"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/windowsmachine/windows"
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+const (
+	ovnKubernetesNetwork = "OVNKubernetes"
+	// HybridOverlaySubnet is an annotation applied by the cluster network operator which is used by the hybrid overlay
+	HybridOverlaySubnet = "k8s.ovn.org/hybrid-overlay-node-subnet"
+	// HybridOverlayMac is an annotation applied by the hybrid-overlay
+	HybridOverlayMac = "k8s.ovn.org/hybrid-overlay-distributed-router-gateway-mac"
+	// HybridOverlayProcess is the process name of the hybrid-overlay-node.exe in the Windows VM
+	HybridOverlayProcess = "hybrid-overlay-node"
+	// hybridOverlayConfigurationTime is the approximate time taken for the hybrid-overlay to complete reconfiguring
+	// the Windows VM's network
+	hybridOverlayConfigurationTime = 2 * time.Minute
+	// hybridOverlayLogDirName is the name of the hybrid-overlay directory in the remote log directory
+	hybridOverlayLogDirName = "hybrid-overlay\\"
+	// hnsPSModuleName is the name of the hns.psm1 module in the remote payload directory
+	hnsPSModuleName = "hns.psm1"
+	// BaseOVNKubeOverlayNetwork is the name of base OVN HNS Overlay network
+	BaseOVNKubeOverlayNetwork = "BaseOVNKubernetesHybridOverlayNetwork"
+	// OVNKubeOverlayNetwork is the name of the OVN HNS Overlay network
+	OVNKubeOverlayNetwork = cni.HybridOverlayNetworkName
+)
+
+func init() {
+	registerBackend(ovnKubernetesNetwork, func(base networkType, cfg *clusterNetworkCfg) ClusterNetworkConfig {
+		return &ovnKubernetes{networkType: base, clusterNetworkConfig: cfg}
+	})
+}
+
+// ovnKubernetes contains information specific to network type OVNKubernetes
+type ovnKubernetes struct {
+	networkType
+	clusterNetworkConfig *clusterNetworkCfg
+	// hybridOverlayConfig is the hybrid overlay configuration of the cluster, populated by Validate()
+	hybridOverlayConfig *operatorv1api.HybridOverlayConfig
+	// vxlanPort is the custom VXLAN port of the hybrid overlay, 0 when the default port is used. It is populated by
+	// Validate().
+	vxlanPort uint32
+}
+
+// GetServiceCIDRs returns the serviceCIDRs slice
+func (ovn *ovnKubernetes) GetServiceCIDRs() ([]string, error) {
+	return ovn.clusterNetworkConfig.serviceCIDRs, nil
+}
+
+// GetClusterNetworkCIDRs returns the clusterNetworkCIDRs slice
+func (ovn *ovnKubernetes) GetClusterNetworkCIDRs() ([]string, error) {
+	return ovn.clusterNetworkConfig.clusterNetworkCIDRs, nil
+}
+
+// GetHybridClusterNetworkCIDRs returns the CIDRs of the hybrid overlay cluster networks
+func (ovn *ovnKubernetes) GetHybridClusterNetworkCIDRs() ([]string, error) {
+	if ovn.hybridOverlayConfig == nil {
+		return nil, errors.New("hybrid overlay configuration has not been validated")
+	}
+	cidrs := make([]string, 0, len(ovn.hybridOverlayConfig.HybridClusterNetwork))
+	for _, hybridNetwork := range ovn.hybridOverlayConfig.HybridClusterNetwork {
+		cidrs = append(cidrs, hybridNetwork.CIDR)
+	}
+	return cidrs, nil
+}
+
+// GetHybridOverlayVXLANPort returns the custom VXLAN port of the hybrid overlay, 0 when the default port is used
+func (ovn *ovnKubernetes) GetHybridOverlayVXLANPort() (uint32, error) {
+	if ovn.hybridOverlayConfig == nil {
+		return 0, errors.New("hybrid overlay configuration has not been validated")
+	}
+	return ovn.vxlanPort, nil
+}
+
+// Validate for OVN Kubernetes checks for network type, hybrid overlay and the service networks. The hybrid overlay
+// configuration is checked against the cluster, service and machine networks, the number of Windows nodes and their
+// Windows builds, with every problem found returned as part of an aggregate error.
+func (ovn *ovnKubernetes) Validate() error {
+	if err := ValidateServiceCIDRs(ovn.clusterNetworkConfig.serviceCIDRs); err != nil {
+		return errors.Wrap(err, "unsupported service network configuration")
+	}
+
+	//check if hybrid overlay is enabled for the cluster
+	networkCR, err := ovn.operatorClient.Networks().Get(context.TODO(), "cluster", metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrap(err, "error getting cluster network.operator object")
+	}
+
+	defaultNetwork := networkCR.Spec.DefaultNetwork
+	if defaultNetwork.OVNKubernetesConfig == nil || defaultNetwork.OVNKubernetesConfig.HybridOverlayConfig == nil {
+		return errors.New("cluster is not configured for OVN hybrid networking")
+	}
+
+	if len(networkCR.Spec.DefaultNetwork.OVNKubernetesConfig.HybridOverlayConfig.HybridClusterNetwork) == 0 {
+		return errors.New("invalid OVN hybrid networking configuration")
+	}
+
+	vxlanPort, err := getHybridOverlayVXLANPort(ovn.dynamicClient)
+	if err != nil {
+		return err
+	}
+	validator, err := newHybridOverlayValidator(defaultNetwork.OVNKubernetesConfig, vxlanPort,
+		ovn.clusterNetworkConfig, ovn.kubeClient)
+	if err != nil {
+		return errors.Wrap(err, "error validating OVN hybrid networking configuration")
+	}
+	if errs := validator.validate(); len(errs) > 0 {
+		return utilerrors.NewAggregate(errs)
+	}
+	ovn.hybridOverlayConfig = defaultNetwork.OVNKubernetesConfig.HybridOverlayConfig.DeepCopy()
+	ovn.vxlanPort = 0
+	if vxlanPort != nil && *vxlanPort != DefaultVXLANPort {
+		ovn.vxlanPort = *vxlanPort
+	}
+	return nil
+}
+
+// Equal compares the service and cluster network CIDRs, the hybrid overlay configuration and VXLAN port of the given
+// OVN Kubernetes configuration
+func (ovn *ovnKubernetes) Equal(other ClusterNetworkConfig) bool {
+	otherOVN, ok := other.(*ovnKubernetes)
+	if !ok {
+		return false
+	}
+	return reflect.DeepEqual(ovn.clusterNetworkConfig, otherOVN.clusterNetworkConfig) &&
+		reflect.DeepEqual(ovn.hybridOverlayConfig, otherOVN.hybridOverlayConfig) && ovn.vxlanPort == otherOVN.vxlanPort
+}
+
+// ovnKubernetesSettings holds the OVN Kubernetes configuration values compared by Equal
+type ovnKubernetesSettings struct {
+	ServiceCIDRs           []string                           `json:"serviceCIDRs"`
+	ClusterNetworkCIDRs    []string                           `json:"clusterNetworkCIDRs"`
+	MachineNetworkCIDRs    []string                           `json:"machineNetworkCIDRs,omitempty"`
+	HybridOverlayConfig    *operatorv1api.HybridOverlayConfig `json:"hybridOverlayConfig"`
+	HybridOverlayVXLANPort uint32                             `json:"hybridOverlayVXLANPort,omitempty"`
+}
+
+// Settings returns the service, cluster and machine network CIDRs, the hybrid overlay configuration and VXLAN port
+func (ovn *ovnKubernetes) Settings() interface{} {
+	return ovnKubernetesSettings{
+		ServiceCIDRs:           ovn.clusterNetworkConfig.serviceCIDRs,
+		ClusterNetworkCIDRs:    ovn.clusterNetworkConfig.clusterNetworkCIDRs,
+		MachineNetworkCIDRs:    ovn.clusterNetworkConfig.machineNetworkCIDRs,
+		HybridOverlayConfig:    ovn.hybridOverlayConfig,
+		HybridOverlayVXLANPort: ovn.vxlanPort,
+	}
+}
+
+// PayloadFiles returns the hybrid overlay and win-overlay CNI plugin files
+func (ovn *ovnKubernetes) PayloadFiles(config *wmcapi.WindowsMachineConfigSpec) map[string]string {
+	return map[string]string{
+		wkl.PayloadPath(wkl.HybridOverlayPath, config.PayloadDirectory):   config.RemoteDirectories.Payload,
+		wkl.PayloadPath(wkl.HNSPSModule, config.PayloadDirectory):         config.RemoteDirectories.Payload,
+		wkl.PayloadPath(wkl.HostLocalCNIPlugin, config.PayloadDirectory):  config.RemoteDirectories.CNI,
+		wkl.PayloadPath(wkl.WinOverlayCNIPlugin, config.PayloadDirectory): config.RemoteDirectories.CNI,
+	}
+}
+
+// HostSubnet returns the hybrid overlay subnet assigned to the node by the cluster network operator
+func (ovn *ovnKubernetes) HostSubnet(node *core.Node) string {
+	return node.Annotations[HybridOverlaySubnet]
+}
+
+// NodeReady returns true once the hybrid overlay has annotated the node with its distributed router gateway MAC
+func (ovn *ovnKubernetes) NodeReady(node *core.Node) bool {
+	_, found := node.Annotations[HybridOverlayMac]
+	return found
+}
+
+// ConfigureNode (re)starts the hybrid overlay on the Windows VM with the cluster VXLAN port and the configured MTU, and
+// waits for the hybrid overlay HNS networks to match them
+func (ovn *ovnKubernetes) ConfigureNode(vm windows.Windows, node *core.Node,
+	config *wmcapi.WindowsMachineConfigSpec) error {
+	hybridOverlayLogDir := config.RemoteDirectories.Log + hybridOverlayLogDirName
+	if _, err := vm.Run("if not exist "+hybridOverlayLogDir+" mkdir "+hybridOverlayLogDir, false); err != nil {
+		return errors.Wrapf(err, "unable to create remote directory %s", hybridOverlayLogDir)
+	}
+
+	// Check if the hybrid-overlay is running
+	_, err := vm.Run("Get-Process -Name \""+HybridOverlayProcess+"\"", true)
+
+	// err being nil implies that hybrid-overlay is running.
+	if err == nil {
+		// Stop the hybrid-overlay
+		stopCmd := "Stop-Process -Name \"" + HybridOverlayProcess + "\""
+		out, err := vm.Run(stopCmd, true)
+		if err != nil {
+			log.Info("unable to stop hybrid-overlay", "stop command", stopCmd, "output", out)
+			return errors.Wrap(err, "unable to stop hybrid-overlay")
+		}
+	}
+
+	// Start the hybrid-overlay in the background over ssh.
+	// TODO: This will be removed in https://issues.redhat.com/browse/WINC-353
+	go vm.Run(config.RemoteDirectories.Payload+wkl.HybridOverlayName+" "+
+		hybridOverlayArgs(node.GetName(), hybridOverlayLogDir, ovn.vxlanPort, config.Network.MTU), false)
+
+	if err = waitForHybridOverlayToRun(vm, config.Retry); err != nil {
+		return errors.Wrapf(err, "error running %s", wkl.HybridOverlayName)
+	}
+
+	// Wait for the hybrid-overlay to complete reconfiguring the network. The only way to detect that it has completed
+	// the reconfiguration is to check for the HNS networks but doing that without reinitializing the WinRM client
+	// results in 5+ minutes wait times for the vm.Run() call to complete. So the only alternative is to wait before
+	// proceeding.
+	time.Sleep(hybridOverlayConfigurationTime)
+
+	// Running the hybrid-overlay causes network reconfiguration in the Windows VM which results in the ssh connection
+	// being closed and the client is not smart enough to reconnect. We have observed that the WinRM connection does not
+	// get closed and does not need reinitialization.
+	if err = vm.Reinitialize(); err != nil {
+		return errors.Wrap(err, "error reinitializing VM after running hybrid-overlay")
+	}
+
+	if err = waitForHNSNetworks(vm, config.Retry); err != nil {
+		return errors.Wrap(err, "error waiting for OVN HNS networks to be created")
+	}
+
+	if err = ovn.VerifyNode(vm, config); err != nil {
+		return errors.Wrap(err, "hybrid overlay HNS network does not match the configuration")
+	}
+	return nil
+}
+
+// VerifyNode checks that the hybrid overlay is running, that both OVN HNS networks exist, and that the hybrid overlay
+// HNS network uses the cluster VXLAN port and the configured MTU
+func (ovn *ovnKubernetes) VerifyNode(vm windows.Windows, config *wmcapi.WindowsMachineConfigSpec) error {
+	// The hybrid overlay is not a Windows service, so nothing restarts it if it exits
+	if _, err := vm.Run("Get-Process -Name \""+HybridOverlayProcess+"\"", true); err != nil {
+		return errors.Wrap(err, "hybrid-overlay is not running")
+	}
+	out, err := vm.Run("\"(Get-HnsNetwork).Name\"", true)
+	if err != nil {
+		return errors.Wrapf(err, "error listing HNS networks with output: %s", out)
+	}
+	if missing := missingHNSNetworks(out); len(missing) > 0 {
+		return errors.Errorf("HNS networks %s not found", strings.Join(missing, ", "))
+	}
+
+	// The VxlanPort policy is only present when the hybrid overlay uses a custom port. The MTU is the one of the
+	// interface holding the management IP of the network.
+	cmd := "\"$net = (Get-HnsNetwork | where { $_.Name -eq '" + OVNKubeOverlayNetwork + "' }); " +
+		"if (!$net) { throw 'HNS network " + OVNKubeOverlayNetwork + " not found' }; " +
+		"$port = ($net.Policies | where { $_.Type -eq 'VxlanPort' }).Port; " +
+		"$mtu = (Get-NetIPAddress -IPAddress $net.ManagementIP | Get-NetIPInterface).NlMtu; " +
+		"Write-Output \"$port;$mtu\"\""
+	out, err = vm.Run(cmd, true)
+	if err != nil {
+		return errors.Wrapf(err, "error getting HNS network %s settings with output: %s", OVNKubeOverlayNetwork, out)
+	}
+	return verifyHNSNetworkSettings(out, ovn.vxlanPort, config.Network.MTU)
+}
+
+// VerifyKubeProxyNetwork checks that the VIP endpoint providing the kube-proxy source VIP exists. The endpoint is
+// created by KubeProxyArgs() and does not survive the HNS networks being recreated.
+func (ovn *ovnKubernetes) VerifyKubeProxyNetwork(vm windows.Windows, config *wmcapi.WindowsMachineConfigSpec) error {
+	cmd := "\"Import-Module -DisableNameChecking " + config.RemoteDirectories.Payload + hnsPSModuleName + "; " +
+		"$net = (Get-HnsNetwork | where { $_.Name -eq '" + OVNKubeOverlayNetwork + "' }); " +
+		"if (!(Get-HnsEndpoint | where { $_.Name -eq 'VIPEndpoint' -and $_.VirtualNetwork -eq $net.ID })) " +
+		"{ throw 'VIP endpoint not found' }\""
+	if out, err := vm.Run(cmd, true); err != nil {
+		return errors.Wrapf(err, "error checking source VIP endpoint with output: %s", out)
+	}
+	return nil
+}
+
+// CNIConfig returns the win-overlay CNI configuration of a node with the given hybrid overlay subnet, based on the CNI
+// config template of the payload if there is one
+func (ovn *ovnKubernetes) CNIConfig(hostSubnet string, config *wmcapi.WindowsMachineConfigSpec) (*cni.Config, error) {
+	return ovn.cniConfig(hostSubnet, config.KubeProxy.EnableDSR,
+		wkl.PayloadPath(wkl.CNIConfigTemplatePath, config.PayloadDirectory))
+}
+
+// cniConfig returns the win-overlay CNI configuration of a node with the given hybrid overlay subnet, excluding all
+// the cluster networks from NAT. If a CNI config template exists at templatePath, it is used as the base of the
+// configuration.
+func (ovn *ovnKubernetes) cniConfig(hostSubnet string, dsr bool, templatePath string) (*cni.Config, error) {
+	builder, err := newCNIConfigBuilder(templatePath)
+	if err != nil {
+		return nil, err
+	}
+	hybridCIDRs, err := ovn.GetHybridClusterNetworkCIDRs()
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting hybrid cluster network CIDRs")
+	}
+	cniCfg, err := builder.WithHostSubnet(hostSubnet).
+		WithServiceCIDRs(ovn.clusterNetworkConfig.serviceCIDRs).
+		WithNATExceptions(ovn.clusterNetworkConfig.clusterNetworkCIDRs).
+		WithNATExceptions(hybridCIDRs).
+		WithDSR(dsr).
+		Build()
+	if err != nil {
+		return nil, errors.Wrap(err, "error building CNI config")
+	}
+	return cniCfg, nil
+}
+
+// KubeProxyFeatureGates returns the kube-proxy feature gates required by the hybrid overlay and, on dual-stack
+// clusters, by the IPv6 services
+func (ovn *ovnKubernetes) KubeProxyFeatureGates() map[string]bool {
+	featureGates := map[string]bool{"WinOverlay": true}
+	if IsDualStack(ovn.clusterNetworkConfig.serviceCIDRs) {
+		featureGates["IPv6DualStack"] = true
+	}
+	return featureGates
+}
+
+// KubeProxyArgs returns the kube-proxy arguments attaching the services to the hybrid overlay HNS network
+func (ovn *ovnKubernetes) KubeProxyArgs(vm windows.Windows, node *core.Node,
+	config *wmcapi.WindowsMachineConfigSpec) ([]string, error) {
+	sourceVIP, err := getSourceVIP(vm, config.RemoteDirectories.Payload+hnsPSModuleName)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting source VIP")
+	}
+	return []string{"--cluster-cidr=" + ovn.HostSubnet(node),
+		"--network-name=" + OVNKubeOverlayNetwork, "--source-vip=" + sourceVIP}, nil
+}
+
+// hybridOverlayArgs returns the arguments of the hybrid-overlay-node.exe for the given node, log directory, custom
+// VXLAN port and MTU. The port and MTU are only passed when they are set, leaving the hybrid overlay defaults in place
+// otherwise.
+func hybridOverlayArgs(nodeName, logDir string, vxlanPort, mtu uint32) string {
+	args := "--node " + nodeName + " --k8s-kubeconfig " + windows.KubeconfigPath + " --logfile=" +
+		logDir + "hybrid-overlay.log"
+	if vxlanPort != 0 {
+		args += " --hybrid-overlay-vxlan-port=" + strconv.FormatUint(uint64(vxlanPort), 10)
+	}
+	if mtu != 0 {
+		args += " --mtu=" + strconv.FormatUint(uint64(mtu), 10)
+	}
+	return args
+}
+
+// verifyHNSNetworkSettings checks the "<VXLAN port>;<MTU>" settings reported by the Windows VM against the given
+// custom VXLAN port and MTU, where 0 selects the hybrid overlay default
+func verifyHNSNetworkSettings(out string, vxlanPort, mtu uint32) error {
+	settings := strings.Split(strings.TrimSpace(out), ";")
+	if len(settings) != 2 {
+		return errors.Errorf("unexpected HNS network %s settings: %s", OVNKubeOverlayNetwork, out)
+	}
+
+	actualPort := settings[0]
+	if vxlanPort != 0 && actualPort != strconv.FormatUint(uint64(vxlanPort), 10) {
+		return errors.Errorf("HNS network %s uses VXLAN port %q instead of %d", OVNKubeOverlayNetwork, actualPort,
+			vxlanPort)
+	}
+	if vxlanPort == 0 && actualPort != "" && actualPort != strconv.Itoa(DefaultVXLANPort) {
+		return errors.Errorf("HNS network %s uses VXLAN port %s instead of the default port", OVNKubeOverlayNetwork,
+			actualPort)
+	}
+	if mtu != 0 && settings[1] != strconv.FormatUint(uint64(mtu), 10) {
+		return errors.Errorf("HNS network %s uses MTU %q instead of %d", OVNKubeOverlayNetwork, settings[1], mtu)
+	}
+	return nil
+}
+
+// missingHNSNetworks returns the OVN HNS networks missing from the given HNS network names, one per line
+func missingHNSNetworks(out string) []string {
+	found := make(map[string]bool)
+	for _, name := range strings.Split(out, "\n") {
+		found[strings.TrimSpace(name)] = true
+	}
+	var missing []string
+	for _, name := range []string{BaseOVNKubeOverlayNetwork, OVNKubeOverlayNetwork} {
+		if !found[name] {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// waitForHNSNetworks waits for the OVN overlay HNS networks to be created until the given retries are exhausted
+func waitForHNSNetworks(vm windows.Windows, retry wmcapi.RetrySpec) error {
+	var out string
+	var err error
+	for retries := int32(0); retries < retry.Count; retries++ {
+		out, err = vm.Run("Get-HnsNetwork", true)
+		if err != nil {
+			// retry
+			continue
+		}
+
+		if strings.Contains(out, BaseOVNKubeOverlayNetwork) &&
+			strings.Contains(out, OVNKubeOverlayNetwork) {
+			return nil
+		}
+		time.Sleep(retry.Interval.Duration)
+	}
+
+	// OVN overlay HNS networks were not found
+	log.Info("Get-HnsNetwork", "output", out)
+	return errors.Wrap(err, "timeout waiting for OVN overlay HNS networks")
+}
+
+// waitForHybridOverlayToRun waits for the hybrid-overlay-node.exe to run until the given retries are exhausted
+func waitForHybridOverlayToRun(vm windows.Windows, retry wmcapi.RetrySpec) error {
+	var err error
+	for retries := int32(0); retries < retry.Count; retries++ {
+		_, err = vm.Run("Get-Process -Name \""+HybridOverlayProcess+"\"", true)
+		if err == nil {
+			return nil
+		}
+		time.Sleep(retry.Interval.Duration)
+	}
+
+	// hybrid-overlay never started running
+	return fmt.Errorf("timeout waiting for hybrid-overlay: %v", err)
+}
+
+// getSourceVIP returns the source VIP of the VM, creating the VIP endpoint if it does not exist yet with the HNS
+// PowerShell module at the given remote path
+func getSourceVIP(vm windows.Windows, hnsPSModule string) (string, error) {
+	cmd := "\"Import-Module -DisableNameChecking " + hnsPSModule + "; " +
+		"$net = (Get-HnsNetwork | where { $_.Name -eq '" + OVNKubeOverlayNetwork + "' }); " +
+		"$endpoint = (Get-HnsEndpoint | where { $_.Name -eq 'VIPEndpoint' -and $_.VirtualNetwork -eq $net.ID }); " +
+		"if (!$endpoint) { " +
+		"$endpoint = New-HnsEndpoint -NetworkId $net.ID -Name VIPEndpoint; " +
+		"Attach-HNSHostEndpoint -EndpointID $endpoint.ID -CompartmentID 1 }; " +
+		"(Get-NetIPConfiguration -AllCompartments -All -Detailed | " +
+		"where { $_.NetAdapter.LinkLayerAddress -eq $endpoint.MacAddress }).IPV4Address.IPAddress.Trim()\""
+	out, err := vm.Run(cmd, true)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to get source VIP with output: %s", out)
+	}
+
+	// stdout will have trailing '\r\n', so need to trim it
+	sourceVIP := strings.TrimSpace(out)
+	if sourceVIP == "" {
+		return "", fmt.Errorf("source VIP is empty")
+	}
+	return sourceVIP, nil
+}
@@ -0,0 +1,116 @@
+package clusternetwork
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+	"github.com/openshift/windows-machine-config-operator/pkg/cni"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestOVNKubernetesCNIConfig tests that the generated CNI config excludes all cluster networks from NAT, with or
+// without a CNI config template
+func TestOVNKubernetesCNIConfig(t *testing.T) {
+	ovn := &ovnKubernetes{
+		clusterNetworkConfig: &clusterNetworkCfg{
+			serviceCIDRs:        []string{"172.30.0.0/16"},
+			clusterNetworkCIDRs: []string{"10.128.0.0/14"},
+		},
+		hybridOverlayConfig: &operatorv1.HybridOverlayConfig{
+			HybridClusterNetwork: []operatorv1.ClusterNetworkEntry{{CIDR: "10.132.0.0/14", HostPrefix: 23}},
+		},
+	}
+	dir, err := ioutil.TempDir("", "cni-template")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	templatePath := filepath.Join(dir, "cni-conf-template.json")
+	require.NoError(t, ioutil.WriteFile(templatePath, []byte(`{"cniVersion":"0.3.1","name":"custom",`+
+		`"type":"win-overlay","ipam":{"type":"host-local"},"policies":[]}`), 0644))
+
+	var tests = []struct {
+		name         string
+		templatePath string
+		cniVersion   string
+		networkName  string
+	}{
+		{"without template", filepath.Join(dir, "missing.json"), cni.Version, cni.HybridOverlayNetworkName},
+		{"with template", templatePath, "0.3.1", "custom"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ovn.cniConfig("10.132.1.0/24", false, tt.templatePath)
+			require.NoError(t, err)
+			assert.Equal(t, tt.cniVersion, config.CNIVersion)
+			assert.Equal(t, tt.networkName, config.Name)
+			assert.Equal(t, "10.132.1.0/24", config.IPAM.Subnet)
+			require.Len(t, config.Policies, 2)
+			assert.Equal(t, []string{"172.30.0.0/16", "10.128.0.0/14", "10.132.0.0/14"},
+				config.Policies[0].Value.ExceptionList)
+			assert.Equal(t, "172.30.0.0/16", config.Policies[1].Value.DestinationPrefix)
+		})
+	}
+
+	_, err = (&ovnKubernetes{clusterNetworkConfig: ovn.clusterNetworkConfig}).cniConfig("10.132.1.0/24", false, "")
+	require.Error(t, err, "CNI config generated before the hybrid overlay configuration was validated")
+}
+
+// TestHybridOverlayArgs tests that the VXLAN port and MTU are only passed to the hybrid overlay when they are set
+func TestHybridOverlayArgs(t *testing.T) {
+	logDir := "C:\\var\\log\\hybrid-overlay\\"
+	base := "--node node1 --k8s-kubeconfig C:\\k\\kubeconfig --logfile=C:\\var\\log\\hybrid-overlay\\hybrid-overlay.log"
+	assert.Equal(t, base, hybridOverlayArgs("node1", logDir, 0, 0))
+	assert.Equal(t, base+" --hybrid-overlay-vxlan-port=9898 --mtu=1400", hybridOverlayArgs("node1", logDir, 9898, 1400))
+}
+
+// TestVerifyHNSNetworkSettings tests that the HNS network settings reported by the Windows VM are checked against the
+// configured VXLAN port and MTU
+func TestVerifyHNSNetworkSettings(t *testing.T) {
+	var tests = []struct {
+		name         string
+		out          string
+		vxlanPort    uint32
+		mtu          uint32
+		errorMessage string
+	}{
+		{"defaults", ";1500\r\n", 0, 0, ""},
+		{"default port reported", "4789;1500", 0, 0, ""},
+		{"custom port and MTU", "9898;1400\r\n", 9898, 1400, ""},
+		{"custom port missing", ";1500", 9898, 0, `uses VXLAN port "" instead of 9898`},
+		{"unexpected custom port", "9898;1500", 0, 0, "uses VXLAN port 9898 instead of the default port"},
+		{"MTU mismatch", ";1500", 0, 1400, `uses MTU "1500" instead of 1400`},
+		{"unexpected output", "garbage", 0, 0, "unexpected HNS network"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyHNSNetworkSettings(tt.out, tt.vxlanPort, tt.mtu)
+			if tt.errorMessage == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errorMessage)
+		})
+	}
+}
+
+// TestMissingHNSNetworks tests that the OVN HNS networks are matched by their exact name
+func TestMissingHNSNetworks(t *testing.T) {
+	var tests = []struct {
+		name     string
+		out      string
+		expected []string
+	}{
+		{"both networks", "nat\r\n" + BaseOVNKubeOverlayNetwork + "\r\n" + OVNKubeOverlayNetwork + "\r\n", nil},
+		{"overlay network missing", BaseOVNKubeOverlayNetwork + "\r\n", []string{OVNKubeOverlayNetwork}},
+		{"no networks", "", []string{BaseOVNKubeOverlayNetwork, OVNKubeOverlayNetwork}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, missingHNSNetworks(tt.out))
+		})
+	}
+}
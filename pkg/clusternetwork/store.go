@@ -0,0 +1,38 @@
+package clusternetwork
+
+import (
+	"sync"
+)
+
+// Store holds the current, validated cluster network configuration. It allows the configuration to be replaced at
+// runtime while controllers are reading it, and is safe for concurrent use.
+type Store struct {
+	// mutex guards network
+	mutex sync.RWMutex
+	// network is the current cluster network configuration
+	network ClusterNetworkConfig
+}
+
+// NewStore returns a Store holding the given cluster network configuration
+func NewStore(network ClusterNetworkConfig) *Store {
+	return &Store{network: network}
+}
+
+// Get returns the current cluster network configuration
+func (s *Store) Get() ClusterNetworkConfig {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.network
+}
+
+// Update replaces the current cluster network configuration with the given one. It returns true if the given
+// configuration differs from the current one.
+func (s *Store) Update(network ClusterNetworkConfig) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.network != nil && s.network.Equal(network) {
+		return false
+	}
+	s.network = network
+	return true
+}
@@ -0,0 +1,208 @@
+package clusteroperator
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+
+	oconfig "github.com/openshift/api/config/v1"
+	configv1 "github.com/openshift/client-go/config/clientset/versioned/typed/config/v1"
+	wmcapi "github.com/openshift/windows-machine-config-operator/pkg/apis/wmc/v1alpha1"
+	wkl "github.com/openshift/windows-machine-config-operator/pkg/controller/wellknownlocations"
+	"github.com/openshift/windows-machine-config-operator/version"
+	"github.com/pkg/errors"
+	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// Name is the name of the ClusterOperator reporting the health of the operator
+	Name = "windows-machine-config-operator"
+	// operatorVersionName is the name of the version of the operator itself in the ClusterOperator
+	operatorVersionName = "operator"
+	// kubeNodeVersionName is the name of the version of the kubelet and kube-proxy payload in the ClusterOperator
+	kubeNodeVersionName = "kube-node"
+)
+
+// Reasons of the ClusterOperator conditions
+const (
+	// ReasonAsExpected is the reason of the conditions when the operator is working as expected
+	ReasonAsExpected = "AsExpected"
+	// ReasonInvalidClusterConfiguration is the reason of the conditions when the cluster cannot run Windows nodes
+	ReasonInvalidClusterConfiguration = "InvalidClusterConfiguration"
+	// ReasonMissingPayloadFiles is the reason of the conditions when files are missing from the operator payload
+	ReasonMissingPayloadFiles = "MissingPayloadFiles"
+	// ReasonOperatorConfigurationUnavailable is the reason of the conditions when the WindowsMachineConfig cannot be
+	// read
+	ReasonOperatorConfigurationUnavailable = "OperatorConfigurationUnavailable"
+	// ReasonInvalidOperatorConfiguration is the reason of the conditions when the WindowsMachineConfig spec is
+	// invalid
+	ReasonInvalidOperatorConfiguration = "InvalidOperatorConfiguration"
+	// ReasonConfiguringWindowsNodes is the reason of the conditions when Windows nodes are being configured
+	ReasonConfiguringWindowsNodes = "ConfiguringWindowsNodes"
+)
+
+var log = logf.Log.WithName("clusteroperator")
+
+// Reporter reports the health of the operator through its ClusterOperator
+type Reporter struct {
+	// client is used to create and update the ClusterOperator
+	client configv1.ClusterOperatorsGetter
+	// namespace is the namespace the operator runs in, reported as a related object
+	namespace string
+}
+
+// NewReporter returns a Reporter updating the ClusterOperator with the given client, for the operator running in the
+// given namespace
+func NewReporter(client configv1.ClusterOperatorsGetter, namespace string) *Reporter {
+	return &Reporter{client: client, namespace: namespace}
+}
+
+// Versions returns the versions of the operator and of the kubelet and kube-proxy binaries of the given payload
+// directory. The payload version is left out if it cannot be read, as the missing file is reported separately.
+func Versions(payloadDirectory string) []oconfig.OperandVersion {
+	versions := []oconfig.OperandVersion{{Name: operatorVersionName, Version: version.Get()}}
+	content, err := ioutil.ReadFile(wkl.PayloadPath(wkl.KubeNodeVersionPath, payloadDirectory))
+	if err != nil {
+		log.Info("could not read payload version", "error", err.Error())
+		return versions
+	}
+	return append(versions, oconfig.OperandVersion{Name: kubeNodeVersionName,
+		Version: strings.TrimSpace(string(content))})
+}
+
+// relatedObjects returns the objects the must-gather collects to debug the operator
+func (r *Reporter) relatedObjects() []oconfig.ObjectReference {
+	return []oconfig.ObjectReference{
+		{Group: "", Resource: "namespaces", Name: r.namespace},
+		{Group: wmcapi.SchemeGroupVersion.Group, Resource: "windowsmachineconfigs",
+			Name: wmcapi.WindowsMachineConfigName},
+	}
+}
+
+// Report sets the given conditions on the ClusterOperator, creating it if it does not exist. The given versions are
+// only reported when the operator is available, as they signal that the payload has been rolled out.
+func (r *Reporter) Report(conditions []oconfig.ClusterOperatorStatusCondition,
+	versions []oconfig.OperandVersion) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		co, err := r.client.ClusterOperators().Get(context.TODO(), Name, metav1.GetOptions{})
+		if err != nil {
+			if !k8sapierrors.IsNotFound(err) {
+				return errors.Wrapf(err, "error getting ClusterOperator %s", Name)
+			}
+			co, err = r.client.ClusterOperators().Create(context.TODO(),
+				&oconfig.ClusterOperator{ObjectMeta: metav1.ObjectMeta{Name: Name}}, metav1.CreateOptions{})
+			if err != nil {
+				return errors.Wrapf(err, "error creating ClusterOperator %s", Name)
+			}
+		}
+
+		status := co.Status.DeepCopy()
+		now := metav1.Now()
+		for _, condition := range conditions {
+			setCondition(&status.Conditions, condition, now)
+		}
+		status.RelatedObjects = r.relatedObjects()
+		if versions != nil && isTrue(status.Conditions, oconfig.OperatorAvailable) {
+			status.Versions = versions
+		}
+		if reflect.DeepEqual(co.Status, *status) {
+			return nil
+		}
+
+		co.Status = *status
+		if _, err := r.client.ClusterOperators().UpdateStatus(context.TODO(), co, metav1.UpdateOptions{}); err != nil {
+			return errors.Wrapf(err, "error updating ClusterOperator %s status", Name)
+		}
+		log.V(1).Info("updated ClusterOperator status", "conditions", conditions)
+		return nil
+	})
+}
+
+// ReportFailure reports that the operator cannot run for the given reason, which is the cause of the given error
+func (r *Reporter) ReportFailure(reason string, err error) error {
+	return r.Report(FailureConditions(reason, err), nil)
+}
+
+// FailureConditions returns the conditions of an operator which cannot run for the given reason and error
+func FailureConditions(reason string, err error) []oconfig.ClusterOperatorStatusCondition {
+	message := err.Error()
+	return []oconfig.ClusterOperatorStatusCondition{
+		{Type: oconfig.OperatorAvailable, Status: oconfig.ConditionFalse, Reason: reason, Message: message},
+		{Type: oconfig.OperatorProgressing, Status: oconfig.ConditionFalse, Reason: reason, Message: message},
+		{Type: oconfig.OperatorDegraded, Status: oconfig.ConditionTrue, Reason: reason, Message: message},
+		{Type: oconfig.OperatorUpgradeable, Status: oconfig.ConditionFalse, Reason: reason, Message: message},
+	}
+}
+
+// StatusConditions returns the conditions of a running operator whose configuration has the given status. The
+// operator is degraded when its configuration is invalid, and progressing while Windows nodes are being configured.
+// Upgrades are blocked while Windows nodes are being configured, so that the configuration is not interrupted.
+func StatusConditions(status *wmcapi.WindowsMachineConfigStatus) []oconfig.ClusterOperatorStatusCondition {
+	conditions := []oconfig.ClusterOperatorStatusCondition{
+		{Type: oconfig.OperatorAvailable, Status: oconfig.ConditionTrue, Reason: ReasonAsExpected,
+			Message: "Windows nodes can be added to the cluster"},
+	}
+
+	if len(status.ValidationErrors) > 0 {
+		conditions = append(conditions, oconfig.ClusterOperatorStatusCondition{Type: oconfig.OperatorDegraded,
+			Status: oconfig.ConditionTrue, Reason: ReasonInvalidOperatorConfiguration,
+			Message: fmt.Sprintf("WindowsMachineConfig %s is invalid: %s", wmcapi.WindowsMachineConfigName,
+				strings.Join(status.ValidationErrors, ", "))})
+	} else {
+		conditions = append(conditions, oconfig.ClusterOperatorStatusCondition{Type: oconfig.OperatorDegraded,
+			Status: oconfig.ConditionFalse, Reason: ReasonAsExpected})
+	}
+
+	nodesMessage := fmt.Sprintf("%d of %d Windows nodes configured", status.ConfiguredNodes, status.WindowsNodes)
+	if status.ConfiguredNodes < status.WindowsNodes {
+		conditions = append(conditions,
+			oconfig.ClusterOperatorStatusCondition{Type: oconfig.OperatorProgressing, Status: oconfig.ConditionTrue,
+				Reason: ReasonConfiguringWindowsNodes, Message: nodesMessage},
+			oconfig.ClusterOperatorStatusCondition{Type: oconfig.OperatorUpgradeable, Status: oconfig.ConditionFalse,
+				Reason: ReasonConfiguringWindowsNodes, Message: nodesMessage})
+	} else {
+		conditions = append(conditions,
+			oconfig.ClusterOperatorStatusCondition{Type: oconfig.OperatorProgressing, Status: oconfig.ConditionFalse,
+				Reason: ReasonAsExpected, Message: nodesMessage},
+			oconfig.ClusterOperatorStatusCondition{Type: oconfig.OperatorUpgradeable, Status: oconfig.ConditionTrue,
+				Reason: ReasonAsExpected})
+	}
+	return conditions
+}
+
+// setCondition sets the given condition in the given conditions. The transition time is set to now when the status
+// of the condition changes, and kept otherwise.
+func setCondition(conditions *[]oconfig.ClusterOperatorStatusCondition,
+	condition oconfig.ClusterOperatorStatusCondition, now metav1.Time) {
+	for i := range *conditions {
+		existing := &(*conditions)[i]
+		if existing.Type != condition.Type {
+			continue
+		}
+		if existing.Status != condition.Status {
+			existing.Status = condition.Status
+			existing.LastTransitionTime = now
+		}
+		existing.Reason = condition.Reason
+		existing.Message = condition.Message
+		return
+	}
+	condition.LastTransitionTime = now
+	*conditions = append(*conditions, condition)
+}
+
+// isTrue returns true if the condition of the given type is true in the given conditions
+func isTrue(conditions []oconfig.ClusterOperatorStatusCondition,
+	conditionType oconfig.ClusterStatusConditionType) bool {
+	for _, condition := range conditions {
+		if condition.Type == conditionType {
+			return condition.Status == oconfig.ConditionTrue
+		}
+	}
+	return false
+}
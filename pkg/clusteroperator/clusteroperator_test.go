@@ -0,0 +1,95 @@
+package clusteroperator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	oconfig "github.com/openshift/api/config/v1"
+	fakeconfigclient "github.com/openshift/client-go/config/clientset/versioned/fake"
+	wmcapi "github.com/openshift/windows-machine-config-operator/pkg/apis/wmc/v1alpha1"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestStatusConditions tests that the conditions reflect the validation errors and the configured Windows nodes
+func TestStatusConditions(t *testing.T) {
+	var tests = []struct {
+		name                string
+		status              wmcapi.WindowsMachineConfigStatus
+		expectedDegraded    oconfig.ConditionStatus
+		expectedProgressing oconfig.ConditionStatus
+		expectedUpgradeable oconfig.ConditionStatus
+	}{
+		{"no Windows nodes", wmcapi.WindowsMachineConfigStatus{}, oconfig.ConditionFalse, oconfig.ConditionFalse,
+			oconfig.ConditionTrue},
+		{"all Windows nodes configured", wmcapi.WindowsMachineConfigStatus{WindowsNodes: 2, ConfiguredNodes: 2},
+			oconfig.ConditionFalse, oconfig.ConditionFalse, oconfig.ConditionTrue},
+		{"Windows nodes being configured", wmcapi.WindowsMachineConfigStatus{WindowsNodes: 2, ConfiguredNodes: 1},
+			oconfig.ConditionFalse, oconfig.ConditionTrue, oconfig.ConditionFalse},
+		{"invalid configuration",
+			wmcapi.WindowsMachineConfigStatus{ValidationErrors: []string{"invalid sshUsername"}}, oconfig.ConditionTrue,
+			oconfig.ConditionFalse, oconfig.ConditionTrue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conditions := StatusConditions(&tt.status)
+			assert.Equal(t, oconfig.ConditionTrue, conditionStatus(conditions, oconfig.OperatorAvailable))
+			assert.Equal(t, tt.expectedDegraded, conditionStatus(conditions, oconfig.OperatorDegraded))
+			assert.Equal(t, tt.expectedProgressing, conditionStatus(conditions, oconfig.OperatorProgressing))
+			assert.Equal(t, tt.expectedUpgradeable, conditionStatus(conditions, oconfig.OperatorUpgradeable))
+		})
+	}
+}
+
+// TestReport tests that Report creates the ClusterOperator, only reports the versions once the operator is available
+// and only changes the transition time of the conditions whose status changes
+func TestReport(t *testing.T) {
+	client := fakeconfigclient.NewSimpleClientset()
+	reporter := NewReporter(client.ConfigV1(), "openshift-windows-machine-config-operator")
+	versions := []oconfig.OperandVersion{{Name: operatorVersionName, Version: "1.0.0"}}
+
+	require.NoError(t, reporter.ReportFailure(ReasonMissingPayloadFiles,
+		errors.New("could not stat /payload/wmcb.exe")))
+	co, err := client.ConfigV1().ClusterOperators().Get(context.TODO(), Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, oconfig.ConditionTrue, conditionStatus(co.Status.Conditions, oconfig.OperatorDegraded))
+	assert.Equal(t, oconfig.ConditionFalse, conditionStatus(co.Status.Conditions, oconfig.OperatorAvailable))
+	assert.Empty(t, co.Status.Versions)
+	assert.Len(t, co.Status.RelatedObjects, 2)
+
+	// Make the transition times distinguishable from the ones set by the next report
+	past := metav1.NewTime(co.Status.Conditions[0].LastTransitionTime.Add(-time.Hour))
+	for i := range co.Status.Conditions {
+		co.Status.Conditions[i].LastTransitionTime = past
+	}
+	_, err = client.ConfigV1().ClusterOperators().UpdateStatus(context.TODO(), co, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	require.NoError(t, reporter.Report(StatusConditions(&wmcapi.WindowsMachineConfigStatus{}), versions))
+	co, err = client.ConfigV1().ClusterOperators().Get(context.TODO(), Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, oconfig.ConditionTrue, conditionStatus(co.Status.Conditions, oconfig.OperatorAvailable))
+	assert.Equal(t, oconfig.ConditionFalse, conditionStatus(co.Status.Conditions, oconfig.OperatorDegraded))
+	assert.Equal(t, versions, co.Status.Versions)
+	for _, condition := range co.Status.Conditions {
+		if condition.Type == oconfig.OperatorProgressing {
+			assert.Equal(t, past, condition.LastTransitionTime, "unchanged condition has a new transition time")
+		} else {
+			assert.NotEqual(t, past, condition.LastTransitionTime, "changed condition kept its transition time")
+		}
+	}
+}
+
+// conditionStatus returns the status of the condition of the given type in the given conditions
+func conditionStatus(conditions []oconfig.ClusterOperatorStatusCondition,
+	conditionType oconfig.ClusterStatusConditionType) oconfig.ConditionStatus {
+	for _, condition := range conditions {
+		if condition.Type == conditionType {
+			return condition.Status
+		}
+	}
+	return oconfig.ConditionUnknown
+}
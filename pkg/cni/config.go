@@ -0,0 +1,313 @@
+package cni
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// Version is the CNI spec version of the generated configuration
+	Version = "0.2.0"
+	// OverlayPluginType is the type of the win-overlay CNI plugin
+	OverlayPluginType = "win-overlay"
+	// HybridOverlayNetworkName is the name of the HNS network created by the hybrid overlay, which the CNI
+	// configuration attaches the pods to
+	HybridOverlayNetworkName = "OVNKubernetesHybridOverlayNetwork"
+	// hostLocalIPAMType is the type of the host-local IPAM plugin
+	hostLocalIPAMType = "host-local"
+	// endpointPolicyName is the name of all the HNS endpoint policies supported by the win-overlay plugin
+	endpointPolicyName = "EndpointPolicy"
+)
+
+// PolicyType is the type of a HNS endpoint policy
+type PolicyType string
+
+const (
+	// OutBoundNATPolicy is the policy type which NATs the traffic leaving the pods, except for the destinations in its
+	// exception list
+	OutBoundNATPolicy PolicyType = "OutBoundNAT"
+	// RoutePolicy is the policy type which routes the traffic to its destination prefix through the overlay
+	RoutePolicy PolicyType = "ROUTE"
+	// ACLPolicy is the policy type which allows or blocks the traffic matching its rule
+	ACLPolicy PolicyType = "ACL"
+)
+
+// supportedVersions is the list of CNI spec versions the win-overlay plugin accepts
+var supportedVersions = []string{"0.1.0", "0.2.0", "0.3.0", "0.3.1", "0.4.0"}
+
+// networkNameRegexp matches valid CNI network names, as defined by the CNI spec
+var networkNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.\-]*$`)
+
+// Config is the configuration of the win-overlay CNI plugin
+type Config struct {
+	CNIVersion   string       `json:"cniVersion"`
+	Name         string       `json:"name"`
+	Type         string       `json:"type"`
+	Capabilities Capabilities `json:"capabilities"`
+	IPAM         IPAM         `json:"ipam"`
+	Policies     []Policy     `json:"policies"`
+	// LoopbackDSR enables Direct Server Return for the traffic of a pod to itself through a service
+	LoopbackDSR bool `json:"loopbackDSR,omitempty"`
+}
+
+// Capabilities holds the capabilities the CNI plugin supports
+type Capabilities struct {
+	DNS bool `json:"dns"`
+}
+
+// IPAM holds the IP address management configuration
+type IPAM struct {
+	Type   string `json:"type"`
+	Subnet string `json:"subnet"`
+}
+
+// Policy is a HNS endpoint policy applied to every pod endpoint
+type Policy struct {
+	Name  string      `json:"name"`
+	Value PolicyValue `json:"value"`
+}
+
+// PolicyValue holds the settings of a HNS endpoint policy. Only the fields relevant to the policy type are set.
+type PolicyValue struct {
+	Type              PolicyType `json:"Type"`
+	ExceptionList     []string   `json:"ExceptionList,omitempty"`
+	DestinationPrefix string     `json:"DestinationPrefix,omitempty"`
+	NeedEncap         bool       `json:"NeedEncap,omitempty"`
+	Action            string     `json:"Action,omitempty"`
+	Direction         string     `json:"Direction,omitempty"`
+	Protocols         string     `json:"Protocols,omitempty"`
+	LocalPorts        string     `json:"LocalPorts,omitempty"`
+	RemoteAddresses   string     `json:"RemoteAddresses,omitempty"`
+	RemotePorts       string     `json:"RemotePorts,omitempty"`
+	Priority          int        `json:"Priority,omitempty"`
+}
+
+// Builder builds a win-overlay CNI configuration. The zero value is not usable, use NewBuilder() or
+// NewBuilderFromTemplate() instead.
+type Builder struct {
+	// config is the configuration being built
+	config Config
+	// hostSubnet is the subnet the pods on the node are assigned IPs from
+	hostSubnet string
+	// serviceCIDRs are the service networks, which are routed through the overlay and excluded from NAT
+	serviceCIDRs []string
+	// natExceptions are the additional destinations excluded from NAT, such as the cluster networks
+	natExceptions []string
+	// policies are the optional policies added to the configuration
+	policies []Policy
+	// loopbackDSR enables Direct Server Return in the configuration
+	loopbackDSR bool
+}
+
+// NewBuilder returns a Builder for the default win-overlay CNI configuration attaching pods to the hybrid overlay
+// network
+func NewBuilder() *Builder {
+	return &Builder{
+		config: Config{
+			CNIVersion:   Version,
+			Name:         HybridOverlayNetworkName,
+			Type:         OverlayPluginType,
+			Capabilities: Capabilities{DNS: true},
+			IPAM:         IPAM{Type: hostLocalIPAMType},
+		},
+	}
+}
+
+// NewBuilderFromTemplate returns a Builder for the CNI configuration in the given template file. The policies in the
+// template are kept, with the OutBoundNAT and ROUTE policies completed by the Builder.
+func NewBuilderFromTemplate(templatePath string) (*Builder, error) {
+	template, err := ioutil.ReadFile(templatePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading CNI config template from %s", templatePath)
+	}
+	b := NewBuilder()
+	if err := json.Unmarshal(template, &b.config); err != nil {
+		return nil, errors.Wrapf(err, "error parsing CNI config template %s", templatePath)
+	}
+	return b, nil
+}
+
+// WithHostSubnet sets the subnet the pods on the node are assigned IPs from
+func (b *Builder) WithHostSubnet(hostSubnet string) *Builder {
+	b.hostSubnet = hostSubnet
+	return b
+}
+
+// WithServiceCIDRs sets the service networks. Every service network is excluded from NAT and routed through the
+// overlay.
+func (b *Builder) WithServiceCIDRs(serviceCIDRs []string) *Builder {
+	b.serviceCIDRs = append(b.serviceCIDRs, serviceCIDRs...)
+	return b
+}
+
+// WithNATExceptions excludes the given networks from NAT, such as the cluster and hybrid overlay networks
+func (b *Builder) WithNATExceptions(cidrs []string) *Builder {
+	b.natExceptions = append(b.natExceptions, cidrs...)
+	return b
+}
+
+// WithACLPolicy adds the given ACL policy to the configuration
+func (b *Builder) WithACLPolicy(acl PolicyValue) *Builder {
+	acl.Type = ACLPolicy
+	return b.WithEndpointPolicy(acl)
+}
+
+// WithEndpointPolicy adds the given endpoint policy to the configuration
+func (b *Builder) WithEndpointPolicy(value PolicyValue) *Builder {
+	b.policies = append(b.policies, Policy{Name: endpointPolicyName, Value: value})
+	return b
+}
+
+// WithDSR enables or disables loopback Direct Server Return
+func (b *Builder) WithDSR(enabled bool) *Builder {
+	b.loopbackDSR = enabled
+	return b
+}
+
+// Build returns the CNI configuration, after validating it against the CNI spec and the win-overlay plugin
+// requirements
+func (b *Builder) Build() (*Config, error) {
+	if b.hostSubnet == "" {
+		return nil, errors.New("can't build CNI config with empty hostSubnet")
+	}
+	if len(b.serviceCIDRs) == 0 {
+		return nil, errors.New("can't build CNI config without service CIDRs")
+	}
+
+	config := b.config
+	config.IPAM.Subnet = b.hostSubnet
+	config.LoopbackDSR = config.LoopbackDSR || b.loopbackDSR
+
+	// The template route policies are replaced with one route policy per service network, while the exceptions of an
+	// existing OutBoundNAT policy are completed. Any other policy is kept as is.
+	natIndex := -1
+	var routeTemplate *PolicyValue
+	policies := make([]Policy, 0, len(config.Policies)+len(b.serviceCIDRs)+len(b.policies))
+	for _, policy := range config.Policies {
+		switch policy.Value.Type {
+		case OutBoundNATPolicy:
+			if natIndex != -1 {
+				return nil, errors.New("CNI config template has more than one OutBoundNAT policy")
+			}
+			natIndex = len(policies)
+			policy.Value.ExceptionList = nil
+		case RoutePolicy:
+			if routeTemplate == nil {
+				value := policy.Value
+				routeTemplate = &value
+			}
+			continue
+		}
+		policies = append(policies, policy)
+	}
+	if natIndex == -1 {
+		natIndex = len(policies)
+		policies = append(policies, Policy{Name: endpointPolicyName, Value: PolicyValue{Type: OutBoundNATPolicy}})
+	}
+	policies[natIndex].Value.ExceptionList = uniqueCIDRs(append(append([]string{}, b.serviceCIDRs...),
+		b.natExceptions...))
+
+	if routeTemplate == nil {
+		routeTemplate = &PolicyValue{Type: RoutePolicy, NeedEncap: true}
+	}
+	for _, serviceCIDR := range b.serviceCIDRs {
+		route := *routeTemplate
+		route.DestinationPrefix = serviceCIDR
+		policies = append(policies, Policy{Name: endpointPolicyName, Value: route})
+	}
+	config.Policies = append(policies, b.policies...)
+
+	if err := config.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid CNI config")
+	}
+	return &config, nil
+}
+
+// Validate checks the configuration against the CNI spec and the win-overlay plugin requirements
+func (c *Config) Validate() error {
+	if !isSupportedVersion(c.CNIVersion) {
+		return errors.Errorf("unsupported cniVersion %q, supported versions are %s", c.CNIVersion,
+			strings.Join(supportedVersions, ", "))
+	}
+	if !networkNameRegexp.MatchString(c.Name) {
+		return errors.Errorf("invalid network name %q", c.Name)
+	}
+	if c.Type != OverlayPluginType {
+		return errors.Errorf("invalid plugin type %q, expected %s", c.Type, OverlayPluginType)
+	}
+	if c.IPAM.Type == "" {
+		return errors.New("IPAM type must be set")
+	}
+	if _, _, err := net.ParseCIDR(c.IPAM.Subnet); err != nil {
+		return errors.Wrapf(err, "invalid IPAM subnet %q", c.IPAM.Subnet)
+	}
+	for i, policy := range c.Policies {
+		if err := policy.validate(); err != nil {
+			return errors.Wrapf(err, "invalid policy %d", i)
+		}
+	}
+	return nil
+}
+
+// Marshal returns the JSON encoding of the configuration
+func (c *Config) Marshal() ([]byte, error) {
+	return json.Marshal(c)
+}
+
+// validate checks that the policy has the fields required by its type
+func (p *Policy) validate() error {
+	if p.Name != endpointPolicyName {
+		return errors.Errorf("unsupported policy name %q", p.Name)
+	}
+	switch p.Value.Type {
+	case OutBoundNATPolicy:
+		for _, cidr := range p.Value.ExceptionList {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return errors.Wrapf(err, "invalid OutBoundNAT exception %q", cidr)
+			}
+		}
+	case RoutePolicy:
+		if _, _, err := net.ParseCIDR(p.Value.DestinationPrefix); err != nil {
+			return errors.Wrapf(err, "invalid ROUTE destination prefix %q", p.Value.DestinationPrefix)
+		}
+	case ACLPolicy:
+		if p.Value.Action != "Allow" && p.Value.Action != "Block" {
+			return errors.Errorf("invalid ACL action %q, expected Allow or Block", p.Value.Action)
+		}
+		if p.Value.Direction != "In" && p.Value.Direction != "Out" {
+			return errors.Errorf("invalid ACL direction %q, expected In or Out", p.Value.Direction)
+		}
+	case "":
+		return errors.New("policy type must be set")
+	}
+	return nil
+}
+
+// isSupportedVersion returns true if the given CNI spec version is supported by the win-overlay plugin
+func isSupportedVersion(version string) bool {
+	for _, supported := range supportedVersions {
+		if version == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// uniqueCIDRs returns the given CIDRs with the duplicates removed, preserving their order
+func uniqueCIDRs(cidrs []string) []string {
+	seen := make(map[string]bool, len(cidrs))
+	unique := make([]string, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if seen[cidr] {
+			continue
+		}
+		seen[cidr] = true
+		unique = append(unique, cidr)
+	}
+	return unique
+}
@@ -0,0 +1,140 @@
+package cni
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestBuild tests that Build generates the expected policies for the given networks
+func TestBuild(t *testing.T) {
+	config, err := NewBuilder().
+		WithHostSubnet("10.132.0.0/24").
+		WithServiceCIDRs([]string{"172.30.0.0/16", "fd02::/112"}).
+		WithNATExceptions([]string{"10.128.0.0/14", "172.30.0.0/16"}).
+		WithNATExceptions([]string{"10.132.0.0/14"}).
+		Build()
+	require.NoError(t, err)
+
+	assert.Equal(t, Version, config.CNIVersion)
+	assert.Equal(t, HybridOverlayNetworkName, config.Name)
+	assert.Equal(t, OverlayPluginType, config.Type)
+	assert.Equal(t, "10.132.0.0/24", config.IPAM.Subnet)
+	require.Len(t, config.Policies, 3)
+	assert.Equal(t, OutBoundNATPolicy, config.Policies[0].Value.Type)
+	assert.Equal(t, []string{"172.30.0.0/16", "fd02::/112", "10.128.0.0/14", "10.132.0.0/14"},
+		config.Policies[0].Value.ExceptionList)
+	assert.Equal(t, PolicyValue{Type: RoutePolicy, DestinationPrefix: "172.30.0.0/16", NeedEncap: true},
+		config.Policies[1].Value)
+	assert.Equal(t, PolicyValue{Type: RoutePolicy, DestinationPrefix: "fd02::/112", NeedEncap: true},
+		config.Policies[2].Value)
+}
+
+// TestBuildOptionalPolicies tests that the optional policies and DSR are added to the configuration
+func TestBuildOptionalPolicies(t *testing.T) {
+	acl := PolicyValue{Action: "Block", Direction: "Out", Protocols: "6", RemoteAddresses: "169.254.169.254/32",
+		Priority: 100}
+	config, err := NewBuilder().
+		WithHostSubnet("10.132.0.0/24").
+		WithServiceCIDRs([]string{"172.30.0.0/16"}).
+		WithACLPolicy(acl).
+		WithDSR(true).
+		Build()
+	require.NoError(t, err)
+
+	assert.True(t, config.LoopbackDSR)
+	require.Len(t, config.Policies, 3)
+	acl.Type = ACLPolicy
+	assert.Equal(t, acl, config.Policies[2].Value)
+
+	_, err = NewBuilder().
+		WithHostSubnet("10.132.0.0/24").
+		WithServiceCIDRs([]string{"172.30.0.0/16"}).
+		WithACLPolicy(PolicyValue{Action: "Deny", Direction: "Out"}).
+		Build()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid ACL action")
+}
+
+// TestBuildFromTemplate tests that the policies of a template are found by type, whatever their order
+func TestBuildFromTemplate(t *testing.T) {
+	template := `{
+	"CniVersion":"0.2.0",
+	"Name":"OVNKubernetesHybridOverlayNetwork",
+	"Type":"win-overlay",
+	"Capabilities":{"Dns":true},
+	"Ipam":{"Type":"host-local","Subnet":"ovn_host_subnet"},
+	"Policies":[
+		{"Name":"EndpointPolicy","Value":{"Type":"ROUTE","DestinationPrefix":"service_network_cidr","NeedEncap":true}},
+		{"Name":"EndpointPolicy","Value":{"Type":"ACL","Action":"Allow","Direction":"In","Priority":200}},
+		{"Name":"EndpointPolicy","Value":{"Type":"OutBoundNAT","ExceptionList":["service_network_cidr"]}}
+	]
+}`
+	dir, err := ioutil.TempDir("", "cni")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	templatePath := filepath.Join(dir, "cni-conf-template.json")
+	require.NoError(t, ioutil.WriteFile(templatePath, []byte(template), 0644))
+
+	builder, err := NewBuilderFromTemplate(templatePath)
+	require.NoError(t, err)
+	config, err := builder.WithHostSubnet("10.132.0.0/24").WithServiceCIDRs([]string{"172.30.0.0/16"}).Build()
+	require.NoError(t, err)
+
+	require.Len(t, config.Policies, 3)
+	assert.Equal(t, ACLPolicy, config.Policies[0].Value.Type)
+	assert.Equal(t, OutBoundNATPolicy, config.Policies[1].Value.Type)
+	assert.Equal(t, []string{"172.30.0.0/16"}, config.Policies[1].Value.ExceptionList)
+	assert.Equal(t, RoutePolicy, config.Policies[2].Value.Type)
+	assert.Equal(t, "172.30.0.0/16", config.Policies[2].Value.DestinationPrefix)
+}
+
+// TestBuildErrors tests that Build rejects incomplete or invalid configurations
+func TestBuildErrors(t *testing.T) {
+	var tests = []struct {
+		name         string
+		builder      *Builder
+		errorMessage string
+	}{
+		{"empty host subnet", NewBuilder().WithServiceCIDRs([]string{"172.30.0.0/16"}),
+			"can't build CNI config with empty hostSubnet"},
+		{"no service CIDRs", NewBuilder().WithHostSubnet("10.132.0.0/24"),
+			"can't build CNI config without service CIDRs"},
+		{"invalid host subnet", NewBuilder().WithHostSubnet("ovn_host_subnet").
+			WithServiceCIDRs([]string{"172.30.0.0/16"}), "invalid IPAM subnet"},
+		{"invalid NAT exception", NewBuilder().WithHostSubnet("10.132.0.0/24").
+			WithServiceCIDRs([]string{"172.30.0.0/16"}).WithNATExceptions([]string{"10.128.0.0"}),
+			"invalid OutBoundNAT exception"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.builder.Build()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errorMessage)
+		})
+	}
+}
+
+// TestValidate tests that Validate enforces the CNI spec
+func TestValidate(t *testing.T) {
+	valid := Config{CNIVersion: Version, Name: HybridOverlayNetworkName, Type: OverlayPluginType,
+		IPAM: IPAM{Type: hostLocalIPAMType, Subnet: "10.132.0.0/24"}}
+	require.NoError(t, valid.Validate())
+
+	invalidVersion := valid
+	invalidVersion.CNIVersion = "1.5.0"
+	assert.Error(t, invalidVersion.Validate())
+
+	invalidName := valid
+	invalidName.Name = "-network"
+	assert.Error(t, invalidName.Validate())
+
+	invalidType := valid
+	invalidType.Type = "win-bridge"
+	assert.Error(t, invalidType.Validate())
+}
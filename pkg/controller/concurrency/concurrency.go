@@ -0,0 +1,94 @@
+package concurrency
+
+import (
+	"sync"
+)
+
+// Limiter limits the number of operations running at once. The limit is given by each operation when it starts, so
+// that it can be changed while operations are running. It is safe for concurrent use.
+type Limiter struct {
+	// mutex guards running
+	mutex sync.Mutex
+	// released is signaled whenever an operation completes
+	released *sync.Cond
+	// running is the number of operations running
+	running int
+}
+
+// NewLimiter returns a Limiter with no operation running
+func NewLimiter() *Limiter {
+	l := &Limiter{}
+	l.released = sync.NewCond(&l.mutex)
+	return l
+}
+
+// Acquire waits until fewer than limit operations are running, and starts an operation. The operations are not
+// limited if limit is 0 or less. Release must be called once the operation completes.
+func (l *Limiter) Acquire(limit int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for limit > 0 && l.running >= limit {
+		l.released.Wait()
+	}
+	l.running++
+}
+
+// Release completes an operation started by Acquire
+func (l *Limiter) Release() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.running--
+	l.released.Broadcast()
+}
+
+// KeyedMutex is a set of mutexes identified by a key, such as the instance ID of a Windows VM. The mutexes are only
+// held in memory while they are locked or waited for. It is safe for concurrent use.
+type KeyedMutex struct {
+	// mutex guards locks
+	mutex sync.Mutex
+	// locks holds the mutexes which are locked or waited for
+	locks map[string]*keyedLock
+}
+
+// keyedLock is a mutex of a KeyedMutex
+type keyedLock struct {
+	sync.Mutex
+	// refs is the number of callers holding or waiting for the mutex
+	refs int
+}
+
+// NewKeyedMutex returns a KeyedMutex with no mutex locked
+func NewKeyedMutex() *KeyedMutex {
+	return &KeyedMutex{locks: make(map[string]*keyedLock)}
+}
+
+// Lock waits until the mutex with the given key is unlocked, and locks it. It returns the function unlocking it.
+func (k *KeyedMutex) Lock(key string) func() {
+	k.mutex.Lock()
+	lock, found := k.locks[key]
+	if !found {
+		lock = &keyedLock{}
+		k.locks[key] = lock
+	}
+	lock.refs++
+	k.mutex.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		k.mutex.Lock()
+		defer k.mutex.Unlock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(k.locks, key)
+		}
+	}
+}
+
+// IsLocked returns true if the mutex with the given key is locked or waited for
+func (k *KeyedMutex) IsLocked(key string) bool {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	_, found := k.locks[key]
+	return found
+}
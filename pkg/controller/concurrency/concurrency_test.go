@@ -0,0 +1,87 @@
+package concurrency
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLimiter tests that no more operations than the limit given by each of them run at once
+func TestLimiter(t *testing.T) {
+	var tests = []struct {
+		name        string
+		limit       int
+		operations  int
+		expectedMax int
+	}{
+		{"limited", 2, 6, 2},
+		{"unlimited", 0, 6, 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := NewLimiter()
+			var mutex sync.Mutex
+			running, max := 0, 0
+			// All the operations wait for each other until the expected number of them run at once
+			started := make(chan struct{})
+			var once sync.Once
+			var wg sync.WaitGroup
+			for i := 0; i < tt.operations; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					limiter.Acquire(tt.limit)
+					defer limiter.Release()
+					mutex.Lock()
+					running++
+					if running > max {
+						max = running
+					}
+					if running == tt.expectedMax {
+						once.Do(func() { close(started) })
+					}
+					mutex.Unlock()
+					<-started
+					time.Sleep(10 * time.Millisecond)
+					mutex.Lock()
+					running--
+					mutex.Unlock()
+				}()
+			}
+			wg.Wait()
+			assert.Equal(t, tt.expectedMax, max)
+		})
+	}
+}
+
+// TestKeyedMutex tests that the mutexes with the same key exclude each other, and the ones with different keys do not,
+// and that they are reported locked while they are held
+func TestKeyedMutex(t *testing.T) {
+	mutex := NewKeyedMutex()
+	unlock := mutex.Lock("i-1")
+	assert.True(t, mutex.IsLocked("i-1"))
+
+	// A different key is not blocked
+	mutex.Lock("i-2")()
+	assert.False(t, mutex.IsLocked("i-2"))
+
+	locked := make(chan struct{})
+	go func() {
+		mutex.Lock("i-1")()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatal("mutex locked twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	<-locked
+	assert.False(t, mutex.IsLocked("i-1"))
+
+	mutex.mutex.Lock()
+	defer mutex.mutex.Unlock()
+	assert.Empty(t, mutex.locks)
+}
@@ -1,16 +1,23 @@
 package controller
 
 import (
+	"github.com/openshift/windows-machine-config-operator/pkg/clusternetwork"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/operatorconfig"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/windowsmachine/nodeconfig"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 )
 
 // AddToManagerFuncs is a list of functions to add all Controllers to the Manager
-var AddToManagerFuncs []func(manager.Manager, string) error
+var AddToManagerFuncs []func(manager.Manager, *clusternetwork.Store, *nodeconfig.NodeWatcher, cache.Cache,
+	*operatorconfig.Payload) error
 
-// AddToManager adds all Controllers to the Manager
-func AddToManager(m manager.Manager, clusterServiceCIDR string) error {
+// AddToManager adds all Controllers to the Manager. The given cache serves the cluster scoped objects, and the objects
+// outside of the manager's namespaces, to all of them. The given payload is the one read when the operator started.
+func AddToManager(m manager.Manager, network *clusternetwork.Store, nodes *nodeconfig.NodeWatcher,
+	clusterCache cache.Cache, payload *operatorconfig.Payload) error {
 	for _, f := range AddToManagerFuncs {
-		if err := f(m, clusterServiceCIDR); err != nil {
+		if err := f(m, network, nodes, clusterCache, payload); err != nil {
 			return err
 		}
 	}
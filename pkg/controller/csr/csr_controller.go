@@ -0,0 +1,241 @@
+package csr
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	mapi "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+	"github.com/openshift/windows-machine-config-operator/pkg/clusternetwork"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/operatorconfig"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/windowsmachine/nodeconfig"
+	"github.com/pkg/errors"
+	certificates "k8s.io/api/certificates/v1beta1"
+	core "k8s.io/api/core/v1"
+	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	// ControllerName is the name of the Windows node CSR controller
+	ControllerName = "csr-controller"
+	// machineNamespace is the namespace of the Machines
+	machineNamespace = "openshift-machine-api"
+	// windowsMachineLabel is the label identifying the Windows Machines
+	windowsMachineLabel = "machine.openshift.io/os-id"
+	// pendingRequeueInterval is the interval after which the CSRs which could not be checked yet are checked again
+	pendingRequeueInterval = 30 * time.Second
+)
+
+var log = logf.Log.WithName(ControllerName)
+
+// Add creates a new CSR Controller and adds it to the Manager. The Controller approves the kubelet client and serving
+// CSRs of the Windows nodes matching the identity of their Machine, and denies the other CSRs of the Windows nodes.
+func Add(mgr manager.Manager, _ *clusternetwork.Store, _ *nodeconfig.NodeWatcher,
+	clusterCache cache.Cache, _ *operatorconfig.Payload) error {
+	reconciler, err := newReconciler(mgr, clusterCache)
+	if err != nil {
+		return errors.Wrapf(err, "could not create %s reconciler", ControllerName)
+	}
+	return add(mgr, reconciler, clusterCache)
+}
+
+// newReconciler returns a new ReconcileCSR reading the CSRs and the nodes from the given reader
+func newReconciler(mgr manager.Manager, reader client.Reader) (*ReconcileCSR, error) {
+	clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating kubernetes clientset")
+	}
+	return &ReconcileCSR{
+		k8sclientset: clientset,
+		reader:       reader,
+		client:       mgr.GetClient(),
+		recorder:     mgr.GetEventRecorderFor(ControllerName),
+	}, nil
+}
+
+// add adds a new Controller to mgr with r as the reconcile.Reconciler, watching the CSRs of the given cache
+func add(mgr manager.Manager, r *ReconcileCSR, clusterCache cache.Cache) error {
+	c, err := controller.New(ControllerName, mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return errors.Wrapf(err, "could not create %s", ControllerName)
+	}
+	// Only the pending CSRs are of interest. The status of a CSR is only updated to approve or deny it.
+	isPending := func(object interface{}) bool {
+		csr, ok := object.(*certificates.CertificateSigningRequest)
+		return ok && !isFinished(csr)
+	}
+	if err := c.Watch(source.NewKindWithCache(&certificates.CertificateSigningRequest{}, clusterCache),
+		&handler.EnqueueRequestForObject{},
+		predicate.Funcs{
+			CreateFunc:  func(e event.CreateEvent) bool { return isPending(e.Object) },
+			UpdateFunc:  func(e event.UpdateEvent) bool { return isPending(e.ObjectNew) },
+			DeleteFunc:  func(event.DeleteEvent) bool { return false },
+			GenericFunc: func(event.GenericEvent) bool { return false },
+		}); err != nil {
+		return errors.Wrap(err, "could not create watch on CertificateSigningRequests")
+	}
+	return nil
+}
+
+// blank assignment to verify that ReconcileCSR implements reconcile.Reconciler
+var _ reconcile.Reconciler = &ReconcileCSR{}
+
+// ReconcileCSR approves the CSRs of the Windows nodes
+type ReconcileCSR struct {
+	// k8sclientset holds the kube client that we can re-use for all kube objects other than custom resources.
+	k8sclientset *kubernetes.Clientset
+	// reader reads the CSRs and the nodes from the cluster scoped cache
+	reader client.Reader
+	// client reads the Machines from the manager's cache
+	client client.Client
+	// recorder to generate events
+	recorder record.EventRecorder
+}
+
+// Reconcile approves the given CSR if it is a kubelet client or serving CSR matching the identity of a Windows
+// Machine, and denies it if it is for a Windows node but does not match. The CSRs which are not for a Windows node
+// are left to the other approvers.
+func (r *ReconcileCSR) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	csr := &certificates.CertificateSigningRequest{}
+	if err := r.reader.Get(context.TODO(), request.NamespacedName, csr); err != nil {
+		if k8sapierrors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, errors.Wrapf(err, "error getting CSR %s", request.Name)
+	}
+	if isFinished(csr) {
+		return reconcile.Result{}, nil
+	}
+	certRequest, err := parseCSR(csr)
+	if err != nil {
+		// A CSR which cannot be parsed cannot be attributed to a Windows node
+		log.V(1).Info("ignoring CSR", "name", csr.GetName(), "reason", err.Error())
+		return reconcile.Result{}, nil
+	}
+
+	machines, err := r.windowsMachines()
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	node, err := r.getNode(certRequest.Subject.CommonName)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	machine, err := reviewCSR(csr, certRequest, machines, node)
+	switch {
+	case err == errNotWindowsNode:
+		return reconcile.Result{}, nil
+	case err == errMachineAddressesUnknown:
+		log.V(1).Info("waiting for the Machine addresses to check CSR", "name", csr.GetName(),
+			"machine", machine.GetName())
+		return reconcile.Result{RequeueAfter: pendingRequeueInterval}, nil
+	case err == errMachineNotConfiguring:
+		// A paused or quarantined Machine may be configured later, and the annotation of a Machine being configured
+		// may not be in the cache yet
+		log.V(1).Info("waiting for the Machine to be configured to check CSR", "name", csr.GetName(),
+			"machine", machine.GetName())
+		return reconcile.Result{RequeueAfter: pendingRequeueInterval}, nil
+	case err != nil:
+		return reconcile.Result{}, r.deny(csr, machine, err)
+	default:
+		return reconcile.Result{}, r.approve(csr, machine)
+	}
+}
+
+// windowsMachines returns the Windows Machines which are not being deleted
+func (r *ReconcileCSR) windowsMachines() ([]mapi.Machine, error) {
+	machineList := &mapi.MachineList{}
+	if err := r.client.List(context.TODO(), machineList, client.InNamespace(machineNamespace),
+		client.MatchingLabels{windowsMachineLabel: "Windows"}); err != nil {
+		return nil, errors.Wrap(err, "error listing Windows Machines")
+	}
+	var machines []mapi.Machine
+	for _, machine := range machineList.Items {
+		if machine.GetDeletionTimestamp() == nil {
+			machines = append(machines, machine)
+		}
+	}
+	return machines, nil
+}
+
+// getNode returns the node with the given certificate common name, or nil if it does not exist
+func (r *ReconcileCSR) getNode(commonName string) (*core.Node, error) {
+	nodeName := strings.TrimPrefix(commonName, nodeUserPrefix)
+	if nodeName == commonName || nodeName == "" {
+		return nil, nil
+	}
+	node := &core.Node{}
+	if err := r.reader.Get(context.TODO(), types.NamespacedName{Name: nodeName}, node); err != nil {
+		if k8sapierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "error getting node %s", nodeName)
+	}
+	return node, nil
+}
+
+// approve approves the given CSR of the node of the given Machine
+func (r *ReconcileCSR) approve(csr *certificates.CertificateSigningRequest, machine *mapi.Machine) error {
+	message := fmt.Sprintf("matches the identity of Windows Machine %s", machine.GetName())
+	if err := r.updateApproval(csr, certificates.CertificateApproved, "WMCOApproved", message); err != nil {
+		return errors.Wrapf(err, "error approving CSR %s", csr.GetName())
+	}
+	log.Info("approved CSR", "name", csr.GetName(), "machine", machine.GetName())
+	r.recorder.Eventf(csr, core.EventTypeNormal, "WMCO CSRApproved",
+		"CSR %s of %s approved: %s", csr.GetName(), csr.Spec.Username, message)
+	return nil
+}
+
+// deny denies the given CSR of the node of the given Machine, for the given reason
+func (r *ReconcileCSR) deny(csr *certificates.CertificateSigningRequest, machine *mapi.Machine, reason error) error {
+	if err := r.updateApproval(csr, certificates.CertificateDenied, "WMCODenied", reason.Error()); err != nil {
+		return errors.Wrapf(err, "error denying CSR %s", csr.GetName())
+	}
+	log.Info("denied CSR", "name", csr.GetName(), "machine", machine.GetName(), "reason", reason.Error())
+	r.recorder.Eventf(csr, core.EventTypeWarning, "WMCO CSRDenied",
+		"CSR %s of %s denied: %v", csr.GetName(), csr.Spec.Username, reason)
+	r.recorder.Eventf(machine, core.EventTypeWarning, "WMCO CSRDenied",
+		"CSR %s of %s denied: %v", csr.GetName(), csr.Spec.Username, reason)
+	return nil
+}
+
+// updateApproval adds a condition of the given type to the given CSR through the approval subresource
+func (r *ReconcileCSR) updateApproval(csr *certificates.CertificateSigningRequest,
+	conditionType certificates.RequestConditionType, reason, message string) error {
+	csr = csr.DeepCopy()
+	csr.Status.Conditions = append(csr.Status.Conditions, certificates.CertificateSigningRequestCondition{
+		Type:           conditionType,
+		Reason:         reason,
+		Message:        message,
+		LastUpdateTime: meta.Now(),
+	})
+	_, err := r.k8sclientset.CertificatesV1beta1().CertificateSigningRequests().UpdateApproval(context.TODO(), csr,
+		meta.UpdateOptions{})
+	return err
+}
+
+// isFinished returns true if the given CSR has been approved or denied
+func isFinished(csr *certificates.CertificateSigningRequest) bool {
+	for _, condition := range csr.Status.Conditions {
+		if condition.Type == certificates.CertificateApproved || condition.Type == certificates.CertificateDenied {
+			return true
+		}
+	}
+	return false
+}
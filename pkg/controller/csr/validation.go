@@ -0,0 +1,247 @@
+package csr
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"strings"
+
+	mapi "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/machinecontrol"
+	"github.com/pkg/errors"
+	certificates "k8s.io/api/certificates/v1beta1"
+	core "k8s.io/api/core/v1"
+)
+
+const (
+	// bootstrapperUsername is the user requesting the first kubelet client certificate of a node, before the node
+	// exists
+	bootstrapperUsername = "system:serviceaccount:openshift-machine-config-operator:node-bootstrapper"
+	// nodeUserPrefix is the prefix of the usernames and certificate common names of the nodes
+	nodeUserPrefix = "system:node:"
+	// nodeGroup is the group of the nodes, required as the only organization of the certificates
+	nodeGroup = "system:nodes"
+	// kubeletServingSignerName is the signer of the kubelet serving certificates
+	kubeletServingSignerName = "kubernetes.io/kubelet-serving"
+)
+
+var (
+	// errNotWindowsNode is returned for the CSRs which are not for a Windows node, and are left to the other approvers
+	errNotWindowsNode = errors.New("CSR is not for a Windows node")
+	// errMachineAddressesUnknown is returned for the serving CSRs of the Machines whose addresses are not known yet
+	errMachineAddressesUnknown = errors.New("Machine addresses are not known yet")
+	// errMachineNotConfiguring is returned for the bootstrap CSRs of the Machines the operator is not configuring, or
+	// has not started configuring yet
+	errMachineNotConfiguring = errors.New("Machine is not being configured")
+)
+
+// Usages allowed in the kubelet certificates
+var (
+	clientUsages = []certificates.KeyUsage{certificates.UsageDigitalSignature, certificates.UsageKeyEncipherment,
+		certificates.UsageClientAuth}
+	servingUsages = []certificates.KeyUsage{certificates.UsageDigitalSignature, certificates.UsageKeyEncipherment,
+		certificates.UsageServerAuth}
+)
+
+// parseCSR returns the certificate request of the given CSR
+func parseCSR(csr *certificates.CertificateSigningRequest) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(csr.Spec.Request)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("request is not a PEM encoded certificate request")
+	}
+	request, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing certificate request")
+	}
+	return request, nil
+}
+
+// reviewCSR checks that the given kubelet CSR, with the given parsed request, is for a Windows node matching the
+// identity of one of the given Windows Machines. The node the CSR is for is given if it exists. The Machine is
+// returned if the CSR can be approved. errNotWindowsNode is returned if the CSR is not for a Windows node, and
+// errMachineAddressesUnknown or errMachineNotConfiguring if it cannot be checked yet. Any other error is a reason to
+// deny the CSR.
+func reviewCSR(csr *certificates.CertificateSigningRequest, request *x509.CertificateRequest,
+	machines []mapi.Machine, node *core.Node) (*mapi.Machine, error) {
+	if !strings.HasPrefix(request.Subject.CommonName, nodeUserPrefix) {
+		return nil, errNotWindowsNode
+	}
+	nodeName := strings.TrimPrefix(request.Subject.CommonName, nodeUserPrefix)
+	machine := findMachine(nodeName, machines, node)
+	if machine == nil {
+		return nil, errNotWindowsNode
+	}
+
+	if len(request.Subject.Organization) != 1 || request.Subject.Organization[0] != nodeGroup {
+		return machine, errors.Errorf("organization %v of the certificate is not %s", request.Subject.Organization,
+			nodeGroup)
+	}
+	if len(request.EmailAddresses) > 0 || len(request.URIs) > 0 {
+		return machine, errors.New("email and URI SANs are not allowed")
+	}
+
+	if isServingCSR(csr) {
+		return machine, reviewServingCSR(csr, request, machine, node)
+	}
+	return machine, reviewClientCSR(csr, request, machine, node)
+}
+
+// reviewClientCSR checks that the given kubelet client CSR is requested by the node bootstrapper for a node which
+// does not exist yet of a Machine the operator is configuring, or by the node matching the given Machine to renew its
+// certificate
+func reviewClientCSR(csr *certificates.CertificateSigningRequest, request *x509.CertificateRequest,
+	machine *mapi.Machine, node *core.Node) error {
+	if err := checkUsages(csr.Spec.Usages, clientUsages, certificates.UsageClientAuth); err != nil {
+		return err
+	}
+	if len(request.DNSNames) > 0 || len(request.IPAddresses) > 0 {
+		return errors.New("client certificates cannot have SANs")
+	}
+
+	switch csr.Spec.Username {
+	case bootstrapperUsername:
+		if node != nil {
+			return errors.Errorf("node %s already exists", node.GetName())
+		}
+		if !machinecontrol.IsConfiguring(machine) {
+			return errMachineNotConfiguring
+		}
+		return nil
+	case request.Subject.CommonName:
+		return checkNode(node, machine)
+	default:
+		return errors.Errorf("user %s cannot request a client certificate for %s", csr.Spec.Username,
+			request.Subject.CommonName)
+	}
+}
+
+// reviewServingCSR checks that the given kubelet serving CSR is requested by the node matching the given Machine,
+// for the addresses of the Machine only
+func reviewServingCSR(csr *certificates.CertificateSigningRequest, request *x509.CertificateRequest,
+	machine *mapi.Machine, node *core.Node) error {
+	if err := checkUsages(csr.Spec.Usages, servingUsages, certificates.UsageServerAuth); err != nil {
+		return err
+	}
+	if csr.Spec.Username != request.Subject.CommonName {
+		return errors.Errorf("user %s cannot request a serving certificate for %s", csr.Spec.Username,
+			request.Subject.CommonName)
+	}
+	if err := checkNode(node, machine); err != nil {
+		return err
+	}
+	if len(machine.Status.Addresses) == 0 {
+		return errMachineAddressesUnknown
+	}
+
+	for _, ip := range request.IPAddresses {
+		if !hasAddress(machine, ip.String(), core.NodeInternalIP, core.NodeExternalIP) {
+			return errors.Errorf("IP address %s is not an address of Machine %s", ip, machine.GetName())
+		}
+	}
+	for _, name := range request.DNSNames {
+		if !hasAddress(machine, name, core.NodeInternalDNS, core.NodeExternalDNS, core.NodeHostName) {
+			return errors.Errorf("DNS name %s is not an address of Machine %s", name, machine.GetName())
+		}
+	}
+	return nil
+}
+
+// isServingCSR returns true if the given CSR is for a kubelet serving certificate, false if it is for a client
+// certificate
+func isServingCSR(csr *certificates.CertificateSigningRequest) bool {
+	if csr.Spec.SignerName != nil && *csr.Spec.SignerName != "" {
+		return *csr.Spec.SignerName == kubeletServingSignerName
+	}
+	// The signer is not set by older API servers, in which case the certificate type is given by its usages
+	for _, usage := range csr.Spec.Usages {
+		if usage == certificates.UsageServerAuth {
+			return true
+		}
+	}
+	return false
+}
+
+// checkUsages returns an error if the given usages are not all in allowed, or do not include required
+func checkUsages(usages, allowed []certificates.KeyUsage, required certificates.KeyUsage) error {
+	hasRequired := false
+	for _, usage := range usages {
+		if !hasUsage(allowed, usage) {
+			return errors.Errorf("usage %s is not allowed", usage)
+		}
+		hasRequired = hasRequired || usage == required
+	}
+	if !hasRequired {
+		return errors.Errorf("usage %s is missing", required)
+	}
+	return nil
+}
+
+// hasUsage returns true if the given usages contain usage
+func hasUsage(usages []certificates.KeyUsage, usage certificates.KeyUsage) bool {
+	for _, u := range usages {
+		if u == usage {
+			return true
+		}
+	}
+	return false
+}
+
+// checkNode returns an error if the given node does not exist or is not the node of the given Machine
+func checkNode(node *core.Node, machine *mapi.Machine) error {
+	if node == nil {
+		return errors.New("node does not exist")
+	}
+	if machine.Spec.ProviderID == nil || node.Spec.ProviderID != *machine.Spec.ProviderID {
+		return errors.Errorf("node %s is not the node of Machine %s", node.GetName(), machine.GetName())
+	}
+	return nil
+}
+
+// findMachine returns the Machine of the node with the given name, or nil if there is none. The Machine is matched by
+// provider ID if the node exists, by hostname otherwise. The node names are lower case, while the Machine hostnames
+// may not be.
+func findMachine(nodeName string, machines []mapi.Machine, node *core.Node) *mapi.Machine {
+	for i := range machines {
+		machine := &machines[i]
+		if node != nil && node.Spec.ProviderID != "" {
+			if machine.Spec.ProviderID != nil && *machine.Spec.ProviderID == node.Spec.ProviderID {
+				return machine
+			}
+			continue
+		}
+		if hasAddress(machine, nodeName, core.NodeInternalDNS, core.NodeHostName) {
+			return machine
+		}
+	}
+	return nil
+}
+
+// hasAddress returns true if the given Machine has the given address with one of the given types. The addresses are
+// compared ignoring case, and IP addresses are compared in their canonical form.
+func hasAddress(machine *mapi.Machine, address string, types ...core.NodeAddressType) bool {
+	for _, machineAddress := range machine.Status.Addresses {
+		if !hasAddressType(types, machineAddress.Type) {
+			continue
+		}
+		if ip := net.ParseIP(machineAddress.Address); ip != nil {
+			if ip.Equal(net.ParseIP(address)) {
+				return true
+			}
+			continue
+		}
+		if strings.EqualFold(machineAddress.Address, address) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAddressType returns true if the given types contain addressType
+func hasAddressType(types []core.NodeAddressType, addressType core.NodeAddressType) bool {
+	for _, t := range types {
+		if t == addressType {
+			return true
+		}
+	}
+	return false
+}
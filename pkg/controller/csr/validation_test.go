@@ -0,0 +1,223 @@
+package csr
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"net"
+	"testing"
+
+	mapi "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/machinecontrol"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	certificates "k8s.io/api/certificates/v1beta1"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// errAny is the expected error of the test cases expecting a CSR to be denied
+var errAny = errors.New("any denial reason")
+
+// newCSR returns a CSR requested by the given user for a certificate with the given subject, SANs and usages
+func newCSR(t *testing.T, username string, subject pkix.Name, dnsNames []string, ips []net.IP,
+	usages ...certificates.KeyUsage) *certificates.CertificateSigningRequest {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.CreateCertificateRequest(rand.Reader,
+		&x509.CertificateRequest{Subject: subject, DNSNames: dnsNames, IPAddresses: ips}, key)
+	require.NoError(t, err)
+	return &certificates.CertificateSigningRequest{
+		ObjectMeta: meta.ObjectMeta{Name: "csr"},
+		Spec: certificates.CertificateSigningRequestSpec{
+			Request:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}),
+			Username: username,
+			Usages:   usages,
+		},
+	}
+}
+
+// TestReviewCSR tests that only the kubelet CSRs matching the identity of a Windows Machine are approved, the bootstrap
+// CSRs only while the Machine is being configured, and that the CSRs of the other nodes are ignored
+func TestReviewCSR(t *testing.T) {
+	providerID := "aws:///us-east-1a/i-0123"
+	machines := []mapi.Machine{{
+		ObjectMeta: meta.ObjectMeta{Name: "windows-machine",
+			Annotations: map[string]string{machinecontrol.ConfiguringAnnotation: ""}},
+		Spec: mapi.MachineSpec{ProviderID: &providerID},
+		Status: mapi.MachineStatus{Addresses: []core.NodeAddress{
+			{Type: core.NodeInternalIP, Address: "10.0.0.10"},
+			{Type: core.NodeInternalDNS, Address: "ip-10-0-0-10.ec2.internal"},
+			{Type: core.NodeHostName, Address: "EC2AMAZ-WIN"},
+		}},
+	}}
+	noAddressMachines := []mapi.Machine{*machines[0].DeepCopy()}
+	noAddressMachines[0].Status.Addresses = nil
+	notConfiguringMachines := []mapi.Machine{*machines[0].DeepCopy()}
+	notConfiguringMachines[0].Annotations = nil
+	pausedMachines := []mapi.Machine{*machines[0].DeepCopy()}
+	pausedMachines[0].Annotations[machinecontrol.PausedAnnotation] = ""
+	node := &core.Node{ObjectMeta: meta.ObjectMeta{Name: "ec2amaz-win"},
+		Spec: core.NodeSpec{ProviderID: providerID}}
+	otherNode := &core.Node{ObjectMeta: meta.ObjectMeta{Name: "ec2amaz-win"},
+		Spec: core.NodeSpec{ProviderID: "aws:///us-east-1a/i-9999"}}
+
+	nodeSubject := pkix.Name{CommonName: nodeUserPrefix + "ec2amaz-win", Organization: []string{nodeGroup}}
+	nodeUser := nodeSubject.CommonName
+	ips := []net.IP{net.ParseIP("10.0.0.10")}
+	dnsNames := []string{"ip-10-0-0-10.ec2.internal", "ec2amaz-win"}
+
+	testCases := []struct {
+		name     string
+		csr      *certificates.CertificateSigningRequest
+		machines []mapi.Machine
+		node     *core.Node
+		// expectedErr is the expected error, nil if the CSR is expected to be approved and errAny if any denial
+		// reason is expected
+		expectedErr error
+	}{
+		{
+			name:     "bootstrap client CSR of a new node",
+			csr:      newCSR(t, bootstrapperUsername, nodeSubject, nil, nil, clientUsages...),
+			machines: machines,
+		},
+		{
+			name:        "bootstrap client CSR of a Machine which is not being configured",
+			csr:         newCSR(t, bootstrapperUsername, nodeSubject, nil, nil, clientUsages...),
+			machines:    notConfiguringMachines,
+			expectedErr: errMachineNotConfiguring,
+		},
+		{
+			name:        "bootstrap client CSR of a paused Machine",
+			csr:         newCSR(t, bootstrapperUsername, nodeSubject, nil, nil, clientUsages...),
+			machines:    pausedMachines,
+			expectedErr: errMachineNotConfiguring,
+		},
+		{
+			name:        "bootstrap client CSR of an existing node",
+			csr:         newCSR(t, bootstrapperUsername, nodeSubject, nil, nil, clientUsages...),
+			machines:    machines,
+			node:        node,
+			expectedErr: errAny,
+		},
+		{
+			name:     "client CSR renewal",
+			csr:      newCSR(t, nodeUser, nodeSubject, nil, nil, clientUsages...),
+			machines: machines,
+			node:     node,
+		},
+		{
+			name:        "client CSR requested by another user",
+			csr:         newCSR(t, "system:node:other", nodeSubject, nil, nil, clientUsages...),
+			machines:    machines,
+			node:        node,
+			expectedErr: errAny,
+		},
+		{
+			name:        "client CSR with SANs",
+			csr:         newCSR(t, bootstrapperUsername, nodeSubject, dnsNames, nil, clientUsages...),
+			machines:    machines,
+			expectedErr: errAny,
+		},
+		{
+			name: "client CSR with the wrong organization",
+			csr: newCSR(t, bootstrapperUsername, pkix.Name{CommonName: nodeSubject.CommonName,
+				Organization: []string{"system:masters"}}, nil, nil, clientUsages...),
+			machines:    machines,
+			expectedErr: errAny,
+		},
+		{
+			name: "client CSR with a usage not allowed",
+			csr: newCSR(t, bootstrapperUsername, nodeSubject, nil, nil, certificates.UsageClientAuth,
+				certificates.UsageCodeSigning),
+			machines:    machines,
+			expectedErr: errAny,
+		},
+		{
+			name:     "serving CSR for the Machine addresses",
+			csr:      newCSR(t, nodeUser, nodeSubject, dnsNames, ips, servingUsages...),
+			machines: machines,
+			node:     node,
+		},
+		{
+			name: "serving CSR for another IP address",
+			csr: newCSR(t, nodeUser, nodeSubject, dnsNames, []net.IP{net.ParseIP("10.0.0.11")},
+				servingUsages...),
+			machines:    machines,
+			node:        node,
+			expectedErr: errAny,
+		},
+		{
+			name:        "serving CSR for another DNS name",
+			csr:         newCSR(t, nodeUser, nodeSubject, []string{"kubernetes.default.svc"}, ips, servingUsages...),
+			machines:    machines,
+			node:        node,
+			expectedErr: errAny,
+		},
+		{
+			name:        "serving CSR requested by another user",
+			csr:         newCSR(t, bootstrapperUsername, nodeSubject, dnsNames, ips, servingUsages...),
+			machines:    machines,
+			node:        node,
+			expectedErr: errAny,
+		},
+		{
+			name:        "serving CSR of a node which does not exist",
+			csr:         newCSR(t, nodeUser, nodeSubject, dnsNames, ips, servingUsages...),
+			machines:    machines,
+			expectedErr: errAny,
+		},
+		{
+			name:        "serving CSR before the Machine addresses are known",
+			csr:         newCSR(t, nodeUser, nodeSubject, dnsNames, ips, servingUsages...),
+			machines:    noAddressMachines,
+			node:        node,
+			expectedErr: errMachineAddressesUnknown,
+		},
+		{
+			name:        "CSR of a node of another Machine",
+			csr:         newCSR(t, nodeUser, nodeSubject, dnsNames, ips, servingUsages...),
+			machines:    machines,
+			node:        otherNode,
+			expectedErr: errNotWindowsNode,
+		},
+		{
+			name: "CSR of a node without Windows Machine",
+			csr: newCSR(t, bootstrapperUsername, pkix.Name{CommonName: nodeUserPrefix + "linux",
+				Organization: []string{nodeGroup}}, nil, nil, clientUsages...),
+			machines:    machines,
+			expectedErr: errNotWindowsNode,
+		},
+		{
+			name:        "CSR which is not for a node",
+			csr:         newCSR(t, "user", pkix.Name{CommonName: "user"}, nil, nil, clientUsages...),
+			machines:    machines,
+			expectedErr: errNotWindowsNode,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			request, err := parseCSR(test.csr)
+			require.NoError(t, err)
+			machine, err := reviewCSR(test.csr, request, test.machines, test.node)
+			switch test.expectedErr {
+			case nil:
+				require.NoError(t, err)
+				assert.Equal(t, "windows-machine", machine.GetName())
+			case errAny:
+				require.Error(t, err)
+				assert.NotEqual(t, errNotWindowsNode, err)
+				assert.NotEqual(t, errMachineAddressesUnknown, err)
+				assert.NotEqual(t, errMachineNotConfiguring, err)
+				assert.NotNil(t, machine)
+			default:
+				assert.Equal(t, test.expectedErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package kubeletconfig
+
+import (
+	"context"
+
+	wmcapi "github.com/openshift/windows-machine-config-operator/pkg/apis/wmc/v1alpha1"
+	"github.com/openshift/windows-machine-config-operator/pkg/clusternetwork"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/machinecontrol"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/operatorconfig"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/signer"
+	wkl "github.com/openshift/windows-machine-config-operator/pkg/controller/wellknownlocations"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/windowsmachine/nodeconfig"
+	"github.com/openshift/windows-machine-config-operator/pkg/metrics"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// ControllerName is the name of the kubelet configuration controller
+const ControllerName = "kubeletconfig-controller"
+
+var log = logf.Log.WithName(ControllerName)
+
+// Add creates a new kubelet configuration Controller and adds it to the Manager. The Controller watches the
+// WindowsMachineConfig and rolls its kubelet settings out to the Windows nodes.
+func Add(mgr manager.Manager, network *clusternetwork.Store, nodes *nodeconfig.NodeWatcher,
+	clusterCache cache.Cache, payload *operatorconfig.Payload) error {
+	reconciler, err := newReconciler(mgr, network, nodes, payload)
+	if err != nil {
+		return errors.Wrapf(err, "could not create %s reconciler", ControllerName)
+	}
+	return add(mgr, reconciler, clusterCache)
+}
+
+// newReconciler returns a new ReconcileKubeletConfig
+func newReconciler(mgr manager.Manager, network *clusternetwork.Store, nodes *nodeconfig.NodeWatcher,
+	payload *operatorconfig.Payload) (*ReconcileKubeletConfig, error) {
+	clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating kubernetes clientset")
+	}
+	sshSigner, err := signer.Create()
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating signer using private key: %v", wkl.PrivateKeyPath)
+	}
+
+	return &ReconcileKubeletConfig{
+		k8sclientset: clientset,
+		reader:       mgr.GetAPIReader(),
+		network:      network,
+		nodes:        nodes,
+		payload:      payload,
+		signer:       sshSigner,
+		recorder:     mgr.GetEventRecorderFor(ControllerName),
+	}, nil
+}
+
+// add adds a new Controller to mgr with r as the reconcile.Reconciler, watching the WindowsMachineConfig through the
+// given cache
+func add(mgr manager.Manager, r *ReconcileKubeletConfig, clusterCache cache.Cache) error {
+	c, err := controller.New(ControllerName, mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return errors.Wrapf(err, "could not create %s", ControllerName)
+	}
+
+	if err := c.Watch(source.NewKindWithCache(&wmcapi.WindowsMachineConfig{}, clusterCache),
+		&handler.EnqueueRequestForObject{}); err != nil {
+		return errors.Wrap(err, "could not create watch on WindowsMachineConfig")
+	}
+	return nil
+}
+
+// blank assignment to verify that ReconcileKubeletConfig implements reconcile.Reconciler
+var _ reconcile.Reconciler = &ReconcileKubeletConfig{}
+
+// ReconcileKubeletConfig reconciles the WindowsMachineConfig kubelet settings with the Windows nodes
+type ReconcileKubeletConfig struct {
+	// k8sclientset holds the kube client that we can re-use for all kube objects other than custom resources.
+	k8sclientset *kubernetes.Clientset
+	// reader reads the WindowsMachineConfig directly from the API server
+	reader client.Reader
+	// network holds the current cluster network configuration, shared with the other controllers
+	network *clusternetwork.Store
+	// nodes serves the Windows nodes from the shared informer
+	nodes *nodeconfig.NodeWatcher
+	// payload holds the contents of the payload directory read when the operator started
+	payload *operatorconfig.Payload
+	// signer is a signer created from the user's private key
+	signer ssh.Signer
+	// recorder to generate events
+	recorder record.EventRecorder
+}
+
+// Reconcile updates the Windows nodes whose KubeletConfigAnnotation does not match the current kubelet settings, one
+// node at a time so that the workloads can be moved to the other nodes. Nodes without the annotation have not finished
+// their initial configuration, which applies the current settings, and are skipped.
+func (r *ReconcileKubeletConfig) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	if request.Name != wmcapi.WindowsMachineConfigName {
+		return reconcile.Result{}, nil
+	}
+	config, err := operatorconfig.Get(r.reader)
+	if err != nil {
+		return reconcile.Result{}, errors.Wrap(err, "error getting operator configuration")
+	}
+	// The whole configuration is validated, as the rollout uses its retry and directory settings
+	if err := operatorconfig.Validate(config, r.payload); err != nil {
+		// Requeuing will not help until the configuration is changed, which triggers a new reconcile
+		log.Error(err, "invalid operator configuration, Windows nodes will not be updated")
+		r.recorder.Eventf(config, core.EventTypeWarning, "WMCO InvalidConfiguration",
+			"Windows nodes will not be updated: %v", err)
+		return reconcile.Result{}, nil
+	}
+	desired, err := nodeconfig.KubeletConfigAnnotationValue(config.Spec.Kubelet)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	nodes, err := r.k8sclientset.CoreV1().Nodes().List(context.TODO(),
+		meta.ListOptions{LabelSelector: nodeconfig.WindowsOSLabel})
+	if err != nil {
+		return reconcile.Result{}, errors.Wrap(err, "error listing Windows nodes")
+	}
+	result := reconcile.Result{}
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		applied, found := node.GetAnnotations()[nodeconfig.KubeletConfigAnnotation]
+		if !found || applied == desired {
+			continue
+		}
+		paused, err := machinecontrol.NodePaused(r.reader, node)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		if paused {
+			log.Info("skipping paused Windows node", "node", node.GetName())
+			result.RequeueAfter = machinecontrol.PausedRequeueInterval
+			continue
+		}
+		instanceID := nodeconfig.InstanceIDFromProviderID(node.Spec.ProviderID)
+		metrics.SetNodeState(instanceID, metrics.NodeStateUpgrading)
+		if err := r.updateNode(node, config); err != nil {
+			metrics.SetNodeState(instanceID, metrics.NodeStateFailed)
+			r.recorder.Eventf(node, core.EventTypeWarning, "WMCO KubeletUpdateFailure",
+				"Node %s failed to be updated with the kubelet settings: %v", node.GetName(), err)
+			// Stop the rollout, so that a problem with the settings does not take down every node
+			return reconcile.Result{}, errors.Wrapf(err, "error updating kubelet of node %s", node.GetName())
+		}
+		metrics.SetNodeState(instanceID, metrics.NodeStateConfigured)
+		r.recorder.Eventf(node, core.EventTypeNormal, "WMCO KubeletUpdate",
+			"Node %s updated with the kubelet settings", node.GetName())
+		log.Info("updated Windows node kubelet settings", "node", node.GetName())
+	}
+	return result, nil
+}
+
+// updateNode cordons and drains the given node, applies the kubelet settings of the given config and uncordons it
+func (r *ReconcileKubeletConfig) updateNode(node *core.Node, config *wmcapi.WindowsMachineConfig) error {
+	nc, err := nodeconfig.NewNodeConfigFromAddresses(r.k8sclientset, r.nodes, node.Status.Addresses,
+		nodeconfig.InstanceIDFromProviderID(node.Spec.ProviderID), r.network.Get(), &config.Spec, r.signer,
+		r.payload.CompatibilityMatrix)
+	if err != nil {
+		return errors.Wrapf(err, "error creating node config for %s", node.GetName())
+	}
+	return nc.UpdateKubelet(node)
+}
@@ -0,0 +1,110 @@
+// Package machinecontrol holds the annotations through which cluster administrators control what the operator does
+// to a Windows Machine and its node, and the helpers the controllers use to honour them
+package machinecontrol
+
+import (
+	"context"
+	"time"
+
+	mapi "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// PausedAnnotation pauses all the operator actions on a Windows Machine and its node, such as for maintenance,
+	// while it is set on the Machine. Its value is ignored.
+	PausedAnnotation = "windowsmachineconfig.openshift.io/paused"
+	// ReconfigureAnnotation requests a full configuration of a Windows Machine, whatever its phase. Its value is
+	// ignored, and the operator removes it once the Machine has been configured.
+	ReconfigureAnnotation = "windowsmachineconfig.openshift.io/reconfigure"
+	// RetryAnnotation requests a quarantined Windows Machine to be configured again. Its value is ignored, and the
+	// operator removes it along with the quarantine.
+	RetryAnnotation = "windowsmachineconfig.openshift.io/retry"
+	// QuarantineAnnotation is set on the Windows Machines whose configuration kept failing permanently, with the
+	// reason of the failures as value. The Machines are not configured again until the annotation is removed.
+	QuarantineAnnotation = "windowsmachineconfig.openshift.io/quarantined"
+	// ConfiguringAnnotation is set by the operator on the Windows Machines it is configuring, until their configuration
+	// succeeds or they are quarantined. The bootstrap CSRs of their node are only approved while it is set.
+	ConfiguringAnnotation = "windowsmachineconfig.openshift.io/configuring"
+	// PreflightFailureAnnotation is set by the operator on the Windows Machines whose VM failed its preflight checks,
+	// with the unmet requirements as value, until the VM is configured. The status of the Machine is left to the
+	// machine-api, which would consider the Machine failed.
+	PreflightFailureAnnotation = "windowsmachineconfig.openshift.io/preflight-failure"
+
+	// PausedRequeueInterval is the time after which the controllers which skipped a paused node check it again, as
+	// they are not triggered by the updates of its Machine
+	PausedRequeueInterval = 5 * time.Minute
+
+	// machineNamespace is the namespace of the Machines
+	machineNamespace = "openshift-machine-api"
+	// windowsMachineLabel is the label identifying the OS of the Machines
+	windowsMachineLabel = "machine.openshift.io/os-id"
+)
+
+// IsPaused returns true if the operator actions on the given Machine are paused
+func IsPaused(machine *mapi.Machine) bool {
+	_, paused := machine.GetAnnotations()[PausedAnnotation]
+	return paused
+}
+
+// IsQuarantined returns true if the given Machine is quarantined
+func IsQuarantined(machine *mapi.Machine) bool {
+	_, quarantined := machine.GetAnnotations()[QuarantineAnnotation]
+	return quarantined
+}
+
+// IsConfiguring returns true if the operator is configuring the given Machine, which is neither paused nor quarantined
+func IsConfiguring(machine *mapi.Machine) bool {
+	_, configuring := machine.GetAnnotations()[ConfiguringAnnotation]
+	return configuring && !IsPaused(machine) && !IsQuarantined(machine)
+}
+
+// WindowsMachines returns the Windows Machines
+func WindowsMachines(reader client.Reader) ([]mapi.Machine, error) {
+	machines := &mapi.MachineList{}
+	if err := reader.List(context.TODO(), machines, client.InNamespace(machineNamespace),
+		client.MatchingLabels{windowsMachineLabel: "Windows"}); err != nil {
+		return nil, errors.Wrap(err, "error listing Windows Machines")
+	}
+	return machines.Items, nil
+}
+
+// NodePaused returns true if the operator actions on the given Windows node are paused through its Machine, which
+// has the same provider ID. A node whose Machine cannot be found is not paused.
+func NodePaused(reader client.Reader, node *core.Node) (bool, error) {
+	machine, err := nodeMachine(reader, node)
+	if err != nil || machine == nil {
+		return false, err
+	}
+	return IsPaused(machine), nil
+}
+
+// NodeConfiguring returns true if the operator is configuring the Machine of the given Windows node. A node whose
+// Machine cannot be found is not being configured.
+func NodeConfiguring(reader client.Reader, node *core.Node) (bool, error) {
+	machine, err := nodeMachine(reader, node)
+	if err != nil || machine == nil {
+		return false, err
+	}
+	return IsConfiguring(machine), nil
+}
+
+// nodeMachine returns the Windows Machine with the same provider ID as the given node, nil if there is none
+func nodeMachine(reader client.Reader, node *core.Node) (*mapi.Machine, error) {
+	if node.Spec.ProviderID == "" {
+		return nil, nil
+	}
+	machines, err := WindowsMachines(reader)
+	if err != nil {
+		return nil, err
+	}
+	for i := range machines {
+		providerID := machines[i].Spec.ProviderID
+		if providerID != nil && *providerID == node.Spec.ProviderID {
+			return &machines[i], nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,89 @@
+package machinecontrol
+
+import (
+	"testing"
+
+	mapi "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+	"github.com/openshift/windows-machine-config-operator/pkg/apis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// TestNodePaused tests that a node is paused through the Windows Machine with the same provider ID
+func TestNodePaused(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, apis.AddToScheme(scheme))
+	newMachine := func(name, providerID string, labels, annotations map[string]string) *mapi.Machine {
+		return &mapi.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: machineNamespace, Labels: labels,
+				Annotations: annotations},
+			Spec: mapi.MachineSpec{ProviderID: &providerID},
+		}
+	}
+	windows := map[string]string{windowsMachineLabel: "Windows"}
+	paused := map[string]string{PausedAnnotation: ""}
+	reader := fake.NewFakeClientWithScheme(scheme,
+		newMachine("paused", "aws:///us-east-1a/i-1", windows, paused),
+		newMachine("running", "aws:///us-east-1a/i-2", windows, nil),
+		newMachine("linux", "aws:///us-east-1a/i-3", nil, paused))
+
+	var tests = []struct {
+		name       string
+		providerID string
+		expected   bool
+	}{
+		{"paused machine", "aws:///us-east-1a/i-1", true},
+		{"running machine", "aws:///us-east-1a/i-2", false},
+		{"not a Windows machine", "aws:///us-east-1a/i-3", false},
+		{"no machine", "aws:///us-east-1a/i-4", false},
+		{"no provider ID", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paused, err := NodePaused(reader, &core.Node{Spec: core.NodeSpec{ProviderID: tt.providerID}})
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, paused)
+		})
+	}
+}
+
+// TestNodeConfiguring tests that a node is being configured while its Windows Machine has the configuring annotation
+// and is neither paused nor quarantined
+func TestNodeConfiguring(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, apis.AddToScheme(scheme))
+	newMachine := func(name, providerID string, annotations map[string]string) *mapi.Machine {
+		return &mapi.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: machineNamespace,
+				Labels: map[string]string{windowsMachineLabel: "Windows"}, Annotations: annotations},
+			Spec: mapi.MachineSpec{ProviderID: &providerID},
+		}
+	}
+	reader := fake.NewFakeClientWithScheme(scheme,
+		newMachine("configuring", "aws:///us-east-1a/i-1", map[string]string{ConfiguringAnnotation: ""}),
+		newMachine("paused", "aws:///us-east-1a/i-2", map[string]string{ConfiguringAnnotation: "",
+			PausedAnnotation: ""}),
+		newMachine("configured", "aws:///us-east-1a/i-3", nil))
+
+	var tests = []struct {
+		name       string
+		providerID string
+		expected   bool
+	}{
+		{"configuring machine", "aws:///us-east-1a/i-1", true},
+		{"paused machine", "aws:///us-east-1a/i-2", false},
+		{"configured machine", "aws:///us-east-1a/i-3", false},
+		{"no machine", "aws:///us-east-1a/i-4", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configuring, err := NodeConfiguring(reader, &core.Node{Spec: core.NodeSpec{ProviderID: tt.providerID}})
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, configuring)
+		})
+	}
+}
@@ -0,0 +1,409 @@
+package monitoring
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	monv1 "github.com/coreos/prometheus-operator/pkg/apis/monitoring/v1"
+	monclient "github.com/coreos/prometheus-operator/pkg/client/versioned/typed/monitoring/v1"
+	wmcapi "github.com/openshift/windows-machine-config-operator/pkg/apis/wmc/v1alpha1"
+	"github.com/openshift/windows-machine-config-operator/pkg/clusternetwork"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/machinecontrol"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/operatorconfig"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/signer"
+	wkl "github.com/openshift/windows-machine-config-operator/pkg/controller/wellknownlocations"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/windowsmachine/nodeconfig"
+	"github.com/operator-framework/operator-sdk/pkg/k8sutil"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	core "k8s.io/api/core/v1"
+	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	kubeTypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	// ControllerName is the name of the Windows node monitoring controller
+	ControllerName = "monitoring-controller"
+	// windowsExporterName is the name of the Service, Endpoints and ServiceMonitor listing the Windows node exporters
+	windowsExporterName = "windows-exporter"
+	// metricsPortName is the name of the Windows node exporter port in the Service and Endpoints
+	metricsPortName = "metrics"
+)
+
+var log = logf.Log.WithName(ControllerName)
+
+// Add creates a new monitoring Controller and adds it to the Manager. The Controller watches the WindowsMachineConfig
+// and the Windows nodes, runs the Windows node exporter on the configured nodes when monitoring is enabled, and lists
+// the nodes in a Service monitored by the cluster Prometheus.
+func Add(mgr manager.Manager, network *clusternetwork.Store, nodes *nodeconfig.NodeWatcher,
+	clusterCache cache.Cache, payload *operatorconfig.Payload) error {
+	reconciler, err := newReconciler(mgr, network, nodes, clusterCache, payload)
+	if err != nil {
+		return errors.Wrapf(err, "could not create %s reconciler", ControllerName)
+	}
+	return add(mgr, reconciler, clusterCache)
+}
+
+// newReconciler returns a new ReconcileMonitoring reading the WindowsMachineConfig and the nodes from the given reader
+func newReconciler(mgr manager.Manager, network *clusternetwork.Store, nodes *nodeconfig.NodeWatcher,
+	reader client.Reader, payload *operatorconfig.Payload) (*ReconcileMonitoring, error) {
+	clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating kubernetes clientset")
+	}
+	monitoringClient, err := monclient.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating monitoring clientset")
+	}
+	sshSigner, err := signer.Create()
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating signer using private key: %v", wkl.PrivateKeyPath)
+	}
+	namespace, err := k8sutil.GetWatchNamespace()
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting watch namespace")
+	}
+	windowsNodeSelector, err := labels.Parse(nodeconfig.WindowsOSLabel)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing Windows node label %s", nodeconfig.WindowsOSLabel)
+	}
+
+	return &ReconcileMonitoring{
+		k8sclientset:        clientset,
+		monitoringClient:    monitoringClient,
+		reader:              reader,
+		namespace:           namespace,
+		windowsNodeSelector: windowsNodeSelector,
+		network:             network,
+		nodes:               nodes,
+		payload:             payload,
+		signer:              sshSigner,
+		recorder:            mgr.GetEventRecorderFor(ControllerName),
+	}, nil
+}
+
+// add adds a new Controller to mgr with r as the reconcile.Reconciler, watching the objects of the given cache
+func add(mgr manager.Manager, r *ReconcileMonitoring, clusterCache cache.Cache) error {
+	c, err := controller.New(ControllerName, mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return errors.Wrapf(err, "could not create %s", ControllerName)
+	}
+	if err := c.Watch(source.NewKindWithCache(&wmcapi.WindowsMachineConfig{}, clusterCache),
+		&handler.EnqueueRequestForObject{}); err != nil {
+		return errors.Wrap(err, "could not create watch on WindowsMachineConfig")
+	}
+	// The exporters are deployed once the nodes are configured, and the Endpoints follow the nodes as they come and go
+	if err := c.Watch(source.NewKindWithCache(&core.Node{}, clusterCache), &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(object handler.MapObject) []reconcile.Request {
+			if !r.windowsNodeSelector.Matches(labels.Set(object.Meta.GetLabels())) {
+				return nil
+			}
+			return []reconcile.Request{{NamespacedName: kubeTypes.NamespacedName{
+				Name: wmcapi.WindowsMachineConfigName}}}
+		}),
+	}); err != nil {
+		return errors.Wrap(err, "could not create watch on Nodes")
+	}
+	return nil
+}
+
+// blank assignment to verify that ReconcileMonitoring implements reconcile.Reconciler
+var _ reconcile.Reconciler = &ReconcileMonitoring{}
+
+// ReconcileMonitoring reconciles the WindowsMachineConfig monitoring settings with the Windows nodes and the objects
+// registering them with the cluster monitoring
+type ReconcileMonitoring struct {
+	// k8sclientset holds the kube client that we can re-use for all kube objects other than custom resources.
+	k8sclientset *kubernetes.Clientset
+	// monitoringClient manages the ServiceMonitor
+	monitoringClient monclient.MonitoringV1Interface
+	// reader reads the WindowsMachineConfig and the nodes from the cluster scoped cache
+	reader client.Reader
+	// namespace is the namespace the Service, Endpoints and ServiceMonitor are created in
+	namespace string
+	// windowsNodeSelector selects the Windows nodes
+	windowsNodeSelector labels.Selector
+	// network holds the current cluster network configuration, shared with the other controllers
+	network *clusternetwork.Store
+	// nodes serves the Windows nodes from the shared informer
+	nodes *nodeconfig.NodeWatcher
+	// payload holds the contents of the payload directory read when the operator started
+	payload *operatorconfig.Payload
+	// signer is a signer created from the user's private key
+	signer ssh.Signer
+	// recorder to generate events
+	recorder record.EventRecorder
+}
+
+// Reconcile deploys the Windows node exporter on the configured Windows nodes whose WindowsExporterAnnotation does
+// not match the monitoring settings, and lists the nodes running it in the windows-exporter Endpoints. When
+// monitoring is disabled, the exporters and the monitoring objects are removed.
+func (r *ReconcileMonitoring) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	if request.Name != wmcapi.WindowsMachineConfigName {
+		return reconcile.Result{}, nil
+	}
+	config, err := operatorconfig.Get(r.reader)
+	if err != nil {
+		return reconcile.Result{}, errors.Wrap(err, "error getting operator configuration")
+	}
+	if err := operatorconfig.Validate(config, r.payload); err != nil {
+		// Requeuing will not help until the configuration is changed, which triggers a new reconcile
+		log.Error(err, "invalid operator configuration, Windows node monitoring will not be updated")
+		return reconcile.Result{}, nil
+	}
+
+	nodes := &core.NodeList{}
+	if err := r.reader.List(context.TODO(), nodes,
+		client.MatchingLabelsSelector{Selector: r.windowsNodeSelector}); err != nil {
+		return reconcile.Result{}, errors.Wrap(err, "error listing Windows nodes")
+	}
+
+	monitoring := config.Spec.Monitoring
+	port := strconv.Itoa(int(monitoring.Port))
+	var failedNodes []string
+	result := reconcile.Result{}
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		applied, found := node.GetAnnotations()[nodeconfig.WindowsExporterAnnotation]
+		switch {
+		case monitoring.Enabled && applied != port:
+			// Nodes which have not finished their initial configuration are picked up once they have
+			if _, configured := node.GetAnnotations()[nodeconfig.KubeletConfigAnnotation]; !configured {
+				continue
+			}
+		case !monitoring.Enabled && found:
+		default:
+			continue
+		}
+		paused, err := machinecontrol.NodePaused(r.reader, node)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		if paused {
+			log.V(1).Info("skipping paused Windows node", "node", node.GetName())
+			result.RequeueAfter = machinecontrol.PausedRequeueInterval
+			continue
+		}
+		err = r.updateNode(node, config, monitoring.Enabled)
+		if err != nil {
+			r.recorder.Eventf(node, core.EventTypeWarning, "WMCO MonitoringUpdateFailure",
+				"Node %s failed to be updated with the monitoring settings: %v", node.GetName(), err)
+			log.Error(err, "error updating Windows node exporter", "node", node.GetName())
+			failedNodes = append(failedNodes, node.GetName())
+		}
+	}
+
+	if monitoring.Enabled {
+		err = r.ensureMonitoringObjects(monitoring.Port, exporterAddresses(nodes.Items, port, config.Spec.Address))
+	} else {
+		err = r.deleteMonitoringObjects()
+	}
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if len(failedNodes) > 0 {
+		// The failed nodes are retried with backoff, the other nodes being listed in the Endpoints in the meantime
+		return reconcile.Result{}, errors.Errorf("error updating Windows node exporters of nodes: %s",
+			strings.Join(failedNodes, ", "))
+	}
+	return result, nil
+}
+
+// updateNode deploys the Windows node exporter on the given node if enabled is true, and removes it otherwise
+func (r *ReconcileMonitoring) updateNode(node *core.Node, config *wmcapi.WindowsMachineConfig, enabled bool) error {
+	nc, err := nodeconfig.NewNodeConfigFromAddresses(r.k8sclientset, r.nodes, node.Status.Addresses,
+		nodeconfig.InstanceIDFromProviderID(node.Spec.ProviderID), r.network.Get(), &config.Spec, r.signer,
+		r.payload.CompatibilityMatrix)
+	if err != nil {
+		return errors.Wrapf(err, "error creating node config for %s", node.GetName())
+	}
+	if enabled {
+		err = nc.ConfigureWindowsExporter(node)
+	} else {
+		err = nc.RemoveWindowsExporter(node)
+	}
+	if err != nil {
+		return err
+	}
+	log.Info("updated Windows node exporter", "node", node.GetName(), "enabled", enabled)
+	return nil
+}
+
+// exporterAddresses returns the addresses of the given nodes running the Windows node exporter on the given port,
+// sorted by IP so that the Endpoints are only updated when the nodes change. The exporters are scraped through the
+// address the nodes were set up through, or the address chosen by the given address policy if it was not recorded.
+func exporterAddresses(nodes []core.Node, port string, policy wmcapi.AddressSpec) []core.EndpointAddress {
+	var addresses []core.EndpointAddress
+	for i := range nodes {
+		node := &nodes[i]
+		ipAddress := nodeAddress(node, policy)
+		if node.GetAnnotations()[nodeconfig.WindowsExporterAnnotation] != port || ipAddress == "" ||
+			node.GetDeletionTimestamp() != nil {
+			continue
+		}
+		nodeName := node.GetName()
+		addresses = append(addresses, core.EndpointAddress{IP: ipAddress, NodeName: &nodeName})
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i].IP < addresses[j].IP })
+	return addresses
+}
+
+// nodeAddress returns the address the given node was set up through, or its first internal address matching the
+// given address policy if it was not recorded. An empty string is returned if the node has no such address.
+func nodeAddress(node *core.Node, policy wmcapi.AddressSpec) string {
+	if address, recorded := node.GetAnnotations()[nodeconfig.AddressAnnotation]; recorded {
+		return address
+	}
+	candidates := nodeconfig.CandidateAddresses(node.Status.Addresses, policy)
+	if len(candidates) == 0 {
+		return ""
+	}
+	return candidates[0]
+}
+
+// ensureMonitoringObjects creates or updates the headless Service and the Endpoints listing the given addresses of
+// the Windows node exporters listening on the given port, and the ServiceMonitor scraping them
+func (r *ReconcileMonitoring) ensureMonitoringObjects(port int32, addresses []core.EndpointAddress) error {
+	objectMeta := meta.ObjectMeta{Name: windowsExporterName, Namespace: r.namespace,
+		Labels: map[string]string{"name": windowsExporterName}}
+
+	// The Service has no selector, as the Windows node exporters do not run in pods
+	service := &core.Service{ObjectMeta: objectMeta, Spec: core.ServiceSpec{
+		ClusterIP: core.ClusterIPNone,
+		Ports: []core.ServicePort{{Name: metricsPortName, Port: port, Protocol: core.ProtocolTCP,
+			TargetPort: intstr.FromInt(int(port))}},
+	}}
+	if err := r.ensureService(service); err != nil {
+		return err
+	}
+
+	endpoints := &core.Endpoints{ObjectMeta: objectMeta}
+	if len(addresses) > 0 {
+		endpoints.Subsets = []core.EndpointSubset{{Addresses: addresses,
+			Ports: []core.EndpointPort{{Name: metricsPortName, Port: port, Protocol: core.ProtocolTCP}}}}
+	}
+	if err := r.ensureEndpoints(endpoints); err != nil {
+		return err
+	}
+
+	serviceMonitor := &monv1.ServiceMonitor{ObjectMeta: objectMeta, Spec: monv1.ServiceMonitorSpec{
+		Selector: meta.LabelSelector{MatchLabels: objectMeta.Labels},
+		Endpoints: []monv1.Endpoint{{
+			Port:     metricsPortName,
+			Interval: "30s",
+			Scheme:   "http",
+			// Identify the series by node rather than by IP, so that they match the other node metrics
+			RelabelConfigs: []*monv1.RelabelConfig{{
+				SourceLabels: []string{"__meta_kubernetes_endpoint_node_name"},
+				TargetLabel:  "instance",
+				Action:       "replace",
+			}},
+		}},
+	}}
+	return r.ensureServiceMonitor(serviceMonitor)
+}
+
+// ensureService creates the given Service or updates the ports of the existing one
+func (r *ReconcileMonitoring) ensureService(service *core.Service) error {
+	services := r.k8sclientset.CoreV1().Services(r.namespace)
+	existing, err := services.Get(context.TODO(), service.GetName(), meta.GetOptions{})
+	if err != nil {
+		if !k8sapierrors.IsNotFound(err) {
+			return errors.Wrapf(err, "error getting Service %s", service.GetName())
+		}
+		_, err = services.Create(context.TODO(), service, meta.CreateOptions{})
+		return errors.Wrapf(err, "error creating Service %s", service.GetName())
+	}
+	if reflect.DeepEqual(existing.Spec.Ports, service.Spec.Ports) {
+		return nil
+	}
+	existing.Spec.Ports = service.Spec.Ports
+	_, err = services.Update(context.TODO(), existing, meta.UpdateOptions{})
+	return errors.Wrapf(err, "error updating Service %s", service.GetName())
+}
+
+// ensureEndpoints creates the given Endpoints or updates the subsets of the existing one
+func (r *ReconcileMonitoring) ensureEndpoints(endpoints *core.Endpoints) error {
+	endpointsClient := r.k8sclientset.CoreV1().Endpoints(r.namespace)
+	existing, err := endpointsClient.Get(context.TODO(), endpoints.GetName(), meta.GetOptions{})
+	if err != nil {
+		if !k8sapierrors.IsNotFound(err) {
+			return errors.Wrapf(err, "error getting Endpoints %s", endpoints.GetName())
+		}
+		_, err = endpointsClient.Create(context.TODO(), endpoints, meta.CreateOptions{})
+		return errors.Wrapf(err, "error creating Endpoints %s", endpoints.GetName())
+	}
+	if reflect.DeepEqual(existing.Subsets, endpoints.Subsets) {
+		return nil
+	}
+	existing.Subsets = endpoints.Subsets
+	_, err = endpointsClient.Update(context.TODO(), existing, meta.UpdateOptions{})
+	if err == nil {
+		log.Info("updated Windows node exporter endpoints", "addresses", len(endpoints.Subsets))
+	}
+	return errors.Wrapf(err, "error updating Endpoints %s", endpoints.GetName())
+}
+
+// ensureServiceMonitor creates the given ServiceMonitor or updates the spec of the existing one. Nothing is done if
+// the ServiceMonitor API is not installed, as the cluster has no Prometheus to register the nodes with.
+func (r *ReconcileMonitoring) ensureServiceMonitor(serviceMonitor *monv1.ServiceMonitor) error {
+	serviceMonitors := r.monitoringClient.ServiceMonitors(r.namespace)
+	existing, err := serviceMonitors.Get(context.TODO(), serviceMonitor.GetName(), meta.GetOptions{})
+	if err != nil {
+		if !k8sapierrors.IsNotFound(err) {
+			return errors.Wrapf(err, "error getting ServiceMonitor %s", serviceMonitor.GetName())
+		}
+		_, err = serviceMonitors.Create(context.TODO(), serviceMonitor, meta.CreateOptions{})
+		if k8sapierrors.IsNotFound(err) {
+			log.Info("ServiceMonitor API not found, Windows node metrics will not be collected by Prometheus")
+			return nil
+		}
+		return errors.Wrapf(err, "error creating ServiceMonitor %s", serviceMonitor.GetName())
+	}
+	if reflect.DeepEqual(existing.Spec, serviceMonitor.Spec) {
+		return nil
+	}
+	existing.Spec = serviceMonitor.Spec
+	_, err = serviceMonitors.Update(context.TODO(), existing, meta.UpdateOptions{})
+	return errors.Wrapf(err, "error updating ServiceMonitor %s", serviceMonitor.GetName())
+}
+
+// deleteMonitoringObjects deletes the Service, Endpoints and ServiceMonitor of the Windows node exporters
+func (r *ReconcileMonitoring) deleteMonitoringObjects() error {
+	deletes := map[string]func() error{
+		"ServiceMonitor": func() error {
+			return r.monitoringClient.ServiceMonitors(r.namespace).Delete(context.TODO(), windowsExporterName,
+				meta.DeleteOptions{})
+		},
+		"Endpoints": func() error {
+			return r.k8sclientset.CoreV1().Endpoints(r.namespace).Delete(context.TODO(), windowsExporterName,
+				meta.DeleteOptions{})
+		},
+		"Service": func() error {
+			return r.k8sclientset.CoreV1().Services(r.namespace).Delete(context.TODO(), windowsExporterName,
+				meta.DeleteOptions{})
+		},
+	}
+	for kind, deleteObject := range deletes {
+		if err := deleteObject(); err != nil && !k8sapierrors.IsNotFound(err) {
+			return errors.Wrapf(err, "error deleting %s %s", kind, windowsExporterName)
+		}
+	}
+	return nil
+}
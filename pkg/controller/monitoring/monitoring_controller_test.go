@@ -0,0 +1,52 @@
+package monitoring
+
+import (
+	"testing"
+
+	wmcapi "github.com/openshift/windows-machine-config-operator/pkg/apis/wmc/v1alpha1"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/windowsmachine/nodeconfig"
+	"github.com/stretchr/testify/assert"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestExporterAddresses tests that only the nodes running the Windows node exporter on the configured port are listed,
+// sorted by IP, through the address the node was set up through if it was recorded
+func TestExporterAddresses(t *testing.T) {
+	newNode := func(name, ip, port string) core.Node {
+		node := core.Node{ObjectMeta: meta.ObjectMeta{Name: name, Annotations: map[string]string{}}}
+		if port != "" {
+			node.Annotations[nodeconfig.WindowsExporterAnnotation] = port
+		}
+		if ip != "" {
+			node.Status.Addresses = []core.NodeAddress{{Type: core.NodeInternalIP, Address: ip}}
+		}
+		return node
+	}
+	deleted := newNode("deleted", "10.0.0.5", "9182")
+	now := meta.Now()
+	deleted.DeletionTimestamp = &now
+	nodes := []core.Node{
+		newNode("second", "10.0.0.2", "9182"),
+		newNode("first", "10.0.0.1", "9182"),
+		newNode("other-port", "10.0.0.3", "9100"),
+		newNode("no-exporter", "10.0.0.4", ""),
+		newNode("no-ip", "", "9182"),
+		deleted,
+	}
+
+	recorded := newNode("recorded", "10.0.0.6", "9182")
+	recorded.Annotations[nodeconfig.AddressAnnotation] = "10.0.1.6"
+	nodes = append(nodes, recorded)
+
+	policy := wmcapi.AddressSpec{Family: wmcapi.IPv4}
+	addresses := exporterAddresses(nodes, "9182", policy)
+	var ips, names []string
+	for _, address := range addresses {
+		ips = append(ips, address.IP)
+		names = append(names, *address.NodeName)
+	}
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2", "10.0.1.6"}, ips)
+	assert.Equal(t, []string{"first", "second", "recorded"}, names)
+	assert.Empty(t, exporterAddresses(nodes, "9200", policy))
+}
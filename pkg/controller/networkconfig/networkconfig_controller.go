@@ -0,0 +1,281 @@
+package networkconfig
+
+import (
+	"context"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+	configclient "github.com/openshift/client-go/config/clientset/versioned"
+	configinformers "github.com/openshift/client-go/config/informers/externalversions"
+	operatorclient "github.com/openshift/client-go/operator/clientset/versioned"
+	operatorinformers "github.com/openshift/client-go/operator/informers/externalversions"
+	wmcapi "github.com/openshift/windows-machine-config-operator/pkg/apis/wmc/v1alpha1"
+	"github.com/openshift/windows-machine-config-operator/pkg/clusternetwork"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/machinecontrol"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/operatorconfig"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/signer"
+	wkl "github.com/openshift/windows-machine-config-operator/pkg/controller/wellknownlocations"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/windowsmachine/nodeconfig"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeTypes "k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	// ControllerName is the name of the network configuration controller
+	ControllerName = "networkconfig-controller"
+	// clusterNetworkName is the name of the network.config and network.operator singletons
+	clusterNetworkName = "cluster"
+)
+
+var log = logf.Log.WithName(ControllerName)
+
+// Add creates a new network configuration Controller and adds it to the Manager. The Controller watches the
+// network.config and network.operator objects and the WindowsMachineConfig, and updates the given network Store when
+// they change.
+func Add(mgr manager.Manager, network *clusternetwork.Store, nodes *nodeconfig.NodeWatcher,
+	clusterCache cache.Cache, payload *operatorconfig.Payload) error {
+	reconciler, err := newReconciler(mgr, network, nodes, payload)
+	if err != nil {
+		return errors.Wrapf(err, "could not create %s reconciler", ControllerName)
+	}
+	return add(mgr, reconciler, clusterCache)
+}
+
+// newReconciler returns a new ReconcileNetworkConfig
+func newReconciler(mgr manager.Manager, network *clusternetwork.Store, nodes *nodeconfig.NodeWatcher,
+	payload *operatorconfig.Payload) (*ReconcileNetworkConfig, error) {
+	oclient, err := configclient.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating config clientset")
+	}
+	operatorClient, err := operatorclient.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating operator clientset")
+	}
+	clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating kubernetes clientset")
+	}
+	dynamicClient, err := dynamic.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating dynamic client")
+	}
+	sshSigner, err := signer.Create()
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating signer using private key: %v", wkl.PrivateKeyPath)
+	}
+
+	return &ReconcileNetworkConfig{
+		oclient:        oclient,
+		operatorClient: operatorClient,
+		k8sclientset:   clientset,
+		dynamicClient:  dynamicClient,
+		reader:         mgr.GetAPIReader(),
+		network:        network,
+		nodes:          nodes,
+		payload:        payload,
+		signer:         sshSigner,
+		recorder:       mgr.GetEventRecorderFor(ControllerName),
+	}, nil
+}
+
+// add adds a new Controller to mgr with r as the reconcile.Reconciler, watching the WindowsMachineConfig through the
+// given cache
+func add(mgr manager.Manager, r *ReconcileNetworkConfig, clusterCache cache.Cache) error {
+	c, err := controller.New(ControllerName, mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return errors.Wrapf(err, "could not create %s", ControllerName)
+	}
+
+	// The network objects are cluster scoped, so they cannot be served by the manager's namespaced cache. Use
+	// dedicated informers which are started along with the manager instead.
+	configInformerFactory := configinformers.NewSharedInformerFactory(r.oclient, 0)
+	operatorInformerFactory := operatorinformers.NewSharedInformerFactory(r.operatorClient, 0)
+	informers := []source.Source{
+		&source.Informer{Informer: configInformerFactory.Config().V1().Networks().Informer()},
+		&source.Informer{Informer: operatorInformerFactory.Operator().V1().Networks().Informer()},
+	}
+	// Both network objects map to the same request, as a change to either of them can change the Windows node
+	// network configuration
+	toClusterRequest := &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(_ handler.MapObject) []reconcile.Request {
+			return []reconcile.Request{{NamespacedName: kubeTypes.NamespacedName{Name: clusterNetworkName}}}
+		}),
+	}
+	for _, informer := range informers {
+		if err := c.Watch(informer, toClusterRequest); err != nil {
+			return errors.Wrap(err, "could not create watch on cluster network objects")
+		}
+	}
+	if err := c.Watch(source.NewKindWithCache(&wmcapi.WindowsMachineConfig{}, clusterCache),
+		toClusterRequest); err != nil {
+		return errors.Wrap(err, "could not create watch on WindowsMachineConfig")
+	}
+
+	return mgr.Add(manager.RunnableFunc(func(stop <-chan struct{}) error {
+		configInformerFactory.Start(stop)
+		operatorInformerFactory.Start(stop)
+		<-stop
+		return nil
+	}))
+}
+
+// blank assignment to verify that ReconcileNetworkConfig implements reconcile.Reconciler
+var _ reconcile.Reconciler = &ReconcileNetworkConfig{}
+
+// ReconcileNetworkConfig reconciles the cluster network configuration with the Windows nodes
+type ReconcileNetworkConfig struct {
+	// oclient is the OpenShift config client, used to get the network.config object
+	oclient configclient.Interface
+	// operatorClient is the OpenShift operator client, used to get the network.operator object
+	operatorClient operatorclient.Interface
+	// k8sclientset holds the kube client that we can re-use for all kube objects other than custom resources.
+	k8sclientset *kubernetes.Clientset
+	// dynamicClient is used to read the network.operator fields missing from the vendored OpenShift API
+	dynamicClient dynamic.Interface
+	// reader reads the WindowsMachineConfig directly from the API server
+	reader client.Reader
+	// network holds the current cluster network configuration, shared with the other controllers
+	network *clusternetwork.Store
+	// nodes serves the Windows nodes from the shared informer
+	nodes *nodeconfig.NodeWatcher
+	// payload holds the contents of the payload directory read when the operator started
+	payload *operatorconfig.Payload
+	// signer is a signer created from the user's private key
+	signer ssh.Signer
+	// recorder to generate events
+	recorder record.EventRecorder
+}
+
+// Reconcile validates the cluster network configuration and updates the Windows nodes whose NetworkConfigAnnotation
+// does not match it and the WindowsMachineConfig network and kube-proxy settings. One node is updated per reconcile,
+// which is requeued until every node is up to date, so that the workloads can be moved to the other nodes. An invalid
+// configuration is logged and ignored, leaving the current configuration in place.
+func (r *ReconcileNetworkConfig) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	network, err := clusternetwork.NetworkConfigurationFactory(r.oclient, r.operatorClient.OperatorV1(),
+		r.k8sclientset, r.dynamicClient)
+	if err != nil {
+		return reconcile.Result{}, errors.Wrap(err, "error getting cluster network configuration")
+	}
+	if err := network.Validate(); err != nil {
+		// Requeuing will not help until the configuration is changed, which triggers a new reconcile
+		log.Error(err, "invalid cluster network configuration, Windows nodes will not be updated")
+		r.recordValidationErrors(err)
+		return reconcile.Result{}, nil
+	}
+
+	config, err := operatorconfig.Get(r.reader)
+	if err != nil {
+		return reconcile.Result{}, errors.Wrap(err, "error getting operator configuration")
+	}
+	if err := operatorconfig.Validate(config, r.payload); err != nil {
+		log.Error(err, "invalid operator configuration, Windows nodes will not be updated")
+		r.recorder.Eventf(config, core.EventTypeWarning, "WMCO InvalidConfiguration",
+			"Windows nodes will not be updated: %v", err)
+		return reconcile.Result{}, nil
+	}
+
+	if r.network.Update(network) {
+		log.Info("cluster network configuration changed")
+	}
+	desired, err := nodeconfig.NetworkConfigAnnotationValue(network, &config.Spec)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	nodes, err := r.k8sclientset.CoreV1().Nodes().List(context.TODO(),
+		meta.ListOptions{LabelSelector: nodeconfig.WindowsOSLabel})
+	if err != nil {
+		return reconcile.Result{}, errors.Wrap(err, "error listing Windows nodes")
+	}
+	result := reconcile.Result{}
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		// Nodes which have not finished their initial configuration pick up the current configuration with it. The
+		// network annotation is not used to tell them apart, as nodes configured before it was introduced lack it.
+		if _, configured := node.GetAnnotations()[nodeconfig.KubeletConfigAnnotation]; !configured {
+			continue
+		}
+		if node.GetAnnotations()[nodeconfig.NetworkConfigAnnotation] == desired {
+			continue
+		}
+		if network.HostSubnet(node) == "" {
+			log.V(1).Info("skipping node with unconfigured network", "node", node.GetName())
+			continue
+		}
+		paused, err := machinecontrol.NodePaused(r.reader, node)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		if paused {
+			log.Info("skipping paused Windows node", "node", node.GetName())
+			result.RequeueAfter = machinecontrol.PausedRequeueInterval
+			continue
+		}
+		// Stop the rollout on failure, so that a problem with the configuration does not take down every node
+		if err := r.updateNode(node, network, &config.Spec); err != nil {
+			return reconcile.Result{}, errors.Wrapf(err, "error updating network configuration of node %s",
+				node.GetName())
+		}
+		// The remaining nodes are updated by the next reconcile
+		return reconcile.Result{Requeue: true}, nil
+	}
+	return result, nil
+}
+
+// updateNode redeploys the network backend setup, CNI configuration and kube-proxy arguments of the given Windows node
+// as required, and records the configuration in its NetworkConfigAnnotation
+func (r *ReconcileNetworkConfig) updateNode(node *core.Node, network clusternetwork.ClusterNetworkConfig,
+	config *wmcapi.WindowsMachineConfigSpec) error {
+	nc, err := nodeconfig.NewNodeConfigFromAddresses(r.k8sclientset, r.nodes, node.Status.Addresses,
+		nodeconfig.InstanceIDFromProviderID(node.Spec.ProviderID), network, config, r.signer,
+		r.payload.CompatibilityMatrix)
+	if err != nil {
+		return errors.Wrapf(err, "error creating node config for %s", node.GetName())
+	}
+	if err := nc.UpdateNetwork(node); err != nil {
+		r.recorder.Eventf(node, core.EventTypeWarning, "WMCO NetworkUpdateFailure",
+			"Node %s failed to be updated with the cluster network configuration", node.GetName())
+		return err
+	}
+	r.recorder.Eventf(node, core.EventTypeNormal, "WMCO NetworkUpdate",
+		"Node %s updated with the cluster network configuration", node.GetName())
+	log.Info("updated Windows node network configuration", "node", node.GetName())
+	return nil
+}
+
+// recordValidationErrors emits a warning event on the network.operator object for each problem found in the cluster
+// network configuration, so that they can be acted upon without looking at the operator logs
+func (r *ReconcileNetworkConfig) recordValidationErrors(validationErr error) {
+	networkCR, err := r.operatorClient.OperatorV1().Networks().Get(context.TODO(), clusterNetworkName,
+		meta.GetOptions{})
+	if err != nil {
+		log.Error(err, "error getting network.operator object to record validation errors")
+		return
+	}
+	// The clientset does not populate the type information, which the recorder needs to reference the object
+	networkCR.SetGroupVersionKind(operatorv1.GroupVersion.WithKind("Network"))
+
+	errs := []error{validationErr}
+	if aggregate, ok := validationErr.(utilerrors.Aggregate); ok {
+		errs = aggregate.Errors()
+	}
+	for _, err := range errs {
+		r.recorder.Eventf(networkCR, core.EventTypeWarning, "WMCO InvalidNetworkConfiguration",
+			"Windows nodes will not be updated: %v", err)
+	}
+}
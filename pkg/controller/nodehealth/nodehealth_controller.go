@@ -0,0 +1,437 @@
+package nodehealth
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	wmcapi "github.com/openshift/windows-machine-config-operator/pkg/apis/wmc/v1alpha1"
+	"github.com/openshift/windows-machine-config-operator/pkg/clusternetwork"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/machinecontrol"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/operatorconfig"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/signer"
+	wkl "github.com/openshift/windows-machine-config-operator/pkg/controller/wellknownlocations"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/windowsmachine/nodeconfig"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	core "k8s.io/api/core/v1"
+	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	// ControllerName is the name of the Windows node health controller
+	ControllerName = "nodehealth-controller"
+	// NodeHealthyCondition is the type of the Node condition reporting the health of all the components checked on a
+	// Windows node
+	NodeHealthyCondition core.NodeConditionType = "WindowsNodeHealthy"
+	// HybridOverlayReadyCondition is the type of the Node condition reporting whether the hybrid overlay of a Windows
+	// node is running with HNS networks matching the configuration
+	HybridOverlayReadyCondition core.NodeConditionType = "WindowsHybridOverlayReady"
+	// CNIConfiguredCondition is the type of the Node condition reporting whether the CNI configuration of a Windows
+	// node matches the cluster network
+	CNIConfiguredCondition core.NodeConditionType = "WindowsCNIConfigured"
+	// KubeProxyReadyCondition is the type of the Node condition reporting whether kube-proxy is running on a Windows
+	// node with the network resources it depends on
+	KubeProxyReadyCondition core.NodeConditionType = "WindowsKubeProxyReady"
+)
+
+// Reasons of the health conditions, in addition to the reasons of the problems found on the nodes
+const (
+	// reasonHealthy is set when the last health check found no problem
+	reasonHealthy = "Healthy"
+	// reasonRemediated is set when the problems found by the last health check have been repaired
+	reasonRemediated = "Remediated"
+	// reasonUnhealthy is set when the problems found by the last health check have not been repaired
+	reasonUnhealthy = "Unhealthy"
+	// reasonHealthCheckFailed is set when the last health check could not be run
+	reasonHealthCheckFailed = "HealthCheckFailed"
+	// reasonHealthChecksDisabled is set when the health checks have been disabled
+	reasonHealthChecksDisabled = "HealthChecksDisabled"
+)
+
+// componentCondition describes the Node condition reporting the health of a component of the Windows nodes
+type componentCondition struct {
+	// component is the component reported by the condition
+	component string
+	// conditionType is the type of the condition
+	conditionType core.NodeConditionType
+	// healthyReason is the reason of the condition when the component is healthy
+	healthyReason string
+	// healthyMessage is the message of the condition when the component is healthy
+	healthyMessage string
+}
+
+// componentConditions lists the conditions reporting a single component. The kubelet has none, as its health is
+// already reported by the Ready condition.
+var componentConditions = []componentCondition{
+	{nodeconfig.ComponentNetwork, HybridOverlayReadyCondition, "HybridOverlayRunning",
+		"hybrid-overlay is running and its HNS networks match the configuration"},
+	{nodeconfig.ComponentCNI, CNIConfiguredCondition, "CNIConfigured",
+		"CNI configuration matches the cluster network"},
+	{nodeconfig.ComponentKubeProxy, KubeProxyReadyCondition, "KubeProxyRunning",
+		"kube-proxy is running with its source VIP endpoint"},
+}
+
+var log = logf.Log.WithName(ControllerName)
+
+// Add creates a new Windows node health Controller and adds it to the Manager. The Controller periodically checks the
+// components run on the configured Windows nodes, repairs the unhealthy ones and reports the results as conditions
+// of each node.
+func Add(mgr manager.Manager, network *clusternetwork.Store, nodes *nodeconfig.NodeWatcher,
+	clusterCache cache.Cache, payload *operatorconfig.Payload) error {
+	reconciler, err := newReconciler(mgr, network, nodes, clusterCache, payload)
+	if err != nil {
+		return errors.Wrapf(err, "could not create %s reconciler", ControllerName)
+	}
+	return add(mgr, reconciler, clusterCache)
+}
+
+// newReconciler returns a new ReconcileNodeHealth reading the WindowsMachineConfig and the nodes from the given reader
+func newReconciler(mgr manager.Manager, network *clusternetwork.Store, nodes *nodeconfig.NodeWatcher,
+	reader client.Reader, payload *operatorconfig.Payload) (*ReconcileNodeHealth, error) {
+	clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating kubernetes clientset")
+	}
+	sshSigner, err := signer.Create()
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating signer using private key: %v", wkl.PrivateKeyPath)
+	}
+
+	return &ReconcileNodeHealth{
+		k8sclientset: clientset,
+		reader:       reader,
+		network:      network,
+		nodes:        nodes,
+		payload:      payload,
+		signer:       sshSigner,
+		recorder:     mgr.GetEventRecorderFor(ControllerName),
+	}, nil
+}
+
+// add adds a new Controller to mgr with r as the reconcile.Reconciler, watching the nodes of the given cache
+func add(mgr manager.Manager, r *ReconcileNodeHealth, clusterCache cache.Cache) error {
+	c, err := controller.New(ControllerName, mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return errors.Wrapf(err, "could not create %s", ControllerName)
+	}
+	windowsNodeSelector, err := labels.Parse(nodeconfig.WindowsOSLabel)
+	if err != nil {
+		return errors.Wrapf(err, "error parsing Windows node label %s", nodeconfig.WindowsOSLabel)
+	}
+	isWindowsNode := func(object meta.Object) bool {
+		return windowsNodeSelector.Matches(labels.Set(object.GetLabels()))
+	}
+	// Each node is checked periodically once it has been seen, so only the events starting the checks are of
+	// interest: the node being listed, and its initial configuration completing. Reacting to the other updates would
+	// run a check on every node status update, including the ones made by this controller.
+	if err := c.Watch(source.NewKindWithCache(&core.Node{}, clusterCache), &handler.EnqueueRequestForObject{},
+		predicate.Funcs{
+			CreateFunc: func(e event.CreateEvent) bool { return isWindowsNode(e.Meta) },
+			UpdateFunc: func(e event.UpdateEvent) bool {
+				_, wasConfigured := e.MetaOld.GetAnnotations()[nodeconfig.KubeletConfigAnnotation]
+				_, configured := e.MetaNew.GetAnnotations()[nodeconfig.KubeletConfigAnnotation]
+				return isWindowsNode(e.MetaNew) && configured && !wasConfigured
+			},
+			DeleteFunc:  func(event.DeleteEvent) bool { return false },
+			GenericFunc: func(event.GenericEvent) bool { return false },
+		}); err != nil {
+		return errors.Wrap(err, "could not create watch on Nodes")
+	}
+	return nil
+}
+
+// blank assignment to verify that ReconcileNodeHealth implements reconcile.Reconciler
+var _ reconcile.Reconciler = &ReconcileNodeHealth{}
+
+// ReconcileNodeHealth checks and repairs the components of the configured Windows nodes
+type ReconcileNodeHealth struct {
+	// k8sclientset holds the kube client that we can re-use for all kube objects other than custom resources.
+	k8sclientset *kubernetes.Clientset
+	// reader reads the WindowsMachineConfig and the nodes from the cluster scoped cache
+	reader client.Reader
+	// network holds the current cluster network configuration, shared with the other controllers
+	network *clusternetwork.Store
+	// nodes serves the Windows nodes from the shared informer
+	nodes *nodeconfig.NodeWatcher
+	// payload holds the contents of the payload directory read when the operator started
+	payload *operatorconfig.Payload
+	// signer is a signer created from the user's private key
+	signer ssh.Signer
+	// recorder to generate events
+	recorder record.EventRecorder
+}
+
+// Reconcile checks the kubelet, kube-proxy, network and CNI configuration of the given Windows node, repairs them if
+// remediation is enabled, and sets the health conditions on the node. The node is checked again after the health
+// check interval.
+func (r *ReconcileNodeHealth) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	config, err := operatorconfig.Get(r.reader)
+	if err != nil {
+		return reconcile.Result{}, errors.Wrap(err, "error getting operator configuration")
+	}
+	if err := operatorconfig.Validate(config, r.payload); err != nil {
+		// The check is postponed until the configuration is fixed, as the node components cannot be repaired with
+		// invalid settings
+		log.V(1).Info("invalid operator configuration, skipping health check", "node", request.Name)
+		return reconcile.Result{RequeueAfter: operatorconfig.DefaultHealthCheckInterval}, nil
+	}
+	result := reconcile.Result{RequeueAfter: config.Spec.HealthCheck.Interval.Duration}
+
+	node := &core.Node{}
+	if err := r.reader.Get(context.TODO(), request.NamespacedName, node); err != nil {
+		if k8sapierrors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, errors.Wrapf(err, "error getting node %s", request.Name)
+	}
+	if node.GetDeletionTimestamp() != nil {
+		return reconcile.Result{}, nil
+	}
+	// Paused nodes are left as they are, including their conditions, until they are resumed
+	paused, err := machinecontrol.NodePaused(r.reader, node)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if paused {
+		log.V(1).Info("skipping health check of paused node", "node", node.GetName())
+		return result, nil
+	}
+
+	if config.Spec.HealthCheck.Mode == wmcapi.HealthCheckDisabled {
+		return result, r.setConditions(node, disabledConditions(node.Status.Conditions, meta.Now()))
+	}
+	// Nodes which have not finished their initial configuration have their components set up by the windowsmachine
+	// controller
+	if _, configured := node.GetAnnotations()[nodeconfig.KubeletConfigAnnotation]; !configured {
+		return result, nil
+	}
+	// The nodes another controller is working on have their components restarted, they are checked once it is done
+	configuring, err := machinecontrol.NodeConfiguring(r.reader, node)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if configuring || nodeconfig.InstanceBusy(nodeconfig.InstanceIDFromProviderID(node.Spec.ProviderID)) {
+		log.V(1).Info("skipping health check of node being configured", "node", node.GetName())
+		return result, nil
+	}
+
+	return result, r.checkNode(node, config)
+}
+
+// checkResult is the outcome of the health check of a node
+type checkResult struct {
+	// err is set if the check could not be run
+	err error
+	// problems are the problems found by the check and left unrepaired
+	problems []nodeconfig.HealthProblem
+	// repaired are the problems found by the check and repaired
+	repaired []nodeconfig.HealthProblem
+}
+
+// checkNode runs the health checks of the given node, remediates the problems found if enabled by the given
+// configuration, and reports the results on the node
+func (r *ReconcileNodeHealth) checkNode(node *core.Node, config *wmcapi.WindowsMachineConfig) error {
+	result := &checkResult{}
+	nc, err := r.newNodeConfig(node, config)
+	if err == nil {
+		result.problems, err = nc.CheckHealth(node)
+		if err == nil && len(result.problems) > 0 && config.Spec.HealthCheck.Mode == wmcapi.HealthCheckRemediate {
+			r.remediate(nc, node, result)
+		}
+	}
+	if err != nil {
+		log.Error(err, "Windows node health check failed", "node", node.GetName())
+		result.err = err
+	}
+	return r.setConditions(node, nodeConditions(node.Status.Conditions, result, meta.Now()))
+}
+
+// nodeConfig is the subset of the node configuration used by the health checks
+type nodeConfig interface {
+	CheckHealth(*core.Node) ([]nodeconfig.HealthProblem, error)
+	Remediate(*core.Node, []nodeconfig.HealthProblem) ([]nodeconfig.HealthProblem, error)
+}
+
+// newNodeConfig returns the node configuration connecting to the Windows VM of the given node, through the address
+// chosen by the address policy of the given configuration
+func (r *ReconcileNodeHealth) newNodeConfig(node *core.Node, config *wmcapi.WindowsMachineConfig) (nodeConfig,
+	error) {
+	network := r.network.Get()
+	if network == nil {
+		return nil, errors.New("cluster network configuration is not available")
+	}
+	nc, err := nodeconfig.NewNodeConfigFromAddresses(r.k8sclientset, r.nodes, node.Status.Addresses,
+		nodeconfig.InstanceIDFromProviderID(node.Spec.ProviderID), network, &config.Spec, r.signer,
+		r.payload.CompatibilityMatrix)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating node config for %s", node.GetName())
+	}
+	return nc, nil
+}
+
+// remediate repairs the problems of the given result found on the given node, emitting an event for each repair,
+// and checks the node again so that the result reflects the state the node has been left in
+func (r *ReconcileNodeHealth) remediate(nc nodeConfig, node *core.Node, result *checkResult) {
+	repaired, err := nc.Remediate(node, result.problems)
+	for _, problem := range repaired {
+		r.recorder.Eventf(node, core.EventTypeNormal, "WMCO Remediation", "Repaired %s of node %s: %v",
+			problem.Component, node.GetName(), problem.Err)
+	}
+	if err != nil {
+		r.recorder.Eventf(node, core.EventTypeWarning, "WMCO RemediationFailure", "%v", err)
+		log.Error(err, "error repairing Windows node", "node", node.GetName())
+	} else {
+		log.Info("repaired Windows node", "node", node.GetName(), "problems", len(repaired))
+	}
+
+	result.problems, result.err = nc.CheckHealth(node)
+	// Only the problems which are not found again have been repaired
+	for _, problem := range repaired {
+		if findProblem(result.problems, problem.Component) == nil {
+			result.repaired = append(result.repaired, problem)
+		}
+	}
+}
+
+// setConditions sets the given conditions on the given node. An event is emitted when the node becomes unhealthy or
+// healthy again.
+func (r *ReconcileNodeHealth) setConditions(node *core.Node, conditions []core.NodeCondition) error {
+	if len(conditions) == 0 {
+		return nil
+	}
+	existing := findCondition(node.Status.Conditions, NodeHealthyCondition)
+	if healthy := findCondition(conditions, NodeHealthyCondition); healthy != nil {
+		switch {
+		case healthy.Status == core.ConditionFalse && (existing == nil || existing.Status != core.ConditionFalse):
+			r.recorder.Eventf(node, core.EventTypeWarning, "WMCO Unhealthy", "Node %s is unhealthy: %s",
+				node.GetName(), healthy.Message)
+		case healthy.Status == core.ConditionTrue && existing != nil && existing.Status == core.ConditionFalse:
+			r.recorder.Eventf(node, core.EventTypeNormal, "WMCO Healthy", "Node %s is healthy again",
+				node.GetName())
+		}
+	}
+
+	// The conditions are merged by type, so the patch leaves the conditions owned by the kubelet untouched
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{"conditions": conditions},
+	})
+	if err != nil {
+		return errors.Wrap(err, "error marshalling node conditions")
+	}
+	_, err = r.k8sclientset.CoreV1().Nodes().PatchStatus(context.TODO(), node.GetName(), patch)
+	return errors.Wrapf(err, "error setting health conditions on node %s", node.GetName())
+}
+
+// nodeConditions returns NodeHealthyCondition and the component conditions reporting the given health check result,
+// at the given time. The transition times of the given existing conditions are kept if their status is unchanged.
+func nodeConditions(existing []core.NodeCondition, result *checkResult, now meta.Time) []core.NodeCondition {
+	conditions := make([]core.NodeCondition, 0, len(componentConditions)+1)
+	add := func(conditionType core.NodeConditionType, status core.ConditionStatus, reason, message string) {
+		conditions = append(conditions, newCondition(findCondition(existing, conditionType), conditionType, status,
+			reason, message, now))
+	}
+
+	switch {
+	case result.err != nil:
+		add(NodeHealthyCondition, core.ConditionUnknown, reasonHealthCheckFailed, result.err.Error())
+	case len(result.problems) > 0:
+		add(NodeHealthyCondition, core.ConditionFalse, reasonUnhealthy, problemsMessage(result.problems))
+	case len(result.repaired) > 0:
+		add(NodeHealthyCondition, core.ConditionTrue, reasonRemediated, "repaired "+problemsMessage(result.repaired))
+	default:
+		add(NodeHealthyCondition, core.ConditionTrue, reasonHealthy, "all Windows node components are healthy")
+	}
+
+	for _, component := range componentConditions {
+		if result.err != nil {
+			add(component.conditionType, core.ConditionUnknown, reasonHealthCheckFailed, result.err.Error())
+		} else if problem := findProblem(result.problems, component.component); problem != nil {
+			add(component.conditionType, core.ConditionFalse, problem.Reason, problem.Err.Error())
+		} else if problem := findProblem(result.repaired, component.component); problem != nil {
+			add(component.conditionType, core.ConditionTrue, reasonRemediated, "repaired: "+problem.Err.Error())
+		} else {
+			add(component.conditionType, core.ConditionTrue, component.healthyReason, component.healthyMessage)
+		}
+	}
+	return conditions
+}
+
+// disabledConditions returns the health conditions of the given existing ones set to unknown, as the health checks
+// are disabled. The conditions are not added to nodes which do not have them, so that clusters which never enabled
+// the health checks do not get them.
+func disabledConditions(existing []core.NodeCondition, now meta.Time) []core.NodeCondition {
+	conditionTypes := []core.NodeConditionType{NodeHealthyCondition}
+	for _, component := range componentConditions {
+		conditionTypes = append(conditionTypes, component.conditionType)
+	}
+	var conditions []core.NodeCondition
+	for _, conditionType := range conditionTypes {
+		if condition := findCondition(existing, conditionType); condition != nil {
+			conditions = append(conditions, newCondition(condition, conditionType, core.ConditionUnknown,
+				reasonHealthChecksDisabled, "Windows node health checks are disabled", now))
+		}
+	}
+	return conditions
+}
+
+// newCondition returns the condition of the given type with the given status, reason and message, checked at the
+// given time. The transition time of the given existing condition is kept if its status is unchanged.
+func newCondition(existing *core.NodeCondition, conditionType core.NodeConditionType, status core.ConditionStatus,
+	reason, message string, now meta.Time) core.NodeCondition {
+	condition := core.NodeCondition{
+		Type:               conditionType,
+		Status:             status,
+		LastHeartbeatTime:  now,
+		LastTransitionTime: now,
+		Reason:             reason,
+		Message:            message,
+	}
+	if existing != nil && existing.Status == status {
+		condition.LastTransitionTime = existing.LastTransitionTime
+	}
+	return condition
+}
+
+// findCondition returns the condition of the given type from the given conditions, or nil if there is none
+func findCondition(conditions []core.NodeCondition, conditionType core.NodeConditionType) *core.NodeCondition {
+	for i := range conditions {
+		if conditions[i].Type == conditionType {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
+
+// findProblem returns the problem of the given component from the given problems, or nil if there is none
+func findProblem(problems []nodeconfig.HealthProblem, component string) *nodeconfig.HealthProblem {
+	for i := range problems {
+		if problems[i].Component == component {
+			return &problems[i]
+		}
+	}
+	return nil
+}
+
+// problemsMessage returns a message describing the given problems
+func problemsMessage(problems []nodeconfig.HealthProblem) string {
+	messages := make([]string, 0, len(problems))
+	for _, problem := range problems {
+		messages = append(messages, problem.Component+": "+problem.Err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
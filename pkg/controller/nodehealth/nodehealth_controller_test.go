@@ -0,0 +1,113 @@
+package nodehealth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/windowsmachine/nodeconfig"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestNewCondition tests that the transition time of a condition only changes with its status
+func TestNewCondition(t *testing.T) {
+	past := meta.NewTime(time.Now().Add(-time.Hour))
+	now := meta.Now()
+	existing := &core.NodeCondition{Type: NodeHealthyCondition, Status: core.ConditionTrue,
+		LastTransitionTime: past, LastHeartbeatTime: past, Reason: reasonHealthy}
+
+	var tests = []struct {
+		name               string
+		existing           *core.NodeCondition
+		status             core.ConditionStatus
+		expectedTransition meta.Time
+	}{
+		{"new condition", nil, core.ConditionTrue, now},
+		{"unchanged status", existing, core.ConditionTrue, past},
+		{"changed status", existing, core.ConditionFalse, now},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			condition := newCondition(tt.existing, NodeHealthyCondition, tt.status, reasonUnhealthy, "message", now)
+			assert.Equal(t, NodeHealthyCondition, condition.Type)
+			assert.Equal(t, tt.status, condition.Status)
+			assert.Equal(t, tt.expectedTransition, condition.LastTransitionTime)
+			assert.Equal(t, now, condition.LastHeartbeatTime)
+			assert.Equal(t, reasonUnhealthy, condition.Reason)
+		})
+	}
+}
+
+// TestNodeConditions tests that the health conditions report the problems of their component, and that the
+// repaired components are reported as remediated
+func TestNodeConditions(t *testing.T) {
+	cniProblem := nodeconfig.HealthProblem{Component: nodeconfig.ComponentCNI,
+		Reason: nodeconfig.ReasonCNIConfigOutdated, Err: errors.New("CNI config does not match")}
+	kubeProxyProblem := nodeconfig.HealthProblem{Component: nodeconfig.ComponentKubeProxy,
+		Reason: nodeconfig.ReasonServiceNotRunning, Err: errors.New("kube-proxy Windows service is not running")}
+
+	var tests = []struct {
+		name     string
+		result   *checkResult
+		expected map[core.NodeConditionType]string
+	}{
+		{"healthy", &checkResult{}, map[core.NodeConditionType]string{
+			NodeHealthyCondition:        reasonHealthy,
+			HybridOverlayReadyCondition: "HybridOverlayRunning",
+			CNIConfiguredCondition:      "CNIConfigured",
+			KubeProxyReadyCondition:     "KubeProxyRunning",
+		}},
+		{"unhealthy", &checkResult{problems: []nodeconfig.HealthProblem{cniProblem}},
+			map[core.NodeConditionType]string{
+				NodeHealthyCondition:        reasonUnhealthy,
+				HybridOverlayReadyCondition: "HybridOverlayRunning",
+				CNIConfiguredCondition:      nodeconfig.ReasonCNIConfigOutdated,
+				KubeProxyReadyCondition:     "KubeProxyRunning",
+			}},
+		{"partially repaired", &checkResult{problems: []nodeconfig.HealthProblem{cniProblem},
+			repaired: []nodeconfig.HealthProblem{kubeProxyProblem}}, map[core.NodeConditionType]string{
+			NodeHealthyCondition:        reasonUnhealthy,
+			HybridOverlayReadyCondition: "HybridOverlayRunning",
+			CNIConfiguredCondition:      nodeconfig.ReasonCNIConfigOutdated,
+			KubeProxyReadyCondition:     reasonRemediated,
+		}},
+		{"repaired", &checkResult{repaired: []nodeconfig.HealthProblem{kubeProxyProblem}},
+			map[core.NodeConditionType]string{
+				NodeHealthyCondition:        reasonRemediated,
+				HybridOverlayReadyCondition: "HybridOverlayRunning",
+				CNIConfiguredCondition:      "CNIConfigured",
+				KubeProxyReadyCondition:     reasonRemediated,
+			}},
+		{"check failed", &checkResult{err: errors.New("unable to connect")}, map[core.NodeConditionType]string{
+			NodeHealthyCondition:        reasonHealthCheckFailed,
+			HybridOverlayReadyCondition: reasonHealthCheckFailed,
+			CNIConfiguredCondition:      reasonHealthCheckFailed,
+			KubeProxyReadyCondition:     reasonHealthCheckFailed,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reasons := make(map[core.NodeConditionType]string)
+			for _, condition := range nodeConditions(nil, tt.result, meta.Now()) {
+				reasons[condition.Type] = condition.Reason
+			}
+			assert.Equal(t, tt.expected, reasons)
+		})
+	}
+}
+
+// TestDisabledConditions tests that only the existing health conditions are reported when the checks are disabled
+func TestDisabledConditions(t *testing.T) {
+	existing := []core.NodeCondition{
+		{Type: core.NodeReady, Status: core.ConditionTrue},
+		{Type: NodeHealthyCondition, Status: core.ConditionTrue},
+	}
+	conditions := disabledConditions(existing, meta.Now())
+	require.Len(t, conditions, 1)
+	assert.Equal(t, NodeHealthyCondition, conditions[0].Type)
+	assert.Equal(t, core.ConditionUnknown, conditions[0].Status)
+	assert.Empty(t, disabledConditions(nil, meta.Now()))
+}
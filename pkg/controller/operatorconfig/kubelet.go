@@ -0,0 +1,156 @@
+package operatorconfig
+
+import (
+	"strconv"
+	"strings"
+
+	wmcapi "github.com/openshift/windows-machine-config-operator/pkg/apis/wmc/v1alpha1"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// maxKubeletVerbosity is the highest kubelet log level
+	maxKubeletVerbosity = 10
+)
+
+// reservableResources are the resources which can be reserved for the system and Kubernetes daemons
+var reservableResources = map[string]bool{
+	"cpu":               true,
+	"memory":            true,
+	"ephemeral-storage": true,
+	"pid":               true,
+}
+
+// windowsEvictionSignals are the eviction signals supported by the kubelet on Windows, which has no inodes
+var windowsEvictionSignals = map[string]bool{
+	"memory.available":  true,
+	"nodefs.available":  true,
+	"imagefs.available": true,
+}
+
+// reservedLabelDomains are the label namespaces the kubelet does not allow to be set with --node-labels
+var reservedLabelDomains = []string{"kubernetes.io", "k8s.io"}
+
+// ValidateKubelet returns an aggregate of the errors found in the given kubelet settings
+func ValidateKubelet(spec wmcapi.KubeletSpec) error {
+	var errs []error
+	for _, reservation := range []struct {
+		name     string
+		reserved map[string]string
+	}{{"systemReserved", spec.SystemReserved}, {"kubeReserved", spec.KubeReserved}} {
+		name, reserved := reservation.name, reservation.reserved
+		for _, resourceName := range sortedFlags(reserved) {
+			if !reservableResources[resourceName] {
+				errs = append(errs, errors.Errorf("resource %s cannot be reserved, set %s to cpu, memory, "+
+					"ephemeral-storage or pid", resourceName, name))
+				continue
+			}
+			if _, err := resource.ParseQuantity(reserved[resourceName]); err != nil {
+				errs = append(errs, errors.Errorf("invalid %s %s quantity %q, set a quantity such as 500m or 1Gi",
+					name, resourceName, reserved[resourceName]))
+			}
+		}
+	}
+
+	for _, signal := range sortedFlags(spec.EvictionHard) {
+		if !windowsEvictionSignals[signal] {
+			errs = append(errs, errors.Errorf("eviction signal %s is not supported on Windows, set evictionHard "+
+				"to memory.available, nodefs.available or imagefs.available", signal))
+			continue
+		}
+		if err := validateEvictionThreshold(spec.EvictionHard[signal]); err != nil {
+			errs = append(errs, errors.Wrapf(err, "invalid evictionHard threshold for %s", signal))
+		}
+	}
+
+	for _, key := range sortedFlags(spec.NodeLabels) {
+		for _, msg := range validation.IsQualifiedName(key) {
+			errs = append(errs, errors.Errorf("invalid node label key %q: %s", key, msg))
+		}
+		for _, msg := range validation.IsValidLabelValue(spec.NodeLabels[key]) {
+			errs = append(errs, errors.Errorf("invalid node label %s value %q: %s", key, spec.NodeLabels[key], msg))
+		}
+		if isReservedLabel(key) {
+			errs = append(errs, errors.Errorf("node label %s is in a reserved namespace, use a label outside of "+
+				"the %s namespaces", key, strings.Join(reservedLabelDomains, " and ")))
+		}
+	}
+
+	if spec.MaxPods != nil && *spec.MaxPods < 1 {
+		errs = append(errs, errors.Errorf("invalid maxPods %d, set maxPods to at least 1", *spec.MaxPods))
+	}
+	if spec.Verbosity != nil && (*spec.Verbosity < 0 || *spec.Verbosity > maxKubeletVerbosity) {
+		errs = append(errs, errors.Errorf("invalid kubelet verbosity %d, set verbosity between 0 and %d",
+			*spec.Verbosity, maxKubeletVerbosity))
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// validateEvictionThreshold checks that the given eviction threshold is a quantity or a percentage
+func validateEvictionThreshold(threshold string) error {
+	if strings.HasSuffix(threshold, "%") {
+		percentage, err := strconv.ParseFloat(strings.TrimSuffix(threshold, "%"), 64)
+		if err != nil || percentage <= 0 || percentage > 100 {
+			return errors.Errorf("invalid percentage %q, set a percentage between 0%% and 100%%", threshold)
+		}
+		return nil
+	}
+	if _, err := resource.ParseQuantity(threshold); err != nil {
+		return errors.Errorf("invalid quantity %q, set a quantity such as 500Mi or a percentage such as 10%%",
+			threshold)
+	}
+	return nil
+}
+
+// isReservedLabel returns true if the given label key is in a namespace reserved for Kubernetes
+func isReservedLabel(key string) bool {
+	parts := strings.SplitN(key, "/", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	for _, domain := range reservedLabelDomains {
+		if parts[0] == domain || strings.HasSuffix(parts[0], "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// KubeletArgs returns the kubelet arguments overriding the bootstrapper configuration with the given settings. Only
+// the settings which are set result in an argument, and the arguments are sorted so that the same settings always
+// result in the same kubelet service.
+func KubeletArgs(spec wmcapi.KubeletSpec) []string {
+	var args []string
+	if len(spec.SystemReserved) > 0 {
+		args = append(args, "--system-reserved="+joinMap(spec.SystemReserved, "="))
+	}
+	if len(spec.KubeReserved) > 0 {
+		args = append(args, "--kube-reserved="+joinMap(spec.KubeReserved, "="))
+	}
+	if len(spec.EvictionHard) > 0 {
+		args = append(args, "--eviction-hard="+joinMap(spec.EvictionHard, "<"))
+	}
+	if len(spec.NodeLabels) > 0 {
+		args = append(args, "--node-labels="+joinMap(spec.NodeLabels, "="))
+	}
+	if spec.MaxPods != nil {
+		args = append(args, "--max-pods="+strconv.Itoa(int(*spec.MaxPods)))
+	}
+	if spec.Verbosity != nil {
+		args = append(args, "--v="+strconv.Itoa(int(*spec.Verbosity)))
+	}
+	return args
+}
+
+// joinMap returns the given map as a comma separated list of key-value pairs joined by separator, sorted by key
+func joinMap(m map[string]string, separator string) string {
+	keys := sortedFlags(m)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+separator+m[key])
+	}
+	return strings.Join(pairs, ",")
+}
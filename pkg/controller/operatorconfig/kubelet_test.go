@@ -0,0 +1,63 @@
+package operatorconfig
+
+import (
+	"testing"
+
+	wmcapi "github.com/openshift/windows-machine-config-operator/pkg/apis/wmc/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestValidateKubelet tests that invalid kubelet settings are rejected with actionable errors
+func TestValidateKubelet(t *testing.T) {
+	zero := int32(0)
+	var tests = []struct {
+		name         string
+		spec         wmcapi.KubeletSpec
+		errorMessage string
+	}{
+		{"defaults", wmcapi.KubeletSpec{}, ""},
+		{"valid settings", wmcapi.KubeletSpec{SystemReserved: map[string]string{"cpu": "500m", "memory": "1Gi"},
+			KubeReserved: map[string]string{"pid": "100"},
+			EvictionHard: map[string]string{"memory.available": "500Mi", "nodefs.available": "10%"},
+			NodeLabels:   map[string]string{"example.com/tier": "frontend"}}, ""},
+		{"unknown reserved resource", wmcapi.KubeletSpec{KubeReserved: map[string]string{"gpu": "1"}},
+			"resource gpu cannot be reserved, set kubeReserved"},
+		{"invalid reserved quantity", wmcapi.KubeletSpec{SystemReserved: map[string]string{"memory": "lots"}},
+			"invalid systemReserved memory quantity \"lots\""},
+		{"unsupported eviction signal", wmcapi.KubeletSpec{EvictionHard: map[string]string{"nodefs.inodesFree": "5%"}},
+			"eviction signal nodefs.inodesFree is not supported on Windows"},
+		{"invalid eviction percentage", wmcapi.KubeletSpec{EvictionHard: map[string]string{"memory.available": "120%"}},
+			"invalid percentage \"120%\""},
+		{"invalid label value", wmcapi.KubeletSpec{NodeLabels: map[string]string{"tier": "front end"}},
+			"invalid node label tier value \"front end\""},
+		{"reserved label", wmcapi.KubeletSpec{NodeLabels: map[string]string{"node-role.kubernetes.io/infra": ""}},
+			"node label node-role.kubernetes.io/infra is in a reserved namespace"},
+		{"invalid max pods", wmcapi.KubeletSpec{MaxPods: &zero}, "invalid maxPods 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateKubelet(tt.spec)
+			if tt.errorMessage == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errorMessage)
+		})
+	}
+}
+
+// TestKubeletArgs tests that only the configured kubelet settings result in deterministic arguments
+func TestKubeletArgs(t *testing.T) {
+	maxPods := int32(50)
+	verbosity := int32(2)
+	assert.Empty(t, KubeletArgs(wmcapi.KubeletSpec{}))
+	assert.Equal(t, []string{"--system-reserved=cpu=500m,memory=1Gi", "--kube-reserved=memory=500Mi",
+		"--eviction-hard=memory.available<500Mi,nodefs.available<10%", "--node-labels=a=1,b=2", "--max-pods=50",
+		"--v=2"},
+		KubeletArgs(wmcapi.KubeletSpec{SystemReserved: map[string]string{"memory": "1Gi", "cpu": "500m"},
+			KubeReserved: map[string]string{"memory": "500Mi"},
+			EvictionHard: map[string]string{"nodefs.available": "10%", "memory.available": "500Mi"},
+			NodeLabels:   map[string]string{"b": "2", "a": "1"}, MaxPods: &maxPods, Verbosity: &verbosity}))
+}
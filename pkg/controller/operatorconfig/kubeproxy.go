@@ -0,0 +1,190 @@
+package operatorconfig
+
+import (
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	wmcapi "github.com/openshift/windows-machine-config-operator/pkg/apis/wmc/v1alpha1"
+	wkl "github.com/openshift/windows-machine-config-operator/pkg/controller/wellknownlocations"
+	"github.com/pkg/errors"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+const (
+	// defaultKubeProxyVerbosity is the kube-proxy log level used when none is configured
+	defaultKubeProxyVerbosity = 4
+	// maxKubeProxyVerbosity is the highest kube-proxy log level
+	maxKubeProxyVerbosity = 10
+	// winDSRFeatureGate is the kube-proxy feature gate required for Direct Server Return
+	winDSRFeatureGate = "WinDSR"
+	// unsafeKubeProxyArgCharacters are the quotes and shell metacharacters rejected in the extra argument values, as
+	// the arguments are passed unquoted on the command line of the kube-proxy service
+	unsafeKubeProxyArgCharacters = "\"'`&|<>^%!;$()"
+)
+
+// kubeProxyFlagRegex matches the kube-proxy flag names which can be set with extra arguments
+var kubeProxyFlagRegex = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// kubeProxyFeatureGates maps the feature gates supported by kube-proxy on Windows to the first kube-proxy version
+// supporting them
+var kubeProxyFeatureGates = map[string]*version.Version{
+	"WinOverlay":                   version.MustParseGeneric("1.14"),
+	winDSRFeatureGate:              version.MustParseGeneric("1.14"),
+	"IPv6DualStack":                version.MustParseGeneric("1.16"),
+	"EndpointSlice":                version.MustParseGeneric("1.16"),
+	"ServiceTopology":              version.MustParseGeneric("1.17"),
+	"EndpointSliceProxying":        version.MustParseGeneric("1.18"),
+	"WindowsEndpointSliceProxying": version.MustParseGeneric("1.19"),
+}
+
+// managedKubeProxyFlags are the kube-proxy flags set by the operator, which cannot be overridden with extra arguments
+var managedKubeProxyFlags = map[string]bool{
+	"windows-service":   true,
+	"v":                 true,
+	"proxy-mode":        true,
+	"hostname-override": true,
+	"kubeconfig":        true,
+	"log-dir":           true,
+	"logtostderr":       true,
+	"feature-gates":     true,
+	"enable-dsr":        true,
+	"cluster-cidr":      true,
+	"network-name":      true,
+	"source-vip":        true,
+}
+
+// PayloadKubeProxyVersion returns the version of the kube-proxy binary shipped in the given payload directory
+func PayloadKubeProxyVersion(payloadDirectory string) (*version.Version, error) {
+	return readKubeProxyVersion(wkl.PayloadPath(wkl.KubeNodeVersionPath, payloadDirectory))
+}
+
+// readKubeProxyVersion returns the Kubernetes version held by the given version file
+func readKubeProxyVersion(path string) (*version.Version, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading kube-proxy version from %s", path)
+	}
+	kubeProxyVersion, err := version.ParseGeneric(strings.TrimSpace(string(content)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing kube-proxy version from %s", path)
+	}
+	return kubeProxyVersion, nil
+}
+
+// ValidateKubeProxy returns an aggregate of the errors found in the given kube-proxy settings, checking the feature
+// gates against the given kube-proxy version
+func ValidateKubeProxy(spec wmcapi.KubeProxySpec, kubeProxyVersion *version.Version) error {
+	var errs []error
+	if spec.Verbosity != nil && (*spec.Verbosity < 0 || *spec.Verbosity > maxKubeProxyVerbosity) {
+		errs = append(errs, errors.Errorf("invalid kube-proxy verbosity %d, set verbosity between 0 and %d",
+			*spec.Verbosity, maxKubeProxyVerbosity))
+	}
+
+	for _, gate := range sortedKeys(spec.FeatureGates) {
+		minVersion, found := kubeProxyFeatureGates[gate]
+		if !found {
+			errs = append(errs, errors.Errorf("unknown kube-proxy feature gate %s, remove it from featureGates",
+				gate))
+			continue
+		}
+		if kubeProxyVersion.LessThan(minVersion) {
+			errs = append(errs, errors.Errorf("kube-proxy feature gate %s requires kube-proxy %s or later, the "+
+				"payload kube-proxy version is %s", gate, minVersion, kubeProxyVersion))
+		}
+	}
+	if spec.EnableDSR {
+		if enabled, found := spec.FeatureGates[winDSRFeatureGate]; found && !enabled {
+			errs = append(errs, errors.Errorf("Direct Server Return requires the %s feature gate, remove it from "+
+				"featureGates or disable enableDSR", winDSRFeatureGate))
+		}
+		if kubeProxyVersion.LessThan(kubeProxyFeatureGates[winDSRFeatureGate]) {
+			errs = append(errs, errors.Errorf("Direct Server Return requires kube-proxy %s or later, the payload "+
+				"kube-proxy version is %s", kubeProxyFeatureGates[winDSRFeatureGate], kubeProxyVersion))
+		}
+	}
+
+	for _, flag := range sortedFlags(spec.ExtraArgs) {
+		if strings.HasPrefix(flag, "-") || !kubeProxyFlagRegex.MatchString(flag) {
+			errs = append(errs, errors.Errorf("invalid kube-proxy extra argument %q, use the lowercase flag name "+
+				"without the leading dashes", flag))
+			continue
+		}
+		if managedKubeProxyFlags[flag] {
+			errs = append(errs, errors.Errorf("kube-proxy flag %s is managed by the operator and cannot be set "+
+				"in extraArgs", flag))
+			continue
+		}
+		if !isSafeKubeProxyArgValue(spec.ExtraArgs[flag]) {
+			errs = append(errs, errors.Errorf("invalid kube-proxy extra argument %s value %q, remove the "+
+				"whitespace, quotes, shell metacharacters and trailing backslash", flag, spec.ExtraArgs[flag]))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// isSafeKubeProxyArgValue returns true if the given extra argument value can be passed unquoted on the kube-proxy
+// service command line. A trailing backslash is rejected as it would escape the quote closing the command line.
+func isSafeKubeProxyArgValue(value string) bool {
+	return !strings.ContainsAny(value, unsafeKubeProxyArgCharacters) &&
+		strings.IndexFunc(value, unicode.IsSpace) < 0 && !strings.HasSuffix(value, `\`)
+}
+
+// KubeProxyArgs returns the kube-proxy arguments for the given settings, enabling the given network specific feature
+// gates in addition to the configured ones. The arguments are sorted so that the same settings always result in the
+// same kube-proxy service.
+func KubeProxyArgs(spec wmcapi.KubeProxySpec, networkFeatureGates map[string]bool) []string {
+	verbosity := int32(defaultKubeProxyVerbosity)
+	if spec.Verbosity != nil {
+		verbosity = *spec.Verbosity
+	}
+
+	featureGates := make(map[string]bool, len(networkFeatureGates)+len(spec.FeatureGates)+1)
+	for gate, enabled := range spec.FeatureGates {
+		featureGates[gate] = enabled
+	}
+	// The network feature gates are required for the node to work, they take precedence over the configured ones
+	for gate, enabled := range networkFeatureGates {
+		featureGates[gate] = enabled
+	}
+	if spec.EnableDSR {
+		featureGates[winDSRFeatureGate] = true
+	}
+
+	args := []string{"--v=" + strconv.Itoa(int(verbosity)), "--enable-dsr=" + strconv.FormatBool(spec.EnableDSR)}
+	if len(featureGates) > 0 {
+		var gates []string
+		for _, gate := range sortedKeys(featureGates) {
+			gates = append(gates, gate+"="+strconv.FormatBool(featureGates[gate]))
+		}
+		args = append(args, "--feature-gates="+strings.Join(gates, ","))
+	}
+	for _, flag := range sortedFlags(spec.ExtraArgs) {
+		args = append(args, "--"+flag+"="+spec.ExtraArgs[flag])
+	}
+	return args
+}
+
+// sortedKeys returns the keys of the given feature gates in alphabetical order
+func sortedKeys(featureGates map[string]bool) []string {
+	keys := make([]string, 0, len(featureGates))
+	for key := range featureGates {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sortedFlags returns the keys of the given arguments in alphabetical order
+func sortedFlags(args map[string]string) []string {
+	flags := make([]string, 0, len(args))
+	for flag := range args {
+		flags = append(flags, flag)
+	}
+	sort.Strings(flags)
+	return flags
+}
@@ -0,0 +1,115 @@
+package operatorconfig
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	wmcapi "github.com/openshift/windows-machine-config-operator/pkg/apis/wmc/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// TestReadKubeProxyVersion tests that the kube-proxy version is read from the payload version file
+func TestReadKubeProxyVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-node")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "version")
+	require.NoError(t, ioutil.WriteFile(path, []byte("v1.19.0-rc.2\n"), 0644))
+
+	kubeProxyVersion, err := readKubeProxyVersion(path)
+	require.NoError(t, err)
+	assert.Equal(t, uint(1), kubeProxyVersion.Major())
+	assert.Equal(t, uint(19), kubeProxyVersion.Minor())
+
+	_, err = readKubeProxyVersion(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
+
+// TestValidateKubeProxy tests that invalid kube-proxy settings are rejected with actionable errors
+func TestValidateKubeProxy(t *testing.T) {
+	verbosity := int32(11)
+	var tests = []struct {
+		name         string
+		spec         wmcapi.KubeProxySpec
+		version      string
+		errorMessage string
+	}{
+		{"defaults", wmcapi.KubeProxySpec{}, "1.19.0", ""},
+		{"valid settings", wmcapi.KubeProxySpec{FeatureGates: map[string]bool{"WindowsEndpointSliceProxying": true},
+			EnableDSR: true, ExtraArgs: map[string]string{"masquerade-all": "true"}}, "1.19.0", ""},
+		{"verbosity out of range", wmcapi.KubeProxySpec{Verbosity: &verbosity}, "1.19.0",
+			"invalid kube-proxy verbosity 11"},
+		{"unknown feature gate", wmcapi.KubeProxySpec{FeatureGates: map[string]bool{"Foo": true}}, "1.19.0",
+			"unknown kube-proxy feature gate Foo"},
+		{"feature gate too recent", wmcapi.KubeProxySpec{FeatureGates: map[string]bool{
+			"WindowsEndpointSliceProxying": true}}, "1.18.6",
+			"kube-proxy feature gate WindowsEndpointSliceProxying requires kube-proxy 1.19 or later"},
+		{"DSR with disabled feature gate", wmcapi.KubeProxySpec{EnableDSR: true,
+			FeatureGates: map[string]bool{"WinDSR": false}}, "1.19.0",
+			"Direct Server Return requires the WinDSR feature gate"},
+		{"DSR unsupported", wmcapi.KubeProxySpec{EnableDSR: true}, "1.13.0",
+			"Direct Server Return requires kube-proxy 1.14 or later"},
+		{"managed flag", wmcapi.KubeProxySpec{ExtraArgs: map[string]string{"source-vip": "10.0.0.1"}}, "1.19.0",
+			"kube-proxy flag source-vip is managed by the operator"},
+		{"flag with dashes", wmcapi.KubeProxySpec{ExtraArgs: map[string]string{"--masquerade-all": "true"}},
+			"1.19.0", "invalid kube-proxy extra argument \"--masquerade-all\""},
+		{"uppercase flag", wmcapi.KubeProxySpec{ExtraArgs: map[string]string{"Masquerade-All": "true"}},
+			"1.19.0", "invalid kube-proxy extra argument \"Masquerade-All\""},
+		{"flag with space", wmcapi.KubeProxySpec{ExtraArgs: map[string]string{"masquerade-all --v": "true"}},
+			"1.19.0", "invalid kube-proxy extra argument \"masquerade-all --v\""},
+		{"value with space", wmcapi.KubeProxySpec{ExtraArgs: map[string]string{"masquerade-all": "true --v=10"}},
+			"1.19.0", "invalid kube-proxy extra argument masquerade-all value \"true --v=10\""},
+		{"value with quote", wmcapi.KubeProxySpec{ExtraArgs: map[string]string{"masquerade-all": "true\" start="}},
+			"1.19.0", "invalid kube-proxy extra argument masquerade-all value"},
+		{"value with metacharacter", wmcapi.KubeProxySpec{ExtraArgs: map[string]string{
+			"masquerade-all": "true&whoami"}}, "1.19.0", "invalid kube-proxy extra argument masquerade-all value"},
+		{"value with trailing backslash", wmcapi.KubeProxySpec{ExtraArgs: map[string]string{
+			"config": "C:\\k\\"}}, "1.19.0", "invalid kube-proxy extra argument config value"},
+		{"value with path", wmcapi.KubeProxySpec{ExtraArgs: map[string]string{"config": "C:\\k\\kube-proxy.yaml",
+			"nodeport-addresses": "10.0.0.0/16,10.1.0.0/16"}}, "1.19.0", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateKubeProxy(tt.spec, version.MustParseGeneric(tt.version))
+			if tt.errorMessage == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errorMessage)
+		})
+	}
+}
+
+// TestKubeProxyArgs tests that the kube-proxy arguments merge the configured and network feature gates and are
+// deterministic
+func TestKubeProxyArgs(t *testing.T) {
+	verbosity := int32(2)
+	var tests = []struct {
+		name         string
+		spec         wmcapi.KubeProxySpec
+		networkGates map[string]bool
+		expected     []string
+	}{
+		{"defaults", wmcapi.KubeProxySpec{}, nil, []string{"--v=4", "--enable-dsr=false"}},
+		{"network feature gates", wmcapi.KubeProxySpec{}, map[string]bool{"WinOverlay": true},
+			[]string{"--v=4", "--enable-dsr=false", "--feature-gates=WinOverlay=true"}},
+		{"DSR", wmcapi.KubeProxySpec{EnableDSR: true}, map[string]bool{"WinOverlay": true},
+			[]string{"--v=4", "--enable-dsr=true", "--feature-gates=WinDSR=true,WinOverlay=true"}},
+		{"all settings", wmcapi.KubeProxySpec{Verbosity: &verbosity,
+			FeatureGates: map[string]bool{"WinOverlay": false, "EndpointSliceProxying": true},
+			ExtraArgs:    map[string]string{"masquerade-all": "true", "iptables-sync-period": "1m"}},
+			map[string]bool{"WinOverlay": true},
+			[]string{"--v=2", "--enable-dsr=false", "--feature-gates=EndpointSliceProxying=true,WinOverlay=true",
+				"--iptables-sync-period=1m", "--masquerade-all=true"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, KubeProxyArgs(tt.spec, tt.networkGates))
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package operatorconfig
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	wmcapi "github.com/openshift/windows-machine-config-operator/pkg/apis/wmc/v1alpha1"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/retry"
+	wkl "github.com/openshift/windows-machine-config-operator/pkg/controller/wellknownlocations"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/windowsmachine/windows"
+	"github.com/pkg/errors"
+	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeTypes "k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// DefaultMachineConfigPool is the MachineConfigPool whose ignition config is used to bootstrap Windows nodes when
+	// no other pool is configured
+	DefaultMachineConfigPool = "worker"
+	// DefaultWindowsExporterPort is the port the Windows node exporter listens on when no other port is configured
+	DefaultWindowsExporterPort = 9182
+	// DefaultHealthCheckInterval is the time between two health checks of a Windows node when no other interval is
+	// configured
+	DefaultHealthCheckInterval = 5 * time.Minute
+	// minHealthCheckInterval is the shortest health check interval, as every check connects to the Windows VM
+	minHealthCheckInterval = time.Minute
+	// DefaultMaxConfigurations is the number of Windows VMs configured at once when no other limit is configured
+	DefaultMaxConfigurations = 5
+	// MaxConfigurationsLimit is the highest number of Windows VMs which can be configured at once
+	MaxConfigurationsLimit = 20
+	// DefaultDrainTimeout is the time allowed for the pods of a Windows node to be evicted when no other timeout is
+	// configured
+	DefaultDrainTimeout = 10 * time.Minute
+)
+
+// windowsDirectoryRegex matches absolute Windows directories ending with a backslash, without characters that would
+// need quoting in the commands run on the Windows VMs
+var windowsDirectoryRegex = regexp.MustCompile(`^[A-Za-z]:\\([^\\/:*?"'<>|\s]+\\)*$`)
+
+// Get returns the WindowsMachineConfig singleton with the defaults applied to its spec. A WindowsMachineConfig with
+// the default settings is returned if the singleton does not exist, so that the operator runs with the default
+// settings until it is created.
+func Get(reader client.Reader) (*wmcapi.WindowsMachineConfig, error) {
+	config := &wmcapi.WindowsMachineConfig{}
+	err := reader.Get(context.TODO(), kubeTypes.NamespacedName{Name: wmcapi.WindowsMachineConfigName}, config)
+	if err != nil {
+		if !k8sapierrors.IsNotFound(err) {
+			return nil, errors.Wrapf(err, "error getting WindowsMachineConfig %s", wmcapi.WindowsMachineConfigName)
+		}
+		config.Name = wmcapi.WindowsMachineConfigName
+	}
+	SetDefaults(&config.Spec)
+	return config, nil
+}
+
+// Default returns a spec holding the default settings
+func Default() *wmcapi.WindowsMachineConfigSpec {
+	spec := &wmcapi.WindowsMachineConfigSpec{}
+	SetDefaults(spec)
+	return spec
+}
+
+// SetDefaults sets the settings of the given spec which are not set to their default value. The network, kube-proxy
+// and kubelet settings are left unset, as their defaults are the ones of the components they configure.
+func SetDefaults(spec *wmcapi.WindowsMachineConfigSpec) {
+	if spec.Bootstrap.MachineConfigPool == "" {
+		spec.Bootstrap.MachineConfigPool = DefaultMachineConfigPool
+	}
+	if spec.Monitoring.Port == 0 {
+		spec.Monitoring.Port = DefaultWindowsExporterPort
+	}
+	if spec.HealthCheck.Mode == "" {
+		spec.HealthCheck.Mode = wmcapi.HealthCheckRemediate
+	}
+	if spec.HealthCheck.Interval == nil {
+		spec.HealthCheck.Interval = &metav1.Duration{Duration: DefaultHealthCheckInterval}
+	}
+	if spec.Retry.Count == 0 {
+		spec.Retry.Count = retry.Count
+	}
+	if spec.Retry.Interval == nil {
+		spec.Retry.Interval = &metav1.Duration{Duration: retry.Interval}
+	}
+	if spec.Retry.Timeout == nil {
+		spec.Retry.Timeout = &metav1.Duration{Duration: retry.Timeout}
+	}
+	if spec.Concurrency.MaxConfigurations == 0 {
+		spec.Concurrency.MaxConfigurations = DefaultMaxConfigurations
+	}
+	if spec.Drain.Timeout == nil {
+		spec.Drain.Timeout = &metav1.Duration{Duration: DefaultDrainTimeout}
+	}
+	if spec.Address.Family == "" {
+		spec.Address.Family = wmcapi.IPv4
+	}
+	if spec.RemoteDirectories.Payload == "" {
+		spec.RemoteDirectories.Payload = windows.RemoteDir
+	}
+	if spec.RemoteDirectories.CNI == "" {
+		spec.RemoteDirectories.CNI = spec.RemoteDirectories.Payload + "cni\\"
+	}
+	if spec.RemoteDirectories.Log == "" {
+		spec.RemoteDirectories.Log = windows.LogDir
+	}
+	if spec.PayloadDirectory == "" {
+		spec.PayloadDirectory = wkl.PayloadDirectory
+	}
+	if spec.SSHUsername == "" {
+		spec.SSHUsername = windows.DefaultSSHUsername
+	}
+}
+
+// Validate returns an error for each setting of the given WindowsMachineConfig which cannot be applied to the Windows
+// nodes, checking the settings against the given payload. The defaults must have been applied to the spec.
+func Validate(config *wmcapi.WindowsMachineConfig, payload *Payload) error {
+	errs := []error{validateSpec(&config.Spec), ValidateKubelet(config.Spec.Kubelet),
+		validatePayloadDirectory(config.Spec.PayloadDirectory, payload),
+		ValidateKubeProxy(config.Spec.KubeProxy, payload.KubeProxyVersion), validateMonitoringPayload(&config.Spec)}
+	return utilerrors.Flatten(utilerrors.NewAggregate(errs))
+}
+
+// validateMonitoringPayload returns an error if the monitoring of the given spec is enabled while the Windows node
+// exporter is missing from the payload, as it could not be copied to the Windows nodes
+func validateMonitoringPayload(spec *wmcapi.WindowsMachineConfigSpec) error {
+	if !spec.Monitoring.Enabled {
+		return nil
+	}
+	path := wkl.PayloadPath(wkl.WindowsExporterPath, spec.PayloadDirectory)
+	if _, err := os.Stat(path); err != nil {
+		return errors.Errorf("monitoring is enabled but the Windows node exporter %s is missing from the operator "+
+			"payload, add it to the payload or disable monitoring", path)
+	}
+	return nil
+}
+
+// validateSpec returns an aggregate of the errors found in the bootstrap, monitoring, health check, retry,
+// concurrency, drain, address, directory and SSH settings of the given spec
+func validateSpec(spec *wmcapi.WindowsMachineConfigSpec) error {
+	var errs []error
+	for _, msg := range validation.IsDNS1123Subdomain(spec.Bootstrap.MachineConfigPool) {
+		errs = append(errs, errors.Errorf("invalid machineConfigPool %q: %s", spec.Bootstrap.MachineConfigPool, msg))
+	}
+	if endpoint := spec.Bootstrap.IgnitionEndpoint; endpoint != "" {
+		if endpointURL, err := url.Parse(endpoint); err != nil || endpointURL.Scheme != "https" ||
+			endpointURL.Hostname() == "" {
+			errs = append(errs, errors.Errorf("invalid ignitionEndpoint %q, set an https URL such as "+
+				"https://mcs.example.com:22623", endpoint))
+		}
+	}
+
+	if spec.Monitoring.Port < 1 || spec.Monitoring.Port > 65535 {
+		errs = append(errs, errors.Errorf("invalid monitoring port %d, set a port between 1 and 65535",
+			spec.Monitoring.Port))
+	}
+
+	switch spec.HealthCheck.Mode {
+	case wmcapi.HealthCheckRemediate, wmcapi.HealthCheckReport, wmcapi.HealthCheckDisabled:
+	default:
+		errs = append(errs, errors.Errorf("invalid health check mode %q, set one of %s, %s or %s",
+			spec.HealthCheck.Mode, wmcapi.HealthCheckRemediate, wmcapi.HealthCheckReport, wmcapi.HealthCheckDisabled))
+	}
+	if spec.HealthCheck.Interval.Duration < minHealthCheckInterval {
+		errs = append(errs, errors.Errorf("invalid health check interval %s, set an interval of at least %s",
+			spec.HealthCheck.Interval.Duration, minHealthCheckInterval))
+	}
+
+	if spec.Retry.Count < 1 {
+		errs = append(errs, errors.Errorf("invalid retry count %d, set count to at least 1", spec.Retry.Count))
+	}
+	if spec.Retry.Interval.Duration <= 0 {
+		errs = append(errs, errors.Errorf("invalid retry interval %s, set a positive interval",
+			spec.Retry.Interval.Duration))
+	}
+	if spec.Retry.Timeout.Duration < spec.Retry.Interval.Duration {
+		errs = append(errs, errors.Errorf("retry timeout %s is shorter than the retry interval %s, increase the "+
+			"timeout", spec.Retry.Timeout.Duration, spec.Retry.Interval.Duration))
+	}
+
+	if spec.Concurrency.MaxConfigurations < 1 || spec.Concurrency.MaxConfigurations > MaxConfigurationsLimit {
+		errs = append(errs, errors.Errorf("invalid maxConfigurations %d, set a limit between 1 and %d",
+			spec.Concurrency.MaxConfigurations, MaxConfigurationsLimit))
+	}
+	if spec.Concurrency.MaxUploads < 0 {
+		errs = append(errs, errors.Errorf("invalid maxUploads %d, set a positive limit or leave it unset",
+			spec.Concurrency.MaxUploads))
+	}
+	if spec.Drain.Timeout.Duration <= 0 {
+		errs = append(errs, errors.Errorf("invalid drain timeout %s, set a positive timeout",
+			spec.Drain.Timeout.Duration))
+	}
+	errs = append(errs, validateAddress(spec.Address)...)
+
+	for _, dir := range []struct {
+		name  string
+		value string
+	}{{"payload", spec.RemoteDirectories.Payload}, {"cni", spec.RemoteDirectories.CNI},
+		{"log", spec.RemoteDirectories.Log}} {
+		if !windowsDirectoryRegex.MatchString(dir.value) {
+			errs = append(errs, errors.Errorf("invalid remote %s directory %q, set an absolute Windows path "+
+				"ending with a backslash and without spaces or quotes", dir.name, dir.value))
+		}
+	}
+	if !strings.HasPrefix(spec.PayloadDirectory, "/") {
+		errs = append(errs, errors.Errorf("invalid payloadDirectory %q, set an absolute path",
+			spec.PayloadDirectory))
+	}
+	if strings.ContainsAny(spec.SSHUsername, " \t\"'@") {
+		errs = append(errs, errors.Errorf("invalid sshUsername %q, set a user name without spaces, quotes or @",
+			spec.SSHUsername))
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// validateAddress returns the errors found in the given address policy. The networks must be of the family of the
+// policy, as no address would match them otherwise.
+func validateAddress(address wmcapi.AddressSpec) []error {
+	var errs []error
+	if address.Family != wmcapi.IPv4 && address.Family != wmcapi.IPv6 {
+		errs = append(errs, errors.Errorf("invalid address family %q, set %s or %s", address.Family, wmcapi.IPv4,
+			wmcapi.IPv6))
+	}
+	for _, cidr := range address.CIDRs {
+		ip, _, err := net.ParseCIDR(cidr)
+		if err != nil {
+			errs = append(errs, errors.Errorf("invalid address CIDR %q, set a network such as 10.0.0.0/16", cidr))
+			continue
+		}
+		if (ip.To4() != nil) != (address.Family == wmcapi.IPv4) {
+			errs = append(errs, errors.Errorf("address CIDR %s is not an %s network, set networks of the address "+
+				"family", cidr, address.Family))
+		}
+	}
+	if strings.ContainsAny(address.Interface, "\"'`$") {
+		errs = append(errs, errors.Errorf("invalid address interface %q, set an interface name without quotes",
+			address.Interface))
+	}
+	return errs
+}
@@ -0,0 +1,169 @@
+package operatorconfig
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	wmcapi "github.com/openshift/windows-machine-config-operator/pkg/apis/wmc/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// TestGet tests that Get returns the WindowsMachineConfig singleton, or an empty configuration if it does not exist
+func TestGet(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, wmcapi.SchemeBuilder.AddToScheme(scheme))
+
+	singleton := &wmcapi.WindowsMachineConfig{}
+	singleton.Name = wmcapi.WindowsMachineConfigName
+	singleton.Spec.Network.MTU = 1400
+	other := &wmcapi.WindowsMachineConfig{}
+	other.Name = "other"
+	other.Spec.Network.MTU = 1300
+
+	var tests = []struct {
+		name        string
+		objects     []runtime.Object
+		expectedMTU uint32
+	}{
+		{"singleton missing", []runtime.Object{other}, 0},
+		{"singleton present", []runtime.Object{singleton, other}, 1400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := Get(fake.NewFakeClientWithScheme(scheme, tt.objects...))
+			require.NoError(t, err)
+			assert.Equal(t, wmcapi.WindowsMachineConfigName, config.Name)
+			assert.Equal(t, tt.expectedMTU, config.Spec.Network.MTU)
+		})
+	}
+}
+
+// TestValidateSpecHealthCheck tests that the health check mode and interval are validated
+func TestValidateSpecHealthCheck(t *testing.T) {
+	var tests = []struct {
+		name        string
+		healthCheck wmcapi.HealthCheckSpec
+		expectedErr bool
+	}{
+		{"defaults", wmcapi.HealthCheckSpec{}, false},
+		{"report", wmcapi.HealthCheckSpec{Mode: wmcapi.HealthCheckReport}, false},
+		{"unknown mode", wmcapi.HealthCheckSpec{Mode: "Restart"}, true},
+		{"short interval", wmcapi.HealthCheckSpec{Interval: &metav1.Duration{Duration: 10 * time.Second}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := &wmcapi.WindowsMachineConfigSpec{HealthCheck: tt.healthCheck}
+			SetDefaults(spec)
+			err := validateSpec(spec)
+			if tt.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// TestValidateSpecConcurrency tests that the concurrency limits are validated
+func TestValidateSpecConcurrency(t *testing.T) {
+	var tests = []struct {
+		name        string
+		concurrency wmcapi.ConcurrencySpec
+		expectedErr bool
+	}{
+		{"defaults", wmcapi.ConcurrencySpec{}, false},
+		{"limits", wmcapi.ConcurrencySpec{MaxConfigurations: MaxConfigurationsLimit, MaxUploads: 4}, false},
+		{"too many configurations", wmcapi.ConcurrencySpec{MaxConfigurations: MaxConfigurationsLimit + 1}, true},
+		{"negative configurations", wmcapi.ConcurrencySpec{MaxConfigurations: -1}, true},
+		{"negative uploads", wmcapi.ConcurrencySpec{MaxUploads: -1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := &wmcapi.WindowsMachineConfigSpec{Concurrency: tt.concurrency}
+			SetDefaults(spec)
+			err := validateSpec(spec)
+			if tt.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// TestValidateSpecDrain tests that the drain timeout is validated
+func TestValidateSpecDrain(t *testing.T) {
+	var tests = []struct {
+		name        string
+		drain       wmcapi.DrainSpec
+		expectedErr bool
+	}{
+		{"defaults", wmcapi.DrainSpec{}, false},
+		{"forced", wmcapi.DrainSpec{Timeout: &metav1.Duration{Duration: time.Minute}, Force: true}, false},
+		{"zero timeout", wmcapi.DrainSpec{Timeout: &metav1.Duration{}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := &wmcapi.WindowsMachineConfigSpec{Drain: tt.drain}
+			SetDefaults(spec)
+			err := validateSpec(spec)
+			if tt.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// TestValidateSpecAddress tests that the address policy only accepts networks and interface names it can match
+func TestValidateSpecAddress(t *testing.T) {
+	var tests = []struct {
+		name        string
+		address     wmcapi.AddressSpec
+		expectedErr bool
+	}{
+		{"defaults", wmcapi.AddressSpec{}, false},
+		{"IPv4 networks", wmcapi.AddressSpec{CIDRs: []string{"10.0.0.0/16", "192.168.1.0/24"}}, false},
+		{"IPv6 network", wmcapi.AddressSpec{Family: wmcapi.IPv6, CIDRs: []string{"fd00::/64"}}, false},
+		{"interface", wmcapi.AddressSpec{Interface: "Ethernet 2"}, false},
+		{"unknown family", wmcapi.AddressSpec{Family: "IPv5"}, true},
+		{"invalid network", wmcapi.AddressSpec{CIDRs: []string{"10.0.0.0"}}, true},
+		{"network of other family", wmcapi.AddressSpec{CIDRs: []string{"fd00::/64"}}, true},
+		{"quoted interface", wmcapi.AddressSpec{Interface: "Ethernet'"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := &wmcapi.WindowsMachineConfigSpec{Address: tt.address}
+			SetDefaults(spec)
+			err := validateSpec(spec)
+			if tt.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// TestValidateMonitoringPayload tests that monitoring can only be enabled once the Windows node exporter is in the
+// payload
+func TestValidateMonitoringPayload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "payload")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	spec := &wmcapi.WindowsMachineConfigSpec{PayloadDirectory: dir}
+	assert.NoError(t, validateMonitoringPayload(spec))
+	spec.Monitoring.Enabled = true
+	assert.Error(t, validateMonitoringPayload(spec))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "windows_exporter.exe"), []byte{}, 0644))
+	assert.NoError(t, validateMonitoringPayload(spec))
+}
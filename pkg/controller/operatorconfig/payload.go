@@ -0,0 +1,48 @@
+package operatorconfig
+
+import (
+	"path"
+
+	oconfig "github.com/openshift/api/config/v1"
+	"github.com/openshift/windows-machine-config-operator/pkg/clusteroperator"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/windowsmachine/windows"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// Payload holds the contents of the payload directory the operator configuration is validated against and the
+// operator reports. The payload is part of the operator image, so it is read once when the operator starts.
+type Payload struct {
+	// Directory is the payload directory the contents have been read from
+	Directory string
+	// KubeProxyVersion is the version of the kube-proxy binary of the payload
+	KubeProxyVersion *version.Version
+	// CompatibilityMatrix holds the Windows builds supported by the payload
+	CompatibilityMatrix *windows.CompatibilityMatrix
+	// Versions are the versions of the operator and of the payload, reported in the ClusterOperator
+	Versions []oconfig.OperandVersion
+}
+
+// ReadPayload reads the contents of the given payload directory
+func ReadPayload(directory string) (*Payload, error) {
+	kubeProxyVersion, err := PayloadKubeProxyVersion(directory)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting payload kube-proxy version")
+	}
+	matrix, err := windows.ReadCompatibilityMatrix(directory)
+	if err != nil {
+		return nil, err
+	}
+	return &Payload{Directory: directory, KubeProxyVersion: kubeProxyVersion, CompatibilityMatrix: matrix,
+		Versions: clusteroperator.Versions(directory)}, nil
+}
+
+// validatePayloadDirectory checks that the given payload directory is the one the given payload has been read from,
+// as the payload is only read when the operator starts
+func validatePayloadDirectory(directory string, payload *Payload) error {
+	if path.Clean(directory) != path.Clean(payload.Directory) {
+		return errors.Errorf("payloadDirectory %s differs from the payload directory %s read when the operator "+
+			"started, restart the operator to use it", directory, payload.Directory)
+	}
+	return nil
+}
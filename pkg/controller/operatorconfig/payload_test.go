@@ -0,0 +1,34 @@
+package operatorconfig
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestValidatePayloadDirectory tests that the payload directory of the configuration must be the one read when the
+// operator started
+func TestValidatePayloadDirectory(t *testing.T) {
+	payload := &Payload{Directory: "/payload"}
+
+	testCases := []struct {
+		name      string
+		directory string
+		expectErr bool
+	}{
+		{name: "same directory", directory: "/payload", expectErr: false},
+		{name: "same directory with trailing slash", directory: "/payload/", expectErr: false},
+		{name: "other directory", directory: "/custom-payload", expectErr: true},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			err := validatePayloadDirectory(test.directory, payload)
+			if test.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
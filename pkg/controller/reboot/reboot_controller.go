@@ -0,0 +1,211 @@
+package reboot
+
+import (
+	"context"
+
+	wmcapi "github.com/openshift/windows-machine-config-operator/pkg/apis/wmc/v1alpha1"
+	"github.com/openshift/windows-machine-config-operator/pkg/clusternetwork"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/machinecontrol"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/operatorconfig"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/signer"
+	wkl "github.com/openshift/windows-machine-config-operator/pkg/controller/wellknownlocations"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/windowsmachine/nodeconfig"
+	"github.com/openshift/windows-machine-config-operator/pkg/metrics"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	core "k8s.io/api/core/v1"
+	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// ControllerName is the name of the Windows node reboot controller
+const ControllerName = "reboot-controller"
+
+var log = logf.Log.WithName(ControllerName)
+
+// Add creates a new Windows node reboot Controller and adds it to the Manager. The Controller watches the boot ID and
+// the readiness of the configured Windows nodes, and runs their node-local setup again once they are ready after a
+// reboot of their VM.
+func Add(mgr manager.Manager, network *clusternetwork.Store, nodes *nodeconfig.NodeWatcher,
+	clusterCache cache.Cache, payload *operatorconfig.Payload) error {
+	clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return errors.Wrap(err, "error creating kubernetes clientset")
+	}
+	sshSigner, err := signer.Create()
+	if err != nil {
+		return errors.Wrapf(err, "error creating signer using private key: %v", wkl.PrivateKeyPath)
+	}
+
+	r := &ReconcileReboot{
+		k8sclientset: clientset,
+		reader:       clusterCache,
+		network:      network,
+		nodes:        nodes,
+		payload:      payload,
+		signer:       sshSigner,
+		recorder:     mgr.GetEventRecorderFor(ControllerName),
+	}
+	return add(mgr, r, clusterCache)
+}
+
+// add adds a new Controller to mgr with r as the reconcile.Reconciler, watching the nodes of the given cache
+func add(mgr manager.Manager, r *ReconcileReboot, clusterCache cache.Cache) error {
+	c, err := controller.New(ControllerName, mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return errors.Wrapf(err, "could not create %s", ControllerName)
+	}
+	windowsNodeSelector, err := labels.Parse(nodeconfig.WindowsOSLabel)
+	if err != nil {
+		return errors.Wrapf(err, "error parsing Windows node label %s", nodeconfig.WindowsOSLabel)
+	}
+	// The nodes are listed when the operator starts, to find the reboots that happened while it was not running.
+	// Afterwards only the updates which may reveal a reboot are of interest.
+	if err := c.Watch(source.NewKindWithCache(&core.Node{}, clusterCache), &handler.EnqueueRequestForObject{},
+		predicate.Funcs{
+			CreateFunc: func(e event.CreateEvent) bool {
+				return windowsNodeSelector.Matches(labels.Set(e.Meta.GetLabels()))
+			},
+			UpdateFunc: func(e event.UpdateEvent) bool {
+				oldNode, okOld := e.ObjectOld.(*core.Node)
+				newNode, okNew := e.ObjectNew.(*core.Node)
+				return okOld && okNew && windowsNodeSelector.Matches(labels.Set(newNode.GetLabels())) &&
+					mayHaveRebooted(oldNode, newNode)
+			},
+			DeleteFunc:  func(event.DeleteEvent) bool { return false },
+			GenericFunc: func(event.GenericEvent) bool { return false },
+		}); err != nil {
+		return errors.Wrap(err, "could not create watch on Nodes")
+	}
+	return nil
+}
+
+// mayHaveRebooted returns true if the update of a node from old to new may reveal a reboot to recover from: its boot
+// ID changed, it became ready, or its configuration completed without its boot ID being recorded
+func mayHaveRebooted(old, new *core.Node) bool {
+	_, recorded := new.GetAnnotations()[nodeconfig.BootIDAnnotation]
+	_, configured := new.GetAnnotations()[nodeconfig.KubeletConfigAnnotation]
+	return old.Status.NodeInfo.BootID != new.Status.NodeInfo.BootID ||
+		!isNodeReady(old) && isNodeReady(new) ||
+		configured && !recorded
+}
+
+// blank assignment to verify that ReconcileReboot implements reconcile.Reconciler
+var _ reconcile.Reconciler = &ReconcileReboot{}
+
+// ReconcileReboot recovers the configured Windows nodes from the reboots of their VM
+type ReconcileReboot struct {
+	// k8sclientset holds the kube client that we can re-use for all kube objects other than custom resources.
+	k8sclientset *kubernetes.Clientset
+	// reader reads the WindowsMachineConfig, the nodes and the Machines from the cluster scoped cache
+	reader client.Reader
+	// network holds the current cluster network configuration, shared with the other controllers
+	network *clusternetwork.Store
+	// nodes serves the Windows nodes from the shared informer
+	nodes *nodeconfig.NodeWatcher
+	// payload holds the contents of the payload directory read when the operator started
+	payload *operatorconfig.Payload
+	// signer is a signer created from the user's private key
+	signer ssh.Signer
+	// recorder to generate events
+	recorder record.EventRecorder
+}
+
+// Reconcile runs the node-local setup of the given Windows node again if its VM has rebooted since the setup last
+// ran, once the node is ready. The boot ID of the configured nodes whose setup has not been recorded yet, such as the
+// nodes configured by a previous version of the operator, is recorded as is.
+func (r *ReconcileReboot) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	node := &core.Node{}
+	if err := r.reader.Get(context.TODO(), request.NamespacedName, node); err != nil {
+		if k8sapierrors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, errors.Wrapf(err, "error getting node %s", request.Name)
+	}
+	// Nodes which have not finished their initial configuration record their boot ID when they do
+	if _, configured := node.GetAnnotations()[nodeconfig.KubeletConfigAnnotation]; !configured ||
+		node.GetDeletionTimestamp() != nil {
+		return reconcile.Result{}, nil
+	}
+	if _, recorded := node.GetAnnotations()[nodeconfig.BootIDAnnotation]; !recorded {
+		return reconcile.Result{}, nodeconfig.RecordBootID(r.k8sclientset, node.GetName(),
+			node.Status.NodeInfo.BootID)
+	}
+	// The setup is run once the node is ready, which triggers a new reconcile
+	if !nodeconfig.Rebooted(node) || !isNodeReady(node) {
+		return reconcile.Result{}, nil
+	}
+	paused, err := machinecontrol.NodePaused(r.reader, node)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if paused {
+		log.Info("skipping reboot recovery of paused node", "node", node.GetName())
+		return reconcile.Result{RequeueAfter: machinecontrol.PausedRequeueInterval}, nil
+	}
+
+	config, err := operatorconfig.Get(r.reader)
+	if err != nil {
+		return reconcile.Result{}, errors.Wrap(err, "error getting operator configuration")
+	}
+	if err := operatorconfig.Validate(config, r.payload); err != nil {
+		// The network components cannot be restarted with invalid settings, the configuration has to be fixed first
+		log.Error(err, "invalid operator configuration, postponing reboot recovery", "node", node.GetName())
+		return reconcile.Result{RequeueAfter: operatorconfig.DefaultHealthCheckInterval}, nil
+	}
+
+	r.recorder.Eventf(node, core.EventTypeNormal, "WMCO RebootDetected",
+		"Node %s rebooted, running its node-local network setup again", node.GetName())
+	log.Info("Windows node rebooted, running node-local setup again", "node", node.GetName(),
+		"bootID", node.Status.NodeInfo.BootID)
+	instanceID := nodeconfig.InstanceIDFromProviderID(node.Spec.ProviderID)
+	if err := r.recover(node, config.Spec); err != nil {
+		metrics.SetNodeState(instanceID, metrics.NodeStateFailed)
+		r.recorder.Eventf(node, core.EventTypeWarning, "WMCO RebootRecoveryFailure",
+			"Node %s failed to recover from its reboot: %v", node.GetName(), err)
+		// The recovery is retried with backoff
+		return reconcile.Result{}, errors.Wrapf(err, "error recovering node %s from reboot", node.GetName())
+	}
+	metrics.SetNodeState(instanceID, metrics.NodeStateConfigured)
+	r.recorder.Eventf(node, core.EventTypeNormal, "WMCO RebootRecovery",
+		"Node %s recovered from its reboot", node.GetName())
+	return reconcile.Result{}, nil
+}
+
+// recover runs the node-local setup of the given node with the given settings, connecting to the address of the
+// node chosen by the address policy
+func (r *ReconcileReboot) recover(node *core.Node, spec wmcapi.WindowsMachineConfigSpec) error {
+	network := r.network.Get()
+	if network == nil {
+		return errors.New("cluster network configuration is not available")
+	}
+	nc, err := nodeconfig.NewNodeConfigFromAddresses(r.k8sclientset, r.nodes, node.Status.Addresses,
+		nodeconfig.InstanceIDFromProviderID(node.Spec.ProviderID), network, &spec, r.signer,
+		r.payload.CompatibilityMatrix)
+	if err != nil {
+		return errors.Wrapf(err, "error creating node config for %s", node.GetName())
+	}
+	return nc.RecoverFromReboot(node)
+}
+
+// isNodeReady returns true if the given node has the Ready condition set to true
+func isNodeReady(node *core.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == core.NodeReady {
+			return condition.Status == core.ConditionTrue
+		}
+	}
+	return false
+}
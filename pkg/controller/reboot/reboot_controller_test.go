@@ -0,0 +1,48 @@
+package reboot
+
+import (
+	"testing"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/windowsmachine/nodeconfig"
+	"github.com/stretchr/testify/assert"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestMayHaveRebooted tests that only the node updates which may reveal a reboot to recover from trigger a reconcile
+func TestMayHaveRebooted(t *testing.T) {
+	newNode := func(bootID string, ready core.ConditionStatus, annotations ...string) *core.Node {
+		node := &core.Node{ObjectMeta: meta.ObjectMeta{Annotations: map[string]string{}}}
+		for _, annotation := range annotations {
+			node.Annotations[annotation] = ""
+		}
+		node.Status.NodeInfo.BootID = bootID
+		node.Status.Conditions = []core.NodeCondition{{Type: core.NodeReady, Status: ready}}
+		return node
+	}
+	configured := []string{nodeconfig.KubeletConfigAnnotation, nodeconfig.BootIDAnnotation}
+
+	var tests = []struct {
+		name     string
+		old      *core.Node
+		new      *core.Node
+		expected bool
+	}{
+		{"unchanged", newNode("a", core.ConditionTrue, configured...),
+			newNode("a", core.ConditionTrue, configured...), false},
+		{"boot ID changed", newNode("a", core.ConditionFalse, configured...),
+			newNode("b", core.ConditionFalse, configured...), true},
+		{"became ready", newNode("b", core.ConditionFalse, configured...),
+			newNode("b", core.ConditionTrue, configured...), true},
+		{"became not ready", newNode("a", core.ConditionTrue, configured...),
+			newNode("a", core.ConditionFalse, configured...), false},
+		{"configured without boot ID", newNode("a", core.ConditionTrue),
+			newNode("a", core.ConditionTrue, nodeconfig.KubeletConfigAnnotation), true},
+		{"not configured", newNode("a", core.ConditionTrue), newNode("a", core.ConditionTrue), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, mayHaveRebooted(tt.old, tt.new))
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package retry
+
+import (
+	"time"
+)
+
+// Class classifies the errors of the Windows VM configurations by how they are retried
+type Class string
+
+const (
+	// Unreachable is the class of the errors connecting to a Windows VM, which is likely still booting
+	Unreachable Class = "VMUnreachable"
+	// AuthenticationFailed is the class of the errors authenticating against a Windows VM, which does not accept the
+	// operator's private key
+	AuthenticationFailed Class = "AuthenticationFailed"
+	// PayloadMissing is the class of the errors caused by a file missing from the operator payload
+	PayloadMissing Class = "PayloadMissing"
+	// InvalidConfiguration is the class of the errors caused by an invalid operator or cluster configuration
+	InvalidConfiguration Class = "InvalidConfiguration"
+	// PreflightFailed is the class of the errors of the Windows VMs which do not meet the requirements of a Windows
+	// node, such as an unsupported Windows build or a missing Containers feature
+	PreflightFailed Class = "PreflightFailed"
+	// RemoteCommandFailed is the class of the errors of the commands run on a Windows VM
+	RemoteCommandFailed Class = "RemoteCommandFailed"
+	// Unknown is the class of the errors which have not been classified
+	Unknown Class = "Unknown"
+)
+
+const (
+	// MaxBackoff is the longest time waited before retrying a failed configuration
+	MaxBackoff = 30 * time.Minute
+	// InvalidConfigurationInterval is the time waited before retrying a configuration which failed because of an
+	// invalid configuration, which is not retried sooner as it has to be fixed first
+	InvalidConfigurationInterval = 5 * time.Minute
+)
+
+// Permanent returns true if the errors of the class are not expected to go away without intervention, such as a
+// replaced private key or operator image, or a fixed Windows VM
+func (c Class) Permanent() bool {
+	return c == AuthenticationFailed || c == PayloadMissing || c == PreflightFailed
+}
+
+// classifiedError is an error with a class
+type classifiedError struct {
+	class Class
+	err   error
+}
+
+func (e *classifiedError) Error() string { return e.err.Error() }
+
+// Cause returns the classified error, so that errors.Cause returns the original error
+func (e *classifiedError) Cause() error { return e.err }
+
+// NewError returns the given error with the given class, or nil if the error is nil
+func NewError(class Class, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classifiedError{class: class, err: err}
+}
+
+// ClassOf returns the class of the given error, which is the class of the outermost classified error it wraps, or
+// Unknown if it wraps none
+func ClassOf(err error) Class {
+	for err != nil {
+		if classified, ok := err.(*classifiedError); ok {
+			return classified.class
+		}
+		causer, ok := err.(interface{ Cause() error })
+		if !ok {
+			break
+		}
+		err = causer.Cause()
+	}
+	return Unknown
+}
+
+// Backoff returns the time to wait before the next attempt after the given number of consecutive failures. The time
+// starts at the given interval and doubles with every failure, up to MaxBackoff.
+func Backoff(interval time.Duration, failures int) time.Duration {
+	backoff := interval
+	for i := 1; i < failures && backoff < MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > MaxBackoff {
+		return MaxBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,42 @@
+package retry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestClassOf tests that the class of the outermost classified error is returned, whatever the wrapping
+func TestClassOf(t *testing.T) {
+	root := errors.New("connection refused")
+	var tests = []struct {
+		name     string
+		err      error
+		expected Class
+	}{
+		{"nil", nil, Unknown},
+		{"unclassified", root, Unknown},
+		{"classified", NewError(Unreachable, root), Unreachable},
+		{"wrapped", errors.Wrap(NewError(Unreachable, root), "error configuring VM"), Unreachable},
+		{"reclassified", errors.Wrap(NewError(PayloadMissing, errors.Wrap(NewError(Unreachable, root),
+			"error copying file")), "error configuring VM"), PayloadMissing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, ClassOf(tt.err))
+		})
+	}
+	assert.Nil(t, NewError(Unreachable, nil))
+	assert.Equal(t, root, errors.Cause(errors.Wrap(NewError(Unreachable, root), "error configuring VM")))
+}
+
+// TestBackoff tests that the backoff doubles with every failure up to MaxBackoff
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, 15*time.Second, Backoff(15*time.Second, 0))
+	assert.Equal(t, 15*time.Second, Backoff(15*time.Second, 1))
+	assert.Equal(t, 60*time.Second, Backoff(15*time.Second, 3))
+	assert.Equal(t, MaxBackoff, Backoff(15*time.Second, 100))
+	assert.Equal(t, MaxBackoff, Backoff(time.Hour, 1))
+}
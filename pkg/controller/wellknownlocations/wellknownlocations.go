@@ -1,45 +1,68 @@
 package wellknownlocations
 
+import (
+	"path"
+	"strings"
+)
+
 const (
 	// PrivateKeyPath contains the path to the private key which is used in decrypting password in case of AWS
 	// cloud provider. This would have been mounted as a secret by user
 	// TODO: Jira story for validation: https://issues.redhat.com/browse/WINC-316
 	PrivateKeyPath = "/etc/private-key/private-key.pem"
-	// payloadDirectory is the directory in the operator image where are all the binaries live
-	payloadDirectory = "/payload/"
+	// PayloadDirectory is the default directory in the operator image where are all the binaries live. The payload
+	// paths below are relative to it, and are moved to a custom payload directory with PayloadPath()
+	PayloadDirectory = "/payload/"
 	// WmcbPath contains the path of the Windows Machine Config Bootstrapper binary. The container image should already
 	// have this binary mounted
-	WmcbPath = payloadDirectory + "wmcb.exe"
+	WmcbPath = PayloadDirectory + "wmcb.exe"
 	// KubeletPath contains the path of the kubelet binary. The container image should already have this binary mounted
-	KubeletPath = payloadDirectory + "/kube-node/kubelet.exe"
+	KubeletPath = PayloadDirectory + "/kube-node/kubelet.exe"
 	// KubeProxyPath contains the path of the kube-proxy binary. The container image should already have this binary
 	// mounted
-	KubeProxyPath = payloadDirectory + "/kube-node/kube-proxy.exe"
+	KubeProxyPath = PayloadDirectory + "/kube-node/kube-proxy.exe"
+	// KubeNodeVersionPath contains the path of the file holding the Kubernetes version of the kubelet and kube-proxy
+	// binaries. The container image should already have this file mounted
+	KubeNodeVersionPath = PayloadDirectory + "/kube-node/version"
 	// IgnoreWgetPowerShellPath contains the path of the powershell script which allows wget to ignore certs. The
 	// container image should already have this mounted
-	IgnoreWgetPowerShellPath = payloadDirectory + "/powershell/wget-ignore-cert.ps1"
+	IgnoreWgetPowerShellPath = PayloadDirectory + "/powershell/wget-ignore-cert.ps1"
 	// HNSPSModule is the path to the powershell module which defines various functions for dealing with Windows HNS
 	// networks
-	HNSPSModule = payloadDirectory + "/powershell/hns.psm1"
+	HNSPSModule = PayloadDirectory + "/powershell/hns.psm1"
 	// cniDirectory is the directory for storing the CNI plugins and the CNI config template
 	cniDirectory = "/cni/"
 	// FlannelCNIPluginPath is the path of the flannel CNI plugin binary. The container image should already have this
 	// binary mounted
-	FlannelCNIPluginPath = payloadDirectory + cniDirectory + "flannel.exe"
+	FlannelCNIPluginPath = PayloadDirectory + cniDirectory + "flannel.exe"
 	// HostLocalCNIPluginPath is the path of the host-local CNI plugin binary. The container image should already have
 	// this binary mounted
-	HostLocalCNIPlugin = payloadDirectory + cniDirectory + "host-local.exe"
+	HostLocalCNIPlugin = PayloadDirectory + cniDirectory + "host-local.exe"
 	// WinBridgeCNIPluginPath is the path of the win-bridge CNI plugin binary. The container image should already have
 	// this binary mounted
-	WinBridgeCNIPlugin = payloadDirectory + cniDirectory + "win-bridge.exe"
+	WinBridgeCNIPlugin = PayloadDirectory + cniDirectory + "win-bridge.exe"
 	// WinOverlayCNIPluginPath is the path of the win-overlay CNI Plugin binary. The container image should already have
 	// this binary mounted
-	WinOverlayCNIPlugin = payloadDirectory + cniDirectory + "win-overlay.exe"
-	// CNIConfigTemplatePath is the path for CNI config template
-	CNIConfigTemplatePath = payloadDirectory + cniDirectory + "cni-conf-template.json"
+	WinOverlayCNIPlugin = PayloadDirectory + cniDirectory + "win-overlay.exe"
+	// CNIConfigTemplatePath is the path for the optional CNI config template overriding the generated CNI config
+	CNIConfigTemplatePath = PayloadDirectory + cniDirectory + "cni-conf-template.json"
+	// WindowsExporterPath contains the path of the Windows node exporter binary, which is not shipped in the operator
+	// image. It has to be mounted in the payload before the collection of the Windows node metrics is enabled.
+	WindowsExporterPath = PayloadDirectory + "windows_exporter.exe"
+	// WindowsBuildsPath contains the path of the compatibility matrix listing the Windows builds supported by the
+	// payload binaries. The container image should already have this file mounted
+	WindowsBuildsPath = PayloadDirectory + "windows-builds.json"
 	// hybridOverlayName is the name of the hybrid overlay executable
 	HybridOverlayName = "hybrid-overlay-node.exe"
 	// HybridOverlayPath contains the path of the hybrid overlay binary. The container image should already have this
 	// binary mounted
-	HybridOverlayPath = payloadDirectory + HybridOverlayName
+	HybridOverlayPath = PayloadDirectory + HybridOverlayName
 )
+
+// PayloadPath returns the location of the given payload path in the given payload directory
+func PayloadPath(payloadPath, payloadDirectory string) string {
+	if payloadDirectory == "" || !strings.HasPrefix(payloadPath, PayloadDirectory) {
+		return payloadPath
+	}
+	return path.Join(payloadDirectory, strings.TrimPrefix(payloadPath, PayloadDirectory))
+}
@@ -0,0 +1,78 @@
+package windowsmachine
+
+import (
+	"sync"
+	"time"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/retry"
+)
+
+// permanentFailureThreshold is the number of consecutive permanent failures after which a Machine is quarantined
+const permanentFailureThreshold = 3
+
+// failureTracker records the consecutive configuration failures of the Windows Machines, to retry them with a backoff.
+// The failures are held in memory, so that a restart of the operator retries all the Machines which are not
+// quarantined. It is safe for concurrent use.
+type failureTracker struct {
+	// mutex guards failures
+	mutex sync.Mutex
+	// failures holds the failures of each Machine, keyed by the Machine name
+	failures map[string]*failureRecord
+}
+
+// failureRecord holds the consecutive configuration failures of a Machine
+type failureRecord struct {
+	// count is the number of consecutive failures
+	count int
+	// permanent is the number of consecutive failures of a permanent class
+	permanent int
+	// nextAttempt is the time before which the configuration is not retried
+	nextAttempt time.Time
+}
+
+// newFailureTracker returns a failureTracker with no failure recorded
+func newFailureTracker() *failureTracker {
+	return &failureTracker{failures: make(map[string]*failureRecord)}
+}
+
+// wait returns the time left before the configuration of the given Machine can be retried, or 0 if it can be retried
+// now
+func (t *failureTracker) wait(name string, now time.Time) time.Duration {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	record, found := t.failures[name]
+	if !found || !now.Before(record.nextAttempt) {
+		return 0
+	}
+	return record.nextAttempt.Sub(now)
+}
+
+// record records a configuration failure of the given class for the given Machine. It returns the time to wait
+// before retrying, which doubles with every consecutive failure starting at the given interval, and whether the
+// Machine has failed permanently too many times in a row to be retried.
+func (t *failureTracker) record(name string, class retry.Class, interval time.Duration,
+	now time.Time) (time.Duration, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	record, found := t.failures[name]
+	if !found {
+		record = &failureRecord{}
+		t.failures[name] = record
+	}
+	record.count++
+	if class.Permanent() {
+		record.permanent++
+	} else {
+		record.permanent = 0
+	}
+	backoff := retry.Backoff(interval, record.count)
+	record.nextAttempt = now.Add(backoff)
+	return backoff, record.permanent >= permanentFailureThreshold
+}
+
+// reset forgets the failures of the given Machine, once it has been configured, quarantined or deleted
+func (t *failureTracker) reset(name string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.failures, name)
+}
@@ -0,0 +1,49 @@
+package windowsmachine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/retry"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestFailureTracker tests that the backoff grows with the consecutive failures, and that only consecutive permanent
+// failures quarantine a Machine
+func TestFailureTracker(t *testing.T) {
+	interval := 15 * time.Second
+	var tests = []struct {
+		name               string
+		classes            []retry.Class
+		expectedBackoff    time.Duration
+		expectedQuarantine bool
+	}{
+		{"first failure", []retry.Class{retry.Unreachable}, interval, false},
+		{"transient failures", []retry.Class{retry.Unreachable, retry.RemoteCommandFailed, retry.Unknown,
+			retry.Unreachable}, 8 * interval, false},
+		{"permanent failures", []retry.Class{retry.AuthenticationFailed, retry.AuthenticationFailed,
+			retry.PayloadMissing}, 4 * interval, true},
+		{"interrupted permanent failures", []retry.Class{retry.AuthenticationFailed, retry.AuthenticationFailed,
+			retry.Unreachable, retry.AuthenticationFailed}, 8 * interval, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := newFailureTracker()
+			now := time.Now()
+			var backoff time.Duration
+			var quarantine bool
+			for _, class := range tt.classes {
+				backoff, quarantine = tracker.record("windows-worker", class, interval, now)
+			}
+			assert.Equal(t, tt.expectedBackoff, backoff)
+			assert.Equal(t, tt.expectedQuarantine, quarantine)
+
+			assert.Equal(t, backoff, tracker.wait("windows-worker", now))
+			assert.Equal(t, time.Duration(0), tracker.wait("windows-worker", now.Add(backoff)))
+			assert.Equal(t, time.Duration(0), tracker.wait("other-worker", now))
+
+			tracker.reset("windows-worker")
+			assert.Equal(t, time.Duration(0), tracker.wait("windows-worker", now))
+		})
+	}
+}
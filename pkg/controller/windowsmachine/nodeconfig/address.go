@@ -0,0 +1,150 @@
+package nodeconfig
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	wmcapi "github.com/openshift/windows-machine-config-operator/pkg/apis/wmc/v1alpha1"
+	"github.com/openshift/windows-machine-config-operator/pkg/clusternetwork"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/retry"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/windowsmachine/windows"
+	"github.com/openshift/windows-machine-config-operator/pkg/metrics"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// AddressAnnotation is the node annotation recording the address of the Windows VM the node-local network setup last
+// ran through
+const AddressAnnotation = "windowsmachineconfig.openshift.io/address"
+
+// CandidateAddresses returns the internal addresses of the given list which match the family and the networks of the
+// given policy, in order of preference. The addresses within the first matching network of the policy come first,
+// and the addresses keep their order in the list otherwise.
+func CandidateAddresses(addresses []v1.NodeAddress, policy wmcapi.AddressSpec) []string {
+	var candidates []string
+	networks := make(map[string]int)
+	for _, address := range addresses {
+		ip := net.ParseIP(address.Address)
+		if address.Type != v1.NodeInternalIP || ip == nil || (ip.To4() == nil) != (policy.Family == wmcapi.IPv6) {
+			continue
+		}
+		if _, duplicate := networks[address.Address]; duplicate {
+			continue
+		}
+		if network := networkIndex(ip, policy.CIDRs); network >= 0 {
+			candidates = append(candidates, address.Address)
+			networks[address.Address] = network
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return networks[candidates[i]] < networks[candidates[j]]
+	})
+	return candidates
+}
+
+// networkIndex returns the index of the first of the given networks containing the given IP, 0 if no network is given
+// and -1 if none of them contains it. Invalid networks are skipped, as they are reported by the validation of the
+// operator configuration.
+func networkIndex(ip net.IP, cidrs []string) int {
+	if len(cidrs) == 0 {
+		return 0
+	}
+	for i, cidr := range cidrs {
+		if _, network, err := net.ParseCIDR(cidr); err == nil && network.Contains(ip) {
+			return i
+		}
+	}
+	return -1
+}
+
+// AddressChanged returns true if the address the node-local network setup of the given node last ran through is no
+// longer the address chosen by the given policy among the given internal addresses of its Windows VM. When the policy
+// names an interface, only the addresses on the VM tell which candidate is chosen, so the address is considered
+// changed once it is no longer a candidate. Nodes without a recorded address have not changed.
+func AddressChanged(node *v1.Node, addresses []v1.NodeAddress, policy wmcapi.AddressSpec) bool {
+	recorded, found := node.GetAnnotations()[AddressAnnotation]
+	candidates := CandidateAddresses(addresses, policy)
+	if !found || len(candidates) == 0 {
+		return false
+	}
+	if policy.Interface == "" {
+		return recorded != candidates[0]
+	}
+	for _, candidate := range candidates {
+		if candidate == recorded {
+			return false
+		}
+	}
+	return true
+}
+
+// NewNodeConfigFromAddresses creates a new instance of nodeConfig for the Windows VM with the given internal
+// addresses, connected to the address chosen by the address policy of the given configuration. When the policy names
+// an interface, the addresses of the interface are looked up on the VM through the first candidate address, and the
+// first candidate on the interface is connected to.
+func NewNodeConfigFromAddresses(clientset *kubernetes.Clientset, nodes *NodeWatcher, addresses []v1.NodeAddress,
+	instanceID string, clusterNetwork clusternetwork.ClusterNetworkConfig, config *wmcapi.WindowsMachineConfigSpec,
+	signer ssh.Signer, matrix *windows.CompatibilityMatrix) (*nodeConfig, error) {
+	candidates := CandidateAddresses(addresses, config.Address)
+	if len(candidates) == 0 {
+		return nil, retry.NewError(retry.InvalidConfiguration,
+			errors.Errorf("no internal address of VM %s matches the address policy", instanceID))
+	}
+	nc, err := NewNodeConfig(clientset, nodes, candidates[0], instanceID, clusterNetwork, config, signer, matrix)
+	if err != nil || config.Address.Interface == "" {
+		return nc, err
+	}
+	interfaceAddresses, err := nc.InterfaceAddresses(config.Address.Interface)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error looking up the addresses of VM %s", instanceID)
+	}
+	address := interfaceCandidate(candidates, interfaceAddresses)
+	if address == "" {
+		return nil, retry.NewError(retry.InvalidConfiguration,
+			errors.Errorf("no internal address of VM %s matching the address policy is on interface %s",
+				instanceID, config.Address.Interface))
+	}
+	if address == candidates[0] {
+		return nc, nil
+	}
+	log.V(1).Info("connecting to the address of the policy interface", "ID", instanceID, "address", address)
+	return NewNodeConfig(clientset, nodes, address, instanceID, clusterNetwork, config, signer, matrix)
+}
+
+// interfaceCandidate returns the first of the given candidates which is one of the given interface addresses, or an
+// empty string if none is. The zone of the IPv6 interface addresses is ignored.
+func interfaceCandidate(candidates, interfaceAddresses []string) string {
+	for _, candidate := range candidates {
+		ip := net.ParseIP(candidate)
+		for _, address := range interfaceAddresses {
+			if ip.Equal(net.ParseIP(strings.SplitN(address, "%", 2)[0])) {
+				return candidate
+			}
+		}
+	}
+	return ""
+}
+
+// UpdateAddress runs the network stages of the given node which depend on the address of its Windows VM again, after
+// the address chosen by the address policy changed, and records the new address. The node is drained first, as its
+// pods lose their network while the components restart, and uncordoned once it is ready again. The node must have
+// been configured previously.
+func (nc *nodeConfig) UpdateAddress(node *v1.Node) error {
+	defer nc.lockInstance()()
+	return metrics.ObserveStage(metrics.StageAddressUpdate, func() error {
+		nc.node = node
+		if err := nc.drainAndRun(nc.configureNodeLocalNetwork); err != nil {
+			return err
+		}
+		return RecordAddress(nc.k8sclientset, node.GetName(), nc.ipAddress)
+	})
+}
+
+// RecordAddress sets AddressAnnotation to the given address on the given node
+func RecordAddress(client kubernetes.Interface, nodeName, address string) error {
+	return errors.Wrapf(annotateNode(client, nodeName, AddressAnnotation, address),
+		"error recording address on node %s", nodeName)
+}
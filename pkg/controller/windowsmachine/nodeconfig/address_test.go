@@ -0,0 +1,90 @@
+package nodeconfig
+
+import (
+	"testing"
+
+	wmcapi "github.com/openshift/windows-machine-config-operator/pkg/apis/wmc/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// machineAddresses holds the addresses of a Windows VM with two internal IPv4 addresses and one IPv6 address
+var machineAddresses = []v1.NodeAddress{
+	{Type: v1.NodeInternalDNS, Address: "ip-10-0-1-5.ec2.internal"},
+	{Type: v1.NodeInternalIP, Address: "10.0.1.5"},
+	{Type: v1.NodeExternalIP, Address: "52.1.2.3"},
+	{Type: v1.NodeInternalIP, Address: "fd00::5"},
+	{Type: v1.NodeInternalIP, Address: "192.168.1.5"},
+	{Type: v1.NodeInternalIP, Address: "10.0.1.5"},
+}
+
+// TestCandidateAddresses tests that the internal addresses are filtered by family and network, and ordered by the
+// preference of their network
+func TestCandidateAddresses(t *testing.T) {
+	var tests = []struct {
+		name     string
+		policy   wmcapi.AddressSpec
+		expected []string
+	}{
+		{"IPv4", wmcapi.AddressSpec{Family: wmcapi.IPv4}, []string{"10.0.1.5", "192.168.1.5"}},
+		{"IPv6", wmcapi.AddressSpec{Family: wmcapi.IPv6}, []string{"fd00::5"}},
+		{"preferred network", wmcapi.AddressSpec{Family: wmcapi.IPv4, CIDRs: []string{"192.168.0.0/16", "10.0.0.0/8"}},
+			[]string{"192.168.1.5", "10.0.1.5"}},
+		{"single network", wmcapi.AddressSpec{Family: wmcapi.IPv4, CIDRs: []string{"192.168.0.0/16"}},
+			[]string{"192.168.1.5"}},
+		{"no matching network", wmcapi.AddressSpec{Family: wmcapi.IPv4, CIDRs: []string{"172.16.0.0/12"}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, CandidateAddresses(machineAddresses, tt.policy))
+		})
+	}
+}
+
+// TestAddressChanged tests that the recorded address is compared with the chosen address, or with the candidates
+// when the policy names an interface
+func TestAddressChanged(t *testing.T) {
+	var tests = []struct {
+		name     string
+		recorded map[string]string
+		policy   wmcapi.AddressSpec
+		expected bool
+	}{
+		{"not recorded", nil, wmcapi.AddressSpec{Family: wmcapi.IPv4}, false},
+		{"unchanged", map[string]string{AddressAnnotation: "10.0.1.5"}, wmcapi.AddressSpec{Family: wmcapi.IPv4},
+			false},
+		{"other address chosen", map[string]string{AddressAnnotation: "192.168.1.5"},
+			wmcapi.AddressSpec{Family: wmcapi.IPv4}, true},
+		{"candidate of interface", map[string]string{AddressAnnotation: "192.168.1.5"},
+			wmcapi.AddressSpec{Family: wmcapi.IPv4, Interface: "Ethernet"}, false},
+		{"no longer a candidate", map[string]string{AddressAnnotation: "10.0.2.7"},
+			wmcapi.AddressSpec{Family: wmcapi.IPv4, Interface: "Ethernet"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Annotations: tt.recorded}}
+			assert.Equal(t, tt.expected, AddressChanged(node, machineAddresses, tt.policy))
+		})
+	}
+}
+
+// TestInterfaceCandidate tests that the first candidate on the interface is chosen, ignoring the IPv6 zones
+func TestInterfaceCandidate(t *testing.T) {
+	var tests = []struct {
+		name               string
+		candidates         []string
+		interfaceAddresses []string
+		expected           string
+	}{
+		{"first candidate", []string{"10.0.1.5", "192.168.1.5"}, []string{"10.0.1.5", "fe80::1%4"}, "10.0.1.5"},
+		{"second candidate", []string{"10.0.1.5", "192.168.1.5"}, []string{"192.168.1.5"}, "192.168.1.5"},
+		{"zoned address", []string{"fe80::5"}, []string{"fe80::5%12"}, "fe80::5"},
+		{"not on interface", []string{"10.0.1.5"}, []string{"172.16.0.5"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, interfaceCandidate(tt.candidates, tt.interfaceAddresses))
+		})
+	}
+}
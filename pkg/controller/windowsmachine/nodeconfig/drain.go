@@ -0,0 +1,233 @@
+package nodeconfig
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	wmcapi "github.com/openshift/windows-machine-config-operator/pkg/apis/wmc/v1alpha1"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1beta1"
+	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	clientretry "k8s.io/client-go/util/retry"
+)
+
+const (
+	// mirrorPodAnnotation is the annotation identifying the static pods mirrored by the kubelet
+	mirrorPodAnnotation = "kubernetes.io/config.mirror"
+	// CordonedAnnotation is the node annotation set while the node is cordoned by the operator, so that the nodes
+	// cordoned by someone else are not uncordoned, even when a failed update is retried after the node was left
+	// cordoned
+	CordonedAnnotation = "windowsmachineconfig.openshift.io/cordoned"
+)
+
+// drainAndRun cordons and drains the node, runs the given disruptive action, such as restarting the kubelet or the
+// network components, and uncordons the node once it is ready again. The node is left cordoned if the action fails,
+// so that no pod is scheduled on it until the action succeeds. A node which was already cordoned by someone else is
+// left cordoned.
+func (nc *nodeConfig) drainAndRun(action func() error) error {
+	nodeName := nc.node.GetName()
+	if err := cordon(nc.k8sclientset, nodeName); err != nil {
+		return err
+	}
+	if err := drain(nc.k8sclientset, nodeName, nc.config.Drain, nc.config.Retry.Interval.Duration); err != nil {
+		return errors.Wrapf(err, "error draining node %s", nodeName)
+	}
+	if err := action(); err != nil {
+		return err
+	}
+	if err := nc.waitForNode("readiness", func(node *v1.Node) bool {
+		return isNodeReady(node) && nc.clusterNetwork.NodeReady(node)
+	}); err != nil {
+		return errors.Wrapf(err, "error waiting for node %s to be ready before uncordoning it", nodeName)
+	}
+	uncordoned, err := uncordon(nc.k8sclientset, nodeName)
+	if err != nil {
+		return err
+	}
+	if !uncordoned {
+		log.Info("leaving node cordoned, as it was not cordoned by the operator", "node", nodeName)
+	}
+	return nil
+}
+
+// cordon marks the given node unschedulable and sets CordonedAnnotation on it. A node which is already unschedulable
+// is left untouched, so that it keeps its annotation if it has been cordoned by the operator and stays without it
+// otherwise.
+func cordon(client kubernetes.Interface, nodeName string) error {
+	err := clientretry.RetryOnConflict(clientretry.DefaultRetry, func() error {
+		node, err := client.CoreV1().Nodes().Get(context.TODO(), nodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if node.Spec.Unschedulable {
+			return nil
+		}
+		node.Spec.Unschedulable = true
+		if node.Annotations == nil {
+			node.Annotations = make(map[string]string, 1)
+		}
+		node.Annotations[CordonedAnnotation] = "true"
+		_, err = client.CoreV1().Nodes().Update(context.TODO(), node, metav1.UpdateOptions{})
+		return err
+	})
+	return errors.Wrapf(err, "error cordoning node %s", nodeName)
+}
+
+// uncordon marks the given node schedulable again and removes its CordonedAnnotation, if it has been cordoned by the
+// operator. It returns false if the node has been left as it is.
+func uncordon(client kubernetes.Interface, nodeName string) (bool, error) {
+	uncordoned := false
+	err := clientretry.RetryOnConflict(clientretry.DefaultRetry, func() error {
+		node, err := client.CoreV1().Nodes().Get(context.TODO(), nodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if _, found := node.Annotations[CordonedAnnotation]; !found {
+			return nil
+		}
+		node.Spec.Unschedulable = false
+		delete(node.Annotations, CordonedAnnotation)
+		_, err = client.CoreV1().Nodes().Update(context.TODO(), node, metav1.UpdateOptions{})
+		uncordoned = err == nil
+		return err
+	})
+	return uncordoned, errors.Wrapf(err, "error uncordoning node %s", nodeName)
+}
+
+// drain evicts the pods running on the given node through the eviction API, so that PodDisruptionBudgets are
+// honoured, and waits for them to be deleted within the timeout of the given settings. DaemonSet and mirror pods are
+// left in place, as they would be recreated on the node right away. The pods whose eviction is still blocked by a
+// disruption budget at the timeout are deleted if the settings force it, and fail the drain otherwise. The evictions
+// and deletions are polled at the given interval.
+func drain(client kubernetes.Interface, nodeName string, spec wmcapi.DrainSpec, interval time.Duration) error {
+	podList, err := client.CoreV1().Pods(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", nodeName).String()})
+	if err != nil {
+		return errors.Wrapf(err, "error listing pods of node %s", nodeName)
+	}
+	var pods []v1.Pod
+	for _, pod := range podList.Items {
+		if isDrainable(pod) {
+			pods = append(pods, pod)
+		}
+	}
+
+	deadline := time.Now().Add(spec.Timeout.Duration)
+	var blocked []v1.Pod
+	for _, pod := range pods {
+		evicted, err := evict(client, pod, interval, deadline)
+		if err != nil {
+			return errors.Wrapf(err, "error evicting pod %s/%s from node %s", pod.GetNamespace(), pod.GetName(),
+				nodeName)
+		}
+		if !evicted {
+			blocked = append(blocked, pod)
+		}
+	}
+	if len(blocked) > 0 && !spec.Force {
+		return errors.Errorf("eviction of pods %s from node %s still blocked by disruption budgets after %s",
+			podNames(blocked), nodeName, spec.Timeout.Duration)
+	}
+	for _, pod := range blocked {
+		log.Info("deleting pod whose eviction is blocked by a disruption budget", "pod", pod.GetName(),
+			"namespace", pod.GetNamespace(), "node", nodeName)
+		err := client.CoreV1().Pods(pod.GetNamespace()).Delete(context.TODO(), pod.GetName(),
+			metav1.DeleteOptions{Preconditions: metav1.NewUIDPreconditions(string(pod.GetUID()))})
+		if err != nil && !k8sapierrors.IsNotFound(err) && !k8sapierrors.IsConflict(err) {
+			return errors.Wrapf(err, "error deleting pod %s/%s from node %s", pod.GetNamespace(), pod.GetName(),
+				nodeName)
+		}
+		// The deleted pods are given their grace period to terminate, even if the timeout has elapsed
+		if grace := gracePeriod(pod) + interval; time.Until(deadline) < grace {
+			deadline = time.Now().Add(grace)
+		}
+	}
+
+	for _, pod := range pods {
+		err := wait.PollImmediate(interval, timeoutUntil(deadline), func() (bool, error) {
+			current, err := client.CoreV1().Pods(pod.GetNamespace()).Get(context.TODO(), pod.GetName(),
+				metav1.GetOptions{})
+			if k8sapierrors.IsNotFound(err) || (err == nil && current.GetUID() != pod.GetUID()) {
+				return true, nil
+			}
+			return false, err
+		})
+		if err != nil {
+			return errors.Wrapf(err, "error waiting for pod %s/%s to be deleted from node %s", pod.GetNamespace(),
+				pod.GetName(), nodeName)
+		}
+	}
+	return nil
+}
+
+// evict evicts the given pod, retrying at the given interval until the given deadline while a disruption budget
+// blocks the eviction. It returns false if the eviction is still blocked at the deadline.
+func evict(client kubernetes.Interface, pod v1.Pod, interval time.Duration, deadline time.Time) (bool, error) {
+	eviction := &policy.Eviction{ObjectMeta: metav1.ObjectMeta{Name: pod.GetName(), Namespace: pod.GetNamespace()}}
+	// A disruption budget which does not allow the eviction yet results in a TooManyRequests error
+	err := wait.PollImmediate(interval, timeoutUntil(deadline), func() (bool, error) {
+		err := client.PolicyV1beta1().Evictions(pod.GetNamespace()).Evict(context.TODO(), eviction)
+		switch {
+		case err == nil, k8sapierrors.IsNotFound(err):
+			return true, nil
+		case k8sapierrors.IsTooManyRequests(err):
+			log.V(1).Info("pod eviction blocked by disruption budget", "pod", pod.GetName(),
+				"namespace", pod.GetNamespace())
+			return false, nil
+		default:
+			return false, err
+		}
+	})
+	if err == wait.ErrWaitTimeout {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// timeoutUntil returns the time left before the given deadline, to be used as a poll timeout. At least a nanosecond
+// is returned once the deadline has passed, as a zero timeout would never expire.
+func timeoutUntil(deadline time.Time) time.Duration {
+	if timeout := time.Until(deadline); timeout > 0 {
+		return timeout
+	}
+	return time.Nanosecond
+}
+
+// gracePeriod returns the termination grace period of the given pod
+func gracePeriod(pod v1.Pod) time.Duration {
+	if pod.Spec.TerminationGracePeriodSeconds == nil {
+		return v1.DefaultTerminationGracePeriodSeconds * time.Second
+	}
+	return time.Duration(*pod.Spec.TerminationGracePeriodSeconds) * time.Second
+}
+
+// podNames returns the namespaced names of the given pods, separated by commas
+func podNames(pods []v1.Pod) string {
+	names := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		names = append(names, pod.GetNamespace()+"/"+pod.GetName())
+	}
+	return strings.Join(names, ", ")
+}
+
+// isDrainable returns true if the given pod has to be evicted to drain its node
+func isDrainable(pod v1.Pod) bool {
+	if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+		return false
+	}
+	if _, found := pod.GetAnnotations()[mirrorPodAnnotation]; found {
+		return false
+	}
+	for _, owner := range pod.GetOwnerReferences() {
+		if owner.Controller != nil && *owner.Controller && owner.Kind == "DaemonSet" {
+			return false
+		}
+	}
+	return true
+}
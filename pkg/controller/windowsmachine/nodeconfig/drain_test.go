@@ -0,0 +1,110 @@
+package nodeconfig
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	wmcapi "github.com/openshift/windows-machine-config-operator/pkg/apis/wmc/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1beta1"
+	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// TestCordonAndUncordon tests that a node is only uncordoned if it has been cordoned by the operator, including by a
+// previous attempt which left it cordoned
+func TestCordonAndUncordon(t *testing.T) {
+	var tests = []struct {
+		name               string
+		unschedulable      bool
+		annotations        map[string]string
+		expectedUncordoned bool
+	}{
+		{"schedulable node", false, nil, true},
+		{"node cordoned by someone else", true, nil, false},
+		{"node left cordoned by a failed attempt", true, map[string]string{CordonedAnnotation: "true"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(&v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "node1", Annotations: tt.annotations},
+				Spec:       v1.NodeSpec{Unschedulable: tt.unschedulable}})
+			require.NoError(t, cordon(client, "node1"))
+			node, err := client.CoreV1().Nodes().Get(context.TODO(), "node1", metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.True(t, node.Spec.Unschedulable)
+
+			uncordoned, err := uncordon(client, "node1")
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedUncordoned, uncordoned)
+			node, err = client.CoreV1().Nodes().Get(context.TODO(), "node1", metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, !tt.expectedUncordoned, node.Spec.Unschedulable)
+			assert.NotContains(t, node.Annotations, CordonedAnnotation)
+		})
+	}
+}
+
+// TestDrain tests that the pods are evicted unless a disruption budget blocks them, in which case they are only
+// deleted if the drain is forced, and that DaemonSet pods are left in place
+func TestDrain(t *testing.T) {
+	controller := true
+	noGracePeriod := int64(0)
+	newPod := func(name string, owners ...metav1.OwnerReference) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", OwnerReferences: owners},
+			Spec:       v1.PodSpec{NodeName: "node1", TerminationGracePeriodSeconds: &noGracePeriod},
+			Status:     v1.PodStatus{Phase: v1.PodRunning},
+		}
+	}
+	daemonSet := metav1.OwnerReference{Kind: "DaemonSet", Name: "ds", Controller: &controller}
+	timeout := &metav1.Duration{Duration: 100 * time.Millisecond}
+
+	var tests = []struct {
+		name              string
+		force             bool
+		expectedErr       bool
+		expectedRemaining []string
+	}{
+		{"blocked eviction", false, true, []string{"blocked", "daemon"}},
+		{"forced drain", true, false, []string{"daemon"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(newPod("evictable"), newPod("blocked"), newPod("daemon", daemonSet))
+			// The eviction of the blocked pod is refused as a disruption budget would, the other evictions delete
+			// their pod
+			client.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				if action.GetSubresource() != "eviction" {
+					return false, nil, nil
+				}
+				eviction := action.(k8stesting.CreateAction).GetObject().(*policy.Eviction)
+				if eviction.GetName() == "blocked" {
+					return true, nil, k8sapierrors.NewTooManyRequests("disruption budget", 1)
+				}
+				return true, nil, client.Tracker().Delete(v1.SchemeGroupVersion.WithResource("pods"),
+					eviction.GetNamespace(), eviction.GetName())
+			})
+
+			err := drain(client, "node1", wmcapi.DrainSpec{Timeout: timeout, Force: tt.force}, 10*time.Millisecond)
+			if tt.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			pods, err := client.CoreV1().Pods("default").List(context.TODO(), metav1.ListOptions{})
+			require.NoError(t, err)
+			var remaining []string
+			for _, pod := range pods.Items {
+				remaining = append(remaining, pod.GetName())
+			}
+			assert.ElementsMatch(t, tt.expectedRemaining, remaining)
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package nodeconfig
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/windowsmachine/windows"
+	"github.com/openshift/windows-machine-config-operator/pkg/metrics"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+)
+
+// Components of the Windows nodes checked by the health checks, in the order they are remediated
+const (
+	// ComponentKubelet is the kubelet Windows service
+	ComponentKubelet = windows.KubeletServiceName
+	// ComponentNetwork is the network backend of the node, such as the hybrid overlay and its HNS networks
+	ComponentNetwork = "network"
+	// ComponentCNI is the CNI configuration of the node
+	ComponentCNI = "cni"
+	// ComponentKubeProxy is the kube-proxy Windows service and the network resources it depends on
+	ComponentKubeProxy = windows.KubeProxyServiceName
+)
+
+// Reasons of the health problems
+const (
+	// ReasonServiceNotRunning is the reason of the Windows services which are stopped or missing
+	ReasonServiceNotRunning = "ServiceNotRunning"
+	// ReasonNetworkUnhealthy is the reason of the network backend components which are not running or do not match
+	// the configuration, and of the components depending on them
+	ReasonNetworkUnhealthy = "NetworkUnhealthy"
+	// ReasonNetworkResourcesMissing is the reason of kube-proxy missing the network resources it depends on
+	ReasonNetworkResourcesMissing = "NetworkResourcesMissing"
+	// ReasonCNIConfigMissing is the reason of the CNI configuration file missing from the node
+	ReasonCNIConfigMissing = "CNIConfigMissing"
+	// ReasonCNIConfigOutdated is the reason of the CNI configuration not matching the cluster network
+	ReasonCNIConfigOutdated = "CNIConfigOutdated"
+)
+
+// remediationOrder maps the components to their position in the remediation order. The network is repaired before
+// the CNI configuration and kube-proxy, as reconfiguring the network reconfigures them too.
+var remediationOrder = map[string]int{ComponentKubelet: 0, ComponentNetwork: 1, ComponentCNI: 2, ComponentKubeProxy: 3}
+
+// HealthProblem is a component of a Windows node found unhealthy by a health check
+type HealthProblem struct {
+	// Component is the unhealthy component
+	Component string
+	// Reason is a CamelCase word identifying the problem
+	Reason string
+	// Err describes why the component is unhealthy
+	Err error
+}
+
+// CheckHealth checks that the kubelet and kube-proxy services of the given node are running, that its network
+// backend and the network resources of kube-proxy are in place, and that its CNI configuration matches the cluster
+// network. At most one problem per component is returned, in the order they are to be remediated. An error is
+// returned if the checks could not be run. The node must have been configured previously.
+func (nc *nodeConfig) CheckHealth(node *v1.Node) ([]HealthProblem, error) {
+	defer nc.lockInstance()()
+	nc.node = node
+	stopped, err := nc.Windows.StoppedServices()
+	if err != nil {
+		return nil, errors.Wrapf(err, "error checking Windows services of %s", node.GetName())
+	}
+	var problems []HealthProblem
+	kubeProxyStopped := false
+	for _, service := range stopped {
+		problems = append(problems, HealthProblem{Component: service, Reason: ReasonServiceNotRunning,
+			Err: errors.Errorf("%s Windows service is not running", service)})
+		kubeProxyStopped = kubeProxyStopped || service == ComponentKubeProxy
+	}
+
+	if err := nc.clusterNetwork.VerifyNode(nc.Windows, nc.config); err != nil {
+		problems = append(problems, HealthProblem{Component: ComponentNetwork, Reason: ReasonNetworkUnhealthy,
+			Err: err})
+		// The kube-proxy network resources depend on the network backend, so they are only checked when it is
+		// healthy
+		if !kubeProxyStopped {
+			problems = append(problems, HealthProblem{Component: ComponentKubeProxy, Reason: ReasonNetworkUnhealthy,
+				Err: errors.New("kube-proxy cannot route traffic while the network is unhealthy")})
+		}
+	} else if err := nc.clusterNetwork.VerifyKubeProxyNetwork(nc.Windows, nc.config); err != nil && !kubeProxyStopped {
+		problems = append(problems, HealthProblem{Component: ComponentKubeProxy,
+			Reason: ReasonNetworkResourcesMissing, Err: err})
+	}
+
+	reason, err := nc.verifyCNIConfig()
+	if err != nil {
+		if reason == "" {
+			return nil, err
+		}
+		problems = append(problems, HealthProblem{Component: ComponentCNI, Reason: reason, Err: err})
+	}
+	sortProblems(problems)
+	return problems, nil
+}
+
+// verifyCNIConfig returns an error if the CNI configuration of the node does not match the one generated for its
+// host subnet, along with the reason of the problem. The reason is empty if the configuration could not be checked.
+func (nc *nodeConfig) verifyCNIConfig() (string, error) {
+	expected, err := nc.clusterNetwork.CNIConfig(nc.clusterNetwork.HostSubnet(nc.node), nc.config)
+	if err != nil {
+		return "", errors.Wrapf(err, "error generating CNI config of %s", nc.node.GetName())
+	}
+	expectedBuf, err := expected.Marshal()
+	if err != nil {
+		return "", errors.Wrap(err, "can't retrieve CNI config JSON")
+	}
+	actual, err := nc.Windows.GetCNIConfig()
+	if err != nil {
+		return "", errors.Wrapf(err, "error getting CNI config of %s", nc.node.GetName())
+	}
+	if actual == "" {
+		return ReasonCNIConfigMissing, errors.Errorf("CNI config %s not found", windows.CNIConfigFileName)
+	}
+	if !equalJSON(expectedBuf, []byte(actual)) {
+		return ReasonCNIConfigOutdated, errors.New("CNI config does not match the cluster network configuration")
+	}
+	return "", nil
+}
+
+// Remediate repairs the components of the given node with the given problems, found by CheckHealth, in order: the
+// kubelet is restarted, and the network, CNI configuration or kube-proxy are reconfigured. The problems which have
+// been repaired are returned, along with an error for the first one which could not be.
+func (nc *nodeConfig) Remediate(node *v1.Node, problems []HealthProblem) ([]HealthProblem, error) {
+	defer nc.lockInstance()()
+	nc.node = node
+	var repaired []HealthProblem
+	networkReconfigured := false
+	for _, problem := range problems {
+		if networkReconfigured && (problem.Component == ComponentCNI || problem.Component == ComponentKubeProxy) {
+			repaired = append(repaired, problem)
+			continue
+		}
+		if err := metrics.ObserveStage(metrics.StageRemediation, func() error {
+			return nc.remediate(problem.Component)
+		}); err != nil {
+			return repaired, errors.Wrapf(err, "error repairing %s of node %s", problem.Component, node.GetName())
+		}
+		networkReconfigured = networkReconfigured || problem.Component == ComponentNetwork
+		repaired = append(repaired, problem)
+	}
+	return repaired, nil
+}
+
+// remediate repairs the given component of the node
+func (nc *nodeConfig) remediate(component string) error {
+	switch component {
+	case ComponentKubelet:
+		return nc.Windows.RestartService(windows.KubeletServiceName)
+	case ComponentNetwork:
+		return nc.updateNetwork(nc.node)
+	case ComponentCNI:
+		return nc.configureCNI()
+	case ComponentKubeProxy:
+		// Reconfiguring kube-proxy recreates its source VIP endpoint and its Windows service if they are missing
+		return nc.configureKubeProxy()
+	default:
+		return errors.Errorf("unknown component %s", component)
+	}
+}
+
+// sortProblems sorts the given problems in the remediation order of their component
+func sortProblems(problems []HealthProblem) {
+	sort.SliceStable(problems, func(i, j int) bool {
+		return remediationOrder[problems[i].Component] < remediationOrder[problems[j].Component]
+	})
+}
+
+// equalJSON returns true if the given JSON documents hold the same values, whatever their formatting
+func equalJSON(a, b []byte) bool {
+	var aValue, bValue interface{}
+	if json.Unmarshal(a, &aValue) != nil || json.Unmarshal(b, &bValue) != nil {
+		return false
+	}
+	return reflect.DeepEqual(aValue, bValue)
+}
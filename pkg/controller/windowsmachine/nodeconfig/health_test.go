@@ -0,0 +1,89 @@
+package nodeconfig
+
+import (
+	"testing"
+
+	wmcapi "github.com/openshift/windows-machine-config-operator/pkg/apis/wmc/v1alpha1"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/windowsmachine/windows"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+)
+
+// fakeWindows implements the health checks of the Windows interface for testing
+type fakeWindows struct {
+	windows.Windows
+	// stopped holds the names of the services returned by StoppedServices
+	stopped []string
+	// cniConfig is returned by GetCNIConfig
+	cniConfig string
+}
+
+func (f *fakeWindows) ID() string { return "i-0" }
+
+func (f *fakeWindows) StoppedServices() ([]string, error) { return f.stopped, nil }
+
+func (f *fakeWindows) GetCNIConfig() (string, error) { return f.cniConfig, nil }
+
+// TestCheckHealth tests that CheckHealth returns at most one problem per component, in the remediation order
+func TestCheckHealth(t *testing.T) {
+	network := &fakeClusterNetwork{serviceCIDRs: []string{"172.30.0.0/16"}, hostSubnet: "10.132.1.0/24"}
+	config := &wmcapi.WindowsMachineConfigSpec{}
+	expected, err := network.CNIConfig(network.hostSubnet, config)
+	require.NoError(t, err)
+	expectedBuf, err := expected.Marshal()
+	require.NoError(t, err)
+	// The configuration read from the node is formatted differently
+	cniConfig := "\r\n" + string(expectedBuf) + "\r\n"
+	networkErr := errors.New("hybrid-overlay is not running")
+	vipErr := errors.New("VIP endpoint not found")
+
+	var tests = []struct {
+		name            string
+		stopped         []string
+		cniConfig       string
+		verifyNodeErr   error
+		verifyVIPErr    error
+		expectedReasons map[string]string
+		expectedOrder   []string
+	}{
+		{"healthy", nil, cniConfig, nil, nil, nil, nil},
+		{"stopped services", []string{ComponentKubeProxy, ComponentKubelet}, cniConfig, nil, nil,
+			map[string]string{ComponentKubelet: ReasonServiceNotRunning, ComponentKubeProxy: ReasonServiceNotRunning},
+			[]string{ComponentKubelet, ComponentKubeProxy}},
+		{"network backend down", nil, cniConfig, networkErr, vipErr,
+			map[string]string{ComponentNetwork: ReasonNetworkUnhealthy, ComponentKubeProxy: ReasonNetworkUnhealthy},
+			[]string{ComponentNetwork, ComponentKubeProxy}},
+		{"network backend down and kube-proxy stopped", []string{ComponentKubeProxy}, cniConfig, networkErr, nil,
+			map[string]string{ComponentNetwork: ReasonNetworkUnhealthy, ComponentKubeProxy: ReasonServiceNotRunning},
+			[]string{ComponentNetwork, ComponentKubeProxy}},
+		{"VIP endpoint missing", nil, cniConfig, nil, vipErr,
+			map[string]string{ComponentKubeProxy: ReasonNetworkResourcesMissing}, []string{ComponentKubeProxy}},
+		{"CNI config missing", nil, "", nil, nil, map[string]string{ComponentCNI: ReasonCNIConfigMissing},
+			[]string{ComponentCNI}},
+		{"CNI config outdated", []string{ComponentKubelet}, `{"name":"other"}`, nil, nil,
+			map[string]string{ComponentKubelet: ReasonServiceNotRunning, ComponentCNI: ReasonCNIConfigOutdated},
+			[]string{ComponentKubelet, ComponentCNI}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			network.verifyNodeErr = tt.verifyNodeErr
+			network.verifyKubeProxyErr = tt.verifyVIPErr
+			nc := &nodeConfig{Windows: &fakeWindows{stopped: tt.stopped, cniConfig: tt.cniConfig},
+				clusterNetwork: network, config: config}
+			problems, err := nc.CheckHealth(&v1.Node{})
+			require.NoError(t, err)
+			var order []string
+			reasons := make(map[string]string)
+			for _, problem := range problems {
+				order = append(order, problem.Component)
+				reasons[problem.Component] = problem.Reason
+			}
+			assert.Equal(t, tt.expectedOrder, order)
+			if tt.expectedReasons != nil {
+				assert.Equal(t, tt.expectedReasons, reasons)
+			}
+		})
+	}
+}
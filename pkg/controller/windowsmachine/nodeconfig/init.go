@@ -1,20 +1,31 @@
 package nodeconfig
 
 import (
+	"sync"
+
+	wmcapi "github.com/openshift/windows-machine-config-operator/pkg/apis/wmc/v1alpha1"
+	"github.com/pkg/errors"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 )
 
+const (
+	// machineConfigServerPort is the port on which the Machine Config Server(MCS) serves ignition configs
+	machineConfigServerPort = "22623"
+)
+
 // cache holds the information of the nodeConfig that is invariant for multiple reconciliation cycles. We'll use this
 // information when we don't want to get the information from the global context coming from reconciler
-// but to have something at nodeConfig package locally which will be passed onto other structs. There is no need to
-// invalidate this cache as of now, since the only entry in this workerIgnitionEndPoint which will be immutable. If
-// someone wants to change it, they've to restart the operator which will invalidate the cache automatically.
-// Note : It is ok to remove this struct in future, if we don't want to continue. As of now, I can think of only
-// 		  worker ignition endpoint being part of this struct.
+// but to have something at nodeConfig package locally which will be passed onto other structs. The
+// workerIgnitionEndPoint is derived from the cluster Infrastructure object, so the cache must be invalidated through
+// InvalidateCache() whenever that object changes.
 type cache struct {
+	// mutex guards the fields below, as the cache can be invalidated by a watch while a reconcile is reading it
+	mutex sync.Mutex
 	// workerIgnitionEndpoint is the Machine Config Server(MCS) endpoint from which we can download the
 	// the OpenShift worker ignition file.
 	workerIgnitionEndPoint string
+	// bootstrap holds the bootstrap settings the cached ignition endpoint was computed for
+	bootstrap wmcapi.BootstrapSpec
 }
 
 var log = logf.Log.WithName("nodeconfig")
@@ -22,17 +33,40 @@ var log = logf.Log.WithName("nodeconfig")
 // cache has the information related to nodeConfig that should not be changed.
 var nodeConfigCache = cache{}
 
-// init populates the cache that we need for nodeConfig
-func init() {
-	var kubeAPIServerEndpoint string
-	kubeAPIServerEndpoint, err := discoverKubeAPIServerEndpoint()
-	if err != nil {
-		log.Error(err, "unable to find kube api server endpoint")
+// InvalidateCache clears the cached ignition endpoint, forcing it to be rediscovered by the next configuration
+func InvalidateCache() {
+	nodeConfigCache.mutex.Lock()
+	defer nodeConfigCache.mutex.Unlock()
+	nodeConfigCache.workerIgnitionEndPoint = ""
+	log.V(1).Info("invalidated nodeconfig cache")
+}
+
+// getIgnitionEndpoint returns the ignition endpoint of the MachineConfigPool of the given bootstrap settings, which is
+// the ignition endpoint override if set. The endpoint is cached until the settings change or the cache is invalidated.
+func getIgnitionEndpoint(bootstrap wmcapi.BootstrapSpec) (string, error) {
+	nodeConfigCache.mutex.Lock()
+	defer nodeConfigCache.mutex.Unlock()
+	if nodeConfigCache.workerIgnitionEndPoint != "" && nodeConfigCache.bootstrap == bootstrap {
+		return nodeConfigCache.workerIgnitionEndPoint, nil
+	}
+
+	var endpoint string
+	var err error
+	if bootstrap.IgnitionEndpoint != "" {
+		endpoint, err = ignitionEndpointFromOverride(bootstrap.IgnitionEndpoint, bootstrap.MachineConfigPool)
+	} else {
+		// We couldn't find it in cache. Let's compute it now.
+		var kubeAPIServerEndpoint string
+		kubeAPIServerEndpoint, err = discoverKubeAPIServerEndpoint()
+		if err != nil {
+			return "", errors.Wrap(err, "unable to find kube api server endpoint")
+		}
+		endpoint, err = ignitionEndpointFromAPIServer(kubeAPIServerEndpoint, bootstrap.MachineConfigPool)
 	}
-	clusterAddress, err := getClusterAddr(kubeAPIServerEndpoint)
 	if err != nil {
-		log.Error(err, "error getting cluster address")
+		return "", err
 	}
-	// populate the cache
-	nodeConfigCache.workerIgnitionEndPoint = "https://" + clusterAddress + ":22623/config/worker"
+	nodeConfigCache.workerIgnitionEndPoint = endpoint
+	nodeConfigCache.bootstrap = bootstrap
+	return endpoint, nil
 }
@@ -0,0 +1,120 @@
+package nodeconfig
+
+import (
+	"context"
+	"encoding/json"
+
+	wmcapi "github.com/openshift/windows-machine-config-operator/pkg/apis/wmc/v1alpha1"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/operatorconfig"
+	"github.com/openshift/windows-machine-config-operator/pkg/metrics"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	clientretry "k8s.io/client-go/util/retry"
+)
+
+// KubeletConfigAnnotation is the node annotation recording the WindowsMachineConfig kubelet settings applied to the
+// node
+const KubeletConfigAnnotation = "windowsmachineconfig.openshift.io/kubelet-config"
+
+// KubeletConfigAnnotationValue returns the value of KubeletConfigAnnotation for a node with the given kubelet
+// settings applied
+func KubeletConfigAnnotationValue(spec wmcapi.KubeletSpec) (string, error) {
+	// Maps are marshalled with sorted keys, so the same settings always result in the same value
+	value, err := json.Marshal(spec)
+	if err != nil {
+		return "", errors.Wrap(err, "error marshalling kubelet settings")
+	}
+	return string(value), nil
+}
+
+// UpdateKubelet applies the current kubelet settings to the given node, cordoning and draining it first. The node is
+// uncordoned once it is ready with the new settings. The node must have been configured previously.
+func (nc *nodeConfig) UpdateKubelet(node *v1.Node) error {
+	defer nc.lockInstance()()
+	return metrics.ObserveStage(metrics.StageKubeletUpdate, func() error {
+		return nc.updateKubelet(node)
+	})
+}
+
+// updateKubelet cordons and drains the given node, applies the current kubelet settings and uncordons it
+func (nc *nodeConfig) updateKubelet(node *v1.Node) error {
+	nc.node = node
+	return nc.drainAndRun(nc.configureKubelet)
+}
+
+// configureKubelet applies the kubelet settings to the kubelet service of the node, waits for the node to be ready
+// if the kubelet was restarted, and records the settings on the node object
+func (nc *nodeConfig) configureKubelet() error {
+	restarted, err := nc.Windows.ConfigureKubelet(operatorconfig.KubeletArgs(nc.config.Kubelet))
+	if err != nil {
+		return errors.Wrapf(err, "error configuring kubelet for %s", nc.node.GetName())
+	}
+	if restarted {
+		if err := nc.waitForNode("readiness", isNodeReady); err != nil {
+			return errors.Wrapf(err, "error waiting for kubelet of %s", nc.node.GetName())
+		}
+	}
+	node, err := applyKubeletConfigToNode(nc.k8sclientset, nc.node.GetName(), nc.config.Kubelet)
+	if err != nil {
+		return err
+	}
+	nc.node = node
+	return nil
+}
+
+// applyKubeletConfigToNode sets the configured labels on the given node, as the kubelet only registers them when the
+// node is created, removes the labels of the previously applied settings which are no longer configured, and records
+// the settings in KubeletConfigAnnotation
+func applyKubeletConfigToNode(client kubernetes.Interface, nodeName string, spec wmcapi.KubeletSpec) (*v1.Node,
+	error) {
+	value, err := KubeletConfigAnnotationValue(spec)
+	if err != nil {
+		return nil, err
+	}
+	var updated *v1.Node
+	err = clientretry.RetryOnConflict(clientretry.DefaultRetry, func() error {
+		node, err := client.CoreV1().Nodes().Get(context.TODO(), nodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if previousValue, found := node.GetAnnotations()[KubeletConfigAnnotation]; found {
+			previous := wmcapi.KubeletSpec{}
+			if err := json.Unmarshal([]byte(previousValue), &previous); err != nil {
+				log.Info("ignoring invalid kubelet config annotation", "node", nodeName, "error", err.Error())
+			}
+			for key := range previous.NodeLabels {
+				if _, configured := spec.NodeLabels[key]; !configured {
+					delete(node.Labels, key)
+				}
+			}
+		}
+		if node.Labels == nil {
+			node.Labels = make(map[string]string, len(spec.NodeLabels))
+		}
+		for key, labelValue := range spec.NodeLabels {
+			node.Labels[key] = labelValue
+		}
+		if node.Annotations == nil {
+			node.Annotations = make(map[string]string, 1)
+		}
+		node.Annotations[KubeletConfigAnnotation] = value
+		updated, err = client.CoreV1().Nodes().Update(context.TODO(), node, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error applying kubelet settings to node %s", nodeName)
+	}
+	return updated, nil
+}
+
+// isNodeReady returns true if the given node has the Ready condition set to true
+func isNodeReady(node *v1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == v1.NodeReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package nodeconfig
+
+import (
+	"context"
+	"testing"
+
+	wmcapi "github.com/openshift/windows-machine-config-operator/pkg/apis/wmc/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// TestApplyKubeletConfigToNode tests that the configured labels are applied to the node, replacing the labels of the
+// previously applied settings, and that the settings are recorded in the node annotation
+func TestApplyKubeletConfigToNode(t *testing.T) {
+	previous, err := KubeletConfigAnnotationValue(wmcapi.KubeletSpec{NodeLabels: map[string]string{"old": "x",
+		"tier": "backend"}})
+	require.NoError(t, err)
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1",
+		Labels:      map[string]string{"old": "x", "tier": "backend", "other": "y"},
+		Annotations: map[string]string{KubeletConfigAnnotation: previous}}}
+	client := fake.NewSimpleClientset(node)
+
+	spec := wmcapi.KubeletSpec{NodeLabels: map[string]string{"tier": "frontend"}}
+	updated, err := applyKubeletConfigToNode(client, "node1", spec)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"tier": "frontend", "other": "y"}, updated.Labels)
+	expected, err := KubeletConfigAnnotationValue(spec)
+	require.NoError(t, err)
+	assert.Equal(t, expected, updated.Annotations[KubeletConfigAnnotation])
+
+	stored, err := client.CoreV1().Nodes().Get(context.TODO(), "node1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, updated.Labels, stored.Labels)
+}
+
+// TestIsDrainable tests that DaemonSet, mirror and completed pods are not evicted when draining a node
+func TestIsDrainable(t *testing.T) {
+	controller := true
+	var tests = []struct {
+		name     string
+		pod      v1.Pod
+		expected bool
+	}{
+		{"running pod", v1.Pod{Status: v1.PodStatus{Phase: v1.PodRunning}}, true},
+		{"completed pod", v1.Pod{Status: v1.PodStatus{Phase: v1.PodSucceeded}}, false},
+		{"mirror pod", v1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			mirrorPodAnnotation: "hash"}}}, false},
+		{"DaemonSet pod", v1.Pod{ObjectMeta: metav1.ObjectMeta{OwnerReferences: []metav1.OwnerReference{
+			{Kind: "DaemonSet", Name: "ds", Controller: &controller}}}}, false},
+		{"ReplicaSet pod", v1.Pod{ObjectMeta: metav1.ObjectMeta{OwnerReferences: []metav1.OwnerReference{
+			{Kind: "ReplicaSet", Name: "rs", Controller: &controller}}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, isDrainable(tt.pod))
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package nodeconfig
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/metrics"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	clientretry "k8s.io/client-go/util/retry"
+)
+
+// WindowsExporterAnnotation is the node annotation recording the port of the Windows node exporter running on the
+// node
+const WindowsExporterAnnotation = "windowsmachineconfig.openshift.io/windows-exporter-port"
+
+// ConfigureWindowsExporter runs the Windows node exporter on the given node with the port of the monitoring settings,
+// and records the port on the node object. The node must have been configured previously.
+func (nc *nodeConfig) ConfigureWindowsExporter(node *v1.Node) error {
+	defer nc.lockInstance()()
+	return metrics.ObserveStage(metrics.StageMonitoring, func() error {
+		nc.node = node
+		if err := nc.Windows.ConfigureWindowsExporter(nc.config.Monitoring.Port); err != nil {
+			return errors.Wrapf(err, "error configuring Windows node exporter for %s", node.GetName())
+		}
+		return setWindowsExporterAnnotation(nc.k8sclientset, node.GetName(),
+			strconv.Itoa(int(nc.config.Monitoring.Port)))
+	})
+}
+
+// RemoveWindowsExporter removes the Windows node exporter from the given node, and the port recorded on the node
+// object
+func (nc *nodeConfig) RemoveWindowsExporter(node *v1.Node) error {
+	defer nc.lockInstance()()
+	return metrics.ObserveStage(metrics.StageMonitoring, func() error {
+		nc.node = node
+		if err := nc.Windows.RemoveWindowsExporter(); err != nil {
+			return errors.Wrapf(err, "error removing Windows node exporter from %s", node.GetName())
+		}
+		return setWindowsExporterAnnotation(nc.k8sclientset, node.GetName(), "")
+	})
+}
+
+// setWindowsExporterAnnotation sets WindowsExporterAnnotation to the given value on the given node, removing it if
+// the value is empty
+func setWindowsExporterAnnotation(client kubernetes.Interface, nodeName, value string) error {
+	err := clientretry.RetryOnConflict(clientretry.DefaultRetry, func() error {
+		node, err := client.CoreV1().Nodes().Get(context.TODO(), nodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if node.Annotations[WindowsExporterAnnotation] == value {
+			return nil
+		}
+		if value == "" {
+			delete(node.Annotations, WindowsExporterAnnotation)
+		} else {
+			if node.Annotations == nil {
+				node.Annotations = make(map[string]string, 1)
+			}
+			node.Annotations[WindowsExporterAnnotation] = value
+		}
+		_, err = client.CoreV1().Nodes().Update(context.TODO(), node, metav1.UpdateOptions{})
+		return err
+	})
+	return errors.Wrapf(err, "error recording Windows node exporter port on node %s", nodeName)
+}
@@ -6,40 +6,47 @@ import (
 	"os"
 	"path/filepath"
 
+	wmcapi "github.com/openshift/windows-machine-config-operator/pkg/apis/wmc/v1alpha1"
 	"github.com/openshift/windows-machine-config-operator/pkg/clusternetwork"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/windowsmachine/windows"
 	"github.com/pkg/errors"
+	"k8s.io/client-go/kubernetes"
 )
 
-// cniConf contains the structure of the CNI template
-type cniConf struct {
-	CNIVersion   string       `json:"cniVersion"`
-	Name         string       `json:"name"`
-	Type         string       `json:"type"`
-	Capabilities capabilities `json:"capabilities"`
-	IPAM         ipam         `json:"ipam"`
-	Policies     policies     `json:"policies"`
-}
-
-// nested structs required for creating cniConf struct
-type capabilities struct {
-	DNS bool `json:"dns"`
-}
+// NetworkConfigAnnotation is the node annotation recording the cluster network configuration and the
+// WindowsMachineConfig network and kube-proxy settings the network of the node was last configured with
+const NetworkConfigAnnotation = "windowsmachineconfig.openshift.io/network-config"
 
-type ipam struct {
-	Type   string `json:"type"`
-	Subnet string `json:"subnet"`
+// networkConfigAnnotationValue is marshalled into the value of NetworkConfigAnnotation
+type networkConfigAnnotationValue struct {
+	Cluster   interface{}          `json:"cluster"`
+	Network   wmcapi.NetworkSpec   `json:"network"`
+	KubeProxy wmcapi.KubeProxySpec `json:"kubeProxy"`
 }
 
-type policies []struct {
-	Name  string `json:"name"`
-	Value value  `json:"value"`
+// NetworkConfigAnnotationValue returns the value of NetworkConfigAnnotation for a node configured for the given
+// cluster network configuration and the network and kube-proxy settings of the given operator configuration
+func NetworkConfigAnnotationValue(clusterNetwork clusternetwork.ClusterNetworkConfig,
+	config *wmcapi.WindowsMachineConfigSpec) (string, error) {
+	// Maps are marshalled with sorted keys, so the same settings always result in the same value
+	value, err := json.Marshal(networkConfigAnnotationValue{Cluster: clusterNetwork.Settings(),
+		Network: config.Network, KubeProxy: config.KubeProxy})
+	if err != nil {
+		return "", errors.Wrap(err, "error marshalling network settings")
+	}
+	return string(value), nil
 }
 
-type value struct {
-	Type              string   `json:"Type"`
-	ExceptionList     []string `json:"ExceptionList,omitempty"`
-	DestinationPrefix string   `json:"DestinationPrefix,omitempty"`
-	NeedEncap         bool     `json:"NeedEncap"`
+// recordNetworkConfig sets NetworkConfigAnnotation on the given node to the network configuration it has been
+// configured with
+func recordNetworkConfig(client kubernetes.Interface, nodeName string,
+	clusterNetwork clusternetwork.ClusterNetworkConfig, config *wmcapi.WindowsMachineConfigSpec) error {
+	value, err := NetworkConfigAnnotationValue(clusterNetwork, config)
+	if err != nil {
+		return err
+	}
+	return errors.Wrapf(annotateNode(client, nodeName, NetworkConfigAnnotation, value),
+		"error recording network configuration on node %s", nodeName)
 }
 
 // network struct contains the node network information
@@ -71,33 +78,21 @@ func (nw *network) cleanupTempConfig(configFile string) error {
 	return nil
 }
 
-// populateCniConfig populates the CNI config template with necessary information and
-// creates a new file in temp directory to store the modified template
-func (nw *network) populateCniConfig(serviceCIDR string, templatePath string) (string, error) {
+// populateCniConfig generates the CNI config for the node with the network backend of the given cluster network
+// configuration and the given operator configuration, and creates a new file in temp directory to store it
+func (nw *network) populateCniConfig(clusterNetwork clusternetwork.ClusterNetworkConfig,
+	config *wmcapi.WindowsMachineConfigSpec) (string, error) {
 	if nw.hostSubnet == "" {
 		return "", errors.New("can't populate CNI config with empty hostSubnet")
 	}
-
-	cniConfTemplate, err := ioutil.ReadFile(templatePath)
+	cniCfg, err := clusterNetwork.CNIConfig(nw.hostSubnet, config)
 	if err != nil {
-		return "", errors.Wrapf(err, "error reading CNI config template from %s", templatePath)
+		return "", errors.Wrap(err, "error generating CNI config")
 	}
 
-	cniCfg := cniConf{}
-	if err = json.Unmarshal(cniConfTemplate, &cniCfg); err != nil {
-		return "", errors.Wrap(err, "error converting CNI template into cniCfg struct")
-	}
-
-	if err = populateCfgPolicies(&cniCfg.Policies, serviceCIDR); err != nil {
-		return "", errors.Wrap(err, "error populating config policies in cniConf struct")
-	}
-
-	cniCfg.IPAM.Subnet = nw.hostSubnet
-
-	// retrieve the json file from the modified struct
-	cniCfgBuf, err := json.Marshal(&cniCfg)
+	cniCfgBuf, err := cniCfg.Marshal()
 	if err != nil {
-		return "", errors.Wrap(err, "can't retrieve cniConf JSON using modified struct")
+		return "", errors.Wrap(err, "can't retrieve CNI config JSON")
 	}
 
 	// Create a temp file to hold the cniCfg
@@ -105,7 +100,7 @@ func (nw *network) populateCniConfig(serviceCIDR string, templatePath string) (s
 	if err != nil {
 		return "", errors.Wrapf(err, "error creating Local temp CNI directory")
 	}
-	cniConfigPath, err := os.Create(filepath.Join(tmpCniDir, "cni.conf"))
+	cniConfigPath, err := os.Create(filepath.Join(tmpCniDir, windows.CNIConfigFileName))
 	if err != nil {
 		return "", errors.Wrapf(err, "error creating local cni.conf file")
 	}
@@ -119,13 +114,3 @@ func (nw *network) populateCniConfig(serviceCIDR string, templatePath string) (s
 	}
 	return cniConfigPath.Name(), nil
 }
-
-// populateCfgPolicies populates the policies in cniConf struct with serviceCIDR information
-func populateCfgPolicies(cniCfgPolicies *policies, serviceCIDR string) error {
-	if len(*cniCfgPolicies) < 2 || len((*cniCfgPolicies)[0].Value.ExceptionList) == 0 || (*cniCfgPolicies)[1].Value.DestinationPrefix == "" {
-		return errors.Errorf("invalid policy fields in cniConf struct")
-	}
-	(*cniCfgPolicies)[0].Value.ExceptionList[0] = serviceCIDR
-	(*cniCfgPolicies)[1].Value.DestinationPrefix = serviceCIDR
-	return nil
-}
@@ -1,97 +1,152 @@
 package nodeconfig
 
 import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
 	"testing"
 
+	wmcapi "github.com/openshift/windows-machine-config-operator/pkg/apis/wmc/v1alpha1"
+	"github.com/openshift/windows-machine-config-operator/pkg/clusternetwork"
+	"github.com/openshift/windows-machine-config-operator/pkg/cni"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/windowsmachine/windows"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
-var tests = []struct {
-	name         string
-	policies     *policies
-	serviceCIDR  string
-	errorMessage string
-}{
-	{name: "invalid policies in cniCfg struct",
-		policies:     mockInvalidPolicies(),
-		serviceCIDR:  "10.128.0.0/14",
-		errorMessage: "invalid policy fields in cniConf struct"},
-
-	{name: "valid policies in cniCfg struct",
-		policies:     mockValidPolicies(),
-		serviceCIDR:  "10.128.0.0/14",
-		errorMessage: ""},
+// fakeClusterNetwork implements the ClusterNetworkConfig interface for testing
+type fakeClusterNetwork struct {
+	serviceCIDRs []string
+	// hostSubnet is returned by HostSubnet
+	hostSubnet string
+	// verifyNodeErr is returned by VerifyNode
+	verifyNodeErr error
+	// verifyKubeProxyErr is returned by VerifyKubeProxyNetwork
+	verifyKubeProxyErr error
 }
 
-// TestPopulateCfgPoliciesError tests if populateCfgPolicies function throws appropriate errors when
-// the policy struct passed has invalid fields to populate CNI config
-func TestPopulateCfgPoliciesError(t *testing.T) {
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			err := populateCfgPolicies(tt.policies, tt.serviceCIDR)
-			if tt.errorMessage == "" {
-				require.Nil(t, err, "Successful check for invalid CNI config template")
-			} else {
-				require.Error(t, err, "Function populateCniConfig did not throw an error "+
-					"when it was expected to")
-				assert.Contains(t, err.Error(), tt.errorMessage)
-			}
-		})
-	}
-}
+func (f *fakeClusterNetwork) Validate() error { return nil }
 
-// TestPopulateCfgPoliciesValues tests if populateCfgPolicies function sets appropriate values in
-// the CNI config policy struct
-func TestPopulateCfgPoliciesValues(t *testing.T) {
-	policies := mockValidPolicies()
-	serviceCIDR := "10.128.0.0/14"
-	_ = populateCfgPolicies(policies, serviceCIDR)
-	if (*policies)[0].Value.ExceptionList[0] != serviceCIDR || (*policies)[1].Value.DestinationPrefix != serviceCIDR {
-		t.Errorf("error populating policies in CNI config")
-	}
+func (f *fakeClusterNetwork) GetServiceCIDRs() ([]string, error) { return f.serviceCIDRs, nil }
+
+func (f *fakeClusterNetwork) GetClusterNetworkCIDRs() ([]string, error) { return nil, nil }
+
+func (f *fakeClusterNetwork) Equal(clusternetwork.ClusterNetworkConfig) bool { return false }
+
+func (f *fakeClusterNetwork) Settings() interface{} { return f.serviceCIDRs }
+
+func (f *fakeClusterNetwork) PayloadFiles(*wmcapi.WindowsMachineConfigSpec) map[string]string {
+	return nil
 }
 
-// mockValidPolicies is a helper function to create a set of valid CNI config policies
-// for testing populateCfgPolicies()
-func mockValidPolicies() *policies {
-	name := "EndPointPolicy"
-	exceptionList := []string{"10.132.0.0/16"}
+func (f *fakeClusterNetwork) HostSubnet(*v1.Node) string { return f.hostSubnet }
+
+func (f *fakeClusterNetwork) ConfigureNode(windows.Windows, *v1.Node, *wmcapi.WindowsMachineConfigSpec) error {
+	return nil
+}
 
-	value0 := &value{ExceptionList: exceptionList, DestinationPrefix: "10.132.0.0/16"}
-	value1 := &value{ExceptionList: exceptionList, DestinationPrefix: "10.132.0.0/16"}
+func (f *fakeClusterNetwork) NodeReady(*v1.Node) bool { return true }
 
-	data0 := struct {
-		Name  string `json:"name"`
-		Value value  `json:"value"`
-	}{name, *value0}
+func (f *fakeClusterNetwork) VerifyNode(windows.Windows, *wmcapi.WindowsMachineConfigSpec) error {
+	return f.verifyNodeErr
+}
 
-	data1 := struct {
-		Name  string `json:"name"`
-		Value value  `json:"value"`
-	}{name, *value1}
+func (f *fakeClusterNetwork) VerifyKubeProxyNetwork(windows.Windows, *wmcapi.WindowsMachineConfigSpec) error {
+	return f.verifyKubeProxyErr
+}
 
-	return &policies{data0, data1}
+func (f *fakeClusterNetwork) CNIConfig(hostSubnet string, config *wmcapi.WindowsMachineConfigSpec) (*cni.Config,
+	error) {
+	return cni.NewBuilder().WithHostSubnet(hostSubnet).WithServiceCIDRs(f.serviceCIDRs).
+		WithDSR(config.KubeProxy.EnableDSR).Build()
 }
 
-// mockValidPolicies is a helper function to create a set of invalid CNI config policies
-// for testing populateCfgPolicies()
-func mockInvalidPolicies() *policies {
-	name := "EndPointPolicy"
-	exceptionList := []string{""}
+func (f *fakeClusterNetwork) KubeProxyFeatureGates() map[string]bool { return nil }
+
+func (f *fakeClusterNetwork) KubeProxyArgs(windows.Windows, *v1.Node, *wmcapi.WindowsMachineConfigSpec) ([]string,
+	error) {
+	return nil, nil
+}
 
-	value0 := &value{ExceptionList: exceptionList, DestinationPrefix: "10.132.0.0/16"}
-	value1 := &value{ExceptionList: exceptionList, DestinationPrefix: ""}
+// TestPopulateCniConfig tests if populateCniConfig writes the CNI config generated by the network backend
+func TestPopulateCniConfig(t *testing.T) {
+	nw := newNetwork()
+	require.NoError(t, nw.setHostSubnet("10.132.1.0/24"))
+	configFile, err := nw.populateCniConfig(&fakeClusterNetwork{serviceCIDRs: []string{"172.30.0.0/16"}},
+		&wmcapi.WindowsMachineConfigSpec{KubeProxy: wmcapi.KubeProxySpec{EnableDSR: true}})
+	require.NoError(t, err)
+	defer nw.cleanupTempConfig(configFile)
+
+	buf, err := ioutil.ReadFile(configFile)
+	require.NoError(t, err)
+	config := cni.Config{}
+	require.NoError(t, json.Unmarshal(buf, &config))
+	assert.Equal(t, cni.HybridOverlayNetworkName, config.Name)
+	assert.Equal(t, "10.132.1.0/24", config.IPAM.Subnet)
+	require.Len(t, config.Policies, 2)
+	assert.Equal(t, "172.30.0.0/16", config.Policies[1].Value.DestinationPrefix)
+	assert.True(t, config.LoopbackDSR)
+}
 
-	policy0 := struct {
-		Name  string `json:"name"`
-		Value value  `json:"value"`
-	}{name, *value0}
+// TestPopulateCniConfigError tests if populateCniConfig throws appropriate errors
+func TestPopulateCniConfigError(t *testing.T) {
+	nw := newNetwork()
+	_, err := nw.populateCniConfig(&fakeClusterNetwork{serviceCIDRs: []string{"172.30.0.0/16"}},
+		&wmcapi.WindowsMachineConfigSpec{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "can't populate CNI config with empty hostSubnet")
+
+	require.NoError(t, nw.setHostSubnet("10.132.1.0/24"))
+	_, err = nw.populateCniConfig(&fakeClusterNetwork{}, &wmcapi.WindowsMachineConfigSpec{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "can't build CNI config without service CIDRs")
+}
 
-	policy1 := struct {
-		Name  string `json:"name"`
-		Value value  `json:"value"`
-	}{name, *value1}
+// TestNetworkConfigAnnotationValue tests that the annotation value changes with the cluster network configuration and
+// the network and kube-proxy settings, and only with them
+func TestNetworkConfigAnnotationValue(t *testing.T) {
+	clusterNetwork := &fakeClusterNetwork{serviceCIDRs: []string{"172.30.0.0/16"}}
+	config := &wmcapi.WindowsMachineConfigSpec{KubeProxy: wmcapi.KubeProxySpec{EnableDSR: true}}
+	value, err := NetworkConfigAnnotationValue(clusterNetwork, config)
+	require.NoError(t, err)
+
+	var tests = []struct {
+		name           string
+		clusterNetwork *fakeClusterNetwork
+		config         *wmcapi.WindowsMachineConfigSpec
+		changed        bool
+	}{
+		{"same configuration", &fakeClusterNetwork{serviceCIDRs: []string{"172.30.0.0/16"}},
+			&wmcapi.WindowsMachineConfigSpec{KubeProxy: wmcapi.KubeProxySpec{EnableDSR: true},
+				Kubelet: wmcapi.KubeletSpec{NodeLabels: map[string]string{"tier": "frontend"}}}, false},
+		{"cluster network changed", &fakeClusterNetwork{serviceCIDRs: []string{"172.31.0.0/16"}}, config, true},
+		{"network settings changed", clusterNetwork,
+			&wmcapi.WindowsMachineConfigSpec{KubeProxy: wmcapi.KubeProxySpec{EnableDSR: true},
+				Network: wmcapi.NetworkSpec{MTU: 1400}}, true},
+		{"kube-proxy settings changed", clusterNetwork, &wmcapi.WindowsMachineConfigSpec{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			other, err := NetworkConfigAnnotationValue(tt.clusterNetwork, tt.config)
+			require.NoError(t, err)
+			assert.Equal(t, tt.changed, other != value)
+		})
+	}
+}
 
-	return &policies{policy0, policy1}
+// TestRecordNetworkConfig tests that the network configuration is recorded in the node annotation
+func TestRecordNetworkConfig(t *testing.T) {
+	client := fake.NewSimpleClientset(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}})
+	clusterNetwork := &fakeClusterNetwork{serviceCIDRs: []string{"172.30.0.0/16"}}
+	config := &wmcapi.WindowsMachineConfigSpec{Network: wmcapi.NetworkSpec{MTU: 1400}}
+	require.NoError(t, recordNetworkConfig(client, "node1", clusterNetwork, config))
+
+	expected, err := NetworkConfigAnnotationValue(clusterNetwork, config)
+	require.NoError(t, err)
+	node, err := client.CoreV1().Nodes().Get(context.TODO(), "node1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, expected, node.Annotations[NetworkConfigAnnotation])
 }
@@ -2,52 +2,61 @@ package nodeconfig
 
 import (
 	"context"
+	"net"
 	"net/url"
 	"strings"
 
 	clientset "github.com/openshift/client-go/config/clientset/versioned"
+	wmcapi "github.com/openshift/windows-machine-config-operator/pkg/apis/wmc/v1alpha1"
 	"github.com/openshift/windows-machine-config-operator/pkg/clusternetwork"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/concurrency"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/operatorconfig"
 	"github.com/openshift/windows-machine-config-operator/pkg/controller/retry"
-	wkl "github.com/openshift/windows-machine-config-operator/pkg/controller/wellknownlocations"
 	"github.com/openshift/windows-machine-config-operator/pkg/controller/windowsmachine/windows"
+	"github.com/openshift/windows-machine-config-operator/pkg/metrics"
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/ssh"
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 )
 
 const (
-	// bootstrapCSR is the CSR name associated with a worker node that just got bootstrapped.
-	bootstrapCSR = "system:serviceaccount:openshift-machine-config-operator:node-bootstrapper"
-	// HybridOverlaySubnet is an annotation applied by the cluster network operator which is used by the hybrid overlay
-	HybridOverlaySubnet = "k8s.ovn.org/hybrid-overlay-node-subnet"
-	// HybridOverlayMac is an annotation applied by the hybrid-overlay
-	HybridOverlayMac = "k8s.ovn.org/hybrid-overlay-distributed-router-gateway-mac"
 	// WindowsOSLabel is the label that is applied by WMCB to identify the Windows nodes bootstrapped via WMCB
 	WindowsOSLabel = "node.openshift.io/os_id=Windows"
 	// WorkerLabel is the label that needs to be applied to the Windows node to make it worker node
 	WorkerLabel = "node-role.kubernetes.io/worker"
 )
 
+// instanceLocks serializes the operations run on each Windows VM, keyed by instance ID, so that a VM is never
+// configured by two reconciles at once, whichever controllers they belong to
+var instanceLocks = concurrency.NewKeyedMutex()
+
 // nodeConfig holds the information to make the given VM a kubernetes node. As of now, it holds the information
 // related to kubeclient and the windowsVM.
 type nodeConfig struct {
 	// k8sclientset holds the information related to kubernetes clientset
 	k8sclientset *kubernetes.Clientset
+	// nodes serves the Windows nodes from the shared informer
+	nodes *NodeWatcher
 	// Windows holds the information related to the windows VM
 	windows.Windows
+	// ipAddress is the address of the Windows VM the operator is connected to
+	ipAddress string
 	// Node holds the information related to node object
 	node *v1.Node
 	// network holds the network information specific to the node
 	network *network
-	// clusterServiceCIDR holds the service CIDR for cluster
-	clusterServiceCIDR string
+	// clusterNetwork holds the cluster network configuration, and the network backend configuring the node for it
+	clusterNetwork clusternetwork.ClusterNetworkConfig
+	// config holds the operator configuration of the Windows nodes
+	config *wmcapi.WindowsMachineConfigSpec
 }
 
-// discoverKubeAPIServerEndpoint discovers the kubernetes api server endpoint from the
+// discoverKubeAPIServerEndpoint discovers the internal kubernetes api server endpoint from the cluster Infrastructure
+// object. The external endpoint is not used instead, as its load balancer does not usually expose the Machine Config
+// Server port.
 func discoverKubeAPIServerEndpoint() (string, error) {
 	cfg, err := config.GetConfig()
 	if err != nil {
@@ -64,107 +73,203 @@ func discoverKubeAPIServerEndpoint() (string, error) {
 		return "", errors.Wrap(err, "unable to get cluster infrastructure resource")
 	}
 	// get API server internal url of format https://api-int.abc.devcluster.openshift.com:6443
-	if host.Status.APIServerInternalURL == "" {
-		return "", errors.Wrap(err, "could not get host name for the kubernetes api server")
+	if host.Status.APIServerInternalURL != "" {
+		return host.Status.APIServerInternalURL, nil
 	}
-	return host.Status.APIServerInternalURL, nil
+	return "", retry.NewError(retry.InvalidConfiguration, errors.New("the cluster Infrastructure has no internal "+
+		"API server URL to derive the Machine Config Server endpoint from, set spec.bootstrap.ignitionEndpoint in "+
+		"the WindowsMachineConfig"))
 }
 
-// NewNodeConfig creates a new instance of nodeConfig to be used by the caller.
-func NewNodeConfig(clientset *kubernetes.Clientset, ipAddress, instanceID, clusterServiceCIDR string,
-	signer ssh.Signer) (*nodeConfig, error) {
-	var err error
-	if nodeConfigCache.workerIgnitionEndPoint == "" {
-		var kubeAPIServerEndpoint string
-		// We couldn't find it in cache. Let's compute it now.
-		kubeAPIServerEndpoint, err = discoverKubeAPIServerEndpoint()
-		if err != nil {
-			return nil, errors.Wrap(err, "unable to find kube api server endpoint")
-		}
-		clusterAddress, err := getClusterAddr(kubeAPIServerEndpoint)
-		if err != nil {
-			return nil, errors.Wrap(err, "error getting cluster address")
-		}
-		workerIgnitionEndpoint := "https://" + clusterAddress + ":22623/config/worker"
-		nodeConfigCache.workerIgnitionEndPoint = workerIgnitionEndpoint
+// NewNodeConfig creates a new instance of nodeConfig to be used by the caller. The node of the VM is looked up and
+// waited for through the given NodeWatcher, and the Windows build of the VM is checked against the given compatibility
+// matrix.
+func NewNodeConfig(clientset *kubernetes.Clientset, nodes *NodeWatcher, ipAddress, instanceID string,
+	clusterNetwork clusternetwork.ClusterNetworkConfig, config *wmcapi.WindowsMachineConfigSpec,
+	signer ssh.Signer, matrix *windows.CompatibilityMatrix) (*nodeConfig, error) {
+	workerIgnitionEndpoint, err := getIgnitionEndpoint(config.Bootstrap)
+	if err != nil {
+		return nil, retry.NewError(retry.InvalidConfiguration, errors.Wrap(err, "error getting ignition endpoint"))
+	}
+	clusterServiceCIDRs, err := clusterNetwork.GetServiceCIDRs()
+	if err != nil {
+		return nil, retry.NewError(retry.InvalidConfiguration,
+			errors.Wrap(err, "error getting cluster service CIDRs"))
 	}
-	if err = clusternetwork.ValidateCIDR(clusterServiceCIDR); err != nil {
-		return nil, errors.Wrap(err, "error receiving valid CIDR value for "+
-			"creating new node config")
+	if err = clusternetwork.ValidateServiceCIDRs(clusterServiceCIDRs); err != nil {
+		return nil, retry.NewError(retry.InvalidConfiguration,
+			errors.Wrap(err, "error receiving valid CIDR values for creating new node config"))
 	}
 
-	win, err := windows.New(ipAddress, instanceID, nodeConfigCache.workerIgnitionEndPoint, signer)
+	win, err := windows.New(ipAddress, instanceID, workerIgnitionEndpoint, signer, config, matrix)
 	if err != nil {
 		return nil, errors.Wrap(err, "error instantiating Windows instance from VM")
 	}
 
-	return &nodeConfig{k8sclientset: clientset, Windows: win, network: newNetwork(),
-		clusterServiceCIDR: clusterServiceCIDR}, nil
+	return &nodeConfig{k8sclientset: clientset, nodes: nodes, Windows: win, ipAddress: ipAddress,
+		network: newNetwork(), clusterNetwork: clusterNetwork, config: config}, nil
+}
+
+// lockInstance waits until no other operation runs on the Windows VM, and returns the function ending the operation.
+// Every exported operation of nodeConfig must hold the lock of its VM.
+func (nc *nodeConfig) lockInstance() func() {
+	return instanceLocks.Lock(nc.ID())
+}
+
+// InstanceBusy returns true if an operation is running on the Windows VM with the given instance ID, or waiting for
+// it
+func InstanceBusy(instanceID string) bool {
+	return instanceLocks.IsLocked(instanceID)
 }
 
 // getClusterAddr gets the cluster address associated with given kubernetes APIServerEndpoint.
 // For example: https://api-int.abc.devcluster.openshift.com:6443 gets translated to
 // api-int.abc.devcluster.openshift.com
-// TODO: Think if this needs to be removed as this is too restrictive. Imagine apiserver behind
-// 		a loadbalancer.
-// 		Jira story: https://issues.redhat.com/browse/WINC-398
 func getClusterAddr(kubeAPIServerEndpoint string) (string, error) {
 	clusterEndPoint, err := url.Parse(kubeAPIServerEndpoint)
 	if err != nil {
 		return "", errors.Wrap(err, "unable to parse the kubernetes API server endpoint")
 	}
 	hostName := clusterEndPoint.Hostname()
-
-	// Check if hostname is valid
-	if !strings.HasPrefix(hostName, "api-int.") {
-		return "", errors.New("invalid API server url format found: expected hostname to start with `api-int.`")
+	if hostName == "" {
+		return "", errors.Errorf("invalid API server url %s: no hostname found", kubeAPIServerEndpoint)
 	}
 	return hostName, nil
 }
 
-// Configure configures the Windows VM to make it a Windows worker node
+// ignitionEndpointFromAPIServer returns the MCS endpoint serving the ignition config of the given MachineConfigPool.
+// The MCS is served on the same host as the given kubernetes API server endpoint.
+// For example: https://api-int.abc.devcluster.openshift.com:6443 and the worker pool gets translated to
+// https://api-int.abc.devcluster.openshift.com:22623/config/worker
+func ignitionEndpointFromAPIServer(kubeAPIServerEndpoint, machineConfigPool string) (string, error) {
+	clusterAddress, err := getClusterAddr(kubeAPIServerEndpoint)
+	if err != nil {
+		return "", errors.Wrap(err, "error getting cluster address")
+	}
+	return "https://" + net.JoinHostPort(clusterAddress, machineConfigServerPort) + "/config/" + machineConfigPool,
+		nil
+}
+
+// ignitionEndpointFromOverride validates the user provided MCS endpoint and returns the ignition endpoint for the
+// given MachineConfigPool. If the endpoint does not specify a path, the path of the pool's config is appended.
+// For example: https://mcs.example.com:22623 and the worker pool gets translated to
+// https://mcs.example.com:22623/config/worker
+func ignitionEndpointFromOverride(endpoint, machineConfigPool string) (string, error) {
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to parse ignition endpoint %s", endpoint)
+	}
+	if endpointURL.Scheme != "https" {
+		return "", errors.Errorf("ignition endpoint %s must use the https scheme", endpoint)
+	}
+	if endpointURL.Hostname() == "" {
+		return "", errors.Errorf("ignition endpoint %s has no hostname", endpoint)
+	}
+	if strings.Trim(endpointURL.Path, "/") == "" {
+		endpointURL.Path = "/config/" + machineConfigPool
+	}
+	return endpointURL.String(), nil
+}
+
+// Configure configures the Windows VM to make it a Windows worker node. A VM whose node has already joined the cluster
+// is reconfigured from scratch, draining the node first and uncordoning it once it is ready again. The duration and
+// failures of each stage of the configuration are recorded in the operator metrics.
 func (nc *nodeConfig) Configure() error {
-	if err := nc.Windows.Configure(); err != nil {
+	defer nc.lockInstance()()
+	node, err := nc.nodes.GetByInstance(nc.ID())
+	if err != nil {
+		return err
+	}
+	if node != nil {
+		log.Info("reconfiguring Windows node", "node", node.GetName())
+		nc.node = node
+		return nc.drainAndRun(nc.configure)
+	}
+	return nc.configure()
+}
+
+// configure runs the stages of the configuration of the Windows VM
+func (nc *nodeConfig) configure() error {
+	if err := metrics.ObserveStage(metrics.StageBootstrap, nc.Windows.Configure); err != nil {
 		return errors.Wrap(err, "configuring the Windows VM failed")
 	}
-	// populate node object in nodeConfig
-	if err := nc.setNode(); err != nil {
-		return errors.Wrapf(err, "error getting node object for VM %s", nc.ID())
+	if err := metrics.ObserveStage(metrics.StageNetworkFiles, nc.transferNetworkFiles); err != nil {
+		return errors.Wrap(err, "error transferring network files to Windows VM")
 	}
-	// Apply worker labels
-	if err := nc.applyWorkerLabel(); err != nil {
-		return errors.Wrap(err, "failed applying worker label")
+	if err := metrics.ObserveStage(metrics.StageNodeRegistration, func() error {
+		// populate node object in nodeConfig
+		if err := nc.setNode(); err != nil {
+			return errors.Wrapf(err, "error getting node object for VM %s", nc.ID())
+		}
+		// Apply worker labels
+		return errors.Wrap(nc.applyWorkerLabel(), "failed applying worker label")
+	}); err != nil {
+		return err
 	}
 	// Now that basic kubelet configuration is complete, configure networking in the node
-	if err := nc.configureNetwork(); err != nil {
+	if err := metrics.ObserveStage(metrics.StageNetwork, nc.configureNetwork); err != nil {
 		return errors.Wrap(err, "configuring node network failed")
 	}
-	return nil
+	// The kubelet settings are applied last, as restarting the kubelet requires the node to become ready again
+	if err := metrics.ObserveStage(metrics.StageKubelet, nc.configureKubelet); err != nil {
+		return errors.Wrap(err, "configuring kubelet failed")
+	}
+	// The boot and the address the node-local setup ran with are recorded, so that it is run again when they change
+	if err := RecordAddress(nc.k8sclientset, nc.node.GetName(), nc.ipAddress); err != nil {
+		return err
+	}
+	return RecordBootID(nc.k8sclientset, nc.node.GetName(), nc.node.Status.NodeInfo.BootID)
 }
 
-// configureNetwork configures k8s networking in the node
-// we are assuming that the WindowsVM and node objects are valid
-func (nc *nodeConfig) configureNetwork() error {
-	// Wait until the node object has the hybrid overlay subnet annotation. Otherwise the hybrid-overlay will fail to
-	// start
-	if err := nc.waitForNodeAnnotation(HybridOverlaySubnet); err != nil {
-		return errors.Wrapf(err, "error waiting for %s node annotation for %s", HybridOverlaySubnet,
-			nc.node.GetName())
-	}
+// UpdateNetwork regenerates the CNI configuration and the kube-proxy arguments of the given node from the current
+// cluster network configuration and redeploys them. The network backend setup is run again first if it does not match
+// the configuration. The node is drained first, as its pods lose their network while the components restart, and
+// uncordoned once it is ready again. The node must have been configured previously.
+func (nc *nodeConfig) UpdateNetwork(node *v1.Node) error {
+	defer nc.lockInstance()()
+	return metrics.ObserveStage(metrics.StageNetworkUpdate, func() error {
+		nc.node = node
+		return nc.drainAndRun(func() error {
+			return nc.updateNetwork(node)
+		})
+	})
+}
 
-	// NOTE: Investigate if we need to introduce a interface wrt to the VM's networking configuration. This will
-	// become more clear with the outcome of https://issues.redhat.com/browse/WINC-343
+// updateNetwork regenerates and redeploys the network configuration of the given node, and records it in
+// NetworkConfigAnnotation
+func (nc *nodeConfig) updateNetwork(node *v1.Node) error {
+	if nc.clusterNetwork.HostSubnet(node) == "" {
+		return errors.Errorf("node %s has no host subnet assigned", node.GetName())
+	}
+	nc.node = node
+	if err := nc.clusterNetwork.VerifyNode(nc.Windows, nc.config); err != nil {
+		log.Info("reconfiguring network backend", "node", nc.node.GetName(), "reason", err.Error())
+		if err := nc.configureNetworkBackend(); err != nil {
+			return err
+		}
+	}
+	if err := nc.configureCNI(); err != nil {
+		return errors.Wrapf(err, "error configuring CNI for %s", nc.node.GetName())
+	}
+	if err := nc.configureKubeProxy(); err != nil {
+		return errors.Wrapf(err, "error configuring kube-proxy for %s", nc.node.GetName())
+	}
+	return recordNetworkConfig(nc.k8sclientset, nc.node.GetName(), nc.clusterNetwork, nc.config)
+}
 
-	// Configure the hybrid overlay in the Windows VM
-	if err := nc.Windows.ConfigureHybridOverlay(nc.node.GetName()); err != nil {
-		return errors.Wrapf(err, "error configuring hybrid overlay for %s", nc.node.GetName())
+// configureNetwork configures k8s networking in the node and records the configuration in NetworkConfigAnnotation
+// we are assuming that the WindowsVM and node objects are valid
+func (nc *nodeConfig) configureNetwork() error {
+	// Wait until the node has been assigned a host subnet. Otherwise the network backend will fail to start
+	if err := nc.waitForNode("host subnet", func(node *v1.Node) bool {
+		return nc.clusterNetwork.HostSubnet(node) != ""
+	}); err != nil {
+		return errors.Wrapf(err, "error waiting for host subnet of %s", nc.node.GetName())
 	}
 
-	// Wait until the node object has the hybrid overlay MAC annotation. This is required for the CNI configuration to
-	// start.
-	if err := nc.waitForNodeAnnotation(HybridOverlayMac); err != nil {
-		return errors.Wrapf(err, "error waiting for %s node annotation for %s", HybridOverlayMac,
-			nc.node.GetName())
+	// Run the network backend setup in the Windows VM
+	if err := nc.configureNetworkBackend(); err != nil {
+		return err
 	}
 
 	// Configure CNI in the Windows VM
@@ -172,9 +277,31 @@ func (nc *nodeConfig) configureNetwork() error {
 		return errors.Wrapf(err, "error configuring CNI for %s", nc.node.GetName())
 	}
 	// Start the kube-proxy service
-	if err := nc.Windows.ConfigureKubeProxy(nc.node.GetName(), nc.node.Annotations[HybridOverlaySubnet]); err != nil {
+	if err := nc.configureKubeProxy(); err != nil {
 		return errors.Wrapf(err, "error starting kube-proxy for %s", nc.node.GetName())
 	}
+	return recordNetworkConfig(nc.k8sclientset, nc.node.GetName(), nc.clusterNetwork, nc.config)
+}
+
+// transferNetworkFiles copies the payload files required by the network backend to the Windows VM
+func (nc *nodeConfig) transferNetworkFiles() error {
+	for src, dest := range nc.clusterNetwork.PayloadFiles(nc.config) {
+		if err := nc.CopyFile(src, dest); err != nil {
+			return errors.Wrapf(err, "error copying %s to %s ", src, dest)
+		}
+	}
+	return nil
+}
+
+// configureNetworkBackend runs the network backend setup in the Windows VM, and waits until the backend reports the
+// node as ready for the CNI configuration
+func (nc *nodeConfig) configureNetworkBackend() error {
+	if err := nc.clusterNetwork.ConfigureNode(nc.Windows, nc.node, nc.config); err != nil {
+		return errors.Wrapf(err, "error configuring network backend for %s", nc.node.GetName())
+	}
+	if err := nc.waitForNode("network backend", nc.clusterNetwork.NodeReady); err != nil {
+		return errors.Wrapf(err, "error waiting for network backend of %s", nc.node.GetName())
+	}
 	return nil
 }
 
@@ -193,63 +320,41 @@ func (nc *nodeConfig) applyWorkerLabel() error {
 	return nil
 }
 
-// setNode identifies the node from the instanceID provided and sets the node object in the nodeconfig.
+// setNode waits for the node of the VM with the instanceID provided to register, and sets the node object in the
+// nodeconfig.
 func (nc *nodeConfig) setNode() error {
-	err := wait.Poll(retry.Interval, retry.Timeout, func() (bool, error) {
-		nodes, err := nc.k8sclientset.CoreV1().Nodes().List(context.TODO(),
-			metav1.ListOptions{LabelSelector: WindowsOSLabel})
-		if err != nil {
-			return false, errors.Wrap(err, "could not get list of nodes")
-		}
-		if len(nodes.Items) == 0 {
-			return false, errors.Errorf("no nodes found")
-		}
-		// get the node with given instance id
-		for _, node := range nodes.Items {
-			if nc.ID() == getInstanceIDfromProviderID(node.Spec.ProviderID) {
-				nc.node = &node
-				return true, nil
-			}
-		}
-		return false, nil
-	})
-	return errors.Wrapf(err, "unable to find node for instanceID %s", nc.ID())
+	node, err := nc.nodes.WaitForInstance(nc.ID(), nc.config.Retry.Timeout.Duration,
+		func(*v1.Node) bool { return true })
+	if err != nil {
+		return errors.Wrapf(err, "unable to find node for instanceID %s", nc.ID())
+	}
+	nc.node = node
+	return nil
 }
 
-// waitForNodeAnnotation checks if the node object has the given annotation and waits for retry.Interval seconds and
-// returns an error if the annotation does not appear in that time frame.
-func (nc *nodeConfig) waitForNodeAnnotation(annotation string) error {
+// waitForNode waits until the given condition, described by description, is met by the node object, re-evaluating it
+// whenever the node changes. It returns an error if the condition is not met within the retry timeout of the operator
+// configuration.
+func (nc *nodeConfig) waitForNode(description string, condition func(*v1.Node) bool) error {
 	nodeName := nc.node.GetName()
-	var found bool
-	err := wait.Poll(retry.Interval, retry.Timeout, func() (bool, error) {
-		node, err := nc.k8sclientset.CoreV1().Nodes().Get(context.TODO(), nodeName, metav1.GetOptions{})
-		if err != nil {
-			return false, errors.Wrapf(err, "error getting node %s", nodeName)
-		}
-		_, found := node.Annotations[annotation]
-		if found {
-			//update node to avoid staleness
-			nc.node = node
-			return true, nil
-		}
-		return false, nil
-	})
-
-	if !found {
-		return errors.Wrapf(err, "timeout waiting for %s node annotation", annotation)
+	node, err := nc.nodes.WaitForNode(nodeName, nc.config.Retry.Timeout.Duration, condition)
+	if err != nil {
+		return errors.Wrapf(err, "timeout waiting for %s of node %s", description, nodeName)
 	}
+	//update node to avoid staleness
+	nc.node = node
 	return nil
 }
 
-// configureCNI populates the CNI config template and sends the config file location
+// configureCNI generates the CNI config of the node with the network backend and sends the config file location
 // for completing CNI configuration in the windows VM
 func (nc *nodeConfig) configureCNI() error {
 	// set the hostSubnet value in the network struct
-	if err := nc.network.setHostSubnet(nc.node.Annotations[HybridOverlaySubnet]); err != nil {
+	if err := nc.network.setHostSubnet(nc.clusterNetwork.HostSubnet(nc.node)); err != nil {
 		return errors.Wrapf(err, "error populating host subnet in node network")
 	}
-	// populate the CNI config file with the host subnet and the service network CIDR
-	configFile, err := nc.network.populateCniConfig(nc.clusterServiceCIDR, wkl.CNIConfigTemplatePath)
+	// populate the CNI config file with the host subnet and the cluster network CIDRs
+	configFile, err := nc.network.populateCniConfig(nc.clusterNetwork, nc.config)
 	if err != nil {
 		return errors.Wrapf(err, "error populating CNI config file %s", configFile)
 	}
@@ -264,9 +369,20 @@ func (nc *nodeConfig) configureCNI() error {
 	return nil
 }
 
-// getInstanceIDfromProviderID gets the instanceID of VM for a given cloud provider ID
+// configureKubeProxy ensures the kube-proxy service runs with the arguments matching the node, the cluster network
+// and the kube-proxy settings
+func (nc *nodeConfig) configureKubeProxy() error {
+	networkArgs, err := nc.clusterNetwork.KubeProxyArgs(nc.Windows, nc.node, nc.config)
+	if err != nil {
+		return errors.Wrap(err, "error getting network specific kube-proxy arguments")
+	}
+	args := operatorconfig.KubeProxyArgs(nc.config.KubeProxy, nc.clusterNetwork.KubeProxyFeatureGates())
+	return nc.Windows.ConfigureKubeProxy(nc.node.GetName(), append(args, networkArgs...))
+}
+
+// InstanceIDFromProviderID gets the instanceID of VM for a given cloud provider ID
 // Ex: aws:///us-east-1e/i-078285fdadccb2eaa. We always want the last entry which is the instanceID
-func getInstanceIDfromProviderID(providerID string) string {
+func InstanceIDFromProviderID(providerID string) string {
 	providerTokens := strings.Split(providerID, "/")
 	return providerTokens[len(providerTokens)-1]
 }
@@ -3,7 +3,9 @@ package nodeconfig
 import (
 	"testing"
 
+	wmcapi "github.com/openshift/windows-machine-config-operator/pkg/apis/wmc/v1alpha1"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // Test_getClusterAddr tests the getClusterAddr function
@@ -24,8 +26,14 @@ func Test_getClusterAddr(t *testing.T) {
 			wantErr: false,
 		},
 		{
-			name:    "Test case with invalid no-api",
+			name:    "Test case with custom internal DNS",
 			args:    args{kubeAPIServerEndpoint: "https://no-api.abc.devcluster.openshift.com:6443"},
+			want:    "no-api.abc.devcluster.openshift.com",
+			wantErr: false,
+		},
+		{
+			name:    "Test case with no hostname",
+			args:    args{kubeAPIServerEndpoint: "https://:6443"},
 			want:    "",
 			wantErr: true,
 		},
@@ -49,3 +57,111 @@ func Test_getClusterAddr(t *testing.T) {
 		})
 	}
 }
+
+// TestIgnitionEndpointFromAPIServer tests that the ignition endpoint is derived from the API server host and pool
+func TestIgnitionEndpointFromAPIServer(t *testing.T) {
+	tests := []struct {
+		name                  string
+		kubeAPIServerEndpoint string
+		machineConfigPool     string
+		want                  string
+		wantErr               bool
+	}{
+		{
+			name:                  "worker pool",
+			kubeAPIServerEndpoint: "https://api-int.abc.devcluster.openshift.com:6443",
+			machineConfigPool:     "worker",
+			want:                  "https://api-int.abc.devcluster.openshift.com:22623/config/worker",
+		},
+		{
+			name:                  "custom pool and custom internal DNS",
+			kubeAPIServerEndpoint: "https://internal.example.com:6443",
+			machineConfigPool:     "windows",
+			want:                  "https://internal.example.com:22623/config/windows",
+		},
+		{
+			name:                  "IPv6 API server",
+			kubeAPIServerEndpoint: "https://[fd00::1]:6443",
+			machineConfigPool:     "worker",
+			want:                  "https://[fd00::1]:22623/config/worker",
+		},
+		{
+			name:                  "invalid API server endpoint",
+			kubeAPIServerEndpoint: "https://:6443",
+			machineConfigPool:     "worker",
+			wantErr:               true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ignitionEndpointFromAPIServer(tt.kubeAPIServerEndpoint, tt.machineConfigPool)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// TestIgnitionEndpointFromOverride tests that user provided ignition endpoints are validated and completed
+func TestIgnitionEndpointFromOverride(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint string
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "endpoint without path",
+			endpoint: "https://mcs.example.com:22623",
+			want:     "https://mcs.example.com:22623/config/windows",
+		},
+		{
+			name:     "endpoint with path",
+			endpoint: "https://mcs.example.com/custom/ignition",
+			want:     "https://mcs.example.com/custom/ignition",
+		},
+		{
+			name:     "endpoint with http scheme",
+			endpoint: "http://mcs.example.com:22623",
+			wantErr:  true,
+		},
+		{
+			name:     "endpoint without hostname",
+			endpoint: "https:///config/worker",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ignitionEndpointFromOverride(tt.endpoint, "windows")
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// TestGetIgnitionEndpoint tests that the ignition endpoint follows the bootstrap settings it is requested with
+func TestGetIgnitionEndpoint(t *testing.T) {
+	defer InvalidateCache()
+
+	endpoint, err := getIgnitionEndpoint(wmcapi.BootstrapSpec{MachineConfigPool: "windows",
+		IgnitionEndpoint: "https://mcs.example.com:22623"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://mcs.example.com:22623/config/windows", endpoint)
+
+	endpoint, err = getIgnitionEndpoint(wmcapi.BootstrapSpec{MachineConfigPool: "worker",
+		IgnitionEndpoint: "https://mcs.example.com:22623"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://mcs.example.com:22623/config/worker", endpoint)
+
+	_, err = getIgnitionEndpoint(wmcapi.BootstrapSpec{MachineConfigPool: "worker",
+		IgnitionEndpoint: "ftp://mcs.example.com"})
+	assert.Error(t, err)
+}
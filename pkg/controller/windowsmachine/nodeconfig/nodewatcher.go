@@ -0,0 +1,210 @@
+package nodeconfig
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	toolscache "k8s.io/client-go/tools/cache"
+)
+
+const (
+	// instanceIDIndex is the name of the index of the Windows nodes on the instance ID of their provider ID
+	instanceIDIndex = "instanceID"
+	// nodeResyncPeriod is the period after which the informer redelivers all the Windows nodes, waking up the callers
+	// waiting for a node even if no change was observed
+	nodeResyncPeriod = 10 * time.Minute
+)
+
+// errNodesNotSynced is returned by the node lookups until the Windows nodes have been listed, as an existing node
+// would look absent
+var errNodesNotSynced = errors.New("Windows nodes have not been listed yet")
+
+// NodeWatcher serves the Windows nodes from a shared informer, indexed by the instance ID of their provider ID. The
+// callers waiting for a node are woken up whenever a Windows node changes, instead of polling the API server. It is
+// safe for concurrent use, and must be started with Start. The nodes are only looked up once the informer has synced.
+type NodeWatcher struct {
+	// informer watches the Windows nodes
+	informer toolscache.SharedIndexInformer
+	// synced is closed once the informer has listed the Windows nodes
+	synced chan struct{}
+	// mutex guards waiters
+	mutex sync.Mutex
+	// waiters are notified of every change of the Windows nodes
+	waiters map[chan struct{}]struct{}
+}
+
+// NewNodeWatcher returns a NodeWatcher watching the Windows nodes with the given clientset
+func NewNodeWatcher(clientset kubernetes.Interface) *NodeWatcher {
+	listWatch := &toolscache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = WindowsOSLabel
+			return clientset.CoreV1().Nodes().List(context.TODO(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = WindowsOSLabel
+			return clientset.CoreV1().Nodes().Watch(context.TODO(), options)
+		},
+	}
+	w := &NodeWatcher{
+		informer: toolscache.NewSharedIndexInformer(listWatch, &v1.Node{}, nodeResyncPeriod,
+			toolscache.Indexers{instanceIDIndex: indexByInstanceID}),
+		synced:  make(chan struct{}),
+		waiters: make(map[chan struct{}]struct{}),
+	}
+	w.informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { w.notify() },
+		UpdateFunc: func(interface{}, interface{}) { w.notify() },
+	})
+	return w
+}
+
+// Start runs the informer until the given channel is closed. It implements the manager.Runnable interface.
+func (w *NodeWatcher) Start(stop <-chan struct{}) error {
+	go func() {
+		if toolscache.WaitForCacheSync(stop, w.informer.HasSynced) {
+			close(w.synced)
+		}
+	}()
+	w.informer.Run(stop)
+	return nil
+}
+
+// hasSynced returns true once the informer has listed the Windows nodes
+func (w *NodeWatcher) hasSynced() bool {
+	select {
+	case <-w.synced:
+		return true
+	default:
+		return false
+	}
+}
+
+// GetByInstance returns a copy of the node of the VM with the given instance ID, or nil if it does not exist. An
+// error is returned until the Windows nodes have been listed.
+func (w *NodeWatcher) GetByInstance(instanceID string) (*v1.Node, error) {
+	node, err := w.getByInstance(instanceID)
+	if err != nil || node == nil {
+		return nil, err
+	}
+	return node.DeepCopy(), nil
+}
+
+// WaitForInstance waits until the node of the VM with the given instance ID exists and meets the given condition, and
+// returns it. An error is returned if it does not within the given timeout.
+func (w *NodeWatcher) WaitForInstance(instanceID string, timeout time.Duration,
+	condition func(*v1.Node) bool) (*v1.Node, error) {
+	return w.waitFor(timeout, condition, func() (*v1.Node, error) {
+		return w.getByInstance(instanceID)
+	})
+}
+
+// getByInstance returns the node of the informer for the VM with the given instance ID, or nil if it does not exist
+func (w *NodeWatcher) getByInstance(instanceID string) (*v1.Node, error) {
+	if !w.hasSynced() {
+		return nil, errNodesNotSynced
+	}
+	nodes, err := w.informer.GetIndexer().ByIndex(instanceIDIndex, instanceID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error looking up node of instance %s", instanceID)
+	}
+	if len(nodes) == 0 {
+		return nil, nil
+	}
+	return nodes[0].(*v1.Node), nil
+}
+
+// WaitForNode waits until the node with the given name meets the given condition, and returns it. An error is
+// returned if it does not within the given timeout.
+func (w *NodeWatcher) WaitForNode(name string, timeout time.Duration, condition func(*v1.Node) bool) (*v1.Node,
+	error) {
+	return w.waitFor(timeout, condition, func() (*v1.Node, error) {
+		if !w.hasSynced() {
+			return nil, errNodesNotSynced
+		}
+		node, exists, err := w.informer.GetIndexer().GetByKey(name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error looking up node %s", name)
+		}
+		if !exists {
+			return nil, nil
+		}
+		return node.(*v1.Node), nil
+	})
+}
+
+// waitFor checks the node returned by get, nil if it does not exist, every time a Windows node changes until it meets
+// the given condition, once the Windows nodes have been listed. A copy of the node is returned, as the nodes of the
+// informer must not be modified.
+func (w *NodeWatcher) waitFor(timeout time.Duration, condition func(*v1.Node) bool,
+	get func() (*v1.Node, error)) (*v1.Node, error) {
+	// Subscribing before the first lookup ensures no change is missed between the lookup and the wait
+	changed := w.subscribe()
+	defer w.unsubscribe(changed)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-w.synced:
+	case <-timer.C:
+		return nil, wait.ErrWaitTimeout
+	}
+	for {
+		node, err := get()
+		if err != nil {
+			return nil, err
+		}
+		if node != nil && condition(node) {
+			return node.DeepCopy(), nil
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return nil, wait.ErrWaitTimeout
+		}
+	}
+}
+
+// subscribe returns a channel receiving a value whenever a Windows node changes. Changes happening while the previous
+// one has not been received yet are coalesced.
+func (w *NodeWatcher) subscribe() chan struct{} {
+	changed := make(chan struct{}, 1)
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.waiters[changed] = struct{}{}
+	return changed
+}
+
+// unsubscribe stops notifying the given channel returned by subscribe
+func (w *NodeWatcher) unsubscribe(changed chan struct{}) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	delete(w.waiters, changed)
+}
+
+// notify wakes up all the waiters
+func (w *NodeWatcher) notify() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for changed := range w.waiters {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// indexByInstanceID returns the instance ID of the given node as its index value
+func indexByInstanceID(obj interface{}) ([]string, error) {
+	node, ok := obj.(*v1.Node)
+	if !ok || node.Spec.ProviderID == "" {
+		return nil, nil
+	}
+	return []string{InstanceIDFromProviderID(node.Spec.ProviderID)}, nil
+}
@@ -0,0 +1,70 @@
+package nodeconfig
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// TestNodeWatcher tests that the callers waiting for a node are woken up by the node changes, and that the waits time
+// out when the node does not meet the condition. The lookups fail until the nodes have been listed.
+func TestNodeWatcher(t *testing.T) {
+	newNode := func(name, instanceID string) *v1.Node {
+		return &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"node.openshift.io/os_id": "Windows"}},
+			Spec:       v1.NodeSpec{ProviderID: "aws:///us-east-1a/" + instanceID},
+		}
+	}
+	client := fake.NewSimpleClientset(newNode("existing", "i-1"))
+	watcher := NewNodeWatcher(client)
+	// An existing node must not look absent before the nodes have been listed
+	_, err := watcher.GetByInstance("i-1")
+	assert.Equal(t, errNodesNotSynced, err)
+	stop := make(chan struct{})
+	defer close(stop)
+	go watcher.Start(stop)
+
+	node, err := watcher.WaitForInstance("i-1", time.Minute, func(*v1.Node) bool { return true })
+	require.NoError(t, err)
+	assert.Equal(t, "existing", node.GetName())
+	node, err = watcher.GetByInstance("i-1")
+	require.NoError(t, err)
+	assert.Equal(t, "existing", node.GetName())
+
+	// The node registers while its instance is waited for
+	registered := make(chan *v1.Node)
+	go func() {
+		node, err := watcher.WaitForInstance("i-2", time.Minute, func(*v1.Node) bool { return true })
+		assert.NoError(t, err)
+		registered <- node
+	}()
+	_, err = client.CoreV1().Nodes().Create(context.TODO(), newNode("new", "i-2"), metav1.CreateOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "new", (<-registered).GetName())
+
+	// The node is annotated while the annotation is waited for
+	hasAnnotation := func(node *v1.Node) bool { return node.Annotations["configured"] == "true" }
+	annotated := make(chan *v1.Node)
+	go func() {
+		node, err := watcher.WaitForNode("new", time.Minute, hasAnnotation)
+		assert.NoError(t, err)
+		annotated <- node
+	}()
+	update := newNode("new", "i-2")
+	update.Annotations = map[string]string{"configured": "true"}
+	_, err = client.CoreV1().Nodes().Update(context.TODO(), update, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	assert.True(t, hasAnnotation(<-annotated))
+
+	_, err = watcher.WaitForNode("existing", 100*time.Millisecond, hasAnnotation)
+	assert.Equal(t, wait.ErrWaitTimeout, err)
+	_, err = watcher.WaitForInstance("i-3", 100*time.Millisecond, func(*v1.Node) bool { return true })
+	assert.Equal(t, wait.ErrWaitTimeout, err)
+}
@@ -0,0 +1,84 @@
+package nodeconfig
+
+import (
+	"context"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/metrics"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	clientretry "k8s.io/client-go/util/retry"
+)
+
+// BootIDAnnotation is the node annotation recording the boot ID of the Windows VM when the node-local setup which
+// does not survive a reboot last ran
+const BootIDAnnotation = "windowsmachineconfig.openshift.io/boot-id"
+
+// Rebooted returns true if the Windows VM of the given node has booted since its node-local setup last ran. Nodes
+// without a recorded boot ID are not considered rebooted, as their setup has not been recorded yet.
+func Rebooted(node *v1.Node) bool {
+	recorded, found := node.GetAnnotations()[BootIDAnnotation]
+	bootID := node.Status.NodeInfo.BootID
+	return found && bootID != "" && recorded != bootID
+}
+
+// RecoverFromReboot runs the network stages of the given node which do not survive a reboot again, and records the
+// boot ID and the address of the node once they have run. The node must have been configured previously.
+func (nc *nodeConfig) RecoverFromReboot(node *v1.Node) error {
+	defer nc.lockInstance()()
+	return metrics.ObserveStage(metrics.StageRebootRecovery, func() error {
+		nc.node = node
+		if err := nc.configureNodeLocalNetwork(); err != nil {
+			return err
+		}
+		// The VM may have come back with another address
+		if err := RecordAddress(nc.k8sclientset, node.GetName(), nc.ipAddress); err != nil {
+			return err
+		}
+		return RecordBootID(nc.k8sclientset, node.GetName(), node.Status.NodeInfo.BootID)
+	})
+}
+
+// configureNodeLocalNetwork runs the network stages which depend on the boot and the address of the Windows VM: the
+// hybrid overlay, which runs from an SSH session, and kube-proxy with its source VIP endpoint, which is not
+// persistent
+func (nc *nodeConfig) configureNodeLocalNetwork() error {
+	if err := nc.configureNetworkBackend(); err != nil {
+		return err
+	}
+	if err := nc.configureKubeProxy(); err != nil {
+		return errors.Wrapf(err, "error starting kube-proxy for %s", nc.node.GetName())
+	}
+	return nil
+}
+
+// RecordBootID sets BootIDAnnotation to the given boot ID on the given node. Nothing is recorded if the boot ID has
+// not been reported by the kubelet yet.
+func RecordBootID(client kubernetes.Interface, nodeName, bootID string) error {
+	if bootID == "" {
+		return nil
+	}
+	return errors.Wrapf(annotateNode(client, nodeName, BootIDAnnotation, bootID),
+		"error recording boot ID on node %s", nodeName)
+}
+
+// annotateNode sets the annotation with the given key to the given value on the given node, unless it already has
+// that value
+func annotateNode(client kubernetes.Interface, nodeName, key, value string) error {
+	return clientretry.RetryOnConflict(clientretry.DefaultRetry, func() error {
+		node, err := client.CoreV1().Nodes().Get(context.TODO(), nodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if current, found := node.Annotations[key]; found && current == value {
+			return nil
+		}
+		if node.Annotations == nil {
+			node.Annotations = make(map[string]string, 1)
+		}
+		node.Annotations[key] = value
+		_, err = client.CoreV1().Nodes().Update(context.TODO(), node, metav1.UpdateOptions{})
+		return err
+	})
+}
@@ -0,0 +1,55 @@
+package nodeconfig
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// TestRebooted tests that a node is only considered rebooted if its boot ID differs from the recorded one
+func TestRebooted(t *testing.T) {
+	var tests = []struct {
+		name        string
+		annotations map[string]string
+		bootID      string
+		expected    bool
+	}{
+		{"not recorded", nil, "a", false},
+		{"same boot", map[string]string{BootIDAnnotation: "a"}, "a", false},
+		{"boot ID not reported", map[string]string{BootIDAnnotation: "a"}, "", false},
+		{"rebooted", map[string]string{BootIDAnnotation: "a"}, "b", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations}}
+			node.Status.NodeInfo.BootID = tt.bootID
+			assert.Equal(t, tt.expected, Rebooted(node))
+		})
+	}
+}
+
+// TestRecordBootID tests that the boot ID is recorded on the node unless it has not been reported
+func TestRecordBootID(t *testing.T) {
+	var tests = []struct {
+		name     string
+		bootID   string
+		expected map[string]string
+	}{
+		{"boot ID reported", "a", map[string]string{BootIDAnnotation: "a"}},
+		{"boot ID not reported", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}})
+			require.NoError(t, RecordBootID(client, "node1", tt.bootID))
+			node, err := client.CoreV1().Nodes().Get(context.TODO(), "node1", metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, node.GetAnnotations())
+		})
+	}
+}
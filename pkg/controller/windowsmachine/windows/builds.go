@@ -0,0 +1,102 @@
+package windows
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/retry"
+	wkl "github.com/openshift/windows-machine-config-operator/pkg/controller/wellknownlocations"
+	"github.com/pkg/errors"
+)
+
+// CompatibilityMatrix lists the Windows builds supported by the kubelet, hybrid overlay and CNI binaries of an
+// operator payload. It is shipped in the payload, so that it always matches the binaries copied to the Windows VMs.
+type CompatibilityMatrix struct {
+	// Builds holds the supported Windows builds
+	Builds []SupportedBuild `json:"builds"`
+}
+
+// SupportedBuild is a Windows build supported by the payload binaries
+type SupportedBuild struct {
+	// Build is the Windows build number, such as 17763
+	Build int `json:"build"`
+	// MinRevision is the earliest update revision of the build supported, such as 1432 for 10.0.17763.1432. Any
+	// revision is supported when unset.
+	MinRevision int `json:"minRevision,omitempty"`
+	// Name is the name of the Windows release of the build, such as Windows Server 2019 (1809)
+	Name string `json:"name"`
+}
+
+func (b SupportedBuild) String() string {
+	if b.MinRevision == 0 {
+		return fmt.Sprintf("10.0.%d (%s)", b.Build, b.Name)
+	}
+	return fmt.Sprintf("10.0.%d.%d or later (%s)", b.Build, b.MinRevision, b.Name)
+}
+
+// ReadCompatibilityMatrix returns the compatibility matrix shipped in the given payload directory
+func ReadCompatibilityMatrix(payloadDirectory string) (*CompatibilityMatrix, error) {
+	path := wkl.PayloadPath(wkl.WindowsBuildsPath, payloadDirectory)
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, retry.NewError(retry.PayloadMissing,
+			errors.Wrapf(err, "error reading Windows compatibility matrix from %s", path))
+	}
+	matrix := &CompatibilityMatrix{}
+	if err := json.Unmarshal(content, matrix); err != nil {
+		return nil, retry.NewError(retry.PayloadMissing,
+			errors.Wrapf(err, "error parsing Windows compatibility matrix from %s", path))
+	}
+	if len(matrix.Builds) == 0 {
+		return nil, retry.NewError(retry.PayloadMissing,
+			errors.Errorf("Windows compatibility matrix %s lists no supported build", path))
+	}
+	return matrix, nil
+}
+
+// Check returns an error with the reason the given Windows build and update revision are not supported, or nil if
+// they are
+func (m *CompatibilityMatrix) Check(build, revision int) error {
+	for _, supported := range m.Builds {
+		if supported.Build != build {
+			continue
+		}
+		if revision < supported.MinRevision {
+			return errors.Errorf("Windows build 10.0.%d.%d is older than the supported %s, install the latest "+
+				"Windows updates", build, revision, supported)
+		}
+		return nil
+	}
+	return errors.Errorf("Windows build 10.0.%d.%d is not supported by the operator payload, use one of %s", build,
+		revision, strings.Join(m.Names(), ", "))
+}
+
+// Names returns the descriptions of the supported builds, such as 10.0.17763 (Windows Server 2019 (1809))
+func (m *CompatibilityMatrix) Names() []string {
+	names := make([]string, 0, len(m.Builds))
+	for _, build := range m.Builds {
+		names = append(names, build.String())
+	}
+	return names
+}
+
+// ParseKernelVersion returns the build and the update revision of the given Windows kernel version reported by the
+// kubelet, such as 10.0.17763.1432
+func ParseKernelVersion(kernelVersion string) (int, int, error) {
+	tokens := strings.Split(strings.TrimSpace(kernelVersion), ".")
+	if len(tokens) != 4 {
+		return 0, 0, errors.Errorf("invalid Windows kernel version %q", kernelVersion)
+	}
+	build, err := strconv.Atoi(tokens[2])
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "invalid build in Windows kernel version %q", kernelVersion)
+	}
+	revision, err := strconv.Atoi(tokens[3])
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "invalid revision in Windows kernel version %q", kernelVersion)
+	}
+	return build, revision, nil
+}
@@ -0,0 +1,69 @@
+package windows
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	wkl "github.com/openshift/windows-machine-config-operator/pkg/controller/wellknownlocations"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCompatibilityMatrixCheck tests that only the builds of the matrix are supported, from their minimum revision
+func TestCompatibilityMatrixCheck(t *testing.T) {
+	matrix := &CompatibilityMatrix{Builds: []SupportedBuild{
+		{Build: 17763, MinRevision: 1000, Name: "Windows Server 2019"},
+		{Build: 19041, Name: "Windows Server, version 2004"}}}
+	var tests = []struct {
+		name        string
+		build       int
+		revision    int
+		expectedErr bool
+	}{
+		{"supported revision", 17763, 1432, false},
+		{"minimum revision", 17763, 1000, false},
+		{"older revision", 17763, 737, true},
+		{"any revision", 19041, 1, false},
+		{"unsupported build", 14393, 3930, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := matrix.Check(tt.build, tt.revision)
+			if tt.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// TestReadCompatibilityMatrix tests that the matrix shipped with the operator is read from the payload directory
+func TestReadCompatibilityMatrix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "payload")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = ReadCompatibilityMatrix(dir + "/")
+	assert.Error(t, err, "missing matrix")
+
+	content, err := ioutil.ReadFile(filepath.Join("..", "..", "..", "internal", "windows-builds.json"))
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(wkl.PayloadPath(wkl.WindowsBuildsPath, dir+"/"), content, 0644))
+	matrix, err := ReadCompatibilityMatrix(dir + "/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.17763 (Windows Server 2019 (1809))"}, matrix.Names())
+}
+
+// TestParseKernelVersion tests that the build and the revision are parsed from the kernel version of a Windows node
+func TestParseKernelVersion(t *testing.T) {
+	build, revision, err := ParseKernelVersion("10.0.17763.1432")
+	require.NoError(t, err)
+	assert.Equal(t, 17763, build)
+	assert.Equal(t, 1432, revision)
+
+	_, _, err = ParseKernelVersion("4.18.0-193.el8.x86_64")
+	assert.Error(t, err)
+}
@@ -5,8 +5,12 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/go-logr/logr"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/retry"
+	"github.com/openshift/windows-machine-config-operator/pkg/metrics"
 	"github.com/pkg/errors"
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
@@ -34,14 +38,17 @@ type sshConnectivity struct {
 	signer ssh.Signer
 	// sshClient is the client used to access the Windows VM via ssh
 	sshClient *ssh.Client
+	// log is the logger of the VM
+	log logr.Logger
 }
 
 // newSshConnectivity returns an instance of sshConnectivity
-func newSshConnectivity(username, ipAddress string, signer ssh.Signer) (connectivity, error) {
+func newSshConnectivity(username, ipAddress string, signer ssh.Signer, log logr.Logger) (connectivity, error) {
 	c := &sshConnectivity{
 		username:  username,
 		ipAddress: ipAddress,
 		signer:    signer,
+		log:       log,
 	}
 	if err := c.init(); err != nil {
 		return nil, errors.Wrap(err, "error instantiating SSH client")
@@ -71,11 +78,17 @@ func (c *sshConnectivity) init() error {
 		if err == nil {
 			break
 		}
-		log.V(1).Info("SSH dial", "IP Address", c.ipAddress, "error", err)
+		metrics.IncSSHDialFailures()
+		c.log.V(1).Info("SSH dial", "IP Address", c.ipAddress, "error", err)
+		// The VM is reachable but rejects the key, which retrying will not change
+		if isAuthenticationError(err) {
+			return retry.NewError(retry.AuthenticationFailed,
+				errors.Wrapf(err, "unable to authenticate against Windows VM %s", c.ipAddress))
+		}
 		time.Sleep(1 * time.Minute)
 	}
 	if err != nil {
-		return errors.Wrapf(err, "unable to connect to Windows VM %s", c.ipAddress)
+		return retry.NewError(retry.Unreachable, errors.Wrapf(err, "unable to connect to Windows VM %s", c.ipAddress))
 	}
 	c.sshClient = sshClient
 	return nil
@@ -89,19 +102,23 @@ func (c *sshConnectivity) run(cmd string) (string, error) {
 
 	session, err := c.sshClient.NewSession()
 	if err != nil {
-		return "", err
+		return "", retry.NewError(retry.Unreachable, err)
 	}
 	defer func() {
 		// io.EOF is returned if you attempt to close a session that is already closed which typically happens given
 		// that Run(), which is called by CombinedOutput(), internally closes the session.
 		if err := session.Close(); err != nil && !errors.Is(err, io.EOF) {
-			log.Error(err, "error closing SSH session")
+			c.log.Error(err, "error closing SSH session")
 		}
 	}()
 
 	out, err := session.CombinedOutput(cmd)
 	if err != nil {
-		return "", err
+		// The command ran and exited with an error, any other error is a connection failure
+		if _, ok := err.(*ssh.ExitError); ok {
+			return "", retry.NewError(retry.RemoteCommandFailed, err)
+		}
+		return "", retry.NewError(retry.Unreachable, err)
 	}
 	return string(out), nil
 }
@@ -114,21 +131,24 @@ func (c *sshConnectivity) transfer(filePath, remoteDir string) error {
 
 	ftp, err := sftp.NewClient(c.sshClient)
 	if err != nil {
-		return err
+		return retry.NewError(retry.Unreachable, err)
 	}
 	defer func() {
 		if err := ftp.Close(); err != nil {
-			log.Error(err, "error closing FTP connection")
+			c.log.Error(err, "error closing FTP connection")
 		}
 	}()
 
 	f, err := os.Open(filePath)
 	if err != nil {
+		if os.IsNotExist(err) {
+			err = retry.NewError(retry.PayloadMissing, err)
+		}
 		return errors.Wrapf(err, "error opening %s file to be transferred", filePath)
 	}
 	defer func() {
 		if err := f.Close(); err != nil {
-			log.Error(err, "error closing local file %s", filePath)
+			c.log.Error(err, "error closing local file %s", filePath)
 		}
 	}()
 
@@ -142,14 +162,20 @@ func (c *sshConnectivity) transfer(filePath, remoteDir string) error {
 		return errors.Wrapf(err, "error initializing %s file on Windows VM", remoteFile)
 	}
 
-	_, err = io.Copy(dstFile, f)
+	written, err := io.Copy(dstFile, f)
+	metrics.AddTransferredBytes(written)
 	if err != nil {
 		return errors.Wrapf(err, "error copying %s to the Windows VM", filePath)
 	}
 
 	// Forcefully close the file so that we can execute it later in the case of binaries
 	if err := dstFile.Close(); err != nil {
-		log.Error(err, "error closing remote file %s", remoteFile)
+		c.log.Error(err, "error closing remote file %s", remoteFile)
 	}
 	return nil
 }
+
+// isAuthenticationError returns true if the given SSH dial error is caused by the VM rejecting the credentials
+func isAuthenticationError(err error) bool {
+	return strings.Contains(err.Error(), "unable to authenticate")
+}
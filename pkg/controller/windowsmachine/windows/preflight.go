@@ -0,0 +1,195 @@
+package windows
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/retry"
+	"github.com/pkg/errors"
+)
+
+const (
+	// minFreeDiskBytes is the free space required on the system drive for the payload and the container images
+	minFreeDiskBytes = 10 << 30
+	// maxClockSkew is the largest difference allowed between the clocks of the VM and the operator, as the
+	// certificates of the node would not be valid yet or anymore otherwise
+	maxClockSkew = time.Minute
+	// kubeletPort is the port the kubelet serves logs and exec requests on, which must be opened in the firewall
+	kubeletPort = 10250
+	// dockerServiceName is the name of the Windows service of the Docker container runtime
+	dockerServiceName = "docker"
+	// timeServiceName is the name of the Windows Time service synchronizing the clock of the VM
+	timeServiceName = "W32Time"
+)
+
+// preflightErrorPrefix starts the message of the PreflightErrors
+const preflightErrorPrefix = "Windows VM failed its preflight checks: "
+
+// PreflightReport holds the state of a Windows VM collected before the VM is configured
+type PreflightReport struct {
+	// Build is the Windows build number, such as 17763
+	Build int `json:"build"`
+	// Revision is the update revision of the Windows build, such as 1432 for 10.0.17763.1432
+	Revision int `json:"revision"`
+	// ContainersFeature is true if the Windows Containers feature is installed
+	ContainersFeature bool `json:"containersFeature"`
+	// FreeDiskBytes is the free space on the system drive C:
+	FreeDiskBytes int64 `json:"freeDiskBytes"`
+	// RuntimeInstalled is true if the Docker service exists
+	RuntimeInstalled bool `json:"runtimeInstalled"`
+	// RuntimeRunning is true if the Docker service is running
+	RuntimeRunning bool `json:"runtimeRunning"`
+	// RuntimeVersion is the version of the Docker engine, empty if it is not running
+	RuntimeVersion string `json:"runtimeVersion"`
+	// TimeServiceRunning is true if the Windows Time service is running
+	TimeServiceRunning bool `json:"timeServiceRunning"`
+	// Time is the time on the VM when the report was collected
+	Time time.Time `json:"-"`
+	// FirewallPorts holds the protocol and the local ports of the enabled inbound firewall rules allowing traffic,
+	// such as TCP/10250, TCP/80,443 or Any/Any
+	FirewallPorts []string `json:"-"`
+}
+
+// preflightOutput is the output of preflightCmd, holding the fields of PreflightReport which are not serialized as is
+type preflightOutput struct {
+	PreflightReport
+	// UnixTime is the time on the VM in seconds since the epoch
+	UnixTime int64 `json:"unixTime"`
+	// FirewallPorts holds the firewall rule ports separated by spaces, as a single element array would be serialized
+	// as a string
+	FirewallPorts string `json:"firewallPorts"`
+}
+
+// PreflightError lists the requirements of a Windows node a Windows VM does not meet
+type PreflightError struct {
+	// Problems holds a user-facing description of each unmet requirement
+	Problems []string
+}
+
+func (e *PreflightError) Error() string {
+	return preflightErrorPrefix + strings.Join(e.Problems, "; ")
+}
+
+// preflight collects the state of the VM and fails with a PreflightFailed error listing the unmet requirements if
+// the VM cannot become a Windows node, including a Windows build which is not supported by the compatibility matrix of
+// the payload
+func (vm *windows) preflight() error {
+	out, err := vm.Run(encodedPowerShellCmd(preflightCmd()), true)
+	if err != nil {
+		return errors.Wrapf(err, "error collecting preflight report with output: %s", out)
+	}
+	report, err := parsePreflightReport(out)
+	if err != nil {
+		return errors.Wrap(err, "error parsing preflight report")
+	}
+	vm.log.V(1).Info("preflight report", "build", report.Build, "revision", report.Revision, "containersFeature",
+		report.ContainersFeature, "freeDiskBytes", report.FreeDiskBytes, "runtimeVersion", report.RuntimeVersion,
+		"time", report.Time)
+	return retry.NewError(retry.PreflightFailed, report.Check(time.Now(), vm.matrix))
+}
+
+// Check returns a PreflightError listing the requirements of a Windows node the VM of the report does not meet, or
+// nil if it meets all of them. The build of the VM is checked against the given compatibility matrix, and its clock
+// is compared with the given time.
+func (r *PreflightReport) Check(now time.Time, matrix *CompatibilityMatrix) error {
+	var problems []string
+	if err := matrix.Check(r.Build, r.Revision); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if !r.ContainersFeature {
+		problems = append(problems, "the Containers Windows feature is not installed, install it with "+
+			"Install-WindowsFeature -Name Containers and restart the VM")
+	}
+	if r.FreeDiskBytes < minFreeDiskBytes {
+		problems = append(problems, fmt.Sprintf("%d MiB free on C:, at least %d MiB are required",
+			r.FreeDiskBytes>>20, minFreeDiskBytes>>20))
+	}
+	switch {
+	case !r.RuntimeInstalled:
+		problems = append(problems, "the Docker container runtime is not installed")
+	case !r.RuntimeRunning:
+		problems = append(problems, fmt.Sprintf("the %s Windows service is not running", dockerServiceName))
+	}
+	if !r.TimeServiceRunning {
+		problems = append(problems, fmt.Sprintf("the %s Windows service synchronizing the clock is not running",
+			timeServiceName))
+	}
+	if skew := r.Time.Sub(now); skew > maxClockSkew || skew < -maxClockSkew {
+		problems = append(problems, fmt.Sprintf("the clock of the VM is %s off the cluster, synchronize it within %s",
+			skew.Round(time.Second), maxClockSkew))
+	}
+	if !portAllowed(r.FirewallPorts, "TCP", kubeletPort) {
+		problems = append(problems, fmt.Sprintf("the kubelet port %d/TCP is not opened in the Windows firewall",
+			kubeletPort))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return &PreflightError{Problems: problems}
+}
+
+// parsePreflightReport parses the output of preflightCmd
+func parsePreflightReport(out string) (*PreflightReport, error) {
+	var output preflightOutput
+	if err := json.Unmarshal([]byte(strings.TrimSpace(out)), &output); err != nil {
+		return nil, errors.Wrapf(err, "invalid preflight report %q", out)
+	}
+	report := output.PreflightReport
+	report.Time = time.Unix(output.UnixTime, 0)
+	report.FirewallPorts = strings.Fields(output.FirewallPorts)
+	return &report, nil
+}
+
+// portAllowed returns true if one of the given firewall rule ports allows the given protocol and port
+func portAllowed(rules []string, protocol string, port int) bool {
+	for _, rule := range rules {
+		tokens := strings.SplitN(rule, "/", 2)
+		if len(tokens) != 2 || !(strings.EqualFold(tokens[0], protocol) || strings.EqualFold(tokens[0], "Any")) {
+			continue
+		}
+		for _, ports := range strings.Split(tokens[1], ",") {
+			if strings.EqualFold(ports, "Any") {
+				return true
+			}
+			bounds := strings.SplitN(ports, "-", 2)
+			low, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				continue
+			}
+			high := low
+			if len(bounds) == 2 {
+				if high, err = strconv.Atoi(bounds[1]); err != nil {
+					continue
+				}
+			}
+			if low <= port && port <= high {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// preflightCmd returns the PowerShell script writing the preflight report of the VM as JSON
+func preflightCmd() string {
+	return "$os = Get-ItemProperty -Path 'HKLM:\\SOFTWARE\\Microsoft\\Windows NT\\CurrentVersion'; " +
+		"$feature = Get-WindowsFeature -Name Containers -ErrorAction SilentlyContinue; " +
+		"$runtime = Get-Service -Name " + dockerServiceName + " -ErrorAction SilentlyContinue; " +
+		"$runtimeVersion = ''; " +
+		"if ($runtime -and $runtime.Status -eq 'Running') { " +
+		"$runtimeVersion = docker version --format '{{.Server.Version}}' 2>$null }; " +
+		"$timeService = Get-Service -Name " + timeServiceName + " -ErrorAction SilentlyContinue; " +
+		"$ports = Get-NetFirewallRule -Enabled True -Direction Inbound -Action Allow | Get-NetFirewallPortFilter | " +
+		"ForEach-Object { \"$($_.Protocol)/$($_.LocalPort -join ',')\" }; " +
+		"[ordered]@{ build = [int]$os.CurrentBuildNumber; revision = [int]$os.UBR; " +
+		"containersFeature = [bool]($feature -and $feature.Installed); " +
+		"freeDiskBytes = [int64](Get-PSDrive -Name C).Free; runtimeInstalled = [bool]$runtime; " +
+		"runtimeRunning = [bool]($runtime -and $runtime.Status -eq 'Running'); " +
+		"runtimeVersion = [string]$runtimeVersion; " +
+		"timeServiceRunning = [bool]($timeService -and $timeService.Status -eq 'Running'); " +
+		"firewallPorts = ($ports -join ' '); unixTime = [DateTimeOffset]::UtcNow.ToUnixTimeSeconds() } | " +
+		"ConvertTo-Json -Compress"
+}
@@ -0,0 +1,90 @@
+package windows
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParsePreflightReport tests that the report written by the preflight script is parsed into its fields
+func TestParsePreflightReport(t *testing.T) {
+	out := `{"build":17763,"revision":1432,"containersFeature":true,"freeDiskBytes":32212254720,` +
+		`"runtimeInstalled":true,` +
+		`"runtimeRunning":true,"runtimeVersion":"19.03.5","timeServiceRunning":true,` +
+		`"firewallPorts":"TCP/10250 UDP/4789 TCP/80,443","unixTime":1600000000}` + "\r\n"
+	report, err := parsePreflightReport(out)
+	require.NoError(t, err)
+	assert.Equal(t, &PreflightReport{Build: 17763, Revision: 1432, ContainersFeature: true, FreeDiskBytes: 32212254720,
+		RuntimeInstalled: true, RuntimeRunning: true, RuntimeVersion: "19.03.5", TimeServiceRunning: true,
+		Time: time.Unix(1600000000, 0), FirewallPorts: []string{"TCP/10250", "UDP/4789", "TCP/80,443"}}, report)
+
+	_, err = parsePreflightReport("Get-WindowsFeature : The term 'Get-WindowsFeature' is not recognized")
+	assert.Error(t, err)
+}
+
+// TestPreflightCheck tests that every unmet requirement is reported
+func TestPreflightCheck(t *testing.T) {
+	now := time.Now()
+	matrix := &CompatibilityMatrix{Builds: []SupportedBuild{{Build: 17763, Name: "Windows Server 2019 (1809)"}}}
+	healthy := func() *PreflightReport {
+		return &PreflightReport{Build: 17763, Revision: 1432, ContainersFeature: true, FreeDiskBytes: 20 << 30,
+			RuntimeInstalled: true, RuntimeRunning: true, RuntimeVersion: "19.03.5", TimeServiceRunning: true,
+			Time: now, FirewallPorts: []string{"TCP/10250"}}
+	}
+
+	var tests = []struct {
+		name             string
+		update           func(*PreflightReport)
+		expectedProblems int
+	}{
+		{"requirements met", func(*PreflightReport) {}, 0},
+		{"unsupported build", func(r *PreflightReport) { r.Build = 14393 }, 1},
+		{"missing Containers feature", func(r *PreflightReport) { r.ContainersFeature = false }, 1},
+		{"low disk space", func(r *PreflightReport) { r.FreeDiskBytes = 1 << 30 }, 1},
+		{"no runtime", func(r *PreflightReport) { r.RuntimeInstalled, r.RuntimeRunning = false, false }, 1},
+		{"stopped runtime", func(r *PreflightReport) { r.RuntimeRunning = false }, 1},
+		{"clock skew", func(r *PreflightReport) { r.Time = now.Add(-5 * time.Minute) }, 1},
+		{"blocked kubelet port", func(r *PreflightReport) { r.FirewallPorts = []string{"TCP/22"} }, 1},
+		{"several problems", func(r *PreflightReport) {
+			r.ContainersFeature, r.TimeServiceRunning = false, false
+		}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := healthy()
+			tt.update(report)
+			err := report.Check(now, matrix)
+			if tt.expectedProblems == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			require.IsType(t, &PreflightError{}, err)
+			assert.Len(t, err.(*PreflightError).Problems, tt.expectedProblems)
+		})
+	}
+}
+
+// TestPortAllowed tests that the protocols, lists and ranges of the firewall rules are matched
+func TestPortAllowed(t *testing.T) {
+	var tests = []struct {
+		name     string
+		rules    []string
+		expected bool
+	}{
+		{"no rules", nil, false},
+		{"port", []string{"TCP/10250"}, true},
+		{"other protocol", []string{"UDP/10250"}, false},
+		{"any protocol", []string{"Any/10250"}, true},
+		{"any port", []string{"TCP/Any"}, true},
+		{"port list", []string{"TCP/80,10250"}, true},
+		{"range", []string{"TCP/10000-11000"}, true},
+		{"other range", []string{"TCP/1-1024"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, portAllowed(tt.rules, "TCP", kubeletPort))
+		})
+	}
+}
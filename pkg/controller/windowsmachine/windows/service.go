@@ -1,5 +1,10 @@
 package windows
 
+import (
+	"strconv"
+	"strings"
+)
+
 // service represents a Windows service
 type service interface {
 	Name() string
@@ -17,16 +22,16 @@ type kubeProxyService struct {
 	args string
 }
 
-// newKubeProxyService returns a service interface with a kubeProxyService implementation
-func newKubeProxyService(nodeName, hostSubnet, sourceVIP string) (service, error) {
+// newKubeProxyService returns a service interface with a kubeProxyService implementation logging to the given
+// directory. The given arguments, built from the kube-proxy settings and the cluster network, are appended to the
+// arguments required to run kube-proxy as a Windows service of the node.
+func newKubeProxyService(nodeName, logDir string, kubeProxyArgs []string) (service, error) {
+	args := []string{"--windows-service", "--proxy-mode=kernelspace", "--hostname-override=" + nodeName,
+		"--kubeconfig=" + KubeconfigPath, "--log-dir=" + logDir, "--logtostderr=false"}
 	return &kubeProxyService{
 		binaryPath: kubeProxyPath,
-		name:       kubeProxyServiceName,
-		args: "--windows-service --v=4 --proxy-mode=kernelspace --feature-gates=WinOverlay=true " +
-			"--hostname-override=" + nodeName + " --kubeconfig=c:\\k\\kubeconfig " +
-			"--cluster-cidr=" + hostSubnet + " --log-dir=" + kubeProxyLogDir + " --logtostderr=false " +
-			"--network-name=OVNKubernetesHybridOverlayNetwork --source-vip=" + sourceVIP +
-			" --enable-dsr=false",
+		name:       KubeProxyServiceName,
+		args:       strings.Join(append(args, kubeProxyArgs...), " "),
 	}, nil
 }
 
@@ -44,3 +49,56 @@ func (s *kubeProxyService) Args() string {
 func (s *kubeProxyService) BinaryPath() string {
 	return s.binaryPath
 }
+
+// windowsExporterService implements the service interface and is specific to the Windows node exporter service
+type windowsExporterService struct {
+	// port is the port the exporter listens on
+	port int32
+}
+
+// newWindowsExporterService returns a service interface with a windowsExporterService implementation listening on the
+// given port
+func newWindowsExporterService(port int32) service {
+	return &windowsExporterService{port: port}
+}
+
+// Name returns the name of the service
+func (s *windowsExporterService) Name() string {
+	return windowsExporterServiceName
+}
+
+// Args returns the arguments that the service will run with
+func (s *windowsExporterService) Args() string {
+	return "--telemetry.addr=:" + strconv.Itoa(int(s.port))
+}
+
+// BinaryPath returns the path of the binary that service the service will run
+func (s *windowsExporterService) BinaryPath() string {
+	return windowsExporterPath
+}
+
+// overrideArgs returns the given service command line with the given "--flag=value" arguments applied. The value of a
+// flag already present is replaced, except for --node-labels whose labels are added to the existing ones. Flags which
+// are not present are appended.
+func overrideArgs(cmd string, args []string) string {
+	tokens := strings.Fields(cmd)
+	for _, arg := range args {
+		flag := strings.SplitN(arg, "=", 2)[0]
+		found := false
+		for i, token := range tokens {
+			if token != flag && !strings.HasPrefix(token, flag+"=") {
+				continue
+			}
+			found = true
+			if flag == "--node-labels" && strings.HasPrefix(token, flag+"=") {
+				tokens[i] = token + "," + strings.TrimPrefix(arg, flag+"=")
+			} else {
+				tokens[i] = arg
+			}
+		}
+		if !found {
+			tokens = append(tokens, arg)
+		}
+	}
+	return strings.Join(tokens, " ")
+}
@@ -0,0 +1,30 @@
+package windows
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestOverrideArgs tests that the overrides replace the flags of a service command line, merging the node labels
+func TestOverrideArgs(t *testing.T) {
+	cmd := "C:\\k\\kubelet.exe --windows-service --v=3 --node-labels=node.openshift.io/os_id=Windows " +
+		"--enforce-node-allocatable=\"\""
+	var tests = []struct {
+		name     string
+		args     []string
+		expected string
+	}{
+		{"no overrides", nil, cmd},
+		{"replaced flag", []string{"--v=5"}, "C:\\k\\kubelet.exe --windows-service --v=5 " +
+			"--node-labels=node.openshift.io/os_id=Windows --enforce-node-allocatable=\"\""},
+		{"merged labels and appended flag", []string{"--node-labels=tier=frontend", "--max-pods=50"},
+			"C:\\k\\kubelet.exe --windows-service --v=3 --node-labels=node.openshift.io/os_id=Windows,tier=frontend " +
+				"--enforce-node-allocatable=\"\" --max-pods=50"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, overrideArgs(cmd, tt.args))
+		})
+	}
+}
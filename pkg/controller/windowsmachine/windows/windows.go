@@ -1,12 +1,16 @@
 package windows
 
 import (
+	"encoding/base64"
+	"encoding/binary"
 	"fmt"
 	"path/filepath"
 	"strings"
-	"time"
+	"unicode/utf16"
 
-	"github.com/openshift/windows-machine-config-operator/pkg/controller/retry"
+	"github.com/go-logr/logr"
+	wmcapi "github.com/openshift/windows-machine-config-operator/pkg/apis/wmc/v1alpha1"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/concurrency"
 	wkl "github.com/openshift/windows-machine-config-operator/pkg/controller/wellknownlocations"
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/ssh"
@@ -14,43 +18,49 @@ import (
 )
 
 const (
-	// remoteDir is the remote temporary directory created on the Windows VM
-	remoteDir = "C:\\Temp\\"
+	// RemoteDir is the default remote directory the payload files are copied to on the Windows VM
+	RemoteDir = "C:\\Temp\\"
 	// winTemp is the default Windows temporary directory
 	winTemp = "C:\\Windows\\Temp\\"
-	// cniDir is the directory for storing CNI files
-	cniDir = "C:\\Temp\\cni\\"
-	// wgetIgnoreCertCmd is the remote location of the wget-ignore-cert.ps1 script
-	wgetIgnoreCertCmd = remoteDir + "wget-ignore-cert.ps1"
-	// hnsPSModule is the remote location of the hns.psm1 module
-	hnsPSModule = remoteDir + "hns.psm1"
-	// k8sDir is the remote kubernetes executable directory
-	k8sDir = "C:\\k\\"
-	// logDir is the remote kubernetes log directory
-	logDir = "C:\\var\\log\\"
-	// kubeProxyLogDir is the remote kube-proxy log directory
-	kubeProxyLogDir = logDir + "kube-proxy\\"
-	// hybridOverlayLogDir is the remote hybrid-overlay log directory
-	hybridOverlayLogDir = logDir + "hybrid-overlay\\"
+	// CNIDir is the default remote directory for storing CNI files
+	CNIDir = RemoteDir + "cni\\"
+	// CNIConfigFileName is the name of the CNI configuration file in the remote CNI directory
+	CNIConfigFileName = "cni.conf"
+	// wgetIgnoreCertScript is the name of the wget-ignore-cert.ps1 script in the remote payload directory
+	wgetIgnoreCertScript = "wget-ignore-cert.ps1"
+	// K8sDir is the remote kubernetes executable directory
+	K8sDir = "C:\\k\\"
+	// LogDir is the default remote kubernetes log directory
+	LogDir = "C:\\var\\log\\"
+	// kubeProxyLogDirName is the name of the kube-proxy directory in the remote log directory
+	kubeProxyLogDirName = "kube-proxy\\"
+	// KubeconfigPath is the remote location of the kubeconfig of the node
+	KubeconfigPath = K8sDir + "kubeconfig"
 	// kubeProxyPath is the location of the kube-proxy exe
-	kubeProxyPath = k8sDir + "kube-proxy.exe"
-	// HybridOverlayProcess is the process name of the hybrid-overlay-node.exe in the Windows VM
-	HybridOverlayProcess = "hybrid-overlay-node"
-	// hybridOverlayConfigurationTime is the approximate time taken for the hybrid-overlay to complete reconfiguring
-	// the Windows VM's network
-	hybridOverlayConfigurationTime = 2 * time.Minute
-	// BaseOVNKubeOverlayNetwork is the name of base OVN HNS Overlay network
-	BaseOVNKubeOverlayNetwork = "BaseOVNKubernetesHybridOverlayNetwork"
-	// OVNKubeOverlayNetwork is the name of the OVN HNS Overlay network
-	OVNKubeOverlayNetwork = "OVNKubernetesHybridOverlayNetwork"
-	// kubeProxyServiceName is the name of the kube-proxy Windows service
-	kubeProxyServiceName = "kube-proxy"
+	kubeProxyPath = K8sDir + "kube-proxy.exe"
+	// KubeProxyServiceName is the name of the kube-proxy Windows service
+	KubeProxyServiceName = "kube-proxy"
+	// KubeletServiceName is the name of the kubelet Windows service created by the bootstrapper
+	KubeletServiceName = "kubelet"
+	// kubeletServiceRegistryKey is the registry key holding the command line of the kubelet Windows service
+	kubeletServiceRegistryKey = "HKLM:\\SYSTEM\\CurrentControlSet\\Services\\" + KubeletServiceName
+	// kubeletBootstrapCmdPath is the remote file holding the kubelet command line generated by the bootstrapper,
+	// which the kubelet overrides are applied to
+	kubeletBootstrapCmdPath = K8sDir + "kubelet-bootstrap-cmd"
+	// windowsExporterServiceName is the name of the Windows node exporter Windows service, which is also the name of
+	// the firewall rule opening its port
+	windowsExporterServiceName = "windows_exporter"
+	// windowsExporterPath is the location of the Windows node exporter exe
+	windowsExporterPath = K8sDir + "windows_exporter.exe"
+	// DefaultSSHUsername is the user the operator connects to the Windows VMs as by default
+	DefaultSSHUsername = "Administrator"
 	// remotePowerShellCmdPrefix holds the PowerShell prefix that needs to be prefixed  for every remote PowerShell
 	// command executed on the remote Windows VM
 	remotePowerShellCmdPrefix = "powershell.exe -NonInteractive -ExecutionPolicy Bypass "
 )
 
-var log = logf.Log.WithName("windows")
+// uploads limits the number of files copied to the Windows VMs at once, across all the VMs
+var uploads = concurrency.NewLimiter()
 
 // Windows contains all the  methods needed to configure a Windows VM to become a worker node
 type Windows interface {
@@ -66,14 +76,33 @@ type Windows interface {
 	Run(string, bool) (string, error)
 	// Reinitialize re-initializes the Windows VM's SSH client
 	Reinitialize() error
-	// Configure prepares the Windows VM for the bootstrapper and then runs it
+	// Configure checks that the Windows VM meets the requirements of a Windows node, prepares it for the bootstrapper
+	// and then runs it. A PreflightError is returned if the VM does not meet the requirements.
 	Configure() error
 	// ConfigureCNI ensures that the CNI configuration in done on the node
 	ConfigureCNI(string) error
-	// ConfigureHybridOverlay ensures that the hybrid overlay is running on the node
-	ConfigureHybridOverlay(string) error
-	// ConfigureKubeProxy ensures that the kube-proxy service is running
-	ConfigureKubeProxy(string, string) error
+	// GetCNIConfig returns the content of the CNI configuration file of the node, or an empty string if it does not
+	// exist
+	GetCNIConfig() (string, error)
+	// ConfigureKubeProxy ensures that the kube-proxy service is running for the given node name with the given
+	// arguments, updating and restarting the service if it already exists
+	ConfigureKubeProxy(string, []string) error
+	// ConfigureKubelet sets the given arguments on the kubelet service created by the bootstrapper, overriding the
+	// values of the flags it already sets. The kubelet is restarted if its command line changed, in which case true
+	// is returned.
+	ConfigureKubelet([]string) (bool, error)
+	// ConfigureWindowsExporter ensures that the Windows node exporter service is running on the given port, and that
+	// the port is opened in the Windows firewall
+	ConfigureWindowsExporter(int32) error
+	// RemoveWindowsExporter removes the Windows node exporter service and its firewall rule
+	RemoveWindowsExporter() error
+	// StoppedServices returns the names of the kubelet and kube-proxy Windows services which are not running,
+	// including the ones which do not exist
+	StoppedServices() ([]string, error)
+	// RestartService restarts the Windows service with the given name, starting it if it is stopped
+	RestartService(string) error
+	// InterfaceAddresses returns the IP addresses of the network interface with the given name
+	InterfaceAddresses(string) ([]string, error)
 }
 
 // windows implements the Windows interface
@@ -87,20 +116,32 @@ type windows struct {
 	workerIgnitionEndpoint string
 	// signer is used for authenticating against the VM
 	signer ssh.Signer
+	// dirs holds the directories used on the VM
+	dirs wmcapi.RemoteDirectoriesSpec
+	// payloadDirectory is the local directory holding the payload files copied to the VM
+	payloadDirectory string
+	// matrix holds the Windows builds supported by the payload, checked before configuring the VM
+	matrix *CompatibilityMatrix
 	// interact is used to connect to and interact with the VM
 	interact connectivity
+	// maxUploads is the number of files copied to the Windows VMs at once, unlimited if 0
+	maxUploads int32
+	// log is the logger of the VM
+	log logr.Logger
 }
 
-// New returns a new Windows instance constructed from the given WindowsVM
-func New(ipAddress, instanceID, workerIgnitionEndpoint string, signer ssh.Signer) (Windows, error) {
+// New returns a new Windows instance constructed from the given WindowsVM, using the SSH user, directories and
+// payload of the given operator configuration. The build of the VM is checked against the given compatibility matrix
+// of the payload.
+func New(ipAddress, instanceID, workerIgnitionEndpoint string, signer ssh.Signer,
+	config *wmcapi.WindowsMachineConfigSpec, matrix *CompatibilityMatrix) (Windows, error) {
 	if workerIgnitionEndpoint == "" {
 		return nil, errors.New("cannot use empty ignition endpoint")
 	}
 
-	// Update the logger name with the VM's cloud ID
-	log = logf.Log.WithName(fmt.Sprintf("VM %s", instanceID))
-	// For now, let's use the `Administrator` user for every node
-	conn, err := newSshConnectivity("Administrator", ipAddress, signer)
+	// The logger name holds the VM's cloud ID
+	log := logf.Log.WithName(fmt.Sprintf("VM %s", instanceID))
+	conn, err := newSshConnectivity(config.SSHUsername, ipAddress, signer, log)
 	if err != nil {
 		return nil, errors.Wrapf(err, "unable to setup VM %s sshConnectivity", instanceID)
 	}
@@ -108,7 +149,12 @@ func New(ipAddress, instanceID, workerIgnitionEndpoint string, signer ssh.Signer
 	return &windows{
 			id:                     instanceID,
 			interact:               conn,
-			workerIgnitionEndpoint: workerIgnitionEndpoint},
+			workerIgnitionEndpoint: workerIgnitionEndpoint,
+			dirs:                   config.RemoteDirectories,
+			payloadDirectory:       config.PayloadDirectory,
+			matrix:                 matrix,
+			maxUploads:             config.Concurrency.MaxUploads,
+			log:                    log},
 		nil
 }
 
@@ -119,6 +165,8 @@ func (vm *windows) ID() string {
 }
 
 func (vm *windows) CopyFile(filePath, remoteDir string) error {
+	uploads.Acquire(int(vm.maxUploads))
+	defer uploads.Release()
 	if err := vm.interact.transfer(filePath, remoteDir); err != nil {
 		return errors.Wrapf(err, "unable to transfer %s to remote dir %s", filePath, remoteDir)
 	}
@@ -145,6 +193,10 @@ func (vm *windows) Reinitialize() error {
 }
 
 func (vm *windows) Configure() error {
+	// The VM is checked first, so that its problems are reported before they fail the bootstrapper
+	if err := vm.preflight(); err != nil {
+		return err
+	}
 	if err := vm.createDirectories(); err != nil {
 		return errors.Wrap(err, "error creating directories on Windows VM")
 	}
@@ -154,99 +206,178 @@ func (vm *windows) Configure() error {
 	return vm.runBootstrapper()
 }
 
-func (vm *windows) ConfigureHybridOverlay(nodeName string) error {
-	// Check if the hybrid-overlay is running
-	_, err := vm.Run("Get-Process -Name \""+HybridOverlayProcess+"\"", true)
-
-	// err being nil implies that hybrid-overlay is running.
-	if err == nil {
-		// Stop the hybrid-overlay
-		stopCmd := "Stop-Process -Name \"" + HybridOverlayProcess + "\""
-		out, err := vm.Run(stopCmd, true)
-		if err != nil {
-			log.Info("unable to stop hybrid-overlay", "stop command", stopCmd, "output", out)
-			return errors.Wrap(err, "unable to stop hybrid-overlay")
-		}
+func (vm *windows) ConfigureCNI(configFile string) error {
+	// copy the CNI config file to the Windows VM
+	if err := vm.CopyFile(configFile, vm.dirs.CNI); err != nil {
+		return errors.Errorf("unable to copy CNI file %s to %s", configFile, vm.dirs.CNI)
 	}
 
-	// Start the hybrid-overlay in the background over ssh.
-	// TODO: This will be removed in https://issues.redhat.com/browse/WINC-353
-	go vm.Run(remoteDir+wkl.HybridOverlayName+" --node "+nodeName+
-		" --k8s-kubeconfig c:\\k\\kubeconfig --logfile="+hybridOverlayLogDir+"hybrid-overlay.log", false)
+	cniConfigDest := vm.dirs.CNI + filepath.Base(configFile)
+	// run the configure-cni command on the Windows VM
+	configureCNICmd := vm.dirs.Payload + "wmcb.exe configure-cni --cni-dir=\"" +
+		vm.dirs.CNI + " --cni-config=\"" + cniConfigDest
 
-	if err = vm.waitForHybridOverlayToRun(); err != nil {
-		return errors.Wrapf(err, "error running %s", wkl.HybridOverlayName)
+	out, err := vm.Run(configureCNICmd, true)
+	if err != nil {
+		vm.log.Info("CNI configuration failed", "command", configureCNICmd, "output", out, "error", err)
+		return errors.Wrap(err, "CNI configuration failed")
 	}
 
-	// Wait for the hybrid-overlay to complete reconfiguring the network. The only way to detect that it has completed
-	// the reconfiguration is to check for the HNS networks but doing that without reinitializing the WinRM client
-	// results in 5+ minutes wait times for the vm.Run() call to complete. So the only alternative is to wait before
-	// proceeding.
-	time.Sleep(hybridOverlayConfigurationTime)
+	return nil
+}
 
-	// Running the hybrid-overlay causes network reconfiguration in the Windows VM which results in the ssh connection
-	// being closed and the client is not smart enough to reconnect. We have observed that the WinRM connection does not
-	// get closed and does not need reinitialization.
-	if err = vm.Reinitialize(); err != nil {
-		return errors.Wrap(err, "error reinitializing VM after running hybrid-overlay")
+func (vm *windows) GetCNIConfig() (string, error) {
+	configPath := vm.dirs.CNI + CNIConfigFileName
+	out, err := vm.Run("\"if (Test-Path "+configPath+") { Get-Content -Raw -Path "+configPath+" }\"", true)
+	if err != nil {
+		return "", errors.Wrapf(err, "error reading CNI configuration %s with output: %s", configPath, out)
 	}
+	return strings.TrimSpace(out), nil
+}
 
-	if err = vm.waitForHNSNetworks(); err != nil {
-		return errors.Wrap(err, "error waiting for OVN HNS networks to be created")
+func (vm *windows) ConfigureKubeProxy(nodeName string, kubeProxyArgs []string) error {
+	kubeProxyService, err := newKubeProxyService(nodeName, vm.dirs.Log+kubeProxyLogDirName, kubeProxyArgs)
+	if err != nil {
+		return errors.Wrap(err, "error creating service object")
+	}
+	exists, err := vm.serviceExists(kubeProxyService)
+	if err != nil {
+		return errors.Wrap(err, "error checking for kube-proxy Windows service")
+	}
+	if exists {
+		// The node has been configured before, update the service in place to pick up the new arguments
+		if err := vm.stopService(kubeProxyService); err != nil {
+			return errors.Wrap(err, "error stopping kube-proxy Windows service")
+		}
+		if err := vm.updateService(kubeProxyService); err != nil {
+			return errors.Wrap(err, "error updating kube-proxy Windows service")
+		}
+	} else if err := vm.createService(kubeProxyService); err != nil {
+		return errors.Wrap(err, "error creating kube-proxy Windows service")
+	}
+	if err := vm.startService(kubeProxyService); err != nil {
+		return errors.Wrap(err, "error starting kube-proxy Windows service")
 	}
-
 	return nil
 }
 
-func (vm *windows) ConfigureCNI(configFile string) error {
-	// copy the CNI config file to the Windows VM
-	if err := vm.CopyFile(configFile, cniDir); err != nil {
-		return errors.Errorf("unable to copy CNI file %s to %s", configFile, cniDir)
+func (vm *windows) ConfigureKubelet(kubeletArgs []string) (bool, error) {
+	bootstrapCmd, err := vm.Run("Get-Content -Raw -Path "+kubeletBootstrapCmdPath, true)
+	if err != nil {
+		return false, errors.Wrap(err, "error getting kubelet bootstrap command line")
+	}
+	currentCmd, err := vm.Run("(Get-ItemProperty -Path "+kubeletServiceRegistryKey+").ImagePath", true)
+	if err != nil {
+		return false, errors.Wrap(err, "error getting kubelet service command line")
+	}
+	cmd := overrideArgs(strings.TrimSpace(bootstrapCmd), kubeletArgs)
+	if cmd == strings.TrimSpace(currentCmd) {
+		return false, nil
 	}
 
-	cniConfigDest := cniDir + filepath.Base(configFile)
-	// run the configure-cni command on the Windows VM
-	configureCNICmd := remoteDir + "wmcb.exe configure-cni --cni-dir=\"" +
-		cniDir + " --cni-config=\"" + cniConfigDest
+	// The command line holds quotes which would not survive the remote shell, so the script is passed encoded
+	setCmd := "Set-ItemProperty -Path " + kubeletServiceRegistryKey + " -Name ImagePath -Value '" +
+		strings.ReplaceAll(cmd, "'", "''") + "'; Restart-Service -Name " + KubeletServiceName + " -Force"
+	if out, err := vm.Run(encodedPowerShellCmd(setCmd), true); err != nil {
+		return false, errors.Wrapf(err, "error updating kubelet service with output: %s", out)
+	}
+	vm.log.V(1).Info("restarted kubelet", "command", cmd)
+	return true, nil
+}
 
-	out, err := vm.Run(configureCNICmd, true)
+func (vm *windows) ConfigureWindowsExporter(port int32) error {
+	exporterService := newWindowsExporterService(port)
+	exists, err := vm.serviceExists(exporterService)
 	if err != nil {
-		log.Info("CNI configuration failed", "command", configureCNICmd, "output", out, "error", err)
-		return errors.Wrap(err, "CNI configuration failed")
+		return errors.Wrap(err, "error checking for Windows node exporter service")
+	}
+	// The binary cannot be replaced while the service is running
+	if exists {
+		if err := vm.stopService(exporterService); err != nil {
+			return errors.Wrap(err, "error stopping Windows node exporter service")
+		}
+	}
+	src := wkl.PayloadPath(wkl.WindowsExporterPath, vm.payloadDirectory)
+	if err := vm.CopyFile(src, K8sDir); err != nil {
+		return errors.Wrapf(err, "error copying %s to %s", src, K8sDir)
+	}
+	if exists {
+		if err := vm.updateService(exporterService); err != nil {
+			return errors.Wrap(err, "error updating Windows node exporter service")
+		}
+	} else if err := vm.createService(exporterService); err != nil {
+		return errors.Wrap(err, "error creating Windows node exporter service")
+	}
+	if err := vm.startService(exporterService); err != nil {
+		return errors.Wrap(err, "error starting Windows node exporter service")
 	}
 
+	// The rule is recreated so that it matches the configured port
+	firewallCmd := fmt.Sprintf("%s; New-NetFirewallRule -Name %s -DisplayName %s -Direction Inbound -Action Allow "+
+		"-Protocol TCP -LocalPort %d", removeFirewallRuleCmd(windowsExporterServiceName), windowsExporterServiceName,
+		windowsExporterServiceName, port)
+	if out, err := vm.Run(encodedPowerShellCmd(firewallCmd), true); err != nil {
+		return errors.Wrapf(err, "error opening Windows node exporter port %d with output: %s", port, out)
+	}
 	return nil
 }
 
-func (vm *windows) ConfigureKubeProxy(nodeName, hostSubnet string) error {
-	sVIP, err := vm.getSourceVIP()
+func (vm *windows) RemoveWindowsExporter() error {
+	exporterService := newWindowsExporterService(0)
+	exists, err := vm.serviceExists(exporterService)
 	if err != nil {
-		return errors.Wrap(err, "error getting source VIP")
+		return errors.Wrap(err, "error checking for Windows node exporter service")
 	}
-	kubeProxyService, err := newKubeProxyService(nodeName, hostSubnet, sVIP)
-	if err != nil {
-		return errors.Wrap(err, "error creating service object")
+	if exists {
+		if err := vm.stopService(exporterService); err != nil {
+			return errors.Wrap(err, "error stopping Windows node exporter service")
+		}
+		if out, err := vm.Run("sc.exe delete "+exporterService.Name(), false); err != nil {
+			return errors.Wrapf(err, "error deleting Windows node exporter service with output: %s", out)
+		}
 	}
-	if err := vm.createService(kubeProxyService); err != nil {
-		return errors.Wrap(err, "error creating kube-proxy Windows service")
+	if out, err := vm.Run(encodedPowerShellCmd(removeFirewallRuleCmd(windowsExporterServiceName)), true); err != nil {
+		return errors.Wrapf(err, "error closing Windows node exporter port with output: %s", out)
 	}
-	if err := vm.startService(kubeProxyService); err != nil {
-		return errors.Wrap(err, "error starting kube-proxy Windows service")
+	return nil
+}
+
+func (vm *windows) StoppedServices() ([]string, error) {
+	out, err := vm.Run(encodedPowerShellCmd(stoppedServicesCmd(KubeletServiceName, KubeProxyServiceName)), true)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting Windows service status with output: %s", out)
+	}
+	return strings.Fields(out), nil
+}
+
+func (vm *windows) RestartService(name string) error {
+	if out, err := vm.Run("Restart-Service -Name "+name+" -Force", true); err != nil {
+		return errors.Wrapf(err, "error restarting Windows service %s with output: %s", name, out)
 	}
+	vm.log.V(1).Info("restarted service", "name", name)
 	return nil
 }
 
+// InterfaceAddresses returns the IP addresses of the network interface with the given name, as listed by
+// interfaceAddressesCmd. An error is returned if the VM has no such interface.
+func (vm *windows) InterfaceAddresses(name string) ([]string, error) {
+	out, err := vm.Run(encodedPowerShellCmd(interfaceAddressesCmd(name)), true)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting addresses of interface %s with output: %s", name, out)
+	}
+	return strings.Fields(out), nil
+}
+
 // Interface helper methods
 
 // createDirectories creates directories required for configuring the Windows node on the VM
 func (vm *windows) createDirectories() error {
 	directoriesToCreate := []string{
-		k8sDir,
-		remoteDir,
-		cniDir,
-		logDir,
-		kubeProxyLogDir,
-		hybridOverlayLogDir,
+		K8sDir,
+		vm.dirs.Payload,
+		vm.dirs.CNI,
+		vm.dirs.Log,
+		vm.dirs.Log + kubeProxyLogDirName,
 	}
 	for _, dir := range directoriesToCreate {
 		if _, err := vm.Run(mkdirCmd(dir), false); err != nil {
@@ -259,18 +390,15 @@ func (vm *windows) createDirectories() error {
 // transferFiles copies various files required for configuring the Windows node, to the VM.
 func (vm *windows) transferFiles() error {
 	srcDestPairs := map[string]string{
-		wkl.IgnoreWgetPowerShellPath: remoteDir,
-		wkl.WmcbPath:                 remoteDir,
-		wkl.HybridOverlayPath:        remoteDir,
-		wkl.HNSPSModule:              remoteDir,
-		wkl.FlannelCNIPluginPath:     cniDir,
-		wkl.WinBridgeCNIPlugin:       cniDir,
-		wkl.HostLocalCNIPlugin:       cniDir,
-		wkl.WinOverlayCNIPlugin:      cniDir,
-		wkl.KubeProxyPath:            k8sDir,
+		wkl.IgnoreWgetPowerShellPath: vm.dirs.Payload,
+		wkl.WmcbPath:                 vm.dirs.Payload,
+		wkl.FlannelCNIPluginPath:     vm.dirs.CNI,
+		wkl.WinBridgeCNIPlugin:       vm.dirs.CNI,
+		wkl.KubeProxyPath:            K8sDir,
 		wkl.KubeletPath:              winTemp,
 	}
-	for src, dest := range srcDestPairs {
+	for payloadPath, dest := range srcDestPairs {
+		src := wkl.PayloadPath(payloadPath, vm.payloadDirectory)
 		if err := vm.CopyFile(src, dest); err != nil {
 			return errors.Wrapf(err, "error copying %s to %s ", src, dest)
 		}
@@ -284,13 +412,20 @@ func (vm *windows) runBootstrapper() error {
 	if err != nil {
 		return errors.Wrap(err, "error initializing bootstrapper files")
 	}
-	wmcbInitializeCmd := remoteDir + "\\wmcb.exe initialize-kubelet --ignition-file " + winTemp +
+	wmcbInitializeCmd := vm.dirs.Payload + "wmcb.exe initialize-kubelet --ignition-file " + winTemp +
 		"worker.ign --kubelet-path " + winTemp + "kubelet.exe"
 	out, err := vm.Run(wmcbInitializeCmd, true)
-	log.V(1).Info("output from wmcb", "output", out)
+	vm.log.V(1).Info("output from wmcb", "output", out)
 	if err != nil {
 		return errors.Wrap(err, "error running bootstrapper")
 	}
+	// Keep the kubelet command line generated by the bootstrapper, so that the kubelet overrides can be changed
+	// without accumulating on top of each other
+	saveCmd := "(Get-ItemProperty -Path " + kubeletServiceRegistryKey + ").ImagePath | Set-Content -Path " +
+		kubeletBootstrapCmdPath
+	if out, err := vm.Run(saveCmd, true); err != nil {
+		return errors.Wrapf(err, "error saving kubelet bootstrap command line with output: %s", out)
+	}
 	return nil
 }
 
@@ -299,10 +434,10 @@ func (vm *windows) initializeBootstrapperFiles() error {
 	// The 0.35.0 maps to ignition spec v2. This should be modified when we switch to v3
 	ignitionUserAgentSpec := "Ignition/0.35.0"
 	// Download the worker ignition to C:\Windows\Temp\ using the script that ignores the server cert
-	ignitionFileDownloadCmd := wgetIgnoreCertCmd + " -server " + vm.workerIgnitionEndpoint + " -output " +
+	ignitionFileDownloadCmd := vm.dirs.Payload + wgetIgnoreCertScript + " -server " + vm.workerIgnitionEndpoint + " -output " +
 		winTemp + "worker.ign" + " -useragent " + ignitionUserAgentSpec
 	out, err := vm.Run(ignitionFileDownloadCmd, true)
-	log.V(1).Info("ignition file download", "cmd", ignitionFileDownloadCmd, "output", out)
+	vm.log.V(1).Info("ignition file download", "cmd", ignitionFileDownloadCmd, "output", out)
 	if err != nil {
 		return errors.Wrap(err, "unable to download worker.ign")
 	}
@@ -319,76 +454,79 @@ func (vm *windows) createService(svc service) error {
 	return nil
 }
 
+// updateService updates the binary path and arguments of a previously created Windows service
+func (vm *windows) updateService(svc service) error {
+	out, err := vm.Run("sc.exe config "+svc.Name()+" binPath=\""+svc.BinaryPath()+" "+
+		svc.Args()+"\" start=auto", false)
+	if err != nil {
+		return errors.Wrapf(err, "failed to update service with output: %s", out)
+	}
+	return nil
+}
+
+// serviceExists returns true if the given service has been created on the Windows VM
+func (vm *windows) serviceExists(svc service) (bool, error) {
+	out, err := vm.Run("\"if (Get-Service -Name "+svc.Name()+" -ErrorAction SilentlyContinue) "+
+		"{ Write-Output true } else { Write-Output false }\"", true)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to query service with output: %s", out)
+	}
+	return strings.TrimSpace(out) == "true", nil
+}
+
 // startService starts a previously created Windows service
 func (vm *windows) startService(svc service) error {
 	out, err := vm.Run("sc.exe start "+svc.Name(), false)
 	if err != nil {
 		return errors.Wrapf(err, "failed to start service with output: %s", out)
 	}
-	log.V(1).Info("started service", "name", svc.Name(), "binary", svc.BinaryPath(), "args", svc.Args())
+	vm.log.V(1).Info("started service", "name", svc.Name(), "binary", svc.BinaryPath(), "args", svc.Args())
 	return nil
 }
 
-// waitForHNSNetworks waits for the OVN overlay HNS networks to be created until the timeout is reached
-func (vm *windows) waitForHNSNetworks() error {
-	var out string
-	var err error
-	for retries := 0; retries < retry.Count; retries++ {
-		out, err = vm.Run("Get-HnsNetwork", true)
-		if err != nil {
-			// retry
-			continue
-		}
-
-		if strings.Contains(out, BaseOVNKubeOverlayNetwork) &&
-			strings.Contains(out, OVNKubeOverlayNetwork) {
-			return nil
-		}
-		time.Sleep(retry.Interval)
+// stopService stops a running Windows service, waiting for it to reach the stopped state
+func (vm *windows) stopService(svc service) error {
+	out, err := vm.Run("Stop-Service -Name "+svc.Name()+" -Force", true)
+	if err != nil {
+		return errors.Wrapf(err, "failed to stop service with output: %s", out)
 	}
-
-	// OVN overlay HNS networks were not found
-	log.Info("Get-HnsNetwork", "output", out)
-	return errors.Wrap(err, "timeout waiting for OVN overlay HNS networks")
+	vm.log.V(1).Info("stopped service", "name", svc.Name())
+	return nil
 }
 
-// waitForHybridOverlayToRun waits for the hybrid-overlay-node.exe to run until the timeout is reached
-func (vm *windows) waitForHybridOverlayToRun() error {
-	var err error
-	for retries := 0; retries < retry.Count; retries++ {
-		_, err = vm.Run("Get-Process -Name \""+HybridOverlayProcess+"\"", true)
-		if err == nil {
-			return nil
-		}
-		time.Sleep(retry.Interval)
-	}
+// Generic helper methods
 
-	// hybrid-overlay never started running
-	return fmt.Errorf("timeout waiting for hybrid-overlay: %v", err)
+// encodedPowerShellCmd returns the arguments running the given PowerShell script without it being interpreted by the
+// remote shell
+func encodedPowerShellCmd(script string) string {
+	utf16Script := utf16.Encode([]rune(script))
+	buf := make([]byte, 2*len(utf16Script))
+	for i, c := range utf16Script {
+		binary.LittleEndian.PutUint16(buf[2*i:], c)
+	}
+	return "-EncodedCommand " + base64.StdEncoding.EncodeToString(buf)
 }
 
-// getSourceVIP returns the source VIP of the VM
-func (vm *windows) getSourceVIP() (string, error) {
-	cmd := "\"Import-Module -DisableNameChecking " + hnsPSModule + "; " +
-		"$net = (Get-HnsNetwork | where { $_.Name -eq 'OVNKubernetesHybridOverlayNetwork' }); " +
-		"$endpoint = New-HnsEndpoint -NetworkId $net.ID -Name VIPEndpoint; " +
-		"Attach-HNSHostEndpoint -EndpointID $endpoint.ID -CompartmentID 1; " +
-		"(Get-NetIPConfiguration -AllCompartments -All -Detailed | " +
-		"where { $_.NetAdapter.LinkLayerAddress -eq $endpoint.MacAddress }).IPV4Address.IPAddress.Trim()\""
-	out, err := vm.Run(cmd, true)
-	if err != nil {
-		return "", errors.Wrapf(err, "failed to get source VIP with output: %s", out)
-	}
+// removeFirewallRuleCmd returns the PowerShell command removing the firewall rule with the given name if it exists
+func removeFirewallRuleCmd(name string) string {
+	return "if (Get-NetFirewallRule -Name " + name + " -ErrorAction SilentlyContinue) { Remove-NetFirewallRule -Name " +
+		name + " }"
+}
 
-	// stdout will have trailing '\r\n', so need to trim it
-	sourceVIP := strings.TrimSpace(out)
-	if sourceVIP == "" {
-		return "", fmt.Errorf("source VIP is empty")
-	}
-	return sourceVIP, nil
+// stoppedServicesCmd returns the PowerShell command writing the name of each of the given Windows services which is
+// not running on a separate line
+func stoppedServicesCmd(names ...string) string {
+	return "foreach ($name in '" + strings.Join(names, "', '") + "') { " +
+		"$svc = Get-Service -Name $name -ErrorAction SilentlyContinue; " +
+		"if (!$svc -or $svc.Status -ne 'Running') { Write-Output $name } }"
 }
 
-// Generic helper methods
+// interfaceAddressesCmd returns the PowerShell script writing the IP addresses of the network interface with the given
+// name, one per line. The script fails if the interface does not exist.
+func interfaceAddressesCmd(name string) string {
+	return "Get-NetIPAddress -InterfaceAlias '" + name + "' -ErrorAction Stop | " +
+		"ForEach-Object { Write-Output $_.IPAddress }"
+}
 
 // mkdirCmd returns the Windows command to create a directory if it does not exists
 func mkdirCmd(dirName string) string {
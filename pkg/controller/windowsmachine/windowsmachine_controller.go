@@ -2,12 +2,23 @@ package windowsmachine
 
 import (
 	"context"
-	"io/ioutil"
+	"fmt"
+	"reflect"
 	"strings"
+	"time"
 
 	mapi "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+	wmcapi "github.com/openshift/windows-machine-config-operator/pkg/apis/wmc/v1alpha1"
+	"github.com/openshift/windows-machine-config-operator/pkg/clusternetwork"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/concurrency"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/machinecontrol"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/operatorconfig"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/retry"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/signer"
 	wkl "github.com/openshift/windows-machine-config-operator/pkg/controller/wellknownlocations"
 	"github.com/openshift/windows-machine-config-operator/pkg/controller/windowsmachine/nodeconfig"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/windowsmachine/windows"
+	"github.com/openshift/windows-machine-config-operator/pkg/metrics"
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/ssh"
 	core "k8s.io/api/core/v1"
@@ -17,6 +28,7 @@ import (
 	kubeTypes "k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
@@ -38,16 +50,18 @@ var log = logf.Log.WithName(ControllerName)
 
 // Add creates a new WindowsMachine Controller and adds it to the Manager. The Manager will set fields on the Controller
 // and start it when the Manager is Started.
-func Add(mgr manager.Manager, clusterServiceCIDR string) error {
-	reconciler, err := newReconciler(mgr, clusterServiceCIDR)
+func Add(mgr manager.Manager, network *clusternetwork.Store, nodes *nodeconfig.NodeWatcher,
+	clusterCache cache.Cache, payload *operatorconfig.Payload) error {
+	reconciler, err := newReconciler(mgr, network, nodes, payload)
 	if err != nil {
 		return errors.Wrapf(err, "could not create %s reconciler", ControllerName)
 	}
-	return add(mgr, reconciler)
+	return add(mgr, reconciler, clusterCache)
 }
 
 // newReconciler returns a new reconcile.Reconciler
-func newReconciler(mgr manager.Manager, clusterServiceCIDR string) (reconcile.Reconciler, error) {
+func newReconciler(mgr manager.Manager, network *clusternetwork.Store, nodes *nodeconfig.NodeWatcher,
+	payload *operatorconfig.Payload) (reconcile.Reconciler, error) {
 	// The default client serves read requests from the cache which
 	// could be stale and result in a get call to return an older version
 	// of the object. Hence we are using a non-default-client referenced
@@ -67,25 +81,32 @@ func newReconciler(mgr manager.Manager, clusterServiceCIDR string) (reconcile.Re
 		return nil, errors.Wrap(err, "error creating kubernetes clientset")
 	}
 
-	signer, err := createSigner()
+	sshSigner, err := signer.Create()
 	if err != nil {
 		return nil, errors.Wrapf(err, "error creating signer using private key: %v", wkl.PrivateKeyPath)
 	}
 
 	return &ReconcileWindowsMachine{client: client,
-			scheme:             mgr.GetScheme(),
-			k8sclientset:       clientset,
-			clusterServiceCIDR: clusterServiceCIDR,
-			signer:             signer,
-			recorder:           mgr.GetEventRecorderFor(ControllerName),
+			scheme:         mgr.GetScheme(),
+			k8sclientset:   clientset,
+			network:        network,
+			nodes:          nodes,
+			payload:        payload,
+			signer:         sshSigner,
+			recorder:       mgr.GetEventRecorderFor(ControllerName),
+			configurations: concurrency.NewLimiter(),
+			failures:       newFailureTracker(),
 		},
 		nil
 }
 
-// add adds a new Controller to mgr with r as the reconcile.Reconciler
-func add(mgr manager.Manager, r reconcile.Reconciler) error {
-	// Create a new controller
-	c, err := controller.New(ControllerName, mgr, controller.Options{Reconciler: r})
+// add adds a new Controller to mgr with r as the reconcile.Reconciler, watching the WindowsMachineConfig through the
+// given cache
+func add(mgr manager.Manager, r reconcile.Reconciler, clusterCache cache.Cache) error {
+	// Create a new controller. Each reconcile configures a Windows VM for many minutes, so several Machines are
+	// reconciled at once, up to the number of configurations allowed by the operator configuration.
+	c, err := controller.New(ControllerName, mgr, controller.Options{Reconciler: r,
+		MaxConcurrentReconciles: operatorconfig.MaxConfigurationsLimit})
 	if err != nil {
 		return errors.Wrapf(err, "could not create %s", ControllerName)
 	}
@@ -118,6 +139,32 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return errors.Wrap(err, "could not create watch on Machine objects")
 	}
 
+	// The running Machines are checked against the address policy whenever it changes, including when the operator
+	// starts, as their addresses may have changed in the meantime
+	if err := c.Watch(source.NewKindWithCache(&wmcapi.WindowsMachineConfig{}, clusterCache),
+		&handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(func(object handler.MapObject) []reconcile.Request {
+				machines, err := machinecontrol.WindowsMachines(mgr.GetClient())
+				if err != nil {
+					log.Error(err, "unable to list the Machines to check against the address policy")
+					return nil
+				}
+				requests := make([]reconcile.Request, 0, len(machines))
+				for _, machine := range machines {
+					requests = append(requests, reconcile.Request{NamespacedName: kubeTypes.NamespacedName{
+						Namespace: machine.GetNamespace(), Name: machine.GetName()}})
+				}
+				return requests
+			}),
+		}, predicate.Funcs{
+			UpdateFunc: func(e event.UpdateEvent) bool {
+				oldConfig, okOld := e.ObjectOld.(*wmcapi.WindowsMachineConfig)
+				newConfig, okNew := e.ObjectNew.(*wmcapi.WindowsMachineConfig)
+				return okOld && okNew && !reflect.DeepEqual(oldConfig.Spec.Address, newConfig.Spec.Address)
+			},
+		}); err != nil {
+		return errors.Wrap(err, "could not create watch on WindowsMachineConfig")
+	}
 	return nil
 }
 
@@ -133,12 +180,20 @@ type ReconcileWindowsMachine struct {
 	scheme *runtime.Scheme
 	// k8sclientset holds the kube client that we can re-use for all kube objects other than custom resources.
 	k8sclientset *kubernetes.Clientset
-	// clusterServiceCIDR holds the cluster network service CIDR
-	clusterServiceCIDR string
+	// network holds the current cluster network configuration
+	network *clusternetwork.Store
+	// nodes serves the Windows nodes from the shared informer
+	nodes *nodeconfig.NodeWatcher
+	// payload holds the contents of the payload directory read when the operator started
+	payload *operatorconfig.Payload
 	// signer is a signer created from the user's private key
 	signer ssh.Signer
 	// recorder to generate events
 	recorder record.EventRecorder
+	// configurations limits the number of Windows VMs configured at once
+	configurations *concurrency.Limiter
+	// failures holds the consecutive configuration failures of the Machines, to retry them with a backoff
+	failures *failureTracker
 }
 
 // Reconcile reads that state of the cluster for a Windows Machine object and makes changes based on the state read
@@ -157,62 +212,209 @@ func (r *ReconcileWindowsMachine) Reconcile(request reconcile.Request) (reconcil
 		if k8sapierrors.IsNotFound(err) {
 			// Request object not found, could have been deleted after reconcile request.
 			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
-			// Return and don't requeue
+			// The failures of a deleted Machine are forgotten, its node state metric is pruned by the
+			// WindowsMachineConfig controller. Return and don't requeue
+			r.failures.reset(request.Name)
 			return reconcile.Result{}, nil
 		}
 		// Error reading the object - requeue the request.
 		return reconcile.Result{}, err
 	}
+
+	// A retry request is handled even if the Machine is paused, as it only releases the Machine from quarantine. The
+	// update of the Machine triggers the next reconcile.
+	if _, requested := machine.GetAnnotations()[machinecontrol.RetryAnnotation]; requested {
+		return reconcile.Result{}, r.releaseQuarantine(machine)
+	}
+	if machinecontrol.IsPaused(machine) {
+		log.V(1).Info("ignoring paused Machine", "name", machine.Name)
+		return reconcile.Result{}, nil
+	}
+	// A quarantined Machine is left alone until someone removes the annotation or requests a retry
+	if reason, quarantined := machine.GetAnnotations()[machinecontrol.QuarantineAnnotation]; quarantined {
+		log.V(1).Info("ignoring quarantined Machine", "name", machine.Name, "reason", reason)
+		return reconcile.Result{}, nil
+	}
+
 	// provisionedPhase is the status of the machine when it is in the `Provisioned` state
 	provisionedPhase := "Provisioned"
-	if machine.Status.Phase == nil || *machine.Status.Phase != provisionedPhase {
-		// Phase can be nil and should be ignored by WMCO
-		// If the Machine is not in provisioned state, WMCO shouldn't care about it
+	// runningPhase is the status of the machine once its node has joined the cluster
+	runningPhase := "Running"
+	// Phase can be nil and should be ignored by WMCO
+	if machine.Status.Phase == nil || !(*machine.Status.Phase == provisionedPhase ||
+		*machine.Status.Phase == runningPhase) {
 		return reconcile.Result{}, nil
 	}
+	// Running Machines are only configured again on request, otherwise their address is checked
+	_, reconfigure := machine.GetAnnotations()[machinecontrol.ReconfigureAnnotation]
+	running := *machine.Status.Phase == runningPhase && !reconfigure
 
-	// Get the IP address associated with the Windows machine.
-	if len(machine.Status.Addresses) == 0 {
-		return reconcile.Result{}, nil
+	config, err := operatorconfig.Get(r.client)
+	if err != nil {
+		return reconcile.Result{}, errors.Wrap(err, "error getting operator configuration")
 	}
-	ipAddress := ""
-	for _, address := range machine.Status.Addresses {
-		if address.Type == core.NodeInternalIP {
-			ipAddress = address.Address
-		}
+	// An invalid configuration has to be fixed before any Machine can be configured, so it does not count as a
+	// failure of the Machine
+	if err := operatorconfig.Validate(config, r.payload); err != nil {
+		log.Error(err, "invalid operator configuration, postponing the configuration", "name", machine.Name,
+			"retryAfter", retry.InvalidConfigurationInterval)
+		return reconcile.Result{RequeueAfter: retry.InvalidConfigurationInterval}, nil
 	}
-	if len(ipAddress) == 0 {
-		return reconcile.Result{}, nil
+	retryInterval := config.Spec.Retry.Interval.Duration
+
+	// Check that the Machine has an IP address matching the address policy. The Machine is checked again later, as
+	// the addresses may not have been reported yet.
+	if len(nodeconfig.CandidateAddresses(machine.Status.Addresses, config.Spec.Address)) == 0 {
+		log.V(1).Info("Machine has no internal IP address matching the address policy yet", "name", machine.Name)
+		return reconcile.Result{RequeueAfter: retryInterval}, nil
 	}
 
 	// Get the instance ID associated with the Windows machine.
-	providerID := *machine.Spec.ProviderID
-	if len(providerID) == 0 {
-		return reconcile.Result{}, nil
+	providerID := ""
+	if machine.Spec.ProviderID != nil {
+		providerID = *machine.Spec.ProviderID
 	}
 	// Ex: aws:///us-east-1e/i-078285fdadccb2eaa. We always want the last entry which is the instanceID
 	providerTokens := strings.Split(providerID, "/")
 	instanceID := providerTokens[len(providerTokens)-1]
 	if len(instanceID) == 0 {
-		return reconcile.Result{}, nil
+		log.V(1).Info("Machine has no provider ID yet", "name", machine.Name)
+		return reconcile.Result{RequeueAfter: retryInterval}, nil
+	}
+	if running {
+		return r.updateAddress(machine, instanceID, config)
+	}
+
+	// The Machine may be requeued by an update before its backoff has elapsed
+	if wait := r.failures.wait(machine.Name, time.Now()); wait > 0 {
+		return reconcile.Result{RequeueAfter: wait}, nil
 	}
 
+	if reconfigure {
+		r.recorder.Eventf(machine, core.EventTypeNormal, "WMCO ReconfigureRequested",
+			"Machine %s is being configured again as requested by the %s annotation", machine.Name,
+			machinecontrol.ReconfigureAnnotation)
+	}
+	// The bootstrap CSRs of the node are only approved while the Machine is marked as being configured
+	if err := r.patchAnnotations(machine, map[string]string{machinecontrol.ConfiguringAnnotation: ""}); err != nil {
+		return reconcile.Result{}, errors.Wrapf(err, "error marking Machine %s as being configured", machine.Name)
+	}
 	// Make the Machine a Windows Worker node
-	if err := r.addWorkerNode(ipAddress, instanceID); err != nil {
-		r.recorder.Eventf(machine, core.EventTypeWarning, "WMCO SetupFailure",
-			"Machine %s failed to be configured", machine.Name)
-		return reconcile.Result{}, err
+	if err := r.addWorkerNode(machine.Status.Addresses, instanceID, config); err != nil {
+		return r.handleFailure(machine, instanceID, err, retryInterval)
 	}
+	r.failures.reset(machine.Name)
+	metrics.SetNodeState(instanceID, metrics.NodeStateConfigured)
 	r.recorder.Eventf(machine, core.EventTypeNormal, "WMCO Setup",
 		"Machine %s Configured Successfully", machine.Name)
+	// The reconfiguration request is removed once fulfilled, so that it is only repeated when set again
+	if err := r.patchAnnotations(machine, nil, machinecontrol.ConfiguringAnnotation,
+		machinecontrol.PreflightFailureAnnotation, machinecontrol.ReconfigureAnnotation); err != nil {
+		return reconcile.Result{}, errors.Wrapf(err, "error acknowledging configuration of Machine %s", machine.Name)
+	}
 
 	return reconcile.Result{}, nil
 }
 
-// addWorkerNode configures the given Windows VM, adding it as a node object to the cluster
-func (r *ReconcileWindowsMachine) addWorkerNode(ipAddress, instanceID string) error {
+// handleFailure records the given configuration failure of the given Machine, and returns when the configuration is
+// retried according to the class of the error. A Machine which keeps failing permanently is quarantined instead.
+func (r *ReconcileWindowsMachine) handleFailure(machine *mapi.Machine, instanceID string, err error,
+	interval time.Duration) (reconcile.Result, error) {
+	class := retry.ClassOf(err)
+	metrics.SetNodeState(instanceID, metrics.NodeStateFailed)
+	r.recorder.Eventf(machine, core.EventTypeWarning, "WMCO SetupFailure",
+		"Machine %s failed to be configured (%s): %v", machine.Name, class, err)
+	// The requirements the VM does not meet are reported on the Machine, as they have to be fixed on the VM
+	if preflightErr, ok := errors.Cause(err).(*windows.PreflightError); ok {
+		if err := r.patchAnnotations(machine, map[string]string{
+			machinecontrol.PreflightFailureAnnotation: strings.Join(preflightErr.Problems, "; ")}); err != nil {
+			log.Error(err, "unable to report the failed preflight checks on the Machine", "name", machine.Name)
+		}
+	}
+
+	// The cluster configuration has to be fixed first, so the Machine is not to blame
+	if class == retry.InvalidConfiguration {
+		log.Error(err, "invalid cluster configuration, postponing the configuration", "name", machine.Name,
+			"retryAfter", retry.InvalidConfigurationInterval)
+		return reconcile.Result{RequeueAfter: retry.InvalidConfigurationInterval}, nil
+	}
+
+	backoff, quarantine := r.failures.record(machine.Name, class, interval, time.Now())
+	if !quarantine {
+		log.Error(err, "configuration failed", "name", machine.Name, "class", class, "retryAfter", backoff)
+		return reconcile.Result{RequeueAfter: backoff}, nil
+	}
+	log.Error(err, "configuration failed permanently, quarantining the Machine", "name", machine.Name, "class",
+		class)
+	if err := r.patchAnnotations(machine, map[string]string{
+		machinecontrol.QuarantineAnnotation: fmt.Sprintf("%s: %v", class, err)},
+		machinecontrol.ConfiguringAnnotation); err != nil {
+		return reconcile.Result{}, errors.Wrapf(err, "error quarantining Machine %s", machine.Name)
+	}
+	r.failures.reset(machine.Name)
+	metrics.SetNodeState(instanceID, metrics.NodeStateQuarantined)
+	r.recorder.Eventf(machine, core.EventTypeWarning, "WMCO Quarantined",
+		"Machine %s is no longer configured after failing permanently, set the %s annotation to retry",
+		machine.Name, machinecontrol.RetryAnnotation)
+	return reconcile.Result{}, nil
+}
+
+// releaseQuarantine acknowledges the retry request of the given Machine, removing it along with the quarantine so
+// that the Machine is configured again
+func (r *ReconcileWindowsMachine) releaseQuarantine(machine *mapi.Machine) error {
+	if err := r.patchAnnotations(machine, nil, machinecontrol.RetryAnnotation,
+		machinecontrol.QuarantineAnnotation); err != nil {
+		return errors.Wrapf(err, "error releasing Machine %s from quarantine", machine.Name)
+	}
+	r.failures.reset(machine.Name)
+	r.recorder.Eventf(machine, core.EventTypeNormal, "WMCO RetryRequested",
+		"Machine %s has been released from quarantine as requested by the %s annotation", machine.Name,
+		machinecontrol.RetryAnnotation)
+	log.Info("released Machine from quarantine", "name", machine.Name)
+	return nil
+}
+
+// patchAnnotations sets the given annotations on the given Machine and removes the annotations with the given keys.
+// The given Machine is updated with the patched Machine. Nothing is patched if the annotations are already in place.
+func (r *ReconcileWindowsMachine) patchAnnotations(machine *mapi.Machine, annotations map[string]string,
+	remove ...string) error {
+	patched := machine.DeepCopy()
+	if patched.Annotations == nil {
+		patched.Annotations = make(map[string]string)
+	}
+	changed := false
+	for key, value := range annotations {
+		if current, found := patched.Annotations[key]; !found || current != value {
+			patched.Annotations[key] = value
+			changed = true
+		}
+	}
+	for _, key := range remove {
+		if _, found := patched.Annotations[key]; found {
+			delete(patched.Annotations, key)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	if err := r.client.Patch(context.TODO(), patched, client.MergeFrom(machine)); err != nil {
+		return err
+	}
+	patched.DeepCopyInto(machine)
+	return nil
+}
+
+// addWorkerNode configures the Windows VM with the given internal addresses with the given operator configuration,
+// adding it as a node object to the cluster
+func (r *ReconcileWindowsMachine) addWorkerNode(addresses []core.NodeAddress, instanceID string,
+	config *wmcapi.WindowsMachineConfig) error {
 	log.V(1).Info("configuring the Windows VM", "ID", instanceID)
-	nc, err := nodeconfig.NewNodeConfig(r.k8sclientset, ipAddress, instanceID, r.clusterServiceCIDR, r.signer)
+	r.configurations.Acquire(int(config.Spec.Concurrency.MaxConfigurations))
+	defer r.configurations.Release()
+
+	nc, err := nodeconfig.NewNodeConfigFromAddresses(r.k8sclientset, r.nodes, addresses, instanceID, r.network.Get(),
+		&config.Spec, r.signer, r.payload.CompatibilityMatrix)
 	if err != nil {
 		return errors.Wrapf(err, "failed to configure Windows VM %s", instanceID)
 	}
@@ -225,6 +427,55 @@ func (r *ReconcileWindowsMachine) addWorkerNode(ipAddress, instanceID string) er
 	return nil
 }
 
+// updateAddress sets up the node-local network of the node of the given running Machine again if the address chosen
+// by the address policy of the given configuration is no longer the address it was set up through. The address
+// chosen for the nodes configured by a previous version of the operator is recorded as is.
+func (r *ReconcileWindowsMachine) updateAddress(machine *mapi.Machine, instanceID string,
+	config *wmcapi.WindowsMachineConfig) (reconcile.Result, error) {
+	node, err := r.nodes.GetByInstance(instanceID)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	// Nodes which have not finished their configuration record their address when they do
+	if node == nil {
+		return reconcile.Result{}, nil
+	}
+	if _, configured := node.GetAnnotations()[nodeconfig.KubeletConfigAnnotation]; !configured {
+		return reconcile.Result{}, nil
+	}
+	if _, recorded := node.GetAnnotations()[nodeconfig.AddressAnnotation]; !recorded {
+		// The interface of the policy can only be matched on the VM, so the address is recorded by the next setup
+		if config.Spec.Address.Interface != "" {
+			return reconcile.Result{}, nil
+		}
+		candidates := nodeconfig.CandidateAddresses(machine.Status.Addresses, config.Spec.Address)
+		return reconcile.Result{}, nodeconfig.RecordAddress(r.k8sclientset, node.GetName(), candidates[0])
+	}
+	if !nodeconfig.AddressChanged(node, machine.Status.Addresses, config.Spec.Address) {
+		return reconcile.Result{}, nil
+	}
+
+	r.recorder.Eventf(machine, core.EventTypeNormal, "WMCO AddressChanged",
+		"Address of Machine %s changed from %s, setting up the network of node %s again", machine.Name,
+		node.GetAnnotations()[nodeconfig.AddressAnnotation], node.GetName())
+	nc, err := nodeconfig.NewNodeConfigFromAddresses(r.k8sclientset, r.nodes, machine.Status.Addresses, instanceID,
+		r.network.Get(), &config.Spec, r.signer, r.payload.CompatibilityMatrix)
+	if err == nil {
+		err = nc.UpdateAddress(node)
+	}
+	if err != nil {
+		metrics.SetNodeState(instanceID, metrics.NodeStateFailed)
+		r.recorder.Eventf(machine, core.EventTypeWarning, "WMCO AddressUpdateFailure",
+			"Network of node %s failed to be set up for the new address of Machine %s: %v", node.GetName(),
+			machine.Name, err)
+		// The update is retried with backoff
+		return reconcile.Result{}, errors.Wrapf(err, "error updating address of node %s", node.GetName())
+	}
+	metrics.SetNodeState(instanceID, metrics.NodeStateConfigured)
+	log.Info("node network set up for the new Machine address", "name", machine.Name, "node", node.GetName())
+	return reconcile.Result{}, nil
+}
+
 // createUserDataSecret creates a secret 'windows-user-data' in 'openshift-machine-api'
 // namespace. This secret will be used to inject cloud provider user data for creating
 // windows machines
@@ -279,25 +530,12 @@ func (r *ReconcileWindowsMachine) createUserDataSecret() error {
 		if k8sapierrors.IsNotFound(err) {
 			log.Info("Creating a new Secret", "Secret.Namespace", userDataSecret.Namespace, "Secret.Name", userDataSecret.Name)
 			err = r.client.Create(context.TODO(), userDataSecret)
-			if err != nil {
-				return errors.Wrap(err, "error creating windows user data secret")
+			// The secret may have been created by a concurrent reconcile
+			if err == nil || k8sapierrors.IsAlreadyExists(err) {
+				return nil
 			}
 		}
 		return errors.Wrap(err, "error creating windows user data secret")
 	}
 	return nil
 }
-
-// createSigner creates a signer using the private key from the privateKeyPath
-func createSigner() (ssh.Signer, error) {
-	privateKeyBytes, err := ioutil.ReadFile(wkl.PrivateKeyPath)
-	if err != nil {
-		return nil, errors.Wrapf(err, "failed to find private key from path: %v", wkl.PrivateKeyPath)
-	}
-
-	signer, err := ssh.ParsePrivateKey(privateKeyBytes)
-	if err != nil {
-		return nil, errors.Wrapf(err, "unable to parse private key: %v", wkl.PrivateKeyPath)
-	}
-	return signer, nil
-}
@@ -0,0 +1,265 @@
+package windowsmachineconfig
+
+import (
+	"context"
+	"reflect"
+	"sort"
+
+	configclient "github.com/openshift/client-go/config/clientset/versioned"
+	mapi "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+	wmcapi "github.com/openshift/windows-machine-config-operator/pkg/apis/wmc/v1alpha1"
+	"github.com/openshift/windows-machine-config-operator/pkg/clusternetwork"
+	"github.com/openshift/windows-machine-config-operator/pkg/clusteroperator"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/machinecontrol"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/operatorconfig"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/windowsmachine/nodeconfig"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/windowsmachine/windows"
+	"github.com/openshift/windows-machine-config-operator/pkg/metrics"
+	"github.com/operator-framework/operator-sdk/pkg/k8sutil"
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	kubeTypes "k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	// ControllerName is the name of the WindowsMachineConfig status controller
+	ControllerName = "windowsmachineconfig-controller"
+	// windowsMachineLabel is the label identifying the OS of the Machines
+	windowsMachineLabel = "machine.openshift.io/os-id"
+)
+
+var log = logf.Log.WithName(ControllerName)
+
+// Add creates a new WindowsMachineConfig status Controller and adds it to the Manager. The Controller watches the
+// WindowsMachineConfig, the Windows nodes and the Windows Machines, and reports the validity of the configuration and
+// the state of the nodes and Machines in the WindowsMachineConfig status and in the operator ClusterOperator.
+func Add(mgr manager.Manager, _ *clusternetwork.Store, _ *nodeconfig.NodeWatcher,
+	clusterCache cache.Cache, payload *operatorconfig.Payload) error {
+	windowsNodeSelector, err := labels.Parse(nodeconfig.WindowsOSLabel)
+	if err != nil {
+		return errors.Wrapf(err, "could not parse Windows node label %s", nodeconfig.WindowsOSLabel)
+	}
+
+	namespace, err := k8sutil.GetWatchNamespace()
+	if err != nil {
+		return errors.Wrap(err, "could not get watch namespace")
+	}
+	oclient, err := configclient.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return errors.Wrap(err, "could not create config clientset")
+	}
+
+	r := &ReconcileWindowsMachineConfig{
+		client:              mgr.GetClient(),
+		reader:              clusterCache,
+		windowsNodeSelector: windowsNodeSelector,
+		reporter:            clusteroperator.NewReporter(oclient.ConfigV1(), namespace),
+		recorder:            mgr.GetEventRecorderFor(ControllerName),
+		payload:             payload,
+	}
+	return add(mgr, r, clusterCache)
+}
+
+// add adds a new Controller to mgr with r as the reconcile.Reconciler, watching the objects of the given cache
+func add(mgr manager.Manager, r *ReconcileWindowsMachineConfig, clusterCache cache.Cache) error {
+	c, err := controller.New(ControllerName, mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return errors.Wrapf(err, "could not create %s", ControllerName)
+	}
+	if err := c.Watch(source.NewKindWithCache(&wmcapi.WindowsMachineConfig{}, clusterCache),
+		&handler.EnqueueRequestForObject{}); err != nil {
+		return errors.Wrap(err, "could not create watch on WindowsMachineConfig")
+	}
+	// Changes to the Windows nodes are reported in the status of the WindowsMachineConfig singleton
+	if err := c.Watch(source.NewKindWithCache(&core.Node{}, clusterCache), &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(object handler.MapObject) []reconcile.Request {
+			if !r.windowsNodeSelector.Matches(labels.Set(object.Meta.GetLabels())) {
+				return nil
+			}
+			return []reconcile.Request{{NamespacedName: kubeTypes.NamespacedName{
+				Name: wmcapi.WindowsMachineConfigName}}}
+		}),
+	}); err != nil {
+		return errors.Wrap(err, "could not create watch on Nodes")
+	}
+	// The paused and quarantined Windows Machines are reported in the status as well
+	if err := c.Watch(source.NewKindWithCache(&mapi.Machine{}, clusterCache), &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(object handler.MapObject) []reconcile.Request {
+			if object.Meta.GetLabels()[windowsMachineLabel] != "Windows" {
+				return nil
+			}
+			return []reconcile.Request{{NamespacedName: kubeTypes.NamespacedName{
+				Name: wmcapi.WindowsMachineConfigName}}}
+		}),
+	}); err != nil {
+		return errors.Wrap(err, "could not create watch on Machines")
+	}
+	return nil
+}
+
+// blank assignment to verify that ReconcileWindowsMachineConfig implements reconcile.Reconciler
+var _ reconcile.Reconciler = &ReconcileWindowsMachineConfig{}
+
+// ReconcileWindowsMachineConfig reconciles the status of the WindowsMachineConfig
+type ReconcileWindowsMachineConfig struct {
+	// client writes the WindowsMachineConfig status
+	client client.Client
+	// reader reads the WindowsMachineConfig and the nodes from the cluster scoped cache
+	reader client.Reader
+	// windowsNodeSelector selects the Windows nodes
+	windowsNodeSelector labels.Selector
+	// reporter reports the status through the operator ClusterOperator
+	reporter *clusteroperator.Reporter
+	// recorder to generate events
+	recorder record.EventRecorder
+	// payload holds the contents of the payload directory read when the operator started
+	payload *operatorconfig.Payload
+}
+
+// Reconcile updates the status of the WindowsMachineConfig singleton with the errors found in its spec and the number
+// of Windows nodes which are configured, and reports it through the operator ClusterOperator. The status of the
+// default configuration is reported through the ClusterOperator until the singleton is created.
+func (r *ReconcileWindowsMachineConfig) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	if request.Name != wmcapi.WindowsMachineConfigName {
+		return reconcile.Result{}, nil
+	}
+	config, err := operatorconfig.Get(r.reader)
+	if err != nil {
+		if reportErr := r.reporter.ReportFailure(clusteroperator.ReasonOperatorConfigurationUnavailable,
+			err); reportErr != nil {
+			log.Error(reportErr, "failed to report the operator status")
+		}
+		return reconcile.Result{}, errors.Wrap(err, "error getting operator configuration")
+	}
+
+	nodes := &core.NodeList{}
+	if err := r.reader.List(context.TODO(), nodes,
+		client.MatchingLabelsSelector{Selector: r.windowsNodeSelector}); err != nil {
+		return reconcile.Result{}, errors.Wrap(err, "error listing Windows nodes")
+	}
+
+	machines, err := machinecontrol.WindowsMachines(r.reader)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	// Forget the metrics of the Windows nodes which have been removed from the cluster, and of the failed VMs whose
+	// Machine has been deleted
+	nodeInstanceIDs := make(map[string]bool, len(nodes.Items))
+	for _, node := range nodes.Items {
+		nodeInstanceIDs[nodeconfig.InstanceIDFromProviderID(node.Spec.ProviderID)] = true
+	}
+	machineInstanceIDs := make(map[string]bool, len(machines))
+	for _, machine := range machines {
+		if machine.Spec.ProviderID != nil {
+			machineInstanceIDs[nodeconfig.InstanceIDFromProviderID(*machine.Spec.ProviderID)] = true
+		}
+	}
+	metrics.PruneNodeStates(nodeInstanceIDs, machineInstanceIDs)
+
+	status := computeStatus(config, operatorconfig.Validate(config, r.payload), nodes.Items, machines,
+		r.payload.CompatibilityMatrix)
+	if err := r.reporter.Report(clusteroperator.StatusConditions(&status), r.payload.Versions); err != nil {
+		return reconcile.Result{}, errors.Wrap(err, "error reporting operator status")
+	}
+	if config.GetResourceVersion() == "" {
+		// The default configuration returned for a missing singleton has no status to update
+		return reconcile.Result{}, nil
+	}
+	if reflect.DeepEqual(config.Status, status) {
+		return reconcile.Result{}, nil
+	}
+	previous := config.Status
+	config.Status = status
+	if err := r.client.Status().Update(context.TODO(), config); err != nil {
+		return reconcile.Result{}, errors.Wrap(err, "error updating WindowsMachineConfig status")
+	}
+	r.recordPauses(previous.PausedMachines, status.PausedMachines, machines)
+	log.V(1).Info("updated status", "windowsNodes", status.WindowsNodes, "configuredNodes", status.ConfiguredNodes,
+		"validationErrors", len(status.ValidationErrors), "unsupportedNodes", len(status.UnsupportedNodes))
+	return reconcile.Result{}, nil
+}
+
+// computeStatus returns the status of the given WindowsMachineConfig, whose validation returned validationErr, with
+// the given Windows nodes and Machines. A node is configured once its kubelet settings have been applied, which is the
+// last step of the node configuration. The nodes are checked against the given compatibility matrix, unless it is nil.
+func computeStatus(config *wmcapi.WindowsMachineConfig, validationErr error, nodes []core.Node,
+	machines []mapi.Machine, matrix *windows.CompatibilityMatrix) wmcapi.WindowsMachineConfigStatus {
+	status := wmcapi.WindowsMachineConfigStatus{
+		ObservedGeneration: config.GetGeneration(),
+		WindowsNodes:       int32(len(nodes)),
+	}
+	if validationErr != nil {
+		if aggregate, ok := validationErr.(utilerrors.Aggregate); ok {
+			for _, err := range aggregate.Errors() {
+				status.ValidationErrors = append(status.ValidationErrors, err.Error())
+			}
+		} else {
+			status.ValidationErrors = []string{validationErr.Error()}
+		}
+	}
+	for _, node := range nodes {
+		if _, found := node.GetAnnotations()[nodeconfig.KubeletConfigAnnotation]; found {
+			status.ConfiguredNodes++
+		}
+		if matrix != nil && !buildSupported(matrix, node.Status.NodeInfo.KernelVersion) {
+			status.UnsupportedNodes = append(status.UnsupportedNodes, node.GetName())
+		}
+	}
+	if matrix != nil {
+		status.SupportedWindowsBuilds = matrix.Names()
+	}
+	for i := range machines {
+		if machinecontrol.IsPaused(&machines[i]) {
+			status.PausedMachines = append(status.PausedMachines, machines[i].GetName())
+		}
+		if machinecontrol.IsQuarantined(&machines[i]) {
+			status.QuarantinedMachines = append(status.QuarantinedMachines, machines[i].GetName())
+		}
+	}
+	// The Machines are listed in a stable order, so that the status is not updated needlessly
+	sort.Strings(status.PausedMachines)
+	sort.Strings(status.QuarantinedMachines)
+	sort.Strings(status.UnsupportedNodes)
+	return status
+}
+
+// buildSupported returns false if the given kernel version reported by a Windows node is a build which is not
+// supported by the given compatibility matrix. The nodes which have not reported their kernel version yet are not
+// reported as unsupported.
+func buildSupported(matrix *windows.CompatibilityMatrix, kernelVersion string) bool {
+	build, revision, err := windows.ParseKernelVersion(kernelVersion)
+	if err != nil {
+		return true
+	}
+	return matrix.Check(build, revision) == nil
+}
+
+// recordPauses emits an event on each of the given Machines which has been paused or resumed since the previous
+// status, acknowledging the request
+func (r *ReconcileWindowsMachineConfig) recordPauses(previous, current []string, machines []mapi.Machine) {
+	wasPaused, isPaused := sets.NewString(previous...), sets.NewString(current...)
+	for i := range machines {
+		machine := &machines[i]
+		switch name := machine.GetName(); {
+		case isPaused.Has(name) && !wasPaused.Has(name):
+			r.recorder.Eventf(machine, core.EventTypeNormal, "WMCO Paused",
+				"Operator actions on Machine %s are paused", name)
+		case wasPaused.Has(name) && !isPaused.Has(name):
+			r.recorder.Eventf(machine, core.EventTypeNormal, "WMCO Resumed",
+				"Operator actions on Machine %s are resumed", name)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package windowsmachineconfig
+
+import (
+	"testing"
+
+	mapi "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+	wmcapi "github.com/openshift/windows-machine-config-operator/pkg/apis/wmc/v1alpha1"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/machinecontrol"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/windowsmachine/nodeconfig"
+	"github.com/openshift/windows-machine-config-operator/pkg/controller/windowsmachine/windows"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// TestComputeStatus tests that the status reports every validation error, counts the configured Windows nodes and lists
+// the paused and quarantined Machines, and the nodes running a Windows build the payload does not support
+func TestComputeStatus(t *testing.T) {
+	config := &wmcapi.WindowsMachineConfig{ObjectMeta: metav1.ObjectMeta{Generation: 3}}
+	nodes := []core.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "configured",
+			Annotations: map[string]string{nodeconfig.KubeletConfigAnnotation: "{}"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "bootstrapping"}},
+	}
+	machines := []mapi.Machine{
+		{ObjectMeta: metav1.ObjectMeta{Name: "windows-c", Annotations: map[string]string{
+			machinecontrol.PausedAnnotation: ""}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "windows-b"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "windows-a", Annotations: map[string]string{
+			machinecontrol.PausedAnnotation: "", machinecontrol.QuarantineAnnotation: "AuthenticationFailed"}}},
+	}
+	matrix := &windows.CompatibilityMatrix{Builds: []windows.SupportedBuild{
+		{Build: 17763, MinRevision: 1000, Name: "Windows Server 2019 (1809)"}}}
+	builds := []core.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "unknown-build"},
+			Status: core.NodeStatus{NodeInfo: core.NodeSystemInfo{KernelVersion: "10.0.19041.450"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "supported"},
+			Status: core.NodeStatus{NodeInfo: core.NodeSystemInfo{KernelVersion: "10.0.17763.1432"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "old-revision"},
+			Status: core.NodeStatus{NodeInfo: core.NodeSystemInfo{KernelVersion: "10.0.17763.737"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "joining"}},
+	}
+	var tests = []struct {
+		name          string
+		validationErr error
+		nodes         []core.Node
+		machines      []mapi.Machine
+		matrix        *windows.CompatibilityMatrix
+		expected      wmcapi.WindowsMachineConfigStatus
+	}{
+		{"no nodes", nil, nil, nil, nil, wmcapi.WindowsMachineConfigStatus{ObservedGeneration: 3}},
+		{"configured and bootstrapping nodes", nil, nodes, nil, nil,
+			wmcapi.WindowsMachineConfigStatus{ObservedGeneration: 3, WindowsNodes: 2, ConfiguredNodes: 1}},
+		{"aggregated validation errors", utilerrors.NewAggregate([]error{errors.New("invalid retry count 0"),
+			errors.New("invalid sshUsername")}), nodes, nil, nil,
+			wmcapi.WindowsMachineConfigStatus{ObservedGeneration: 3,
+				ValidationErrors: []string{"invalid retry count 0", "invalid sshUsername"}, WindowsNodes: 2,
+				ConfiguredNodes: 1}},
+		{"single validation error", errors.New("error getting payload kube-proxy version"), nil, nil, nil,
+			wmcapi.WindowsMachineConfigStatus{ObservedGeneration: 3,
+				ValidationErrors: []string{"error getting payload kube-proxy version"}}},
+		{"paused and quarantined machines", nil, nil, machines, nil, wmcapi.WindowsMachineConfigStatus{
+			ObservedGeneration: 3, PausedMachines: []string{"windows-a", "windows-c"},
+			QuarantinedMachines: []string{"windows-a"}}},
+		{"unsupported builds", nil, builds, nil, matrix, wmcapi.WindowsMachineConfigStatus{ObservedGeneration: 3,
+			WindowsNodes: 4, SupportedWindowsBuilds: []string{"10.0.17763.1000 or later (Windows Server 2019 (1809))"},
+			UnsupportedNodes: []string{"old-revision", "unknown-build"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, computeStatus(config, tt.validationErr, tt.nodes, tt.machines,
+				tt.matrix))
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	oconfig "github.com/openshift/api/config/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// namespace is the prefix of the names of the operator metrics
+const namespace = "wmco"
+
+// Stages of the Windows node configuration, used as the stage label of the configuration metrics
+const (
+	// StageBootstrap is the stage transferring the payload to the Windows VM and running the bootstrapper
+	StageBootstrap = "bootstrap"
+	// StageNetworkFiles is the stage transferring the network backend files to the Windows VM
+	StageNetworkFiles = "network_files"
+	// StageNodeRegistration is the stage waiting for the node object of the Windows VM and labelling it
+	StageNodeRegistration = "node_registration"
+	// StageNetwork is the stage configuring the network backend, CNI and kube-proxy of the node
+	StageNetwork = "network"
+	// StageKubelet is the stage applying the kubelet settings to the node
+	StageKubelet = "kubelet"
+	// StageNetworkUpdate is the stage reconfiguring the network of a configured node
+	StageNetworkUpdate = "network_update"
+	// StageKubeletUpdate is the stage draining a configured node and applying new kubelet settings to it
+	StageKubeletUpdate = "kubelet_update"
+	// StageMonitoring is the stage deploying or removing the Windows node exporter on a configured node
+	StageMonitoring = "monitoring"
+	// StageRemediation is the stage repairing a component of a configured node found unhealthy by a health check
+	StageRemediation = "remediation"
+	// StageRebootRecovery is the stage running the node-local setup of a configured node again after its VM rebooted
+	StageRebootRecovery = "reboot_recovery"
+	// StageAddressUpdate is the stage running the node-local setup of a configured node again after the address of
+	// its VM changed
+	StageAddressUpdate = "address_update"
+)
+
+// States of the Windows nodes, used as the state label of the Windows nodes metric
+const (
+	// NodeStateConfigured is the state of the nodes which have been fully configured
+	NodeStateConfigured = "configured"
+	// NodeStateFailed is the state of the nodes whose last configuration or update failed
+	NodeStateFailed = "failed"
+	// NodeStateUpgrading is the state of the nodes being updated with new kubelet settings
+	NodeStateUpgrading = "upgrading"
+	// NodeStateQuarantined is the state of the nodes whose configuration kept failing permanently, and is no longer
+	// retried until the Machine is released from quarantine
+	NodeStateQuarantined = "quarantined"
+)
+
+var (
+	configurationAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "node_configuration_attempts_total",
+		Help:      "Number of times a Windows node configuration stage has been run",
+	}, []string{"stage"})
+	configurationFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "node_configuration_failures_total",
+		Help:      "Number of times a Windows node configuration stage has failed",
+	}, []string{"stage"})
+	configurationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "node_configuration_duration_seconds",
+		Help:      "Duration of the Windows node configuration stages, whether they succeeded or not",
+		// The stages range from a few seconds to the retry timeout of the operator configuration
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	}, []string{"stage"})
+	transferredBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "file_transfer_bytes_total",
+		Help:      "Number of bytes copied to the Windows VMs",
+	})
+	sshDialFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ssh_dial_failures_total",
+		Help:      "Number of failed attempts to open an SSH connection to a Windows VM",
+	})
+	windowsNodes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "windows_nodes",
+		Help:      "Number of Windows nodes by state",
+	}, []string{"state"})
+	payloadInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "payload_info",
+		Help:      "Versions of the operator and of the payload it configures the Windows nodes with",
+	}, []string{"component", "version"})
+)
+
+// nodeStates holds the state of each Windows node, keyed by the instance ID of its VM
+var nodeStates = struct {
+	// mutex guards states and the windowsNodes metric computed from them
+	mutex  sync.Mutex
+	states map[string]string
+}{states: make(map[string]string)}
+
+func init() {
+	// The metrics are served by the manager's metrics endpoint
+	metrics.Registry.MustRegister(configurationAttempts, configurationFailures, configurationDuration,
+		transferredBytes, sshDialFailures, windowsNodes, payloadInfo)
+	for _, state := range []string{NodeStateConfigured, NodeStateFailed, NodeStateUpgrading,
+		NodeStateQuarantined} {
+		windowsNodes.WithLabelValues(state).Set(0)
+	}
+}
+
+// ObserveStage runs the given configuration stage, recording the attempt, its duration and its failure if the stage
+// returns an error. The error of the stage is returned.
+func ObserveStage(stage string, run func() error) error {
+	configurationAttempts.WithLabelValues(stage).Inc()
+	start := time.Now()
+	err := run()
+	configurationDuration.WithLabelValues(stage).Observe(time.Since(start).Seconds())
+	if err != nil {
+		configurationFailures.WithLabelValues(stage).Inc()
+	}
+	return err
+}
+
+// AddTransferredBytes records the given number of bytes as copied to a Windows VM
+func AddTransferredBytes(bytes int64) {
+	transferredBytes.Add(float64(bytes))
+}
+
+// IncSSHDialFailures records a failed attempt to connect to a Windows VM
+func IncSSHDialFailures() {
+	sshDialFailures.Inc()
+}
+
+// SetNodeState records the given state for the Windows node of the VM with the given instance ID
+func SetNodeState(instanceID, state string) {
+	nodeStates.mutex.Lock()
+	defer nodeStates.mutex.Unlock()
+	nodeStates.states[instanceID] = state
+	updateWindowsNodes()
+}
+
+// PruneNodeStates forgets the state of the nodes whose instance ID is not in the given set of Windows node instance
+// IDs, as their node has been removed from the cluster. Failed and quarantined nodes are kept while their instance ID
+// is in the given set of Windows Machine instance IDs, as their configuration may have failed before their node object
+// was created.
+func PruneNodeStates(nodeInstanceIDs, machineInstanceIDs map[string]bool) {
+	nodeStates.mutex.Lock()
+	defer nodeStates.mutex.Unlock()
+	for instanceID, state := range nodeStates.states {
+		if nodeInstanceIDs[instanceID] {
+			continue
+		}
+		if !machineInstanceIDs[instanceID] || state != NodeStateFailed && state != NodeStateQuarantined {
+			delete(nodeStates.states, instanceID)
+		}
+	}
+	updateWindowsNodes()
+}
+
+// updateWindowsNodes sets the Windows nodes metric from the node states. The caller must hold the node states mutex.
+func updateWindowsNodes() {
+	counts := map[string]int{NodeStateConfigured: 0, NodeStateFailed: 0, NodeStateUpgrading: 0,
+		NodeStateQuarantined: 0}
+	for _, state := range nodeStates.states {
+		counts[state]++
+	}
+	for state, count := range counts {
+		windowsNodes.WithLabelValues(state).Set(float64(count))
+	}
+}
+
+// SetPayloadVersions records the given versions of the operator and its payload, replacing the previous ones
+func SetPayloadVersions(versions []oconfig.OperandVersion) {
+	payloadInfo.Reset()
+	for _, version := range versions {
+		payloadInfo.WithLabelValues(version.Name, version.Version).Set(1)
+	}
+}
@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestObserveStage tests that ObserveStage counts the attempts and failures of a stage and returns its error
+func TestObserveStage(t *testing.T) {
+	stageErr := errors.New("bootstrapper failed")
+	assert.NoError(t, ObserveStage(StageBootstrap, func() error { return nil }))
+	assert.Equal(t, stageErr, ObserveStage(StageBootstrap, func() error { return stageErr }))
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(configurationAttempts.WithLabelValues(StageBootstrap)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(configurationFailures.WithLabelValues(StageBootstrap)))
+}
+
+// TestNodeStates tests that the Windows nodes metric counts the nodes by their last state, and that pruning only
+// forgets the failed nodes once their Machine is deleted
+func TestNodeStates(t *testing.T) {
+	SetNodeState("i-1", NodeStateConfigured)
+	SetNodeState("i-2", NodeStateUpgrading)
+	SetNodeState("i-3", NodeStateFailed)
+	SetNodeState("i-2", NodeStateConfigured)
+	assert.Equal(t, float64(2), testutil.ToFloat64(windowsNodes.WithLabelValues(NodeStateConfigured)))
+	assert.Equal(t, float64(0), testutil.ToFloat64(windowsNodes.WithLabelValues(NodeStateUpgrading)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(windowsNodes.WithLabelValues(NodeStateFailed)))
+
+	PruneNodeStates(map[string]bool{"i-1": true}, map[string]bool{"i-1": true, "i-2": true, "i-3": true})
+	assert.Equal(t, float64(1), testutil.ToFloat64(windowsNodes.WithLabelValues(NodeStateConfigured)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(windowsNodes.WithLabelValues(NodeStateFailed)))
+
+	PruneNodeStates(map[string]bool{"i-1": true}, map[string]bool{"i-1": true})
+	assert.Equal(t, float64(1), testutil.ToFloat64(windowsNodes.WithLabelValues(NodeStateConfigured)))
+	assert.Equal(t, float64(0), testutil.ToFloat64(windowsNodes.WithLabelValues(NodeStateFailed)))
+}
@@ -7,6 +7,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/openshift/windows-machine-config-operator/pkg/clusternetwork"
 	"github.com/openshift/windows-machine-config-operator/pkg/controller/windowsmachine/nodeconfig"
 	framework "github.com/operator-framework/operator-sdk/pkg/test"
 	"github.com/stretchr/testify/require"
@@ -90,7 +91,7 @@ func (tc *testContext) createWindowsMachineSet(replicas int32, windowsLabel bool
 // the overall wait time in test suite
 func (tc *testContext) waitForWindowsNodes(nodeCount int32, waitForAnnotations, expectError bool) error {
 	var nodes *v1.NodeList
-	annotations := []string{nodeconfig.HybridOverlaySubnet, nodeconfig.HybridOverlayMac}
+	annotations := []string{clusternetwork.HybridOverlaySubnet, clusternetwork.HybridOverlayMac}
 	var creationTime time.Duration
 	if expectError {
 		// The time we expect to wait, if the windowsLabel is